@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/gdbc"
+	"github.com/daiguadaidai/haqi/services/manal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configCmd 是 rootCmd 的一个子命令
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "tomysql 任务配置文件相关工具",
+}
+
+// validateCmd 是 configCmd 的一个子命令
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "检测 tomysql 任务配置, 以及源实例, 目标实例是否可以连接, 不执行任务",
+	Long: `检测 tomysql 任务配置, 以及源实例, 目标实例, 限流从库和 kafka broker 是否可以连接, 不执行任务.
+参数和 tomysql 相同, 命令行参数优先于配置文件中的参数
+Example:
+./haqi config validate --config=task.yaml
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyTaskFile(cmd, validateConfigFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err := manal.Validate(validateTMC, validateODBC, validateTDBC)
+		gdbc.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("配置检测通过")
+	},
+}
+
+var validateConfigFile string
+var validateTMC *config.ToMySQLConfig
+var validateODBC *config.DBConfig // 源数据库配置信息
+var validateTDBC *config.DBConfig // 目标数据库配置信息
+
+// 添加配置文件相关子命令
+func addConfigCMD() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(validateCmd)
+	validateTMC = new(config.ToMySQLConfig)
+	validateODBC = new(config.DBConfig)
+	validateTDBC = new(config.DBConfig)
+	addToMySQLFlags(validateCmd, validateTMC, validateODBC, validateTDBC)
+	addConfigFileFlag(validateCmd, &validateConfigFile)
+}
+
+func addConfigFileFlag(cmd *cobra.Command, path *string) {
+	cmd.PersistentFlags().StringVar(path, "config", "",
+		"任务配置文件(.yaml, .yml, .toml), 键为参数名, 如: ori-db-host. 命令行参数优先于配置文件中的参数")
+}
+
+// 使用配置文件中的值设置命令行中没有指定的参数
+func applyTaskFile(cmd *cobra.Command, path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	values, err := config.LoadTaskFile(path)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	for name, items := range values {
+		flag := flags.Lookup(name)
+		if flag == nil || name == "config" {
+			return fmt.Errorf("配置文件 %s 中有未知的参数: %s", path, name)
+		}
+		if flag.Changed { // 命令行中已经指定
+			continue
+		}
+		if err = setFlag(flags, flag, items); err != nil {
+			return fmt.Errorf("配置文件 %s 中的参数 %s 错误. %v", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+func setFlag(flags *pflag.FlagSet, flag *pflag.Flag, items []string) error {
+	switch flag.Value.Type() {
+	case "stringSlice": // 每次 Set 的值按 CSV 解析
+		for _, item := range items {
+			if strings.ContainsAny(item, ",\"") {
+				item = fmt.Sprintf("\"%s\"", strings.Replace(item, "\"", "\"\"", -1))
+			}
+			if err := flags.Set(flag.Name, item); err != nil {
+				return err
+			}
+		}
+	case "stringArray":
+		for _, item := range items {
+			if err := flags.Set(flag.Name, item); err != nil {
+				return err
+			}
+		}
+	default:
+		if len(items) != 1 {
+			return fmt.Errorf("只能指定一个值, 实际: %v", items)
+		}
+		return flags.Set(flag.Name, items[0])
+	}
+
+	return nil
+}
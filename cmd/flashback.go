@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/services/manal"
+	"github.com/spf13/cobra"
+)
+
+// flashbackCmd 是 rootCmd 的一个子命令
+var flashbackCmd = &cobra.Command{
+	Use:   "flashback",
+	Short: "生成binlog的回滚sql",
+	Long: `解析指定范围的binlog, 倒序生成回滚sql(insert -> delete, delete -> insert, update -> 修改前的数据)
+Example:
+生成回滚sql到文件
+./haqi flashback \
+    --start-log-file="mysql-bin.000090" \
+    --start-log-pos=0 \
+    --end-log-file="mysql-bin.000092" \
+    --end-log-pos=424 \
+    --thread-id=15 \
+    --trans-schema="schema1" \
+    --trans-table="schema2.table1" \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --sql-file="/tmp/flashback.sql"
+
+生成回滚sql并且直接在目标实例执行
+./haqi flashback \
+    --start-log-file="mysql-bin.000090" \
+    --start-log-pos=0 \
+    --end-log-file="mysql-bin.000092" \
+    --end-log-pos=424 \
+    --trans-table="schema2.table1" \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --execute \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manal.StartFlashback(flashbackFC, flashbackODBC, flashbackTDBC)
+	},
+}
+
+var flashbackFC *config.FlashbackConfig
+var flashbackODBC *config.DBConfig // 源数据库配置信息
+var flashbackTDBC *config.DBConfig // 执行回滚sql的数据库配置信息
+
+// 添加生成回滚SQL子命令
+func addFlashbackCMD() {
+	rootCmd.AddCommand(flashbackCmd)
+	flashbackFC = new(config.FlashbackConfig)
+	addBaseFlags(flashbackCmd, &flashbackFC.BaseConfig, config.FLASHBACK_ENABLE_TRANS_INSERT,
+		config.FLASHBACK_ENABLE_TRANS_UPDATE, config.FLASHBACK_ENABLE_TRANS_DELETE)
+	flashbackCmd.PersistentFlags().StringVar(&flashbackFC.SQLFile, "sql-file",
+		"", "回滚sql输出文件")
+	flashbackCmd.PersistentFlags().BoolVar(&flashbackFC.Execute, "execute",
+		false, "是否直接在目标实例(--std-db-*)中使用一个事务执行回滚sql")
+
+	// 源链接的数据库配置
+	flashbackODBC = new(config.DBConfig)
+	addDBFlags(flashbackCmd, flashbackODBC, "ori", "源")
+
+	// 执行回滚sql的数据库配置
+	flashbackTDBC = new(config.DBConfig)
+	addDBFlags(flashbackCmd, flashbackTDBC, "std", "目标")
+}
@@ -44,10 +44,16 @@ Example:
     --thread-id=15 \
     --trans-schema="schema1" \
     --trans-table="schema2.table1" \
+    --row-filter="schema2.table1: tenant_id = 42 AND status IN ('closed')" \
     --enable-trans-insert=false \
     --enable-trans-update=false \
     --enable-trans-delete=true \
+    --insert-mode="insert" \
+    --update-mode="update" \
+    --delete-mode="archive" \
     --schema-suffix=_archive \
+    --ddl-policy="ignore" \
+    --audit-columns \
     --ori-db-host="127.0.0.1" \
     --ori-db-port=3306 \
     --ori-db-username="root" \
@@ -57,10 +63,166 @@ Example:
     --std-db-username="root" \
     --std-db-password="root" \
     --task-uuid="201901182256351181056356ymnuqk" \
+    --resume \
     --read-api="http://127.0.0.1:19528/api/v1/pili/tasks/get" \
     --update-api="http://127.0.0.1:19528/api/v1/pili/tasks"
+
+使用 TLS 链接源实例, 通过 Unix socket 链接本地的目标实例
+./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --trans-table="schema2.table1" \
+    --ori-db-host="mysql.example.com" \
+    --ori-db-port=3306 \
+    --ori-db-username="repl" \
+    --ori-db-password="repl" \
+    --ori-db-tls-ca="/etc/mysql/ca.pem" \
+    --ori-db-tls-cert="/etc/mysql/client-cert.pem" \
+    --ori-db-tls-key="/etc/mysql/client-key.pem" \
+    --std-db-socket="/var/lib/mysql/mysql.sock" \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+修改目标表名, 将分表合并到一个归档表, sha256 的密钥通过环境变量指定
+HAQI_HASH_KEY="xxx" ./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --trans-schema="shop" \
+    --table-route="shop.orders -> archive_2026.orders_deleted" \
+    --table-route="shop./orders_\d{2}/ -> archive_2026.orders_merged" \
+    --table-route="/shop_\d+/.* -> shop_archive.*" \
+    --column-rule="shop.users: drop avatar" \
+    --column-rule="shop.users: sha256 email" \
+    --column-rule="shop.users: last4 phone" \
+    --column-rule="shop.users: add source varchar(16) = 'shop'" \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+离线模式, 解析本地的binlog文件(不连接源实例)
+./haqi tomysql \
+    --binlog-dir="/data/binlog" \
+    --start-log-file="mysql-bin.000090" \
+    --start-log-pos=0 \
+    --end-log-file="mysql-bin.000092" \
+    --end-log-pos=424 \
+    --schema-file="/data/schema.sql" \
+    --trans-table="schema2.table1" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+dry-run, 将生成的sql写入文件, 不修改目标实例
+./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --stop-datetime="2019-01-18 22:30:00" \
+    --trans-table="schema2.table1" \
+    --dry-run \
+    --sink="sql-file" \
+    --output-dir="/tmp/haqi" \
+    --output-max-size=256 \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+将每一行数据的修改发送到 kafka, 消息发送成功后在目标实例中保存checkpoint
+./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --trans-table="schema2.table1" \
+    --sink="kafka" \
+    --kafka-brokers="127.0.0.1:9092" \
+    --kafka-topic-prefix="haqi." \
+    --task-uuid="201901182256351181056356ymnuqk" \
+    --checkpoint \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+目标实例负载过高时暂停应用, 并限制每秒应用的行数
+./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --stop-datetime="2019-01-18 22:30:00" \
+    --trans-table="schema2.table1" \
+    --max-threads-running=30 \
+    --max-replica-lag=10s \
+    --throttle-replicas="127.0.0.1:3308" \
+    --throttle-flag-file="/tmp/haqi.throttle" \
+    --max-rows-per-second=5000 \
+    --max-batch-rows=500 \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+指定 开始时间 和 结束时间
+./haqi tomysql \
+    --start-datetime="2019-01-18 22:00:00" \
+    --stop-datetime="2019-01-18 22:30:00" \
+    --trans-table="schema2.table1" \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+指定 开始GTID集合 和 结束GTID集合
+./haqi tomysql \
+    --start-gtid="3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23" \
+    --end-gtid="3E11FA47-71CA-11E1-9E33-C80AA9429562:1-100" \
+    --trans-table="schema2.table1" \
+    --ori-db-host="127.0.0.1" \
+    --ori-db-port=3306 \
+    --ori-db-username="root" \
+    --ori-db-password="root" \
+    --std-db-host="127.0.0.1" \
+    --std-db-port=3306 \
+    --std-db-username="root" \
+    --std-db-password="root"
+
+使用任务配置文件, 命令行参数优先于配置文件中的参数, 配置文件中 ${NAME} 替换为环境变量的值
+./haqi tomysql --config=task.yaml --start-log-file="mysql-bin.000091"
+
+task.yaml:
+start-log-file: mysql-bin.000090
+trans-table:
+  - schema2.table1
+ori-db:
+  host: 127.0.0.1
+  port: 3306
+  username: root
+  password: ${ORI_DB_PASSWORD}
+std-db:
+  host: 127.0.0.1
+  port: 3307
+  username: root
+  password: ${STD_DB_PASSWORD:-root}
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyTaskFile(cmd, manalConfigFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		manal.Start(manalTMC, manalODBC, manalTDBC)
 	},
 }
@@ -76,8 +238,12 @@ func Execute() {
 
 func init() {
 	addManalCMD()
+	addFlashbackCMD()
+	addServeCMD()
+	addConfigCMD()
 }
 
+var manalConfigFile string
 var manalTMC *config.ToMySQLConfig
 var manalODBC *config.DBConfig // 源数据库配置信息
 var manalTDBC *config.DBConfig // 目标数据库配置信息
@@ -86,78 +252,187 @@ var manalTDBC *config.DBConfig // 目标数据库配置信息
 func addManalCMD() {
 	rootCmd.AddCommand(manalCmd)
 	manalTMC = new(config.ToMySQLConfig)
-	manalCmd.PersistentFlags().StringVar(&manalTMC.StartLogFile, "start-log-file",
-		"", "开始日志文件")
-	manalCmd.PersistentFlags().Uint32Var(&manalTMC.StartLogPos, "start-log-pos",
-		0, "开始日志文件点位")
-	manalCmd.PersistentFlags().StringVar(&manalTMC.EndLogFile, "end-log-file",
-		"", "结束日志文件")
-	manalCmd.PersistentFlags().Uint32Var(&manalTMC.EndLogPos, "end-log-pos",
-		0, "结束日志文件点位")
-	manalCmd.PersistentFlags().StringSliceVar(&manalTMC.TransSchemas, "trans-schema",
-		make([]string, 0, 1), "指定需要执行的schema, 该命令可以指定多个")
-	manalCmd.PersistentFlags().StringSliceVar(&manalTMC.TransTables, "trans-table",
-		make([]string, 0, 1), "需要执行的表, 该命令可以指定多个")
-	manalCmd.PersistentFlags().Uint32Var(&manalTMC.ThreadID, "thread-id",
-		0, "需要执行的thread id")
-	manalCmd.PersistentFlags().BoolVar(&manalTMC.EnableTransInsert, "enable-trans-insert",
-		config.ENABLE_TRANS_INSERT, "是否启用执行 insert")
-	manalCmd.PersistentFlags().BoolVar(&manalTMC.EnableTransUpdate, "enable-trans-update",
-		config.ENABLE_TRANS_UPDATE, "是否启用执行 update")
-	manalCmd.PersistentFlags().BoolVar(&manalTMC.EnableTransDelete, "enable-trans-delete",
-		config.ENABLE_TRANS_DELETE, "是否启用执行 delete")
-	manalCmd.PersistentFlags().StringVar(&manalTMC.SchemaSuffix, "schema-suffix",
+	manalODBC = new(config.DBConfig)
+	manalTDBC = new(config.DBConfig)
+	addToMySQLFlags(manalCmd, manalTMC, manalODBC, manalTDBC)
+	addConfigFileFlag(manalCmd, &manalConfigFile)
+}
+
+// 添加应用binlog到mysql的参数. serve 模式创建任务时也使用这些参数的默认值
+func addToMySQLFlags(cmd *cobra.Command, tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) {
+	addBaseFlags(cmd, &tmc.BaseConfig, config.ENABLE_TRANS_INSERT,
+		config.ENABLE_TRANS_UPDATE, config.ENABLE_TRANS_DELETE)
+	cmd.PersistentFlags().StringVar(&tmc.InsertMode, "insert-mode",
+		config.DEFAULT_INSERT_MODE, "insert 事件应用方式. insert: 使用INSERT写入, replace: 使用REPLACE写入")
+	cmd.PersistentFlags().StringVar(&tmc.UpdateMode, "update-mode",
+		config.DEFAULT_UPDATE_MODE, "update 事件应用方式. update: 通过主键UPDATE, replace: 使用REPLACE写入修改后的数据")
+	cmd.PersistentFlags().StringVar(&tmc.DeleteMode, "delete-mode",
+		config.DEFAULT_DELETE_MODE, "delete 事件应用方式. archive: 将删除的数据归档写入, delete: 通过主键DELETE")
+	cmd.PersistentFlags().IntVar(&tmc.InsertBatchRows, "insert-batch-rows",
+		config.DEFAULT_INSERT_BATCH_ROWS, "一个源事务中同一个表的 INSERT/REPLACE(包括归档的 delete) 合并为一个语句的最大行数. 0: 不限制")
+	cmd.PersistentFlags().IntVar(&tmc.InsertBatchBytes, "insert-batch-bytes",
+		config.DEFAULT_INSERT_BATCH_BYTES, "合并的语句的最大大小(字节), 需要小于目标实例的 max_allowed_packet, 超过时拆分为多个语句. 0: 不限制")
+	cmd.PersistentFlags().DurationVar(&tmc.InsertBatchInterval, "insert-batch-interval",
+		config.DEFAULT_INSERT_BATCH_INTERVAL, "合并的数据最长等待时间, 超过后执行. 0: 不限制(事务结束时执行)")
+	cmd.PersistentFlags().StringVar(&tmc.SchemaSuffix, "schema-suffix",
 		config.DEFAULT_SCHEMA_SUFFIX, "目标数据库后缀")
-	manalCmd.PersistentFlags().StringVar(&manalTMC.TaskUUID, "task-uuid",
+	cmd.PersistentFlags().StringArrayVar(&tmc.TableRoutes, "table-route",
+		make([]string, 0, 1), "目标表名路由规则, 该命令可以指定多个, 按顺序匹配, 没有匹配的表写入 <源库><schema-suffix>.<源表>. "+
+			"格式: '<源库>.<源表> -> <目标库>.<目标表>', 源名称可以是 * 或 /正则表达式/, 目标名称中 * 表示源名称, $1 表示正则表达式分组. "+
+			"如: 'shop.orders -> archive_2026.orders_deleted', 'shop./orders_\\d{2}/ -> archive.orders'(分表合并)")
+	cmd.PersistentFlags().StringArrayVar(&tmc.ColumnRules, "column-rule",
+		make([]string, 0, 1), "字段处理规则, 该命令可以指定多个, 同一个表的规则按顺序执行. 格式: 'schema.table: <action> <args>'. "+
+			"action: drop a, b(删除字段) | rename a TO b(修改字段名) | sha256 a(HMAC-SHA256值, 类型为 char(64), 密钥通过 hash-key 指定) | "+
+			"last4 a(只保留最后4个字符) | null a(写入NULL) | add c <类型> = <值>(在最后添加字段, 值可以是常量, 字段, "+
+			"sha256(), last4(), upper(), lower(), concat(), coalesce()). "+
+			"如: 'shop.users: sha256 email', 'shop.users: add source varchar(16) = \"shop\"'")
+	cmd.PersistentFlags().StringVar(&tmc.HashKey, "hash-key",
+		"", "字段处理规则中 sha256 使用的 HMAC-SHA256 密钥, 使用了 sha256 时必须指定. "+
+			"不指定则使用环境变量 "+config.ENV_HASH_KEY+"(建议使用环境变量, 避免密钥出现在进程列表中). 需要保密, 相同的密钥才能得到相同的值")
+	cmd.PersistentFlags().StringVar(&tmc.TaskUUID, "task-uuid",
 		"", "关联的任务UUID")
-	manalCmd.PersistentFlags().StringVar(&manalTMC.UpdateAPI, "update-api",
+	cmd.PersistentFlags().BoolVar(&tmc.Checkpoint, "checkpoint",
+		false, "在目标实例中保存应用完成的位点(checkpoint), 需要指定 task-uuid, 会在 checkpoint-schema 中创建保存checkpoint的表")
+	cmd.PersistentFlags().StringVar(&tmc.CheckpointSchema, "checkpoint-schema",
+		config.DEFAULT_CHECKPOINT_SCHEMA, "目标实例中保存checkpoint的数据库")
+	cmd.PersistentFlags().IntVar(&tmc.Workers, "workers",
+		config.DEFAULT_WORKERS, "应用线程数, 数据按 表+主键值 分配给应用线程, 同一行数据的修改按顺序应用. "+
+			"大于1时一个源事务会拆分成多个目标实例事务分别提交(不是原子的), 不能和 checkpoint, resume 一起使用")
+	cmd.PersistentFlags().BoolVar(&tmc.Resume, "resume",
+		false, "从目标实例中上次应用完成的checkpoint继续执行, 需要指定 task-uuid, 继续执行时会保存checkpoint")
+	cmd.PersistentFlags().BoolVar(&tmc.ReplicateDDL, "replicate-ddl",
+		config.DEFAULT_REPLICATE_DDL, "将需要执行的表的DDL(CREATE/ALTER/RENAME/DROP TABLE)按顺序应用到目标数据库")
+	cmd.PersistentFlags().StringVar(&tmc.DDLPolicy, "ddl-policy",
+		config.DEFAULT_DDL_POLICY, "会删除数据的DDL(DROP TABLE, TRUNCATE TABLE, ALTER TABLE ... DROP COLUMN)的处理方式. "+
+			"apply: 在目标实例执行, ignore: 不执行(保留被删除的表和字段), abort: 停止应用")
+	cmd.PersistentFlags().BoolVar(&tmc.AuditColumns, "audit-columns",
+		false, "目标表添加审计字段(_haqi_log_file, _haqi_log_pos, _haqi_event_time, _haqi_thread_id, "+
+			"_haqi_gtid, _haqi_op), 记录每一行数据来源的binlog位点, 时间, 线程ID, GTID 和操作类型")
+	cmd.PersistentFlags().StringVar(&tmc.Sink, "sink",
+		config.DEFAULT_SINK, "应用的数据写入的目标. mysql: 写入目标实例, sql-file: 将生成的sql写入文件(带binlog位点和时间注释), "+
+			"jsonl: 每一行数据的修改输出为一个 JSON 对象, csv: 每个表的修改输出到各自的 CSV 文件, "+
+			"kafka: 每一行数据的修改作为一条消息(key 为主键值)发送到 kafka")
+	cmd.PersistentFlags().StringVar(&tmc.OutputDir, "output-dir",
+		config.DEFAULT_OUTPUT_DIR, "写入文件的目录")
+	cmd.PersistentFlags().StringVar(&tmc.OutputPrefix, "output-prefix",
+		config.DEFAULT_OUTPUT_PREFIX, "写入文件的文件名前缀, 文件名为: <prefix>.000001.sql, CSV 文件名为: <prefix>.<schema>.<table>.000001.csv")
+	cmd.PersistentFlags().Int64Var(&tmc.OutputMaxSize, "output-max-size",
+		config.DEFAULT_OUTPUT_MAX_SIZE, "写入文件的最大大小(MB), 超过后切换到新的文件. 0: 不切换")
+	cmd.PersistentFlags().DurationVar(&tmc.OutputRotateInterval, "output-rotate-interval",
+		0, "写入文件的最长时间, 超过后切换到新的文件. 如: 1h, 30m. 0: 不切换")
+	cmd.PersistentFlags().StringSliceVar(&tmc.KafkaBrokers, "kafka-brokers",
+		nil, "kafka broker 地址, 多个使用逗号分隔. 如: 127.0.0.1:9092,127.0.0.1:9093")
+	cmd.PersistentFlags().StringVar(&tmc.KafkaTopicPrefix, "kafka-topic-prefix",
+		config.DEFAULT_KAFKA_TOPIC_PREFIX, "kafka topic 前缀, topic 为: <prefix><schema>.<table>, DDL 发送到: <prefix>ddl")
+	cmd.PersistentFlags().BoolVar(&tmc.DryRun, "dry-run",
+		false, "不修改目标实例: 不创建和修复目标表, 不执行DDL. 需要指定写入文件或 kafka 的 --sink(sql-file, jsonl, csv, kafka)")
+	cmd.PersistentFlags().IntVar(&tmc.MaxThreadsRunning, "max-threads-running",
+		0, "目标实例 Threads_running 超过该值时暂停应用. 0: 不检测")
+	cmd.PersistentFlags().DurationVar(&tmc.MaxReplicaLag, "max-replica-lag",
+		0, "目标实例从库(--throttle-replicas)延迟超过该值时暂停应用. 如: 10s. 0: 不检测")
+	cmd.PersistentFlags().StringSliceVar(&tmc.ThrottleReplicas, "throttle-replicas",
+		nil, "需要检测延迟的目标实例从库地址, 使用目标实例的用户名和密码连接. 如: 127.0.0.1:3308,127.0.0.1:3309")
+	cmd.PersistentFlags().StringVar(&tmc.ThrottleFlagFile, "throttle-flag-file",
+		"", "该文件存在时暂停应用, 删除后恢复")
+	cmd.PersistentFlags().StringVar(&tmc.ThrottleQuery, "throttle-query",
+		"", "在目标实例执行的查询, 返回的第一个值大于0时暂停应用. 如: SELECT HOUR(NOW()) BETWEEN 9 AND 18")
+	cmd.PersistentFlags().DurationVar(&tmc.ThrottleInterval, "throttle-interval",
+		config.DEFAULT_THROTTLE_INTERVAL, "检测是否需要暂停应用的间隔")
+	cmd.PersistentFlags().IntVar(&tmc.MaxRowsPerSecond, "max-rows-per-second",
+		0, "每秒最多应用的行数, 所有应用线程共用. 0: 不限制")
+	cmd.PersistentFlags().IntVar(&tmc.MaxBatchRows, "max-batch-rows",
+		0, "一次最多应用的行数, row event 中的数据超过该值时分多次应用. 0: 不限制")
+	cmd.PersistentFlags().StringVar(&tmc.MetricsAddr, "metrics-addr",
+		"", "Prometheus 监控指标 HTTP 监听地址, 通过 /metrics 获取. 如: 127.0.0.1:19530. 为空则不启动(serve 模式通过控制服务的 /metrics 获取)")
+	cmd.PersistentFlags().StringVar(&tmc.UpdateAPI, "update-api",
 		"", "更新任务信息API")
-	manalCmd.PersistentFlags().StringVar(&manalTMC.ReadAPI, "read-api",
+	cmd.PersistentFlags().StringVar(&tmc.ReadAPI, "read-api",
 		"", "获取任务信息API")
 
 	// 源链接的数据库配置
-	manalODBC = new(config.DBConfig)
-	manalCmd.PersistentFlags().StringVar(&manalODBC.Host, "ori-db-host",
-		config.DB_HOST, "(源)数据库host")
-	manalCmd.PersistentFlags().IntVar(&manalODBC.Port, "ori-db-port",
-		config.DB_PORT, "(源)数据库port")
-	manalCmd.PersistentFlags().StringVar(&manalODBC.Username, "ori-db-username",
-		config.DB_USERNAME, "(源)数据库用户名")
-	manalCmd.PersistentFlags().StringVar(&manalODBC.Password, "ori-db-password",
-		config.DB_PASSWORD, "(源)数据库密码")
-	manalCmd.PersistentFlags().StringVar(&manalODBC.Database, "ori-db-schema",
-		config.DB_SCHEMA, "(源)数据库名称")
-	manalCmd.PersistentFlags().StringVar(&manalODBC.CharSet, "ori-db-charset",
-		config.DB_CHARSET, "(源)数据库字符集")
-	manalCmd.PersistentFlags().IntVar(&manalODBC.Timeout, "ori-db-timeout",
-		config.DB_TIMEOUT, "(源)数据库timeout")
-	manalCmd.PersistentFlags().IntVar(&manalODBC.MaxIdelConns, "ori-db-max-idel-conns",
-		config.DB_MAX_IDEL_CONNS, "(源)数据库最大空闲连接数")
-	manalCmd.PersistentFlags().IntVar(&manalODBC.MaxOpenConns, "ori-db-max-open-conns",
-		config.DB_MAX_OPEN_CONNS, "(源)数据库最大连接数")
-	manalCmd.PersistentFlags().BoolVar(&manalODBC.AutoCommit, "ori-db-auto-commit",
-		config.DB_AUTO_COMMIT, "(源)数据库自动提交")
+	addDBFlags(cmd, odbc, "ori", "源")
 
 	// 目标链接的数据库配置
-	manalTDBC = new(config.DBConfig)
-	manalCmd.PersistentFlags().StringVar(&manalTDBC.Host, "std-db-host",
-		config.DB_HOST, "(目标)数据库host")
-	manalCmd.PersistentFlags().IntVar(&manalTDBC.Port, "std-db-port",
-		config.DB_PORT, "(目标)数据库port")
-	manalCmd.PersistentFlags().StringVar(&manalTDBC.Username, "std-db-username",
-		config.DB_USERNAME, "(目标)数据库用户名")
-	manalCmd.PersistentFlags().StringVar(&manalTDBC.Password, "std-db-password",
-		config.DB_PASSWORD, "(目标)数据库密码")
-	manalCmd.PersistentFlags().StringVar(&manalTDBC.Database, "std-db-schema",
-		config.DB_SCHEMA, "(目标)数据库名称")
-	manalCmd.PersistentFlags().StringVar(&manalTDBC.CharSet, "std-db-charset",
-		config.DB_CHARSET, "(目标)数据库字符集")
-	manalCmd.PersistentFlags().IntVar(&manalTDBC.Timeout, "std-db-timeout",
-		config.DB_TIMEOUT, "(目标)数据库timeout")
-	manalCmd.PersistentFlags().IntVar(&manalTDBC.MaxIdelConns, "std-db-max-idel-conns",
-		config.DB_MAX_IDEL_CONNS, "(目标)数据库最大空闲连接数")
-	manalCmd.PersistentFlags().IntVar(&manalTDBC.MaxOpenConns, "std-db-max-open-conns",
-		config.DB_MAX_OPEN_CONNS, "(目标)数据库最大连接数")
-	manalCmd.PersistentFlags().BoolVar(&manalTDBC.AutoCommit, "std-db-auto-commit",
-		config.DB_AUTO_COMMIT, "(目标)数据库自动提交")
+	addDBFlags(cmd, tdbc, "std", "目标")
+}
+
+// 添加位点, 过滤条件, 离线模式相关参数
+func addBaseFlags(cmd *cobra.Command, bc *config.BaseConfig, enableInsert, enableUpdate, enableDelete bool) {
+	cmd.PersistentFlags().StringVar(&bc.StartLogFile, "start-log-file",
+		"", "开始日志文件")
+	cmd.PersistentFlags().Uint32Var(&bc.StartLogPos, "start-log-pos",
+		0, "开始日志文件点位")
+	cmd.PersistentFlags().StringVar(&bc.EndLogFile, "end-log-file",
+		"", "结束日志文件")
+	cmd.PersistentFlags().Uint32Var(&bc.EndLogPos, "end-log-pos",
+		0, "结束日志文件点位")
+	cmd.PersistentFlags().StringVar(&bc.StartGTID, "start-gtid",
+		"", "开始GTID集合, 从该集合之后的事务开始解析(不包含该集合中的事务). 如: 3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23")
+	cmd.PersistentFlags().StringVar(&bc.EndGTID, "end-gtid",
+		"", "结束GTID集合, 已解析的事务包含该集合时结束, 需要同时指定 --start-gtid. 如: 3E11FA47-71CA-11E1-9E33-C80AA9429562:1-100")
+	cmd.PersistentFlags().StringVar(&bc.StartDatetime, "start-datetime",
+		"", "开始时间, 从该时间(包含)之后的event开始执行. 格式: 2006-01-02 15:04:05")
+	cmd.PersistentFlags().StringVar(&bc.StopDatetime, "stop-datetime",
+		"", "结束时间, event的时间超过该时间则结束. 格式: 2006-01-02 15:04:05")
+	cmd.PersistentFlags().StringSliceVar(&bc.TransSchemas, "trans-schema",
+		make([]string, 0, 1), "指定需要执行的schema, 该命令可以指定多个")
+	cmd.PersistentFlags().StringSliceVar(&bc.TransTables, "trans-table",
+		make([]string, 0, 1), "需要执行的表, 该命令可以指定多个")
+	cmd.PersistentFlags().Uint32Var(&bc.ThreadID, "thread-id",
+		0, "需要执行的thread id")
+	cmd.PersistentFlags().StringArrayVar(&bc.RowFilters, "row-filter",
+		make([]string, 0, 1), "行过滤规则, 只执行满足条件的行, 该命令可以指定多个. "+
+			"格式: 'schema.table: <expr>', 如: 'db1.t1: tenant_id = 42 AND status IN ('closed')'. "+
+			"支持 =, !=, <>, <, <=, >, >=, [NOT] IN, [NOT] BETWEEN, [NOT] LIKE, IS [NOT] NULL, AND, OR, NOT")
+	cmd.PersistentFlags().BoolVar(&bc.EnableTransInsert, "enable-trans-insert",
+		enableInsert, "是否启用执行 insert")
+	cmd.PersistentFlags().BoolVar(&bc.EnableTransUpdate, "enable-trans-update",
+		enableUpdate, "是否启用执行 update")
+	cmd.PersistentFlags().BoolVar(&bc.EnableTransDelete, "enable-trans-delete",
+		enableDelete, "是否启用执行 delete")
+	cmd.PersistentFlags().StringVar(&bc.BinlogDir, "binlog-dir",
+		"", "离线模式: 本地binlog文件所在目录")
+	cmd.PersistentFlags().StringSliceVar(&bc.BinlogFiles, "binlog-files",
+		make([]string, 0, 1), "离线模式: 需要解析的本地binlog文件, 该命令可以指定多个")
+	cmd.PersistentFlags().StringVar(&bc.SchemaFile, "schema-file",
+		"", "开始位点时的表结构文件(mysqldump --no-data 导出), 作为解析binlog的第一个版本的表结构. "+
+			"不指定则在线模式使用源实例当前的表结构, 离线模式从目标实例获取同名表结构. 开始位点之后执行过DDL时需要指定")
+}
+
+// 添加数据库链接参数. prefix: 参数前缀(ori -> --ori-db-host), desc: 参数描述(源 -> (源)数据库host)
+func addDBFlags(cmd *cobra.Command, dbc *config.DBConfig, prefix string, desc string) {
+	cmd.PersistentFlags().StringVar(&dbc.Host, prefix+"-db-host",
+		config.DB_HOST, fmt.Sprintf("(%s)数据库host", desc))
+	cmd.PersistentFlags().IntVar(&dbc.Port, prefix+"-db-port",
+		config.DB_PORT, fmt.Sprintf("(%s)数据库port", desc))
+	cmd.PersistentFlags().StringVar(&dbc.Username, prefix+"-db-username",
+		config.DB_USERNAME, fmt.Sprintf("(%s)数据库用户名", desc))
+	cmd.PersistentFlags().StringVar(&dbc.Password, prefix+"-db-password",
+		config.DB_PASSWORD, fmt.Sprintf("(%s)数据库密码", desc))
+	cmd.PersistentFlags().StringVar(&dbc.Database, prefix+"-db-schema",
+		config.DB_SCHEMA, fmt.Sprintf("(%s)数据库名称", desc))
+	cmd.PersistentFlags().StringVar(&dbc.CharSet, prefix+"-db-charset",
+		config.DB_CHARSET, fmt.Sprintf("(%s)数据库字符集", desc))
+	cmd.PersistentFlags().IntVar(&dbc.Timeout, prefix+"-db-timeout",
+		config.DB_TIMEOUT, fmt.Sprintf("(%s)数据库timeout", desc))
+	cmd.PersistentFlags().IntVar(&dbc.MaxIdelConns, prefix+"-db-max-idel-conns",
+		config.DB_MAX_IDEL_CONNS, fmt.Sprintf("(%s)数据库最大空闲连接数", desc))
+	cmd.PersistentFlags().IntVar(&dbc.MaxOpenConns, prefix+"-db-max-open-conns",
+		config.DB_MAX_OPEN_CONNS, fmt.Sprintf("(%s)数据库最大连接数", desc))
+	cmd.PersistentFlags().BoolVar(&dbc.AutoCommit, prefix+"-db-auto-commit",
+		config.DB_AUTO_COMMIT, fmt.Sprintf("(%s)数据库自动提交", desc))
+	cmd.PersistentFlags().StringVar(&dbc.Socket, prefix+"-db-socket",
+		"", fmt.Sprintf("(%s)数据库 Unix socket 文件, 指定后通过 socket 链接, host 和 port 只作为实例的标识", desc))
+	cmd.PersistentFlags().BoolVar(&dbc.TLS, prefix+"-db-tls",
+		false, fmt.Sprintf("(%s)使用 TLS 链接数据库, 指定了 CA 或客户端证书时自动启用", desc))
+	cmd.PersistentFlags().StringVar(&dbc.TLSCA, prefix+"-db-tls-ca",
+		"", fmt.Sprintf("(%s)校验服务端证书的 CA 文件, 不指定使用系统的根证书", desc))
+	cmd.PersistentFlags().StringVar(&dbc.TLSCert, prefix+"-db-tls-cert",
+		"", fmt.Sprintf("(%s)TLS 客户端证书文件", desc))
+	cmd.PersistentFlags().StringVar(&dbc.TLSKey, prefix+"-db-tls-key",
+		"", fmt.Sprintf("(%s)TLS 客户端私钥文件", desc))
+	cmd.PersistentFlags().StringVar(&dbc.TLSServerName, prefix+"-db-tls-server-name",
+		"", fmt.Sprintf("(%s)校验服务端证书使用的名称, 默认使用 host", desc))
+	cmd.PersistentFlags().BoolVar(&dbc.TLSSkipVerify, prefix+"-db-tls-skip-verify",
+		false, fmt.Sprintf("(%s)不校验服务端证书", desc))
 }
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/services/server"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd 是 rootCmd 的一个子命令
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "启动 HTTP 控制服务, 在一个进程中管理多个 tomysql 任务",
+	Long: `启动 HTTP 控制服务, 通过 API 创建, 查看, 暂停, 恢复, 取消 tomysql 任务, 修改结束位点和获取解析/应用的位点.
+任务参数的字段名和 config.ToMySQLConfig, config.DBConfig 的字段名相同, 没有指定的参数使用 tomysql 命令行参数的默认值.
+返回数据格式: {"status": true, "message": "success", "data": ...}
+
+API:
+POST /api/v1/tasks                   创建任务, 指定了 TaskUUID 则作为任务ID
+GET  /api/v1/tasks                   所有任务
+GET  /api/v1/tasks/{id}              任务信息
+POST /api/v1/tasks/{id}/pause        暂停解析binlog
+POST /api/v1/tasks/{id}/resume       恢复解析binlog
+POST /api/v1/tasks/{id}/cancel       取消任务, 已经解析的event应用完成后结束
+PUT  /api/v1/tasks/{id}/end          修改结束位点: {"end_log_file": "mysql-bin.000092", "end_log_pos": 424} 或 {"end_gtid_set": "..."}
+GET  /api/v1/tasks/{id}/positions    推送解析和应用的位点(Server-Sent Events), ?interval=5s 指定推送间隔
+GET  /metrics                        Prometheus 监控指标, task 标签为任务ID
+
+Example:
+./haqi serve --listen-addr="127.0.0.1:19529"
+
+curl -X POST http://127.0.0.1:19529/api/v1/tasks -d '{
+    "tmc": {"StartDatetime": "2019-01-18 22:00:00", "TransTables": ["schema2.table1"], "Workers": 4},
+    "odbc": {"Host": "127.0.0.1", "Port": 3306, "Username": "root", "Password": "root"},
+    "tdbc": {"Host": "127.0.0.1", "Port": 3307, "Username": "root", "Password": "root"}
+}'
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		server.Start(serveSC, newTaskConfig)
+	},
+}
+
+var serveSC *config.ServeConfig
+
+// 添加 HTTP 控制服务子命令
+func addServeCMD() {
+	rootCmd.AddCommand(serveCmd)
+	serveSC = new(config.ServeConfig)
+	serveCmd.PersistentFlags().StringVar(&serveSC.ListenAddr, "listen-addr",
+		config.DEFAULT_LISTEN_ADDR, "HTTP 控制服务监听地址")
+}
+
+// 创建任务的参数, 使用 tomysql 命令行参数的默认值
+func newTaskConfig() (*config.ToMySQLConfig, *config.DBConfig, *config.DBConfig) {
+	tmc := new(config.ToMySQLConfig)
+	odbc := new(config.DBConfig)
+	tdbc := new(config.DBConfig)
+	addToMySQLFlags(new(cobra.Command), tmc, odbc, tdbc)
+
+	return tmc, odbc, tdbc
+}
@@ -4,6 +4,16 @@ type APIConfig struct {
 	TaskUUID  string
 	UpdateAPI string
 	ReadAPI   string
+	ServeMode bool   // 在 HTTP 控制服务中执行, 结束位点可以通过控制服务的API修改
+	TaskName  string // 监控指标中的任务名称, 没有指定则使用 TaskUUID
+}
+
+// 监控指标中 task 标签的值
+func (this *APIConfig) TaskLabel() string {
+	if len(this.TaskName) != 0 {
+		return this.TaskName
+	}
+	return this.TaskUUID
 }
 
 // 是否启动 实时读取API信息
@@ -1,17 +1,33 @@
 package config
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/daiguadaidai/haqi/utils"
+	"github.com/siddontang/go-mysql/mysql"
+)
+
 type BaseConfig struct {
 	StartLogFile      string
 	StartLogPos       uint32
 	EndLogFile        string
 	EndLogPos         uint32
+	StartGTID         string // 已经执行过的GTID集合, 从该集合之后开始解析
+	EndGTID           string // 结束的GTID集合, 解析过的GTID包含该集合则结束
+	StartDatetime     string // 开始时间, 解析该时间之后(包含)的event
+	StopDatetime      string // 结束时间, event的时间超过该时间则结束
 	TransSchemas      []string
 	TransTables       []string
 	ThreadID          uint32
+	RowFilters        []string // 行过滤规则, 格式: schema.table: <expr>, 只执行满足条件的行
 	EnableTransUpdate bool
 	EnableTransInsert bool
 	EnableTransDelete bool
 	SchemaSuffix      string
+	BinlogDir         string   // 离线模式: 本地binlog文件所在目录
+	BinlogFiles       []string // 离线模式: 指定需要解析的本地binlog文件
+	SchemaFile        string   // 开始位点时的表结构文件(mysqldump --no-data), 不指定则在线模式从源实例, 离线模式从目标实例获取表结构
 }
 
 // 是否有开始位点信息
@@ -22,6 +38,89 @@ func (this *BaseConfig) HaveStartPosInfo() bool {
 	return true
 }
 
+// 是否有开始GTID信息
+func (this *BaseConfig) HaveStartGTIDInfo() bool {
+	if this.StartGTID == "" {
+		return false
+	}
+	return true
+}
+
+// 是否有结束GTID信息
+func (this *BaseConfig) HaveEndGTIDInfo() bool {
+	if this.EndGTID == "" {
+		return false
+	}
+	return true
+}
+
+// 检测GTID集合格式是否正确
+func (this *BaseConfig) checkGTID() error {
+	if this.HaveStartGTIDInfo() {
+		if _, err := mysql.ParseMysqlGTIDSet(this.StartGTID); err != nil {
+			return fmt.Errorf("开始GTID集合格式错误 %s. %v", this.StartGTID, err)
+		}
+	}
+	if this.HaveEndGTIDInfo() {
+		if _, err := mysql.ParseMysqlGTIDSet(this.EndGTID); err != nil {
+			return fmt.Errorf("结束GTID集合格式错误 %s. %v", this.EndGTID, err)
+		}
+		// 通过位点或时间开始时, 解析的GTID集合只包含解析过的事务, 永远不会包含结束GTID集合
+		if !this.HaveStartGTIDInfo() {
+			return fmt.Errorf("指定结束GTID集合(--end-gtid)时需要指定开始GTID集合(--start-gtid)")
+		}
+	}
+
+	return nil
+}
+
+// 是否有开始时间信息
+func (this *BaseConfig) HaveStartTimeInfo() bool {
+	if this.StartDatetime == "" {
+		return false
+	}
+	return true
+}
+
+// 是否有结束时间信息
+func (this *BaseConfig) HaveStopTimeInfo() bool {
+	if this.StopDatetime == "" {
+		return false
+	}
+	return true
+}
+
+// 获取开始时间, 使用本地时区
+func (this *BaseConfig) StartTime() (time.Time, error) {
+	return time.ParseInLocation(utils.TIME_FORMAT, this.StartDatetime, time.Local)
+}
+
+// 获取结束时间, 使用本地时区
+func (this *BaseConfig) StopTime() (time.Time, error) {
+	return time.ParseInLocation(utils.TIME_FORMAT, this.StopDatetime, time.Local)
+}
+
+// 检测开始时间和结束时间格式是否正确, 开始时间需要小于结束时间
+func (this *BaseConfig) checkDatetime() error {
+	var startTime, stopTime time.Time
+	var err error
+	if this.HaveStartTimeInfo() {
+		if startTime, err = this.StartTime(); err != nil {
+			return fmt.Errorf("开始时间格式错误 %s, 正确格式: %s. %v", this.StartDatetime, utils.TIME_FORMAT, err)
+		}
+	}
+	if this.HaveStopTimeInfo() {
+		if stopTime, err = this.StopTime(); err != nil {
+			return fmt.Errorf("结束时间格式错误 %s, 正确格式: %s. %v", this.StopDatetime, utils.TIME_FORMAT, err)
+		}
+	}
+	if this.HaveStartTimeInfo() && this.HaveStopTimeInfo() && !startTime.Before(stopTime) {
+		return fmt.Errorf("开始时间 %s 需要小于结束时间 %s", this.StartDatetime, this.StopDatetime)
+	}
+
+	return nil
+}
+
 // 是否所有结束位点信息
 func (this *BaseConfig) HaveEndPosInfo() bool {
 	if this.EndLogFile == "" {
@@ -29,3 +128,11 @@ func (this *BaseConfig) HaveEndPosInfo() bool {
 	}
 	return true
 }
+
+// 是否是离线模式, 离线模式读取本地的binlog文件, 不连接源实例
+func (this *BaseConfig) IsOffline() bool {
+	if this.BinlogDir == "" && len(this.BinlogFiles) == 0 {
+		return false
+	}
+	return true
+}
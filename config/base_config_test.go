@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestBaseConfig_CheckGTID(t *testing.T) {
+	endGTID := "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-100"
+
+	// 通过位点开始时解析的GTID集合不会包含结束GTID集合
+	bc := &BaseConfig{StartLogFile: "mysql-bin.000001", StartLogPos: 4, EndGTID: endGTID}
+	if err := bc.checkGTID(); err == nil {
+		t.Fatal("没有开始GTID集合时指定结束GTID集合应该失败")
+	}
+
+	bc = &BaseConfig{StartGTID: "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23", EndGTID: endGTID}
+	if err := bc.checkGTID(); err != nil {
+		t.Fatal(err)
+	}
+
+	bc = &BaseConfig{StartGTID: "3E11FA47-71CA-11E1-9E33-C80AA9429562:abc"}
+	if err := bc.checkGTID(); err == nil {
+		t.Fatal("开始GTID集合格式错误应该失败")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"github.com/daiguadaidai/haqi/utils"
 	"github.com/siddontang/go-mysql/replication"
 	"strings"
-	"sync"
 )
 
 const (
@@ -33,25 +32,45 @@ type DBConfig struct {
 	MaxIdelConns      int
 	AllowOldPasswords int
 	AutoCommit        bool
+	Socket            string // Unix socket 文件, 指定后通过 socket 链接, host:port 只用于显示
+	TLS               bool   // 使用 TLS 链接
+	TLSCA             string // 校验服务端证书的 CA 文件
+	TLSCert           string // 客户端证书文件
+	TLSKey            string // 客户端私钥文件
+	TLSServerName     string // 校验服务端证书使用的名称, 默认使用 host
+	TLSSkipVerify     bool   // 不校验服务端证书
 }
 
+// 获取 go-sql-driver 的 DSN. 启用了 TLS 需要先调用 RegisterTLSConfig
 func (this *DBConfig) GetDataSource() string {
 	dataSource := fmt.Sprintf(
-		"%v:%v@tcp(%v:%v)/%v?charset=%v&allowOldPasswords=%v&timeout=%vs&autocommit=%v&parseTime=True&loc=Local",
+		"%v:%v@%v/%v?charset=%v&timeout=%vs&autocommit=%v&parseTime=True&loc=Local",
 		this.Username,
 		this.Password,
-		this.Host,
-		this.Port,
+		this.netAddr(),
 		this.Database,
 		this.CharSet,
-		this.AllowOldPasswords,
 		this.Timeout,
 		this.AutoCommit,
 	)
+	if this.AllowOldPasswords != 0 {
+		dataSource += "&allowOldPasswords=1"
+	}
+	if this.EnableTLS() {
+		dataSource += "&tls=" + this.TLSConfigName()
+	}
 
 	return dataSource
 }
 
+// DSN 中的链接地址: tcp(host:port) 或 unix(socket)
+func (this *DBConfig) netAddr() string {
+	if len(this.Socket) != 0 {
+		return fmt.Sprintf("unix(%s)", this.Socket)
+	}
+	return fmt.Sprintf("tcp(%s)", this.Addr())
+}
+
 func (this *DBConfig) Check() error {
 	if strings.TrimSpace(this.Database) == "" {
 		return fmt.Errorf("数据库不能为空")
@@ -60,60 +79,31 @@ func (this *DBConfig) Check() error {
 	return nil
 }
 
-func (this *DBConfig) GetSyncerConfig() replication.BinlogSyncerConfig {
-	return replication.BinlogSyncerConfig{
-		ServerID: utils.RandRangeUint32(100000000, 200000000),
-		Flavor:   "mysql",
-		Host:     this.Host,
-		Port:     uint16(this.Port),
-		User:     this.Username,
-		Password: this.Password,
+// 获取解析 binlog 的 syncer 配置. 指定了 socket 时通过 socket 链接.
+// decimal 解析为 decimal.Decimal, 防止转化为 float64 丢失精度
+func (this *DBConfig) GetSyncerConfig() (replication.BinlogSyncerConfig, error) {
+	tlsConfig, err := this.GetTLSConfig()
+	if err != nil {
+		return replication.BinlogSyncerConfig{}, err
 	}
-}
-
-func (this *DBConfig) Addr() string {
-	return fmt.Sprintf("%s:%d", this.Host, this.Port)
-}
-
-var configMap sync.Map
 
-/* 保存数据库配置信息,
-将配置信息的 host:port 为 key 将配置信息保存到 sync.Map 中
-*/
-func AddDBConfig(dbc *DBConfig) error {
-	if dbc == nil {
-		return fmt.Errorf("配置文件不能为 nil")
+	cfg := replication.BinlogSyncerConfig{
+		ServerID:   utils.RandRangeUint32(100000000, 200000000),
+		Flavor:     "mysql",
+		Host:       this.Host,
+		Port:       uint16(this.Port),
+		User:       this.Username,
+		Password:   this.Password,
+		TLSConfig:  tlsConfig,
+		UseDecimal: true,
+	}
+	if len(this.Socket) != 0 { // host 中包含 / 时 syncer 使用 unix socket 链接
+		cfg.Host = this.Socket
 	}
-	configMap.Store(dbc.Addr(), dbc)
-	return nil
-}
 
-/* 通过host, port 获取数据库配置信息
-Params:
-    _host: ip
-        string-> 127.0.0.1
-    _port: 端口
-        int-> 3306
-*/
-func GetDBConifgByHostPort(host string, port int) (*DBConfig, bool) {
-	key := fmt.Sprintf("%v:%v", host, port)
-	return GetDBConfig(key)
+	return cfg, nil
 }
 
-/* 获取数据库配置文件
-通过给定的 key 从 sync.Map 中获取一个数据库配置信息
-Params:
-    _dynamicKey: 获取配置文件的key
-        string-> 127.0.0.1:3306
-Return:
-    *setting.DBConfig: 数据库配置信息
-    bool: 是否获取成功
-*/
-func GetDBConfig(key string) (*DBConfig, bool) {
-	dbConfigInterface, ok := configMap.Load(key)
-	if !ok {
-		return nil, ok
-	}
-
-	return dbConfigInterface.(interface{}).(*DBConfig), ok
+func (this *DBConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", this.Host, this.Port)
 }
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 是否使用 TLS 链接. 指定了 CA, 客户端证书时也使用 TLS
+func (this *DBConfig) EnableTLS() bool {
+	return this.TLS || len(this.TLSCA) != 0 || len(this.TLSCert) != 0 || len(this.TLSKey) != 0
+}
+
+// 获取 TLS 配置, 没有启用 TLS 返回 nil.
+// 没有指定 CA 使用系统的根证书, 没有指定 server name 使用 host 校验服务端证书
+func (this *DBConfig) GetTLSConfig() (*tls.Config, error) {
+	if !this.EnableTLS() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         this.TLSServerName,
+		InsecureSkipVerify: this.TLSSkipVerify,
+	}
+	if len(tlsConfig.ServerName) == 0 {
+		tlsConfig.ServerName = this.Host
+	}
+
+	if len(this.TLSCA) != 0 {
+		pem, err := ioutil.ReadFile(this.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 文件失败. %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 文件 %s 中没有可用的证书", this.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(this.TLSCert) != 0 || len(this.TLSKey) != 0 {
+		if len(this.TLSCert) == 0 || len(this.TLSKey) == 0 {
+			return nil, fmt.Errorf("客户端证书和私钥需要同时指定. 证书: %s, 私钥: %s", this.TLSCert, this.TLSKey)
+		}
+		cert, err := tls.LoadX509KeyPair(this.TLSCert, this.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败. %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// TLS 配置在 go-sql-driver 中注册的名称, 相同的配置名称相同. 没有启用 TLS 返回空
+func (this *DBConfig) TLSConfigName() string {
+	if !this.EnableTLS() {
+		return ""
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%v", this.Host, this.TLSCA, this.TLSCert,
+		this.TLSKey, this.TLSServerName, this.TLSSkipVerify)))
+	return fmt.Sprintf("haqi-%x", sum[:8])
+}
+
+// 在 go-sql-driver 中注册 TLS 配置, 使用 GetDataSource 链接之前调用
+func (this *DBConfig) RegisterTLSConfig() error {
+	tlsConfig, err := this.GetTLSConfig()
+	if err != nil || tlsConfig == nil {
+		return err
+	}
+	if err = mysql.RegisterTLSConfig(this.TLSConfigName(), tlsConfig); err != nil {
+		return fmt.Errorf("注册 TLS 配置失败. %v", err)
+	}
+
+	return nil
+}
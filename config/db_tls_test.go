@@ -0,0 +1,122 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 生成自签名的证书和私钥文件
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "haqi-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestDBConfig_GetTLSConfig(t *testing.T) {
+	dbc := &DBConfig{Host: "mysql.example.com", Port: 3306}
+	if tlsConfig, err := dbc.GetTLSConfig(); err != nil || tlsConfig != nil {
+		t.Fatalf("没有启用 TLS: %v, %v", tlsConfig, err)
+	}
+	if strings.Contains(dbc.GetDataSource(), "tls=") {
+		t.Fatalf("没有启用 TLS 的 DSN: %s", dbc.GetDataSource())
+	}
+
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	dbc.TLSCA, dbc.TLSCert, dbc.TLSKey = certFile, certFile, keyFile
+	tlsConfig, err := dbc.GetTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != dbc.Host || tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("TLS 配置错误: %+v", tlsConfig)
+	}
+	if err = dbc.RegisterTLSConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dbc.GetDataSource(), "@tcp(mysql.example.com:3306)/") ||
+		!strings.HasSuffix(dbc.GetDataSource(), "&tls="+dbc.TLSConfigName()) {
+		t.Fatalf("DSN 错误: %s", dbc.GetDataSource())
+	}
+	syncerConfig, err := dbc.GetSyncerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncerConfig.TLSConfig == nil || syncerConfig.Host != dbc.Host {
+		t.Fatalf("syncer 配置错误: %+v", syncerConfig)
+	}
+
+	dbc.TLSServerName = "db1"
+	tlsConfig, err = dbc.GetTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "db1" {
+		t.Fatalf("server name: %s", tlsConfig.ServerName)
+	}
+
+	// 证书和私钥需要同时指定
+	dbc.TLSKey = ""
+	if _, err = dbc.GetTLSConfig(); err == nil {
+		t.Fatal("只指定证书应该失败")
+	}
+	dbc.TLSCert = ""
+	dbc.TLSCA = keyFile
+	if _, err = dbc.GetTLSConfig(); err == nil {
+		t.Fatal("CA 文件中没有证书应该失败")
+	}
+}
+
+func TestDBConfig_Socket(t *testing.T) {
+	dbc := &DBConfig{Host: "127.0.0.1", Port: 3306, Socket: "/tmp/mysql.sock"}
+	if !strings.Contains(dbc.GetDataSource(), "@unix(/tmp/mysql.sock)/") {
+		t.Fatalf("DSN 错误: %s", dbc.GetDataSource())
+	}
+	syncerConfig, err := dbc.GetSyncerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncerConfig.Host != dbc.Socket {
+		t.Fatalf("syncer 应该使用 socket 链接: %s", syncerConfig.Host)
+	}
+	if !syncerConfig.UseDecimal {
+		t.Fatal("syncer 应该将 decimal 解析为 decimal.Decimal")
+	}
+}
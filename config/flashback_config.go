@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	FLASHBACK_ENABLE_TRANS_UPDATE = true
+	FLASHBACK_ENABLE_TRANS_INSERT = true
+	FLASHBACK_ENABLE_TRANS_DELETE = true
+)
+
+type FlashbackConfig struct {
+	ToMySQLConfig
+	SQLFile string // 回滚sql输出文件
+	Execute bool   // 是否直接在目标实例执行回滚sql
+}
+
+func (this *FlashbackConfig) Check() error {
+	if err := this.checkGTID(); err != nil {
+		return err
+	}
+	if err := this.checkDatetime(); err != nil {
+		return err
+	}
+	if !this.HaveStartPosInfo() && !this.HaveStartGTIDInfo() && !this.HaveStartTimeInfo() && !this.IsOffline() {
+		return fmt.Errorf("没有指定开始位点")
+	}
+	// 回滚sql需要倒序输出, 必须有明确的结束位点
+	if !this.HaveEndPosInfo() && !this.HaveEndGTIDInfo() && !this.HaveStopTimeInfo() && !this.IsOffline() {
+		return fmt.Errorf("没有指定结束位点")
+	}
+	if this.HaveStartPosInfo() && this.HaveEndPosInfo() {
+		if err := checkStartLessThanEnd(&this.BaseConfig); err != nil {
+			return err
+		}
+	}
+
+	if len(this.SQLFile) == 0 && !this.Execute {
+		return fmt.Errorf("没有指定回滚sql输出文件, 也没有指定在目标实例执行")
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	DEFAULT_LISTEN_ADDR     = "127.0.0.1:19529"
+	DEFAULT_STREAM_INTERVAL = time.Second // 推送任务位点信息的默认间隔
+)
+
+// HTTP 控制服务配置
+type ServeConfig struct {
+	ListenAddr string // 监听地址
+}
+
+func (this *ServeConfig) Check() error {
+	if _, _, err := net.SplitHostPort(this.ListenAddr); err != nil {
+		return fmt.Errorf("监听地址格式错误 %s, 正确格式: host:port. %v", this.ListenAddr, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,451 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 任务配置文件. 键为 tomysql 的命令行参数名, 嵌套的键使用 - 连接, 如:
+//
+//	start-log-file: mysql-bin.000090
+//	trans-table:
+//	  - schema2.table1
+//	ori-db:
+//	  host: 127.0.0.1
+//	  password: ${ORI_DB_PASSWORD}
+//
+// TOML 格式中的表名也作为前缀: [ori-db] 下的 host 对应 ori-db-host. 键中的 _ 等同于 -.
+// 字符串中的 ${NAME} 替换为环境变量的值, ${NAME:-default} 在环境变量为空时使用默认值.
+// 只支持配置任务需要的格式: YAML 的映射, 列表和单行的值; TOML 的表, 键值对和数组
+
+// 任务配置文件中的参数, 键为参数名, 列表的值有多个
+type TaskFileValues map[string][]string
+
+// 读取任务配置文件, 通过扩展名判断格式: .yaml, .yml, .toml
+func LoadTaskFile(path string) (TaskFileValues, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败. %v", err)
+	}
+
+	var values TaskFileValues
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAMLTaskFile(string(data))
+	case ".toml":
+		values, err = parseTOMLTaskFile(string(data))
+	default:
+		return nil, fmt.Errorf("不能识别的配置文件格式: %s. 支持: .yaml, .yml, .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("配置文件 %s 格式错误. %v", path, err)
+	}
+
+	return values, nil
+}
+
+func (this TaskFileValues) set(key string, values []string) error {
+	key = strings.ToLower(strings.Replace(key, "_", "-", -1))
+	if _, ok := this[key]; ok {
+		return fmt.Errorf("参数 %s 重复指定", key)
+	}
+	for i, value := range values {
+		expanded, err := expandEnv(value)
+		if err != nil {
+			return fmt.Errorf("参数 %s: %v", key, err)
+		}
+		values[i] = expanded
+	}
+	this[key] = values
+
+	return nil
+}
+
+// 替换 ${NAME} 和 ${NAME:-default} 为环境变量的值, 没有默认值的环境变量需要存在, $$ 为 $
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '$' {
+			sb.WriteByte('$')
+			i++
+			continue
+		}
+		if i+1 >= len(s) || s[i+1] != '{' {
+			sb.WriteByte('$')
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("环境变量没有结束的 }: %s", s)
+		}
+		expr := s[i+2 : i+end]
+		name, def, hasDef := expr, "", false
+		if idx := strings.Index(expr, ":-"); idx >= 0 {
+			name, def, hasDef = expr[:idx], expr[idx+2:], true
+		}
+		value, ok := os.LookupEnv(name)
+		switch {
+		case ok && (len(value) != 0 || !hasDef):
+			sb.WriteString(value)
+		case hasDef:
+			sb.WriteString(def)
+		default:
+			return "", fmt.Errorf("环境变量 %s 不存在", name)
+		}
+		i += end
+	}
+
+	return sb.String(), nil
+}
+
+// 去掉 # 开始的注释, 引号中的 # 不是注释
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// 解析带引号的字符串, 返回字符串和引号之后的内容
+func parseQuoted(s string) (string, string, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == quote {
+			// YAML 单引号中 '' 代表一个 '
+			if quote == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				sb.WriteByte('\'')
+				i++
+				continue
+			}
+			return sb.String(), s[i+1:], nil
+		}
+		if c != '\\' || quote != '"' {
+			sb.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(s) {
+			break
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '"', '\\', '/':
+			sb.WriteByte(s[i])
+		default:
+			return "", "", fmt.Errorf("不支持的转义字符 \\%c", s[i])
+		}
+	}
+
+	return "", "", fmt.Errorf("字符串没有结束的引号: %s", s)
+}
+
+// 解析一个值, 带引号的字符串去掉引号
+func parseScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+		return s, nil
+	}
+	value, rest, err := parseQuoted(s)
+	if err != nil {
+		return "", err
+	}
+	if len(strings.TrimSpace(rest)) != 0 {
+		return "", fmt.Errorf("字符串之后有多余的内容: %s", s)
+	}
+
+	return value, nil
+}
+
+// 解析 [a, "b", c] 格式的数组
+func parseFlowList(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("数组格式错误: %s", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+
+	values := make([]string, 0)
+	for len(s) != 0 {
+		var item string
+		if s[0] == '"' || s[0] == '\'' {
+			value, rest, err := parseQuoted(s)
+			if err != nil {
+				return nil, err
+			}
+			item, s = value, strings.TrimSpace(rest)
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			item, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		values = append(values, item)
+		if len(s) == 0 {
+			break
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("数组元素之间需要使用逗号分隔: %s", s)
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+
+	return values, nil
+}
+
+type yamlLine struct {
+	no     int // 行号
+	indent int
+	text   string
+}
+
+// 解析 YAML 格式的任务配置文件
+func parseYAMLTaskFile(content string) (TaskFileValues, error) {
+	lines := make([]yamlLine, 0)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(stripComment(strings.TrimRight(line, "\r")), " \t")
+		text := strings.TrimLeft(line, " ")
+		if len(text) == 0 || text == "---" {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, fmt.Errorf("第 %d 行: 不能使用 tab 缩进", i+1)
+		}
+		lines = append(lines, yamlLine{no: i + 1, indent: len(line) - len(text), text: text})
+	}
+
+	values := make(TaskFileValues)
+	if len(lines) == 0 {
+		return values, nil
+	}
+	next, err := parseYAMLMapping(lines, 0, lines[0].indent, "", values)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("第 %d 行: 缩进错误", lines[next].no)
+	}
+
+	return values, nil
+}
+
+// 解析缩进为 indent 的映射, 返回下一个需要解析的行
+func parseYAMLMapping(lines []yamlLine, i int, indent int, prefix string, values TaskFileValues) (int, error) {
+	for i < len(lines) && lines[i].indent >= indent {
+		line := lines[i]
+		if line.indent > indent {
+			return i, fmt.Errorf("第 %d 行: 缩进错误", line.no)
+		}
+		if strings.HasPrefix(line.text, "- ") || line.text == "-" {
+			return i, fmt.Errorf("第 %d 行: 列表需要在参数名之后", line.no)
+		}
+
+		idx := strings.Index(line.text, ": ")
+		if strings.HasSuffix(line.text, ":") && (idx < 0 || idx == len(line.text)-1) {
+			idx = len(line.text) - 1
+		}
+		if idx <= 0 {
+			return i, fmt.Errorf("第 %d 行: 需要 key: value 格式", line.no)
+		}
+		key := prefix + strings.TrimSpace(line.text[:idx])
+		value := strings.TrimSpace(line.text[idx+1:])
+		i++
+
+		if len(value) != 0 {
+			var err error
+			if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+				return i, fmt.Errorf("第 %d 行: 不支持多行字符串", line.no)
+			}
+			items := []string{value}
+			if strings.HasPrefix(value, "[") {
+				items, err = parseFlowList(value)
+			} else {
+				items[0], err = parseScalar(value)
+			}
+			if err == nil {
+				err = values.set(key, items)
+			}
+			if err != nil {
+				return i, fmt.Errorf("第 %d 行: %v", line.no, err)
+			}
+			continue
+		}
+
+		// 没有值: 之后缩进更多的行为列表或者嵌套的映射, 否则为空值
+		if i >= len(lines) || lines[i].indent <= indent {
+			if err := values.set(key, []string{""}); err != nil {
+				return i, fmt.Errorf("第 %d 行: %v", line.no, err)
+			}
+			continue
+		}
+		var err error
+		if strings.HasPrefix(lines[i].text, "- ") || lines[i].text == "-" {
+			i, err = parseYAMLList(lines, i, lines[i].indent, key, values)
+		} else {
+			i, err = parseYAMLMapping(lines, i, lines[i].indent, key+"-", values)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return i, nil
+}
+
+// 解析缩进为 indent 的列表, 列表元素只能是单个的值
+func parseYAMLList(lines []yamlLine, i int, indent int, key string, values TaskFileValues) (int, error) {
+	items := make([]string, 0)
+	first := lines[i].no
+	for i < len(lines) && lines[i].indent == indent && (strings.HasPrefix(lines[i].text, "- ") || lines[i].text == "-") {
+		item, err := parseScalar(strings.TrimPrefix(lines[i].text, "-"))
+		if err != nil {
+			return i, fmt.Errorf("第 %d 行: %v", lines[i].no, err)
+		}
+		items = append(items, item)
+		i++
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return i, fmt.Errorf("第 %d 行: 列表元素只能是单个的值", lines[i].no)
+	}
+	if err := values.set(key, items); err != nil {
+		return i, fmt.Errorf("第 %d 行: %v", first, err)
+	}
+
+	return i, nil
+}
+
+// 解析 TOML 格式的任务配置文件
+func parseTOMLTaskFile(content string) (TaskFileValues, error) {
+	values := make(TaskFileValues)
+	prefix := ""
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		no := i + 1
+		line := strings.TrimSpace(stripComment(strings.TrimRight(lines[i], "\r")))
+		if len(line) == 0 {
+			continue
+		}
+
+		// 表名
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("第 %d 行: 表名格式错误: %s", no, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if len(name) == 0 {
+				return nil, fmt.Errorf("第 %d 行: 表名不能为空", no)
+			}
+			prefix = strings.Replace(name, ".", "-", -1) + "-"
+			continue
+		}
+
+		idx := strings.IndexByte(line, '=')
+		if idx <= 0 {
+			return nil, fmt.Errorf("第 %d 行: 需要 key = value 格式", no)
+		}
+		key, err := parseScalar(line[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", no, err)
+		}
+		key = prefix + strings.Replace(key, ".", "-", -1)
+		value := strings.TrimSpace(line[idx+1:])
+		if len(value) == 0 {
+			return nil, fmt.Errorf("第 %d 行: 参数 %s 没有值", no, key)
+		}
+
+		var items []string
+		if value[0] == '[' {
+			// 数组可以有多行, 合并到数组结束
+			for !tomlArrayClosed(value) && i+1 < len(lines) {
+				i++
+				value += " " + strings.TrimSpace(stripComment(strings.TrimRight(lines[i], "\r")))
+			}
+			items, err = parseFlowList(value)
+		} else {
+			var item string
+			item, err = parseTOMLScalar(value)
+			items = []string{item}
+		}
+		if err == nil {
+			err = values.set(key, items)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", no, err)
+		}
+	}
+
+	return values, nil
+}
+
+// TOML 的值: 字符串需要引号, 其他为数字和布尔值
+func parseTOMLScalar(s string) (string, error) {
+	if s[0] == '"' || s[0] == '\'' {
+		return parseScalar(s)
+	}
+	if s == "true" || s == "false" {
+		return s, nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(s, "_", "", -1), 64); err != nil {
+		return "", fmt.Errorf("不能识别的值 %s, 字符串需要使用引号", s)
+	}
+
+	return strings.Replace(s, "_", "", -1), nil
+}
+
+// 数组是否已经结束(引号外的 [ 和 ] 个数相同)
+func tomlArrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth == 0
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTaskFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTaskFile_YAML(t *testing.T) {
+	os.Setenv("HAQI_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("HAQI_TEST_PASSWORD")
+
+	path := writeTaskFile(t, "task.yaml", `
+# 注释
+start-log-file: mysql-bin.000090
+enable_checkpoint: true
+trans-table:
+  - schema2.table1
+  - "schema2.table2"
+row-filter: ["schema1.t1:id > 10, name = 'a'"]
+ori-db:
+  host: 127.0.0.1 # 源实例
+  port: 3306
+  password: ${HAQI_TEST_PASSWORD}
+std-db:
+  username: ${HAQI_TEST_USER:-root}
+  password: '$$abc'
+`)
+	values, err := LoadTaskFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := TaskFileValues{
+		"start-log-file":    {"mysql-bin.000090"},
+		"enable-checkpoint": {"true"},
+		"trans-table":       {"schema2.table1", "schema2.table2"},
+		"row-filter":        {"schema1.t1:id > 10, name = 'a'"},
+		"ori-db-host":       {"127.0.0.1"},
+		"ori-db-port":       {"3306"},
+		"ori-db-password":   {"secret"},
+		"std-db-username":   {"root"},
+		"std-db-password":   {"$abc"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("解析结果: %v, 期望: %v", values, expected)
+	}
+}
+
+func TestLoadTaskFile_TOML(t *testing.T) {
+	path := writeTaskFile(t, "task.toml", `
+start-log-file = "mysql-bin.000090"
+trans-table = [
+  "schema2.table1", # 第一个表
+  "schema2.table2",
+]
+
+[ori-db]
+host = "127.0.0.1"
+port = 3306
+`)
+	values, err := LoadTaskFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := TaskFileValues{
+		"start-log-file": {"mysql-bin.000090"},
+		"trans-table":    {"schema2.table1", "schema2.table2"},
+		"ori-db-host":    {"127.0.0.1"},
+		"ori-db-port":    {"3306"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("解析结果: %v, 期望: %v", values, expected)
+	}
+}
+
+func TestLoadTaskFile_Error(t *testing.T) {
+	os.Unsetenv("HAQI_TEST_MISSING")
+	cases := map[string]string{
+		"task.json": `{}`,
+		"env.yaml":  "ori-db-password: ${HAQI_TEST_MISSING}\n",
+		"dup.yaml":  "ori-db-host: a\nori-db:\n  host: b\n",
+		"dup.toml":  "start_log_file = \"a\"\nstart-log-file = \"b\"\n",
+		"bad.toml":  "trans-table = [\"a\"\n",
+	}
+	for name, content := range cases {
+		if _, err := LoadTaskFile(writeTaskFile(t, name, content)); err == nil {
+			t.Errorf("%s 应该解析失败", name)
+		}
+	}
+}
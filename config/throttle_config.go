@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_THROTTLE_INTERVAL = time.Second // 检测是否需要限流的默认间隔
+)
+
+// 应用数据的限流配置. 目标实例负载过高时暂停应用, 并限制应用的速度
+type ThrottleConfig struct {
+	MaxThreadsRunning int           // 目标实例 Threads_running 超过该值时暂停应用, 0: 不检测
+	MaxReplicaLag     time.Duration // 目标实例从库延迟超过该值时暂停应用, 0: 不检测
+	ThrottleReplicas  []string      // 需要检测延迟的目标实例从库地址(host:port), 使用目标实例的用户名和密码
+	ThrottleFlagFile  string        // 该文件存在时暂停应用
+	ThrottleQuery     string        // 在目标实例执行的查询, 返回的第一个值大于0时暂停应用
+	ThrottleInterval  time.Duration // 检测是否需要限流的间隔
+	MaxRowsPerSecond  int           // 每秒最多应用的行数, 0: 不限制
+	MaxBatchRows      int           // 一次最多应用的行数, row event 中的数据超过该值时分多次应用. 0: 不限制
+}
+
+// 是否需要定时检测目标实例的负载
+func (this *ThrottleConfig) EnableThrottleCheck() bool {
+	return this.MaxThreadsRunning > 0 || this.MaxReplicaLag > 0 ||
+		len(this.ThrottleFlagFile) != 0 || len(this.ThrottleQuery) != 0
+}
+
+func (this *ThrottleConfig) checkThrottle() error {
+	if this.MaxThreadsRunning < 0 {
+		return fmt.Errorf("限流的 Threads_running 阈值 %d 不能小于0", this.MaxThreadsRunning)
+	}
+	if this.MaxReplicaLag < 0 {
+		return fmt.Errorf("限流的从库延迟阈值 %s 不能小于0", this.MaxReplicaLag)
+	}
+	if this.MaxReplicaLag > 0 && len(this.ThrottleReplicas) == 0 {
+		return fmt.Errorf("检测从库延迟需要指定从库地址. 如: --throttle-replicas=127.0.0.1:3308")
+	}
+	for _, addr := range this.ThrottleReplicas {
+		if _, _, err := SplitReplicaAddr(addr); err != nil {
+			return err
+		}
+	}
+	if this.EnableThrottleCheck() && this.ThrottleInterval <= 0 {
+		return fmt.Errorf("检测限流的间隔 %s 需要大于0", this.ThrottleInterval)
+	}
+	if this.MaxRowsPerSecond < 0 {
+		return fmt.Errorf("每秒应用的行数 %d 不能小于0", this.MaxRowsPerSecond)
+	}
+	if this.MaxBatchRows < 0 {
+		return fmt.Errorf("一次应用的行数 %d 不能小于0", this.MaxBatchRows)
+	}
+
+	return nil
+}
+
+// 解析从库地址 host:port
+func SplitReplicaAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("从库地址格式错误 %s, 正确格式: host:port. %v", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return "", 0, fmt.Errorf("从库地址端口错误 %s", addr)
+	}
+
+	return host, port, nil
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/models"
+	"os"
+	"time"
 )
 
 const (
@@ -10,6 +13,54 @@ const (
 	ENABLE_TRANS_INSERT   = false
 	ENABLE_TRANS_DELETE   = true
 	DEFAULT_SCHEMA_SUFFIX = "_archive"
+
+	DEFAULT_CHECKPOINT_SCHEMA = "haqi" // 目标实例中保存checkpoint的数据库
+	DEFAULT_WORKERS           = 1      // 默认的应用线程数
+
+	ENV_HASH_KEY = "HAQI_HASH_KEY" // 没有指定 hash-key 时, 从该环境变量获取 sha256 字段处理的密钥
+)
+
+// 各类事件的应用方式
+const (
+	INSERT_MODE_INSERT  = "insert"  // insert 事件使用 INSERT 写入
+	INSERT_MODE_REPLACE = "replace" // insert 事件使用 REPLACE 写入
+	UPDATE_MODE_UPDATE  = "update"  // update 事件使用 UPDATE ... WHERE <pk> 应用
+	UPDATE_MODE_REPLACE = "replace" // update 事件使用 REPLACE 写入修改后的数据
+	DELETE_MODE_ARCHIVE = "archive" // delete 事件将删除前的数据 INSERT 到目标表(归档)
+	DELETE_MODE_DELETE  = "delete"  // delete 事件使用 DELETE ... WHERE <pk> 应用
+
+	DEFAULT_INSERT_MODE = INSERT_MODE_INSERT
+	DEFAULT_UPDATE_MODE = UPDATE_MODE_UPDATE
+	DEFAULT_DELETE_MODE = DELETE_MODE_ARCHIVE
+
+	DEFAULT_INSERT_BATCH_ROWS     = 1000                   // 合并的 INSERT 默认最大行数
+	DEFAULT_INSERT_BATCH_BYTES    = 1024 * 1024            // 合并的 INSERT 默认最大大小(字节), 需要小于 max_allowed_packet
+	DEFAULT_INSERT_BATCH_INTERVAL = 100 * time.Millisecond // 合并的 INSERT 默认最长等待时间
+)
+
+// 会删除数据的DDL(DROP TABLE, TRUNCATE TABLE, ALTER TABLE ... DROP COLUMN)在目标实例的处理方式
+const (
+	DDL_POLICY_APPLY  = "apply"  // 在目标实例执行
+	DDL_POLICY_IGNORE = "ignore" // 不执行, 目标实例保留被删除的表和字段
+	DDL_POLICY_ABORT  = "abort"  // 停止应用, 需要人工处理
+
+	DEFAULT_REPLICATE_DDL = true
+	DEFAULT_DDL_POLICY    = DDL_POLICY_IGNORE
+)
+
+// 应用的数据写入的目标
+const (
+	SINK_MYSQL      = "mysql"    // 写入目标实例
+	SINK_SQL_FILE   = "sql-file" // 将生成的sql写入文件
+	SINK_JSON_LINES = "jsonl"    // 每一行数据的修改输出为一个 JSON 对象(JSON Lines)
+	SINK_CSV        = "csv"      // 每个表的修改输出到各自的 CSV 文件
+	SINK_KAFKA      = "kafka"    // 每一行数据的修改作为一条消息发送到 kafka, topic 为 <prefix><schema>.<table>
+
+	DEFAULT_SINK               = SINK_MYSQL
+	DEFAULT_OUTPUT_DIR         = "."
+	DEFAULT_OUTPUT_PREFIX      = "haqi"
+	DEFAULT_OUTPUT_MAX_SIZE    = 256 // 输出文件最大大小(MB)
+	DEFAULT_KAFKA_TOPIC_PREFIX = "haqi."
 )
 
 var sc *ToMySQLConfig
@@ -17,6 +68,71 @@ var sc *ToMySQLConfig
 type ToMySQLConfig struct {
 	BaseConfig
 	APIConfig
+	ThrottleConfig
+	InsertMode string // insert 事件应用方式
+	UpdateMode string // update 事件应用方式
+	DeleteMode string // delete 事件应用方式
+
+	TableRoutes []string // 目标表名路由规则, 格式: <源库>.<源表> -> <目标库>.<目标表>, 没有匹配的表使用源库名加上后缀
+	ColumnRules []string // 字段处理规则, 格式: schema.table: <action> <args>, 写入目标前删除, 修改名称, 脱敏和添加字段
+	HashKey     string   // 字段处理规则中 sha256 使用的 HMAC-SHA256 密钥, 为空则使用环境变量 HAQI_HASH_KEY
+
+	InsertBatchRows     int           // 一个源事务中同一个表的 INSERT/REPLACE 合并为一个语句的最大行数, 0: 不限制
+	InsertBatchBytes    int           // 合并的语句的最大大小(字节), 0: 不限制
+	InsertBatchInterval time.Duration // 合并的数据最长等待时间, 0: 不限制(事务结束时执行)
+
+	Checkpoint       bool   // 是否在目标实例中保存应用完成的位点(checkpoint), 需要指定 task uuid
+	CheckpointSchema string // 目标实例中保存checkpoint的数据库
+	Resume           bool   // 是否从上次应用完成的checkpoint继续执行
+	Workers          int    // 应用线程数
+
+	ReplicateDDL bool   // 是否将需要执行的表的DDL应用到目标实例
+	DDLPolicy    string // 会删除数据的DDL在目标实例的处理方式
+
+	AuditColumns bool // 目标表是否添加记录数据来源(binlog位点, 时间, 线程ID, GTID, 操作类型)的审计字段
+
+	Sink          string // 应用的数据写入的目标
+	OutputDir     string // 写入文件的目录
+	OutputPrefix  string // 写入文件的文件名前缀
+	OutputMaxSize int64  // 写入文件的最大大小(MB), 超过后切换到新的文件
+	DryRun        bool   // 不修改目标实例(不创建和修复目标表)
+
+	OutputRotateInterval time.Duration // 写入文件的最长时间, 超过后切换到新的文件
+
+	KafkaBrokers     []string // kafka broker 地址
+	KafkaTopicPrefix string   // kafka topic 前缀
+
+	MetricsAddr string // 监控指标(/metrics) HTTP 监听地址, 为空则不启动
+}
+
+// 应用的数据是否写入目标实例
+func (this *ToMySQLConfig) IsMySQLSink() bool {
+	return len(this.Sink) == 0 || this.Sink == SINK_MYSQL
+}
+
+// 目标表是否允许保留源表中已经删除的字段
+func (this *ToMySQLConfig) KeepDroppedColumns() bool {
+	return !this.ReplicateDDL || this.DDLPolicy != DDL_POLICY_APPLY
+}
+
+// 是否需要在目标实例中保存checkpoint, 指定了 checkpoint 或者 resume 并且指定了 task uuid 才保存.
+// 写入 kafka 时, 消息发送成功后在目标实例中保存已经发送完成的位点
+func (this *ToMySQLConfig) EnableCheckpoint() bool {
+	if !this.Checkpoint && !this.Resume {
+		return false
+	}
+	if len(this.TaskUUID) == 0 || len(this.CheckpointSchema) == 0 || this.DryRun {
+		return false
+	}
+	return this.IsMySQLSink() || this.Sink == SINK_KAFKA
+}
+
+// 字段处理规则中 sha256 使用的密钥, 没有指定 hash-key 时使用环境变量中的密钥
+func (this *ToMySQLConfig) GetHashKey() string {
+	if len(this.HashKey) != 0 {
+		return this.HashKey
+	}
+	return os.Getenv(ENV_HASH_KEY)
 }
 
 func SetToMySQLConfig(cfg *ToMySQLConfig) {
@@ -24,6 +140,31 @@ func SetToMySQLConfig(cfg *ToMySQLConfig) {
 }
 
 func (this *ToMySQLConfig) Check() error {
+	if err := this.checkMode(); err != nil {
+		return err
+	}
+
+	if this.Workers < 1 {
+		return fmt.Errorf("应用线程数 %d 不能小于1", this.Workers)
+	}
+
+	if this.InsertBatchRows < 0 || this.InsertBatchBytes < 0 || this.InsertBatchInterval < 0 {
+		return fmt.Errorf("合并 INSERT 的行数 %d, 大小 %d, 等待时间 %s 不能小于0",
+			this.InsertBatchRows, this.InsertBatchBytes, this.InsertBatchInterval)
+	}
+
+	if err := this.checkSink(); err != nil {
+		return err
+	}
+
+	if err := this.checkResume(); err != nil {
+		return err
+	}
+
+	if err := this.checkThrottle(); err != nil {
+		return err
+	}
+
 	if err := this.checkCondition(); err != nil {
 		return err
 	}
@@ -31,30 +172,119 @@ func (this *ToMySQLConfig) Check() error {
 	return nil
 }
 
+// 检测各类事件的应用方式
+func (this *ToMySQLConfig) checkMode() error {
+	switch this.InsertMode {
+	case INSERT_MODE_INSERT, INSERT_MODE_REPLACE:
+	default:
+		return fmt.Errorf("不能识别的 insert 应用方式: %s. 可选值: %s, %s",
+			this.InsertMode, INSERT_MODE_INSERT, INSERT_MODE_REPLACE)
+	}
+
+	switch this.UpdateMode {
+	case UPDATE_MODE_UPDATE, UPDATE_MODE_REPLACE:
+	default:
+		return fmt.Errorf("不能识别的 update 应用方式: %s. 可选值: %s, %s",
+			this.UpdateMode, UPDATE_MODE_UPDATE, UPDATE_MODE_REPLACE)
+	}
+
+	switch this.DeleteMode {
+	case DELETE_MODE_ARCHIVE, DELETE_MODE_DELETE:
+	default:
+		return fmt.Errorf("不能识别的 delete 应用方式: %s. 可选值: %s, %s",
+			this.DeleteMode, DELETE_MODE_ARCHIVE, DELETE_MODE_DELETE)
+	}
+
+	switch this.DDLPolicy {
+	case DDL_POLICY_APPLY, DDL_POLICY_IGNORE, DDL_POLICY_ABORT:
+	default:
+		return fmt.Errorf("不能识别的DDL处理方式: %s. 可选值: %s, %s, %s",
+			this.DDLPolicy, DDL_POLICY_APPLY, DDL_POLICY_IGNORE, DDL_POLICY_ABORT)
+	}
+
+	return nil
+}
+
+// 检测数据写入的目标
+func (this *ToMySQLConfig) checkSink() error {
+	switch this.Sink {
+	case SINK_MYSQL:
+		if this.DryRun {
+			return fmt.Errorf("dry-run 模式不能写入目标实例, 请指定写入文件. 如: --sink=%s", SINK_SQL_FILE)
+		}
+		return nil
+	case SINK_KAFKA:
+		if len(this.KafkaBrokers) == 0 {
+			return fmt.Errorf("写入 kafka 需要指定 broker 地址. 如: --kafka-brokers=127.0.0.1:9092")
+		}
+		return nil
+	case SINK_SQL_FILE, SINK_JSON_LINES, SINK_CSV:
+	default:
+		return fmt.Errorf("不能识别的写入目标: %s. 可选值: %s, %s, %s, %s, %s",
+			this.Sink, SINK_MYSQL, SINK_SQL_FILE, SINK_JSON_LINES, SINK_CSV, SINK_KAFKA)
+	}
+
+	if len(this.OutputDir) == 0 || len(this.OutputPrefix) == 0 {
+		return fmt.Errorf("写入文件需要指定输出目录和文件名前缀")
+	}
+	if this.OutputMaxSize < 0 {
+		return fmt.Errorf("输出文件最大大小 %d 不能小于0", this.OutputMaxSize)
+	}
+	if this.OutputRotateInterval < 0 {
+		return fmt.Errorf("输出文件切换时间 %s 不能小于0", this.OutputRotateInterval)
+	}
+
+	return nil
+}
+
+func (this *ToMySQLConfig) checkResume() error {
+	if this.Resume && !this.EnableCheckpoint() {
+		return fmt.Errorf("从checkpoint继续执行需要指定 task uuid 和 checkpoint 数据库, 并且写入目标实例或 kafka")
+	}
+	// 多个应用线程时一个源事务拆分成多个目标实例事务分别提交, 出错或者停止时目标实例中可能只应用了源事务的一部分
+	if this.Workers > 1 && this.EnableCheckpoint() {
+		return fmt.Errorf("多个应用线程(%d)时源事务在目标实例中不是原子提交的, 不能保存checkpoint和继续执行. "+
+			"请使用 --workers=1 或者不指定 --checkpoint 和 --resume", this.Workers)
+	}
+
+	return nil
+}
+
 func (this *ToMySQLConfig) checkCondition() error {
+	if err := this.checkGTID(); err != nil {
+		return err
+	}
+	if err := this.checkDatetime(); err != nil {
+		return err
+	}
+
 	// 同时指定了开始位点和结束位点
 	if this.HaveStartPosInfo() && this.HaveEndPosInfo() {
-		// 判断开始位点是否大于结束位点
-		if this.StartLogFile < this.EndLogFile {
-			return nil
-		} else if this.StartLogFile == this.EndLogFile { // 文件相等
-			if this.StartLogPos < this.EndLogPos {
-				return nil
-			} else {
-				return fmt.Errorf("指定的开始位点 %s:%d 大于结束位点 %s:%d (文件相等)",
-					this.StartLogFile, this.StartLogPos, this.EndLogFile, this.EndLogPos)
-			}
-		} else { // 开始位点大于结束位点
-			return fmt.Errorf("指定的开始位点 %s:%d 大于结束位点 %s:%d (文件大于)",
-				this.StartLogFile, this.StartLogPos, this.EndLogFile, this.EndLogPos)
-		}
+		return checkStartLessThanEnd(&this.BaseConfig)
+	}
+
+	// 指定了结束GTID或者结束时间
+	haveStartInfo := this.HaveStartPosInfo() || this.HaveStartGTIDInfo() || this.HaveStartTimeInfo()
+	if (haveStartInfo || this.IsOffline()) && (this.HaveEndGTIDInfo() || this.HaveStopTimeInfo()) {
+		return nil
+	}
+
+	// 离线模式没有指定开始位点从第一个文件开始, 没有指定结束位点则解析到最后一个文件结束
+	if this.IsOffline() {
+		seelog.Infof("离线模式. binlog目录: %s, binlog文件: %v, schema文件: %s",
+			this.BinlogDir, this.BinlogFiles, this.SchemaFile)
+		return nil
 	}
 
-	if !this.HaveStartPosInfo() { // 没有指定开始位点
+	if !haveStartInfo { // 没有指定开始位点
 		return fmt.Errorf("没有指定开始位点")
 	}
 
 	// 到这里说明, 有开始位点,没有结束位点
+	if this.ServeMode {
+		seelog.Infof("TaskUUID: %s. 结束位点通过 HTTP 控制服务指定", this.TaskUUID)
+		return nil
+	}
 	if !this.EnableReadAPI() {
 		return fmt.Errorf("没有指定结束位点, 并且也没有指定使用读取数据的API/没有指定task uuid." +
 			" 读取的API是用来获取结束位点的.")
@@ -68,3 +298,14 @@ func (this *ToMySQLConfig) checkCondition() error {
 
 	return nil
 }
+
+// 判断开始位点是否小于结束位点
+func checkStartLessThanEnd(bc *BaseConfig) error {
+	startPos := &models.Position{File: bc.StartLogFile, Position: bc.StartLogPos}
+	endPos := &models.Position{File: bc.EndLogFile, Position: bc.EndLogPos}
+	if startPos.LessThan(endPos) {
+		return nil
+	}
+
+	return fmt.Errorf("指定的开始位点 %s 大于等于结束位点 %s", startPos.String(), endPos.String())
+}
@@ -0,0 +1,57 @@
+package config
+
+import "testing"
+
+func TestToMySQLConfig_CheckResume(t *testing.T) {
+	tmc := &ToMySQLConfig{Workers: 4, TaskUUID: "task1", CheckpointSchema: DEFAULT_CHECKPOINT_SCHEMA, Checkpoint: true}
+	if err := tmc.checkResume(); err == nil {
+		t.Fatal("多个应用线程不能保存checkpoint")
+	}
+
+	tmc.Workers = 1
+	if err := tmc.checkResume(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不保存checkpoint可以使用多个应用线程
+	tmc.Workers = 4
+	tmc.Checkpoint = false
+	if err := tmc.checkResume(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestToMySQLConfig_EnableCheckpoint(t *testing.T) {
+	// 只指定 task uuid 不保存checkpoint
+	tmc := &ToMySQLConfig{TaskUUID: "task1", CheckpointSchema: DEFAULT_CHECKPOINT_SCHEMA}
+	if tmc.EnableCheckpoint() {
+		t.Fatal("没有指定 checkpoint 不应该保存checkpoint")
+	}
+
+	tmc.Checkpoint = true
+	if !tmc.EnableCheckpoint() {
+		t.Fatal("指定了 checkpoint 和 task uuid 应该保存checkpoint")
+	}
+
+	tmc.Checkpoint, tmc.Resume = false, true
+	if !tmc.EnableCheckpoint() {
+		t.Fatal("从checkpoint继续执行时应该保存checkpoint")
+	}
+
+	tmc.TaskUUID = ""
+	if tmc.EnableCheckpoint() {
+		t.Fatal("没有指定 task uuid 不应该保存checkpoint")
+	}
+}
+
+func TestToMySQLConfig_GetHashKey(t *testing.T) {
+	t.Setenv(ENV_HASH_KEY, "env-key")
+	tmc := &ToMySQLConfig{}
+	if key := tmc.GetHashKey(); key != "env-key" {
+		t.Fatalf("没有指定 hash-key 应该使用环境变量中的密钥, 实际: %s", key)
+	}
+	tmc.HashKey = "flag-key"
+	if key := tmc.GetHashKey(); key != "flag-key" {
+		t.Fatalf("指定了 hash-key 应该优先使用, 实际: %s", key)
+	}
+}
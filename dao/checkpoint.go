@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+const (
+	CHECKPOINT_TABLE = "haqi_checkpoint"
+)
+
+// 创建保存checkpoint的表
+func (this *DefaultDao) CreateCheckpointTable(sName string) error {
+	if err := this.ReCreateDB(sName); err != nil {
+		return err
+	}
+
+	sqlStr := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`.`%s` (\n"+
+		"    `task_uuid` VARCHAR(64) NOT NULL COMMENT '任务UUID',\n"+
+		"    `worker_id` INT NOT NULL DEFAULT 0 COMMENT '应用线程ID',\n"+
+		"    `log_file` VARCHAR(255) NOT NULL DEFAULT '' COMMENT '应用完成的binlog文件',\n"+
+		"    `log_pos` INT UNSIGNED NOT NULL DEFAULT 0 COMMENT '应用完成的binlog位点',\n"+
+		"    `gtid_set` TEXT NOT NULL COMMENT '应用完成的GTID集合',\n"+
+		"    `event_time` DATETIME NULL DEFAULT NULL COMMENT '应用完成的event时间',\n"+
+		"    `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n"+
+		"    PRIMARY KEY (`task_uuid`, `worker_id`)\n"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='haqi 任务应用完成的位点'",
+		sName, CHECKPOINT_TABLE)
+
+	return this.CreateTable(sqlStr)
+}
+
+// 保存checkpoint, 需要和应用的数据在同一个事务中执行
+func (this *DefaultDao) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	sqlStr := fmt.Sprintf("INSERT INTO `%s`.`%s`(`task_uuid`, `worker_id`, `log_file`, `log_pos`, `gtid_set`, `event_time`) "+
+		"VALUES(?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE "+
+		"`log_file` = VALUES(`log_file`), `log_pos` = VALUES(`log_pos`), "+
+		"`gtid_set` = VALUES(`gtid_set`), `event_time` = VALUES(`event_time`)",
+		sName, CHECKPOINT_TABLE)
+
+	var eventTime interface{}
+	if !cp.EventTime.IsZero() {
+		eventTime = cp.EventTime.Format("2006-01-02 15:04:05")
+	}
+
+	return this.ExecDML(sqlStr, cp.TaskUUID, cp.WorkerID, cp.LogFile, cp.LogPos, cp.GTIDSet, eventTime)
+}
+
+// 获取任务所有应用线程的checkpoint, 按应用线程ID排序
+func (this *DefaultDao) FindCheckpoints(sName string, taskUUID string) ([]*models.Checkpoint, error) {
+	sqlStr := fmt.Sprintf("SELECT `task_uuid`, `worker_id`, `log_file`, `log_pos`, `gtid_set` "+
+		"FROM `%s`.`%s` WHERE `task_uuid` = ? ORDER BY `worker_id`", sName, CHECKPOINT_TABLE)
+
+	var cps []*models.Checkpoint
+	if err := this.DB.Raw(sqlStr, taskUUID).Find(&cps).Error; err != nil {
+		return nil, err
+	}
+
+	return cps, nil
+}
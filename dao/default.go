@@ -1,10 +1,13 @@
 package dao
 
 import (
+	"database/sql"
 	"fmt"
+	"github.com/daiguadaidai/haqi/config"
 	"github.com/daiguadaidai/haqi/gdbc"
 	"github.com/daiguadaidai/haqi/models"
 	"github.com/jinzhu/gorm"
+	"strconv"
 	"strings"
 )
 
@@ -12,8 +15,8 @@ type DefaultDao struct {
 	DB *gorm.DB
 }
 
-func NewDefaultDao(host string, port int) (*DefaultDao, error) {
-	instance, err := gdbc.GetInstanceByHostPort(host, port)
+func NewDefaultDao(dbc *config.DBConfig) (*DefaultDao, error) {
+	instance, err := gdbc.GetInstance(dbc)
 	if err != nil {
 		return nil, err
 	}
@@ -40,6 +43,16 @@ func (this *DefaultDao) ShowMasterStatus() (*models.Position, error) {
 	return pos, nil
 }
 
+// 获取已经被清除的GTID集合
+func (this *DefaultDao) GetGTIDPurged() (string, error) {
+	sql := `SELECT @@GLOBAL.gtid_purged`
+	var gtidPurged string
+	if err := this.DB.Raw(sql).Row().Scan(&gtidPurged); err != nil {
+		return "", err
+	}
+	return gtidPurged, nil
+}
+
 // 删除一个不存在的表
 func (this *DefaultDao) DropNotExistsTable() error {
 	sql := "DROP TABLE IF EXISTS `__gmod__`.`__gmod__`"
@@ -82,6 +95,25 @@ func (this *DefaultDao) FindTableColumnNames(sName string, tName string) ([]stri
 	return cNames, nil
 }
 
+// 获取表中所有的字段信息
+func (this *DefaultDao) FindTableColumns(sName string, tName string) ([]*models.Column, error) {
+	sql := `
+    SELECT COLUMN_NAME,
+        DATA_TYPE,
+        COLUMN_TYPE
+    FROM information_schema.COLUMNS
+    WHERE TABLE_SCHEMA = ?
+        AND TABLE_NAME = ?
+    ORDER BY ORDINAL_POSITION ASC
+`
+	var columns []*models.Column
+	if err := this.DB.Raw(sql, sName, tName).Find(&columns).Error; err != nil {
+		return nil, err
+	}
+
+	return columns, nil
+}
+
 // 获取主键字段名
 func (this *DefaultDao) FindTablePKColumnNames(sName string, tName string) ([]string, error) {
 	sql := `
@@ -156,9 +188,30 @@ func (this *DefaultDao) GetUKName(sName string, tName string) (string, error) {
 	return ukName, nil
 }
 
-// 执行dml
-func (this *DefaultDao) ExecDML(sql string) error {
-	return this.DB.Exec(sql).Error
+// 执行dml, args 为 sql 中 ? 占位符对应的参数
+func (this *DefaultDao) ExecDML(sql string, args ...interface{}) error {
+	return this.DB.Exec(sql, args...).Error
+}
+
+// 开启事务, 返回使用该事务的dao
+func (this *DefaultDao) Begin() (*DefaultDao, error) {
+	tx := this.DB.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &DefaultDao{
+		DB: tx,
+	}, nil
+}
+
+// 提交事务, 只能在 Begin 返回的dao中使用
+func (this *DefaultDao) Commit() error {
+	return this.DB.Commit().Error
+}
+
+// 回滚事务, 只能在 Begin 返回的dao中使用
+func (this *DefaultDao) Rollback() error {
+	return this.DB.Rollback().Error
 }
 
 // 获取最老和最新的日志位点
@@ -192,7 +245,7 @@ func (this *DefaultDao) ShowCreateTable(schema, table string) (string, bool, err
 	var showCreateSQL string
 
 	if err := this.DB.Raw(sqlStr).Row().Scan(&ignore, &showCreateSQL); err != nil {
-		if strings.HasSuffix(err.Error(), "doesn't exist") {
+		if strings.HasSuffix(err.Error(), "doesn't exist") || strings.Contains(err.Error(), "Unknown database") {
 			return "", false, nil
 		}
 		return "", false, err
@@ -264,3 +317,75 @@ func (this *DefaultDao) AlterTable(sqlStr string) error {
 	}
 	return nil
 }
+
+// 执行DDL语句
+func (this *DefaultDao) ExecDDL(sqlStr string) error {
+	if err := this.DB.Exec(sqlStr).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// 获取当前正在执行的线程数
+func (this *DefaultDao) GetThreadsRunning() (int64, error) {
+	sqlStr := `SHOW GLOBAL STATUS LIKE 'Threads_running'`
+	var name string
+	var threadsRunning int64
+	if err := this.DB.Raw(sqlStr).Row().Scan(&name, &threadsRunning); err != nil {
+		return 0, err
+	}
+	return threadsRunning, nil
+}
+
+// 获取从库延迟(Seconds_Behind_Master). 复制线程没有运行时 Seconds_Behind_Master 为 NULL, 返回 false
+func (this *DefaultDao) GetReplicaLag() (int64, bool, error) {
+	rows, err := this.DB.Raw(`SHOW SLAVE STATUS`).Rows()
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, false, err
+		}
+		return 0, false, fmt.Errorf("不是从库")
+	}
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err = rows.Scan(scanArgs...); err != nil {
+		return 0, false, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" {
+			continue
+		}
+		if !values[i].Valid {
+			return 0, false, nil
+		}
+		lag, err := strconv.ParseInt(values[i].String, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("Seconds_Behind_Master: %s. %v", values[i].String, err)
+		}
+		return lag, true, nil
+	}
+
+	return 0, false, fmt.Errorf("SHOW SLAVE STATUS 中没有 Seconds_Behind_Master")
+}
+
+// 执行返回一个数值的查询, 如: 限流检测的查询
+func (this *DefaultDao) QueryInt(sqlStr string) (int64, error) {
+	var value sql.NullInt64
+	if err := this.DB.Raw(sqlStr).Row().Scan(&value); err != nil {
+		return 0, err
+	}
+	return value.Int64, nil
+}
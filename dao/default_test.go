@@ -3,10 +3,11 @@ package dao
 import (
 	"fmt"
 	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
 	"testing"
 )
 
-func initDBConfig() {
+func initDBConfig() *config.DBConfig {
 	dbConfig := &config.DBConfig{
 		Host:         "10.10.10.21",
 		Port:         3307,
@@ -17,16 +18,27 @@ func initDBConfig() {
 		AutoCommit:   true,
 		MaxOpenConns: 100,
 		MaxIdelConns: 100,
-		Timeout:      10,
+		Timeout:      3,
 	}
 
-	config.SetDBConfig(dbConfig)
+	return dbConfig
 }
 
-func TestDefaultDao_QueryBinaryLogs(t *testing.T) {
-	initDBConfig()
+// 获取测试实例的dao, 实例不可用时跳过测试
+func newTestDefaultDao(t *testing.T) *DefaultDao {
+	dbConfig := initDBConfig()
+	defaultDao, err := NewDefaultDao(dbConfig)
+	if err != nil {
+		t.Skipf("测试实例 %s 不可用. %v", dbConfig.Addr(), err)
+	}
+	if err = defaultDao.DB.DB().Ping(); err != nil {
+		t.Skipf("测试实例 %s 不可用. %v", dbConfig.Addr(), err)
+	}
+	return defaultDao
+}
 
-	logs, err := NewDefaultDao().ShowBinaryLogs()
+func TestDefaultDao_QueryBinaryLogs(t *testing.T) {
+	logs, err := newTestDefaultDao(t).ShowBinaryLogs()
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -36,11 +48,33 @@ func TestDefaultDao_QueryBinaryLogs(t *testing.T) {
 }
 
 func TestDefaultDao_ShowMasterStatus(t *testing.T) {
-	initDBConfig()
-
-	pos, err := NewDefaultDao().ShowMasterStatus()
+	pos, err := newTestDefaultDao(t).ShowMasterStatus()
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 	fmt.Println(pos)
 }
+
+func TestDefaultDao_Checkpoint(t *testing.T) {
+	defaultDao := newTestDefaultDao(t)
+	sName := "haqi_test"
+	if err := defaultDao.CreateCheckpointTable(sName); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cp := &models.Checkpoint{
+		TaskUUID: "test_checkpoint",
+		LogFile:  "mysql-bin.000001",
+		LogPos:   1024,
+	}
+	if err := defaultDao.SaveCheckpoint(sName, cp); err != nil {
+		t.Fatal(err.Error())
+	}
+	cps, err := defaultDao.FindCheckpoints(sName, cp.TaskUUID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(cps) != 1 || cps[0].String() != cp.String() {
+		t.Fatalf("checkpoint: %v, 期望: %s", cps, cp.String())
+	}
+}
@@ -0,0 +1,240 @@
+package dao
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+var (
+	useDBRegexp       = regexp.MustCompile("(?i)^USE\\s+`?([^`;\\s]+)`?")
+	createTableRegexp = regexp.MustCompile("(?i)^CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?(?:`?([^`.\\s]+)`?\\.)?`?([^`\\s(]+)`?")
+	keyColumnRegexp   = regexp.MustCompile("`([^`]+)`")
+	keyPrefixRegexp   = regexp.MustCompile("\\(\\d+\\)")
+)
+
+// 从 schema 文件(mysqldump --no-data 的输出)中获取表结构信息
+type DumpDao struct {
+	tables      []*models.DBTable
+	createTable map[string]string // key: schema.table, value: 建表语句
+}
+
+func NewDumpDao(fileName string) (*DumpDao, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("打开schema文件失败. %v", err)
+	}
+	defer f.Close()
+
+	dumpDao := &DumpDao{
+		tables:      make([]*models.DBTable, 0, 1),
+		createTable: make(map[string]string),
+	}
+
+	var currSchema string
+	var stmt []string // 正在解析的建表语句
+	var currTable *models.DBTable
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimLine := strings.TrimSpace(line)
+
+		if currTable == nil {
+			if items := useDBRegexp.FindStringSubmatch(trimLine); items != nil {
+				currSchema = items[1]
+				continue
+			}
+			items := createTableRegexp.FindStringSubmatch(trimLine)
+			if items == nil {
+				continue
+			}
+			sName := items[1]
+			if sName == "" {
+				sName = currSchema
+			}
+			if sName == "" {
+				return nil, fmt.Errorf("schema文件中的表 %s 没有指定数据库", items[2])
+			}
+			currTable = models.NewDBTable(sName, items[2])
+		}
+
+		// 建表语句可以在一行中(第一行就以 ; 结尾)
+		stmt = append(stmt, line)
+		if strings.HasSuffix(trimLine, ";") {
+			createSQL, err := formatCreateTable(currTable.TableName, strings.Join(stmt, "\n"))
+			if err != nil {
+				return nil, fmt.Errorf("schema文件中表 %s 的建表语句. %v", currTable.String(), err)
+			}
+			dumpDao.addTable(currTable, createSQL)
+			currTable = nil
+			stmt = nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取schema文件失败. %v", err)
+	}
+	if currTable != nil {
+		return nil, fmt.Errorf("schema文件中表 %s 的建表语句不完整", currTable.String())
+	}
+
+	return dumpDao, nil
+}
+
+// 将建表语句格式化为和 SHOW CREATE TABLE 一样的格式: 第一行为 CREATE TABLE, 每个定义一行, 最后一行为表选项
+func formatCreateTable(tName string, stmt string) (string, error) {
+	stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+	loc := createTableRegexp.FindStringIndex(stmt)
+	start := strings.Index(stmt[loc[1]:], "(")
+	if start < 0 {
+		return "", fmt.Errorf("没有字段定义")
+	}
+	start += loc[1]
+
+	defs := make([]string, 0, 8)
+	depth := 0
+	var quote byte
+	defStart := start + 1
+	for i := start; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ',' && depth == 1:
+			defs = append(defs, strings.TrimSpace(stmt[defStart:i]))
+			defStart = i + 1
+		case c == ')':
+			depth--
+			if depth == 0 {
+				defs = append(defs, strings.TrimSpace(stmt[defStart:i]))
+				options := strings.TrimSpace(stmt[i+1:])
+				return strings.TrimSpace(fmt.Sprintf("CREATE TABLE `%s` (\n  %s\n) %s",
+					tName, strings.Join(defs, ",\n  "), options)), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("括号不完整")
+}
+
+func (this *DumpDao) addTable(table *models.DBTable, createSQL string) {
+	if _, ok := this.createTable[table.String()]; !ok {
+		this.tables = append(this.tables, table)
+	}
+	this.createTable[table.String()] = createSQL
+}
+
+// 获取建表语句中定义的行, 第一行和最后一行分别为 CREATE TABLE 和 表选项
+func (this *DumpDao) definitions(sName string, tName string) ([]string, error) {
+	createSQL, ok := this.createTable[fmt.Sprintf("%s.%s", sName, tName)]
+	if !ok {
+		return nil, fmt.Errorf("schema文件中不存在表 %s.%s", sName, tName)
+	}
+
+	items := strings.Split(createSQL, "\n")
+	defs := make([]string, 0, len(items))
+	for _, item := range items[1:] {
+		item = strings.TrimSuffix(strings.TrimSpace(item), ",")
+		if item == "" || strings.HasPrefix(item, ")") {
+			continue
+		}
+		defs = append(defs, item)
+	}
+
+	return defs, nil
+}
+
+func (this *DumpDao) FindTablesBySchema(sName string) ([]*models.DBTable, error) {
+	tables := make([]*models.DBTable, 0, 1)
+	for _, table := range this.tables {
+		if table.TableSchema == sName {
+			tables = append(tables, table)
+		}
+	}
+
+	return tables, nil
+}
+
+func (this *DumpDao) FindTableColumns(sName string, tName string) ([]*models.Column, error) {
+	defs, err := this.definitions(sName, tName)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]*models.Column, 0, len(defs))
+	for _, def := range defs {
+		if !strings.HasPrefix(def, "`") {
+			continue
+		}
+		column, err := models.ParseColumnDefinition(def)
+		if err != nil {
+			return nil, fmt.Errorf("表 %s.%s. %v", sName, tName, err)
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+func (this *DumpDao) FindTablePKColumnNames(sName string, tName string) ([]string, error) {
+	defs, err := this.definitions(sName, tName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range defs {
+		if strings.HasPrefix(strings.ToUpper(def), "PRIMARY KEY") {
+			return keyColumnNames(def), nil
+		}
+	}
+
+	return make([]string, 0), nil
+}
+
+func (this *DumpDao) FindTableUKColumnNames(sName string, tName string) ([]string, string, error) {
+	defs, err := this.definitions(sName, tName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, def := range defs {
+		if strings.HasPrefix(strings.ToUpper(def), "UNIQUE KEY") {
+			names := keyColumnNames(def)
+			if len(names) < 2 {
+				continue
+			}
+			return names[1:], names[0], nil
+		}
+	}
+
+	return make([]string, 0), "", nil
+}
+
+func (this *DumpDao) ShowCreateTable(schema, table string) (string, bool, error) {
+	createSQL, ok := this.createTable[fmt.Sprintf("%s.%s", schema, table)]
+	return createSQL, ok, nil
+}
+
+// 获取索引定义中的所有字段名, 如: UNIQUE KEY `uk` (`a`,`b`) 返回 uk, a, b
+func keyColumnNames(def string) []string {
+	def = keyPrefixRegexp.ReplaceAllString(def, "") // 去掉前缀索引长度
+	items := keyColumnRegexp.FindAllStringSubmatch(def, -1)
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item[1]
+	}
+
+	return names
+}
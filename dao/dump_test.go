@@ -0,0 +1,118 @@
+package dao
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testDumpSQL = "-- MySQL dump 10.13\n" +
+	"USE `shop`;\n" +
+	"DROP TABLE IF EXISTS `orders`;\n" +
+	"CREATE TABLE `orders` (\n" +
+	"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `status` enum('new','closed', 'x y') NOT NULL DEFAULT 'new',\n" +
+	"  `ext` json DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  UNIQUE KEY `uk_status` (`status`,`ext`(10))\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+	"CREATE TABLE `other`.`t1` (\n" +
+	"  `a` int(11) DEFAULT NULL\n" +
+	") ENGINE=InnoDB;\n"
+
+func TestNewDumpDao(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "schema.sql")
+	if err = ioutil.WriteFile(fileName, []byte(testDumpSQL), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dumpDao, err := NewDumpDao(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables, _ := dumpDao.FindTablesBySchema("shop")
+	if len(tables) != 1 || tables[0].String() != "shop.orders" {
+		t.Fatalf("tables: %v", tables)
+	}
+
+	columns, err := dumpDao.FindTableColumns("shop", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 3 {
+		t.Fatalf("columns: %v", columns)
+	}
+	if columns[0].DataType != "bigint" || !columns[0].IsUnsigned() {
+		t.Fatalf("column id: %#v", columns[0])
+	}
+	if columns[1].ColumnType != "enum('new','closed', 'x y')" {
+		t.Fatalf("column status: %#v", columns[1])
+	}
+
+	pk, _ := dumpDao.FindTablePKColumnNames("shop", "orders")
+	if !reflect.DeepEqual(pk, []string{"id"}) {
+		t.Fatalf("pk: %v", pk)
+	}
+	uk, ukName, _ := dumpDao.FindTableUKColumnNames("shop", "orders")
+	if ukName != "uk_status" || !reflect.DeepEqual(uk, []string{"status", "ext"}) {
+		t.Fatalf("uk: %s %v", ukName, uk)
+	}
+
+	if _, ok, _ := dumpDao.ShowCreateTable("other", "t1"); !ok {
+		t.Fatal("other.t1 应该存在")
+	}
+	if _, ok, _ := dumpDao.ShowCreateTable("shop", "t1"); ok {
+		t.Fatal("shop.t1 不应该存在")
+	}
+}
+
+func TestNewDumpDao_SingleLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "schema.sql")
+	dumpSQL := "USE `shop`;\n" +
+		"CREATE TABLE `t2` (`id` int NOT NULL, `c` varchar(10) DEFAULT 'a,(b', PRIMARY KEY (`id`)) ENGINE=InnoDB;\n" +
+		"CREATE TABLE `t3` (\n" +
+		"  `x` int(11) DEFAULT NULL\n" +
+		") ENGINE=InnoDB;\n"
+	if err = ioutil.WriteFile(fileName, []byte(dumpSQL), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dumpDao, err := NewDumpDao(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, _ := dumpDao.FindTablesBySchema("shop")
+	if len(tables) != 2 || tables[0].TableName != "t2" || tables[1].TableName != "t3" {
+		t.Fatalf("tables: %v", tables)
+	}
+
+	createSQL, _, _ := dumpDao.ShowCreateTable("shop", "t2")
+	expect := "CREATE TABLE `t2` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `c` varchar(10) DEFAULT 'a,(b',\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB"
+	if createSQL != expect {
+		t.Fatalf("建表语句:\n%s", createSQL)
+	}
+	columns, err := dumpDao.FindTableColumns("shop", "t2")
+	if err != nil || len(columns) != 2 {
+		t.Fatalf("columns: %v, %v", columns, err)
+	}
+	if pk, _ := dumpDao.FindTablePKColumnNames("shop", "t2"); !reflect.DeepEqual(pk, []string{"id"}) {
+		t.Fatalf("pk: %v", pk)
+	}
+}
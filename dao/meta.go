@@ -0,0 +1,14 @@
+package dao
+
+import (
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 获取表结构信息的数据源. 可以是数据库实例(DefaultDao), 也可以是 schema 文件(DumpDao)
+type MetaDao interface {
+	FindTablesBySchema(sName string) ([]*models.DBTable, error)
+	FindTableColumns(sName string, tName string) ([]*models.Column, error)
+	FindTablePKColumnNames(sName string, tName string) ([]string, error)
+	FindTableUKColumnNames(sName string, tName string) ([]string, string, error)
+	ShowCreateTable(schema, table string) (string, bool, error)
+}
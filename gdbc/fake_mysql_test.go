@@ -0,0 +1,224 @@
+package gdbc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 测试使用的 MySQL 服务, 只实现握手(不校验密码), COM_PING, COM_QUERY(SELECT 返回一行 1, 其他返回 OK) 和 COM_QUIT
+type fakeMySQL struct {
+	sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	connID   uint32
+	wg       sync.WaitGroup
+}
+
+const (
+	fakeClientLongPassword  = 0x00000001
+	fakeClientLongFlag      = 0x00000004
+	fakeClientConnectWithDB = 0x00000008
+	fakeClientProtocol41    = 0x00000200
+	fakeClientTransactions  = 0x00002000
+	fakeClientSecureConn    = 0x00008000
+	fakeClientPluginAuth    = 0x00080000
+
+	fakeServerStatusAutocommit = 0x0002
+)
+
+// 监听 addr, 如: 127.0.0.1:0. 包含 / 时监听 Unix socket
+func newFakeMySQL(t *testing.T, addr string) *fakeMySQL {
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeMySQL{listener: listener, conns: make(map[net.Conn]struct{})}
+	server.wg.Add(1)
+	go server.serve()
+
+	return server
+}
+
+func (this *fakeMySQL) Addr() string {
+	return this.listener.Addr().String()
+}
+
+func (this *fakeMySQL) Port() int {
+	return this.listener.Addr().(*net.TCPAddr).Port
+}
+
+// 停止监听并断开所有的链接
+func (this *fakeMySQL) Close() {
+	this.listener.Close()
+	this.Lock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.Unlock()
+	this.wg.Wait()
+}
+
+func (this *fakeMySQL) serve() {
+	defer this.wg.Done()
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		this.Lock()
+		this.conns[conn] = struct{}{}
+		this.connID++
+		connID := this.connID
+		this.Unlock()
+
+		this.wg.Add(1)
+		go func() {
+			defer this.wg.Done()
+			defer func() {
+				conn.Close()
+				this.Lock()
+				delete(this.conns, conn)
+				this.Unlock()
+			}()
+			this.handle(conn, connID)
+		}()
+	}
+}
+
+func (this *fakeMySQL) handle(conn net.Conn, connID uint32) {
+	pc := &fakePacketConn{conn: conn}
+	if err := pc.write(0, fakeHandshake(connID)); err != nil {
+		return
+	}
+	if _, err := pc.read(); err != nil { // 握手回复, 不校验用户名和密码
+		return
+	}
+	if err := pc.write(2, fakeOK()); err != nil {
+		return
+	}
+
+	for {
+		data, err := pc.read()
+		if err != nil || len(data) == 0 {
+			return
+		}
+		switch data[0] {
+		case 0x01: // COM_QUIT
+			return
+		case 0x0e: // COM_PING
+			err = pc.write(1, fakeOK())
+		case 0x03: // COM_QUERY
+			query := strings.ToUpper(strings.TrimSpace(string(data[1:])))
+			if strings.HasPrefix(query, "SELECT") {
+				err = pc.writeSelectOne()
+			} else {
+				err = pc.write(1, fakeOK())
+			}
+		default:
+			err = pc.write(1, fakeErr(1047, "Unknown command"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func fakeHandshake(connID uint32) []byte {
+	caps := uint32(fakeClientLongPassword | fakeClientLongFlag | fakeClientConnectWithDB | fakeClientProtocol41 |
+		fakeClientTransactions | fakeClientSecureConn | fakeClientPluginAuth)
+	authData := []byte("12345678901234567890")
+
+	data := []byte{10}
+	data = append(data, "5.7.26-fake\x00"...)
+	data = binary.LittleEndian.AppendUint32(data, connID)
+	data = append(data, authData[:8]...)
+	data = append(data, 0)
+	data = binary.LittleEndian.AppendUint16(data, uint16(caps))
+	data = append(data, 33) // utf8_general_ci
+	data = binary.LittleEndian.AppendUint16(data, fakeServerStatusAutocommit)
+	data = binary.LittleEndian.AppendUint16(data, uint16(caps>>16))
+	data = append(data, byte(len(authData)+1))
+	data = append(data, make([]byte, 10)...)
+	data = append(data, authData[8:]...)
+	data = append(data, 0)
+	data = append(data, "mysql_native_password\x00"...)
+
+	return data
+}
+
+func fakeOK() []byte {
+	data := []byte{0x00, 0, 0}
+	data = binary.LittleEndian.AppendUint16(data, fakeServerStatusAutocommit)
+	return binary.LittleEndian.AppendUint16(data, 0)
+}
+
+func fakeEOF() []byte {
+	data := []byte{0xfe, 0, 0}
+	return binary.LittleEndian.AppendUint16(data, fakeServerStatusAutocommit)
+}
+
+func fakeErr(code uint16, msg string) []byte {
+	data := []byte{0xff}
+	data = binary.LittleEndian.AppendUint16(data, code)
+	data = append(data, "#HY000"...)
+	return append(data, msg...)
+}
+
+func fakeLenEncString(data []byte, s string) []byte {
+	data = append(data, byte(len(s)))
+	return append(data, s...)
+}
+
+type fakePacketConn struct {
+	conn net.Conn
+}
+
+func (this *fakePacketConn) read() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(this.conn, header); err != nil {
+		return nil, err
+	}
+	data := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(this.conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (this *fakePacketConn) write(seq byte, data []byte) error {
+	packet := []byte{byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16), seq}
+	_, err := this.conn.Write(append(packet, data...))
+	return err
+}
+
+// 返回一列一行的结果集, 值为 1
+func (this *fakePacketConn) writeSelectOne() error {
+	column := make([]byte, 0, 32)
+	for _, s := range []string{"def", "", "", "", "1", ""} {
+		column = fakeLenEncString(column, s)
+	}
+	column = append(column, 0x0c)
+	column = binary.LittleEndian.AppendUint16(column, 63) // binary
+	column = binary.LittleEndian.AppendUint32(column, 1)
+	column = append(column, 0x08) // LONGLONG
+	column = binary.LittleEndian.AppendUint16(column, 0)
+	column = append(column, 0, 0, 0)
+
+	packets := [][]byte{{1}, column, fakeEOF(), fakeLenEncString(nil, "1"), fakeEOF()}
+	for i, packet := range packets {
+		if err := this.write(byte(i+1), packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,54 +1,209 @@
 package gdbc
 
 import (
-	"fmt"
+	"path/filepath"
 	"sync"
 	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
 )
 
-// 测试并发获取数据库链接(使用了单例模式)
-func TestGetOrmInstance(t *testing.T) {
-	wg := new(sync.WaitGroup)
+func newTestDBConfig(port int) *config.DBConfig {
+	return &config.DBConfig{
+		Username:     "root",
+		Password:     "root",
+		CharSet:      config.DB_CHARSET,
+		Host:         "127.0.0.1",
+		Port:         port,
+		Timeout:      1,
+		MaxOpenConns: 10,
+		MaxIdelConns: 2,
+		AutoCommit:   true,
+	}
+}
 
-	for i := 0; i < 5; i++ {
-		wg.Add(1)
-		go func(_wg *sync.WaitGroup) {
-			defer _wg.Done()
+func selectOne(t *testing.T, instance *Instance) {
+	var one int
+	if err := instance.DB.DB().QueryRow("SELECT 1").Scan(&one); err != nil {
+		t.Fatal(err)
+	}
+	if one != 1 {
+		t.Fatalf("SELECT 1 返回: %d", one)
+	}
+}
+
+// 测试并发获取数据库链接, 同一个实例只创建一个链接
+func TestGetInstance(t *testing.T) {
+	server := newFakeMySQL(t, "127.0.0.1:0")
+	defer server.Close()
+	cfg := newTestDBConfig(server.Port())
 
-			ormInstance := GetOrmInstance()
-			ormInstance.DB.DB().Ping()
+	wg := new(sync.WaitGroup)
+	instances := make([]*Instance, 5)
+	for i := range instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
 
-			// 查询数据库
-			rows, err := ormInstance.DB.DB().Query("SELECT * FROM task limit 1")
+			instance, err := GetInstance(cfg)
 			if err != nil {
 				t.Errorf("error: %v", err)
+				return
 			}
-			defer rows.Close()
-			fmt.Println(rows)
-
-			/*
-			   columns, _ := rows.Columns()
-			   scanArgs := make([]interface{}, len(columns))
-			   values := make([]interface{}, len(columns))
-			   for j := range values {
-			       scanArgs[j] = &values[j]
-			   }
-
-			   record := make(map[string]string)
-			   for rows.Next() {
-			       //将行数据保存到record字典
-			       err = rows.Scan(scanArgs...)
-			       for i, col := range values {
-			           if col != nil {
-			               record[columns[i]] = string(col.([]byte))
-			           }
-			       }
-			   }
-
-			   fmt.Println(record)
-			*/
-		}(wg)
+			instances[i] = instance
+		}(i)
 	}
-
 	wg.Wait()
+
+	for _, instance := range instances {
+		if instance == nil || instance != instances[0] {
+			t.Fatalf("同一个实例获取到了不同的链接: %v", instances)
+		}
+	}
+	selectOne(t, instances[0])
+}
+
+// 源实例和目标实例在不同的地址, 或者在同一个地址使用不同的用户, 都使用自己的链接
+func TestGetInstance_TwoInstances(t *testing.T) {
+	servers := []*fakeMySQL{newFakeMySQL(t, "127.0.0.1:0"), newFakeMySQL(t, "127.0.0.1:0")}
+	cfgs := []*config.DBConfig{newTestDBConfig(servers[0].Port()), newTestDBConfig(servers[1].Port())}
+	otherUserCfg := newTestDBConfig(servers[0].Port())
+	otherUserCfg.Username = "haqi"
+	cfgs = append(cfgs, otherUserCfg)
+
+	instances := make([]*Instance, len(cfgs))
+	for _, server := range servers {
+		defer server.Close()
+	}
+	for i, cfg := range cfgs {
+		instance, err := GetInstance(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selectOne(t, instance)
+		instances[i] = instance
+	}
+
+	if instances[0] == instances[1] || instances[0] == instances[2] {
+		t.Fatal("不同的实例或用户获取到了同一个链接")
+	}
+	if instance, err := GetInstance(newTestDBConfig(servers[0].Port())); err != nil || instance != instances[0] {
+		t.Fatalf("相同的配置应该获取到同一个链接. %v", err)
+	}
+	if _, err := GetInstance(nil); err == nil {
+		t.Fatal("没有配置信息的实例应该获取失败")
+	}
+}
+
+// 同一个实例和用户, 数据库, 密码, 字符集或 autocommit 不同时使用不同的链接
+func TestRegistry_DSN(t *testing.T) {
+	server := newFakeMySQL(t, "127.0.0.1:0")
+	defer server.Close()
+	registry := NewRegistry()
+	defer registry.Close()
+
+	cfg1 := newTestDBConfig(server.Port())
+	cfg1.Database = "db1"
+	cfg2 := newTestDBConfig(server.Port())
+	cfg2.Database = "db2"
+	instance1, err := registry.Get(cfg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance2, err := registry.Get(cfg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance1 == instance2 {
+		t.Fatal("数据库不同获取到了同一个链接")
+	}
+	selectOne(t, instance2)
+
+	others := []func(cfg *config.DBConfig){
+		func(cfg *config.DBConfig) { cfg.Password = "other" },
+		func(cfg *config.DBConfig) { cfg.CharSet = "latin1" },
+		func(cfg *config.DBConfig) { cfg.AutoCommit = false },
+		func(cfg *config.DBConfig) { cfg.Timeout = 5 },
+	}
+	for i, change := range others {
+		cfg := newTestDBConfig(server.Port())
+		cfg.Database = "db1"
+		change(cfg)
+		if NewDSNKey(cfg) == NewDSNKey(cfg1) {
+			t.Fatalf("第 %d 个配置修改后链接标识相同: %s", i, NewDSNKey(cfg).String())
+		}
+	}
+	same := *cfg1
+	if NewDSNKey(cfg1) != NewDSNKey(&same) {
+		t.Fatal("相同的配置链接标识应该相同")
+	}
+}
+
+func TestRegistry_Reconnect(t *testing.T) {
+	server := newFakeMySQL(t, "127.0.0.1:0")
+	addr := server.Addr()
+	cfg := newTestDBConfig(server.Port())
+	registry := NewRegistry()
+	registry.HealthCheckInterval = 0 // 每次获取都检测
+	defer registry.Close()
+
+	instance, err := registry.Get(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectOne(t, instance)
+
+	// 实例不可用时获取失败, 链接池不关闭, 恢复后原来的链接池重新链接
+	server.Close()
+	if _, err = registry.Get(cfg); err == nil {
+		t.Fatal("实例不可用时应该获取失败")
+	}
+	server = newFakeMySQL(t, addr)
+	defer server.Close()
+	reconnected, err := registry.Get(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconnected != instance {
+		t.Fatal("实例恢复后应该使用原来的链接池")
+	}
+	selectOne(t, instance)
+
+	// 不同的用户使用不同的链接
+	otherCfg := newTestDBConfig(server.Port())
+	otherCfg.Username = "haqi"
+	other, err := registry.Get(otherCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == reconnected {
+		t.Fatal("不同的用户获取到了同一个链接")
+	}
+
+	if err = registry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = registry.Get(cfg); err == nil {
+		t.Fatal("关闭后不能再获取链接")
+	}
+}
+
+// 通过 Unix socket 链接, socket 不同使用不同的链接
+func TestRegistry_Socket(t *testing.T) {
+	server := newFakeMySQL(t, filepath.Join(t.TempDir(), "mysql.sock"))
+	defer server.Close()
+	registry := NewRegistry()
+	defer registry.Close()
+
+	cfg := newTestDBConfig(3306)
+	cfg.Socket = server.Addr()
+	instance, err := registry.Get(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectOne(t, instance)
+
+	if key := NewDSNKey(cfg); key == NewDSNKey(newTestDBConfig(3306)) {
+		t.Fatalf("socket 链接和 tcp 链接的标识相同: %s", key.String())
+	}
 }
@@ -0,0 +1,169 @@
+package gdbc
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/jinzhu/gorm"
+)
+
+// 距离上次检测超过该时间, 获取链接时重新检测链接是否可用
+const DEFAULT_HEALTH_CHECK_INTERVAL = 30 * time.Second
+
+// 链接的标识, DSN 中的任何参数(用户, 密码, 数据库, 字符集, autocommit, 超时, socket, TLS 等)不同都需要不同的链接
+type DSNKey struct {
+	Host     string
+	Port     int
+	Username string
+	Database string
+	Socket   string
+	TLS      string // TLS 配置名称, 没有启用 TLS 为空
+	dsnHash  string // 完整 DSN 的 sha256, 不在标识中保存明文密码
+}
+
+func NewDSNKey(cfg *config.DBConfig) DSNKey {
+	sum := sha256.Sum256([]byte(cfg.GetDataSource()))
+	return DSNKey{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Database: cfg.Database,
+		Socket:   cfg.Socket,
+		TLS:      cfg.TLSConfigName(),
+		dsnHash:  hex.EncodeToString(sum[:]),
+	}
+}
+
+func (this DSNKey) String() string {
+	addr := fmt.Sprintf("%s:%d", this.Host, this.Port)
+	if len(this.Socket) != 0 {
+		addr = fmt.Sprintf("unix(%s)", this.Socket)
+	}
+	if len(this.TLS) != 0 {
+		addr += "(tls)"
+	}
+	return fmt.Sprintf("%s@%s/%s", this.Username, addr, this.Database)
+}
+
+// 一个标识对应的链接, 第一次获取时创建
+type registryEntry struct {
+	sync.Mutex
+	instance  *Instance
+	checkedAt time.Time // 上次检测链接可用的时间
+}
+
+// 数据库链接注册表, 每个标识一个链接池
+type Registry struct {
+	sync.Mutex
+	entries             map[DSNKey]*registryEntry
+	closed              bool
+	HealthCheckInterval time.Duration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		entries:             make(map[DSNKey]*registryEntry),
+		HealthCheckInterval: DEFAULT_HEALTH_CHECK_INTERVAL,
+	}
+}
+
+// 默认的注册表, GetInstance 等函数从这里获取链接
+var DefaultRegistry = NewRegistry()
+
+func (this *Registry) entry(key DSNKey) (*registryEntry, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed {
+		return nil, fmt.Errorf("数据库链接已经关闭, 不能获取 %s 的链接", key.String())
+	}
+	e, ok := this.entries[key]
+	if !ok {
+		e = new(registryEntry)
+		this.entries[key] = e
+	}
+
+	return e, nil
+}
+
+// 获取配置对应的链接, 没有则创建. 距离上次检测超过检测间隔时检测链接是否可用, 不可用则返回错误.
+// 链接池被多个线程共用, 不可用时不关闭(会中断其他线程正在执行的事务), database/sql 会丢弃断开的链接, 实例恢复后重新链接
+func (this *Registry) Get(cfg *config.DBConfig) (*Instance, error) {
+	key := NewDSNKey(cfg)
+	e, err := this.entry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if e.instance != nil {
+		if time.Since(e.checkedAt) < this.HealthCheckInterval {
+			return e.instance, nil
+		}
+		if err = e.instance.DB.DB().Ping(); err != nil {
+			if err == driver.ErrBadConn {
+				seelog.Warnf("数据库链接 %s 已经断开", key.String())
+			}
+			return nil, fmt.Errorf("数据库链接 %s 不可用. %v", key.String(), err)
+		}
+		e.checkedAt = time.Now()
+		return e.instance, nil
+	}
+
+	if e.instance, err = openInstance(cfg); err != nil {
+		return nil, fmt.Errorf("链接数据库 %s 失败. %v", key.String(), err)
+	}
+	e.checkedAt = time.Now()
+
+	return e.instance, nil
+}
+
+func openInstance(cfg *config.DBConfig) (*Instance, error) {
+	if err := cfg.RegisterTLSConfig(); err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open("mysql", cfg.GetDataSource())
+	if err != nil {
+		return nil, err
+	}
+	db.DB().SetMaxOpenConns(cfg.MaxOpenConns)
+	db.DB().SetMaxIdleConns(cfg.MaxIdelConns)
+
+	return &Instance{DB: db}, nil
+}
+
+// 关闭所有的链接, 关闭后不能再获取链接
+func (this *Registry) Close() error {
+	this.Lock()
+	entries := this.entries
+	this.entries = make(map[DSNKey]*registryEntry)
+	this.closed = true
+	this.Unlock()
+
+	var firstErr error
+	for key, e := range entries {
+		e.Lock()
+		if e.instance != nil {
+			if err := e.instance.DB.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("关闭数据库链接 %s 失败. %v", key.String(), err)
+			}
+			e.instance = nil
+		}
+		e.Unlock()
+	}
+
+	return firstErr
+}
+
+// 关闭默认注册表中所有的链接, 程序退出前调用
+func Close() error {
+	return DefaultRegistry.Close()
+}
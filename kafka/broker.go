@@ -0,0 +1,263 @@
+package kafka
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/cihub/seelog"
+)
+
+// 本地的 kafka broker 替身, 只实现了生产者使用的 Metadata 和 Produce 接口.
+// 消息保存在内存中, 用于测试和在没有kafka集群的环境中验证输出的数据
+type Broker struct {
+	sync.Mutex
+	NumPartitions int // 自动创建的topic的分区数
+	listener      net.Listener
+	nodeID        int32
+	host          string
+	port          int32
+	topics        map[string][][]*Message // topic -> 分区 -> 消息
+	produceErrors []int16                 // 之后的 produce 请求依次返回的错误码
+	wg            sync.WaitGroup
+}
+
+// 在 addr 上启动 broker, 端口为0时随机选择端口
+func NewBroker(addr string, numPartitions int) (*Broker, error) {
+	if numPartitions <= 0 {
+		numPartitions = 1
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("kafka broker 监听 %s 失败. %v", addr, err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	broker := &Broker{
+		NumPartitions: numPartitions,
+		listener:      listener,
+		host:          host,
+		port:          int32(portNum),
+		topics:        make(map[string][][]*Message),
+	}
+	broker.wg.Add(1)
+	go broker.serve()
+
+	return broker, nil
+}
+
+// broker 监听的地址
+func (this *Broker) Addr() string {
+	return this.listener.Addr().String()
+}
+
+// 之后的 produce 请求依次返回指定的错误码, 请求中的消息不会保存
+func (this *Broker) FailProduce(codes ...int16) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.produceErrors = append(this.produceErrors, codes...)
+}
+
+// 获取 topic 中所有分区的消息, 按分区, offset 排序
+func (this *Broker) Messages(topic string) []*Message {
+	this.Lock()
+	defer this.Unlock()
+
+	msgs := make([]*Message, 0)
+	for _, partition := range this.topics[topic] {
+		msgs = append(msgs, partition...)
+	}
+
+	return msgs
+}
+
+// 所有的 topic
+func (this *Broker) Topics() []string {
+	this.Lock()
+	defer this.Unlock()
+
+	topics := make([]string, 0, len(this.topics))
+	for topic := range this.topics {
+		topics = append(topics, topic)
+	}
+
+	return topics
+}
+
+func (this *Broker) Close() error {
+	err := this.listener.Close()
+	this.wg.Wait()
+
+	return err
+}
+
+func (this *Broker) serve() {
+	defer this.wg.Done()
+
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		go this.handle(conn)
+	}
+}
+
+func (this *Broker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+
+		d := &decoder{buf: frame}
+		apiKey := d.int16()
+		apiVersion := d.int16()
+		correlationID := d.int32()
+		d.string() // client_id
+		if d.err != nil {
+			return
+		}
+
+		e := new(encoder)
+		e.int32(0) // size
+		e.int32(correlationID)
+		switch {
+		case apiKey == API_KEY_METADATA && apiVersion == METADATA_VERSION:
+			this.metadata(d, e)
+		case apiKey == API_KEY_PRODUCE && apiVersion == PRODUCE_VERSION:
+			this.produce(d, e)
+		default:
+			seelog.Errorf("kafka broker: 不支持的请求 api_key: %d, api_version: %d", apiKey, apiVersion)
+			return
+		}
+		if d.err != nil {
+			seelog.Errorf("kafka broker: 解析请求失败. %v", d.err)
+			return
+		}
+
+		e.putInt32(0, int32(len(e.buf)-4))
+		if _, err = conn.Write(e.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (this *Broker) metadata(d *decoder, e *encoder) {
+	topics := make([]string, 0, 1)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		topics = append(topics, d.string())
+	}
+	d.int8() // allow_auto_topic_creation, 总是自动创建
+
+	this.Lock()
+	defer this.Unlock()
+
+	e.int32(0) // throttle_time_ms
+	e.int32(1)
+	e.int32(this.nodeID)
+	e.string(this.host)
+	e.int32(this.port)
+	e.nullableString(nil) // rack
+	e.nullableString(nil) // cluster_id
+	e.int32(this.nodeID)  // controller_id
+
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		partitions := this.topicPartitions(topic)
+		e.int16(ERR_NONE)
+		e.string(topic)
+		e.int8(0) // is_internal
+		e.int32(int32(len(partitions)))
+		for i := range partitions {
+			e.int16(ERR_NONE)
+			e.int32(int32(i))
+			e.int32(this.nodeID) // leader
+			e.int32(1)           // replica_nodes
+			e.int32(this.nodeID)
+			e.int32(1) // isr_nodes
+			e.int32(this.nodeID)
+		}
+	}
+}
+
+func (this *Broker) produce(d *decoder, e *encoder) {
+	d.nullableString() // transactional_id
+	d.int16()          // acks
+	d.int32()          // timeout
+
+	this.Lock()
+	defer this.Unlock()
+
+	code := ERR_NONE
+	if len(this.produceErrors) > 0 {
+		code = this.produceErrors[0]
+		this.produceErrors = this.produceErrors[1:]
+	}
+
+	topicCount := d.arrayLen()
+	e.int32(int32(topicCount))
+	for i := 0; i < topicCount; i++ {
+		topic := d.string()
+		partitions := this.topicPartitions(topic)
+		partitionCount := d.arrayLen()
+		e.string(topic)
+		e.int32(int32(partitionCount))
+		for j := 0; j < partitionCount; j++ {
+			index := d.int32()
+			records := d.bytes()
+			if d.err != nil {
+				return
+			}
+
+			partitionCode := code
+			baseOffset := int64(-1)
+			if partitionCode == ERR_NONE {
+				if index < 0 || int(index) >= len(partitions) {
+					partitionCode = ERR_UNKNOWN_TOPIC_OR_PARTITION
+				} else if msgs, err := decodeRecordBatches(records); err != nil {
+					seelog.Errorf("kafka broker: topic: %s, 分区: %d 解析消息失败. %v", topic, index, err)
+					partitionCode = ERR_CORRUPT_MESSAGE
+				} else {
+					baseOffset = int64(len(partitions[index]))
+					for k, msg := range msgs {
+						msg.Topic = topic
+						msg.Partition = index
+						msg.Offset = baseOffset + int64(k)
+					}
+					partitions[index] = append(partitions[index], msgs...)
+				}
+			}
+
+			e.int32(index)
+			e.int16(partitionCode)
+			e.int64(baseOffset)
+			e.int64(-1) // log_append_time
+		}
+	}
+	e.int32(0) // throttle_time_ms
+}
+
+// 获取 topic 的分区, 不存在则创建
+func (this *Broker) topicPartitions(topic string) [][]*Message {
+	partitions, ok := this.topics[topic]
+	if !ok {
+		partitions = make([][]*Message, this.NumPartitions)
+		this.topics[topic] = partitions
+	}
+
+	return partitions
+}
@@ -0,0 +1,465 @@
+package kafka
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	DEFAULT_CLIENT_ID           = "haqi"
+	DEFAULT_TIMEOUT             = 10 * time.Second
+	DEFAULT_MAX_RETRIES         = 5
+	DEFAULT_RETRY_BACKOFF       = 500 * time.Millisecond
+	ACKS_ALL              int16 = -1 // 所有同步副本都写入成功才返回
+)
+
+// 生产者配置
+type ProducerConfig struct {
+	ClientID     string
+	Timeout      time.Duration // 连接和请求超时时间
+	MaxRetries   int           // 发送失败后重试次数
+	RetryBackoff time.Duration // 重试间隔
+}
+
+func (this *ProducerConfig) fillDefault() {
+	if len(this.ClientID) == 0 {
+		this.ClientID = DEFAULT_CLIENT_ID
+	}
+	if this.Timeout <= 0 {
+		this.Timeout = DEFAULT_TIMEOUT
+	}
+	if this.MaxRetries <= 0 {
+		this.MaxRetries = DEFAULT_MAX_RETRIES
+	}
+	if this.RetryBackoff <= 0 {
+		this.RetryBackoff = DEFAULT_RETRY_BACKOFF
+	}
+}
+
+// 同步发送消息的生产者. Send 返回成功代表所有消息都已经被所有同步副本确认
+type Producer struct {
+	sync.Mutex
+	addrs      []string
+	cfg        ProducerConfig
+	conns      map[int32]*brokerConn // node id -> 连接
+	brokers    map[int32]string      // node id -> 地址
+	partitions map[string][]int32    // topic -> 分区 leader 的 node id
+}
+
+func NewProducer(addrs []string, cfg *ProducerConfig) (*Producer, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("没有指定kafka broker地址")
+	}
+	producer := &Producer{
+		addrs:      addrs,
+		conns:      make(map[int32]*brokerConn),
+		brokers:    make(map[int32]string),
+		partitions: make(map[string][]int32),
+	}
+	if cfg != nil {
+		producer.cfg = *cfg
+	}
+	producer.cfg.fillDefault()
+
+	return producer, nil
+}
+
+// 发送消息. 消息通过key选择分区, 发送成功后会设置消息的分区和offset.
+// 失败会重试, 重试时只会重新发送没有确认的分区, 同一条消息可能会被发送多次
+func (this *Producer) Send(msgs []*Message) error {
+	this.Lock()
+	defer this.Unlock()
+
+	pending := msgs
+	var err error
+	for attempt := 0; attempt <= this.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			seelog.Warnf("kafka 发送消息失败, %v 后第 %d 次重试. %v", this.cfg.RetryBackoff, attempt, err)
+			time.Sleep(this.cfg.RetryBackoff)
+		}
+		if pending, err = this.send(pending); err == nil {
+			return nil
+		}
+		if perr, ok := err.(*ProduceError); ok && !isRetriable(perr.Code) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// 发送一次, 返回没有发送成功的消息
+func (this *Producer) send(msgs []*Message) ([]*Message, error) {
+	topics := make([]string, 0, 1)
+	for _, msg := range msgs {
+		if _, ok := this.partitions[msg.Topic]; !ok {
+			topics = append(topics, msg.Topic)
+		}
+	}
+	if len(topics) > 0 {
+		if err := this.refreshMetadata(topics); err != nil {
+			return msgs, err
+		}
+	}
+
+	// 按 leader 分组, 每个 leader 发送一个请求
+	requests := make(map[int32]*produceRequest)
+	for _, msg := range msgs {
+		leaders := this.partitions[msg.Topic]
+		if len(leaders) == 0 {
+			return msgs, fmt.Errorf("topic: %s 没有可用的分区", msg.Topic)
+		}
+		msg.Partition = partitionByKey(msg.Key, len(leaders))
+		leader := leaders[msg.Partition]
+		req, ok := requests[leader]
+		if !ok {
+			req = newProduceRequest()
+			requests[leader] = req
+		}
+		req.add(msg)
+	}
+
+	failed := make([]*Message, 0)
+	var lastErr error
+	for leader, req := range requests {
+		if err := this.produce(leader, req); err != nil {
+			if perr, ok := err.(*ProduceError); ok && !isRetriable(perr.Code) {
+				return req.failed, err
+			}
+			lastErr = err
+			failed = append(failed, req.failed...)
+		}
+	}
+	if lastErr != nil {
+		// 分区 leader 可能已经变化, 下次发送前重新获取元数据
+		this.partitions = make(map[string][]int32)
+		return failed, lastErr
+	}
+
+	return nil, nil
+}
+
+// broker 返回的写入分区失败
+type ProduceError struct {
+	Topic     string
+	Partition int32
+	Code      int16
+}
+
+func (this *ProduceError) Error() string {
+	return fmt.Sprintf("topic: %s, 分区: %d 写入失败, 错误码: %d", this.Topic, this.Partition, this.Code)
+}
+
+// 向 leader 发送请求, 设置成功消息的offset, 失败的消息记录在 req.failed 中
+func (this *Producer) produce(leader int32, req *produceRequest) error {
+	req.failed = req.all()
+
+	conn, err := this.conn(leader)
+	if err != nil {
+		return err
+	}
+
+	e := new(encoder)
+	e.nullableString(nil) // transactional_id
+	e.int16(ACKS_ALL)
+	e.int32(int32(this.cfg.Timeout / time.Millisecond))
+	e.int32(int32(len(req.topics)))
+	for _, topic := range req.topics {
+		e.string(topic)
+		partitions := req.msgs[topic]
+		e.int32(int32(len(partitions)))
+		for _, partition := range req.partitionOrder[topic] {
+			e.int32(partition)
+			e.bytes(encodeRecordBatch(partitions[partition]))
+		}
+	}
+
+	d, err := conn.request(API_KEY_PRODUCE, PRODUCE_VERSION, e.buf)
+	if err != nil {
+		this.closeConn(leader)
+		return err
+	}
+
+	var lastErr error
+	topicCount := d.arrayLen()
+	for i := 0; i < topicCount; i++ {
+		topic := d.string()
+		partitionCount := d.arrayLen()
+		for j := 0; j < partitionCount; j++ {
+			partition := d.int32()
+			code := d.int16()
+			baseOffset := d.int64()
+			d.int64() // log_append_time
+			if d.err != nil {
+				break
+			}
+			msgs := req.msgs[topic][partition]
+			if code != ERR_NONE {
+				lastErr = &ProduceError{Topic: topic, Partition: partition, Code: code}
+				if !isRetriable(code) {
+					return lastErr
+				}
+				continue
+			}
+			for k, msg := range msgs {
+				msg.Offset = baseOffset + int64(k)
+			}
+			delete(req.msgs[topic], partition)
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("解析kafka produce响应失败. %v", d.err)
+	}
+
+	// 成功的分区已经删除, 剩下的是失败或者响应中缺少的分区
+	req.failed = req.all()
+	if len(req.failed) > 0 && lastErr == nil {
+		lastErr = fmt.Errorf("kafka produce 响应中缺少 topic: %s, 分区: %d",
+			req.failed[0].Topic, req.failed[0].Partition)
+	}
+
+	return lastErr
+}
+
+// 获取 topic 的分区 leader, broker 开启了自动创建topic时会创建不存在的topic
+func (this *Producer) refreshMetadata(topics []string) error {
+	e := new(encoder)
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+	e.int8(1) // allow_auto_topic_creation
+
+	var lastErr error
+	for _, addr := range this.bootstrapAddrs() {
+		conn, err := dialBroker(addr, &this.cfg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		d, err := conn.request(API_KEY_METADATA, METADATA_VERSION, e.buf)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return this.parseMetadata(d)
+	}
+
+	return fmt.Errorf("获取kafka元数据失败. %v", lastErr)
+}
+
+func (this *Producer) parseMetadata(d *decoder) error {
+	d.int32() // throttle_time_ms
+	brokerCount := d.arrayLen()
+	for i := 0; i < brokerCount; i++ {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		this.brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.nullableString() // cluster_id
+	d.int32()          // controller_id
+
+	var lastErr error
+	topicCount := d.arrayLen()
+	for i := 0; i < topicCount; i++ {
+		code := d.int16()
+		topic := d.string()
+		d.int8() // is_internal
+		partitionCount := d.arrayLen()
+		leaders := make([]int32, partitionCount)
+		ok := code == ERR_NONE
+		for j := 0; j < partitionCount; j++ {
+			partitionCode := d.int16()
+			index := d.int32()
+			leader := d.int32()
+			for k, n := 0, d.arrayLen(); k < n; k++ { // replica_nodes
+				d.int32()
+			}
+			for k, n := 0, d.arrayLen(); k < n; k++ { // isr_nodes
+				d.int32()
+			}
+			if partitionCode != ERR_NONE || leader < 0 || index < 0 || int(index) >= partitionCount {
+				ok = false
+				continue
+			}
+			leaders[index] = leader
+		}
+		if d.err != nil {
+			break
+		}
+		if !ok || partitionCount == 0 {
+			lastErr = fmt.Errorf("topic: %s 元数据不可用, 错误码: %d", topic, code)
+			continue
+		}
+		this.partitions[topic] = leaders
+	}
+	if d.err != nil {
+		return fmt.Errorf("解析kafka元数据失败. %v", d.err)
+	}
+
+	return lastErr
+}
+
+// 获取元数据时使用的地址, 先使用已知的 broker 再使用配置的地址
+func (this *Producer) bootstrapAddrs() []string {
+	addrs := make([]string, 0, len(this.brokers)+len(this.addrs))
+	for _, addr := range this.brokers {
+		addrs = append(addrs, addr)
+	}
+	return append(addrs, this.addrs...)
+}
+
+func (this *Producer) conn(nodeID int32) (*brokerConn, error) {
+	if conn, ok := this.conns[nodeID]; ok {
+		return conn, nil
+	}
+	addr, ok := this.brokers[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("未知的kafka broker: %d", nodeID)
+	}
+	conn, err := dialBroker(addr, &this.cfg)
+	if err != nil {
+		return nil, err
+	}
+	this.conns[nodeID] = conn
+
+	return conn, nil
+}
+
+func (this *Producer) closeConn(nodeID int32) {
+	if conn, ok := this.conns[nodeID]; ok {
+		conn.Close()
+		delete(this.conns, nodeID)
+	}
+}
+
+func (this *Producer) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	for nodeID := range this.conns {
+		this.closeConn(nodeID)
+	}
+
+	return nil
+}
+
+// 一个 broker 上的 produce 请求, 按 topic, 分区 分组
+type produceRequest struct {
+	topics         []string
+	partitionOrder map[string][]int32
+	msgs           map[string]map[int32][]*Message
+	failed         []*Message
+}
+
+func newProduceRequest() *produceRequest {
+	return &produceRequest{
+		topics:         make([]string, 0, 1),
+		partitionOrder: make(map[string][]int32),
+		msgs:           make(map[string]map[int32][]*Message),
+	}
+}
+
+func (this *produceRequest) add(msg *Message) {
+	partitions, ok := this.msgs[msg.Topic]
+	if !ok {
+		partitions = make(map[int32][]*Message)
+		this.msgs[msg.Topic] = partitions
+		this.topics = append(this.topics, msg.Topic)
+	}
+	if _, ok = partitions[msg.Partition]; !ok {
+		this.partitionOrder[msg.Topic] = append(this.partitionOrder[msg.Topic], msg.Partition)
+	}
+	partitions[msg.Partition] = append(partitions[msg.Partition], msg)
+}
+
+func (this *produceRequest) all() []*Message {
+	msgs := make([]*Message, 0)
+	for _, topic := range this.topics {
+		for _, partition := range this.partitionOrder[topic] {
+			msgs = append(msgs, this.msgs[topic][partition]...)
+		}
+	}
+	return msgs
+}
+
+// 和 broker 的连接, 请求和响应一一对应
+type brokerConn struct {
+	conn          net.Conn
+	cfg           *ProducerConfig
+	correlationID int32
+}
+
+func dialBroker(addr string, cfg *ProducerConfig) (*brokerConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("连接kafka broker %s 失败. %v", addr, err)
+	}
+
+	return &brokerConn{conn: conn, cfg: cfg}, nil
+}
+
+// 发送请求并读取响应, 返回响应体的解码器
+func (this *brokerConn) request(apiKey int16, apiVersion int16, body []byte) (*decoder, error) {
+	this.correlationID++
+
+	e := new(encoder)
+	e.int32(0) // size
+	e.int16(apiKey)
+	e.int16(apiVersion)
+	e.int32(this.correlationID)
+	e.string(this.cfg.ClientID)
+	e.buf = append(e.buf, body...)
+	e.putInt32(0, int32(len(e.buf)-4))
+
+	// produce 请求需要等待副本确认, 多等待一个请求超时时间
+	this.conn.SetDeadline(time.Now().Add(2 * this.cfg.Timeout))
+	if _, err := this.conn.Write(e.buf); err != nil {
+		return nil, fmt.Errorf("发送kafka请求失败. %v", err)
+	}
+
+	resp, err := readFrame(this.conn)
+	if err != nil {
+		return nil, fmt.Errorf("读取kafka响应失败. %v", err)
+	}
+	d := &decoder{buf: resp}
+	if correlationID := d.int32(); correlationID != this.correlationID {
+		return nil, fmt.Errorf("kafka响应不匹配, 请求: %d, 响应: %d", this.correlationID, correlationID)
+	}
+
+	return d, nil
+}
+
+func (this *brokerConn) Close() error {
+	return this.conn.Close()
+}
+
+// 读取一个带长度前缀的数据帧
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int32(uint32(size[0])<<24 | uint32(size[1])<<16 | uint32(size[2])<<8 | uint32(size[3]))
+	if n < 0 || n > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("kafka 数据帧大小不合法: %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+const MAX_FRAME_SIZE = 100 * 1024 * 1024
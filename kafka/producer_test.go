@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecordBatch(t *testing.T) {
+	msgs := []*Message{
+		{Key: []byte("1"), Value: []byte("a"), Timestamp: 1000},
+		{Key: nil, Value: []byte("b"), Timestamp: 1005},
+		{Key: []byte("3"), Value: nil, Timestamp: 1002},
+	}
+	data := encodeRecordBatch(msgs)
+	got, err := decodeRecordBatches(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("消息数量不一致: %d != %d", len(got), len(msgs))
+	}
+	for i, msg := range msgs {
+		if string(got[i].Key) != string(msg.Key) || (got[i].Key == nil) != (msg.Key == nil) ||
+			string(got[i].Value) != string(msg.Value) || got[i].Timestamp != msg.Timestamp {
+			t.Fatalf("第 %d 条消息不一致: %+v != %+v", i, got[i], msg)
+		}
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, err = decodeRecordBatches(data); err == nil {
+		t.Fatal("crc错误的数据应该解析失败")
+	}
+}
+
+func TestPartitionByKey(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		p := partitionByKey(key, 7)
+		if p < 0 || p >= 7 {
+			t.Fatalf("分区超出范围: %d", p)
+		}
+		if p != partitionByKey(key, 7) {
+			t.Fatalf("相同的key分区不一致")
+		}
+	}
+}
+
+func newTestProducer(t *testing.T, numPartitions int) (*Broker, *Producer) {
+	broker, err := NewBroker("127.0.0.1:0", numPartitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := NewProducer([]string{broker.Addr()}, &ProducerConfig{RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return broker, producer
+}
+
+func TestProducer_Send(t *testing.T) {
+	broker, producer := newTestProducer(t, 3)
+	defer broker.Close()
+	defer producer.Close()
+
+	msgs := make([]*Message, 0)
+	for i := 0; i < 20; i++ {
+		msgs = append(msgs, &Message{
+			Topic: "db1.t1",
+			Key:   []byte(fmt.Sprintf("%d", i%5)),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		})
+	}
+	if err := producer.Send(msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	got := broker.Messages("db1.t1")
+	if len(got) != len(msgs) {
+		t.Fatalf("broker 中消息数量: %d, 期望: %d", len(got), len(msgs))
+	}
+	// 相同key的消息在同一个分区中保持顺序
+	partitions := make(map[string]int32)
+	lastValue := make(map[string]string)
+	for _, msg := range got {
+		key := string(msg.Key)
+		if p, ok := partitions[key]; ok && p != msg.Partition {
+			t.Fatalf("key: %s 在不同的分区中", key)
+		}
+		partitions[key] = msg.Partition
+		if string(msg.Value) < lastValue[key] && len(msg.Value) == len(lastValue[key]) {
+			t.Fatalf("key: %s 的消息顺序错误", key)
+		}
+		lastValue[key] = string(msg.Value)
+	}
+	for _, msg := range msgs {
+		if msg.Offset < 0 {
+			t.Fatalf("发送成功的消息没有设置offset: %+v", msg)
+		}
+	}
+}
+
+func TestProducer_Retry(t *testing.T) {
+	broker, producer := newTestProducer(t, 1)
+	defer broker.Close()
+	defer producer.Close()
+
+	broker.FailProduce(ERR_NOT_LEADER_FOR_PARTITION, ERR_REQUEST_TIMED_OUT)
+	msgs := []*Message{{Topic: "t", Key: []byte("1"), Value: []byte("v")}}
+	if err := producer.Send(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if got := broker.Messages("t"); len(got) != 1 {
+		t.Fatalf("重试后 broker 中消息数量: %d, 期望: 1", len(got))
+	}
+
+	// 不能重试的错误直接返回
+	broker.FailProduce(-1)
+	if err := producer.Send(msgs); err == nil {
+		t.Fatal("不能重试的错误应该发送失败")
+	}
+	if got := broker.Messages("t"); len(got) != 1 {
+		t.Fatalf("发送失败后 broker 中消息数量: %d, 期望: 1", len(got))
+	}
+}
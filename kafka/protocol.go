@@ -0,0 +1,379 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// 使用的 Kafka 协议接口和版本
+const (
+	API_KEY_PRODUCE  int16 = 0
+	API_KEY_METADATA int16 = 3
+
+	PRODUCE_VERSION  int16 = 3 // 使用 v2 格式的 record batch
+	METADATA_VERSION int16 = 4 // 支持自动创建topic
+)
+
+// 错误码
+const (
+	ERR_NONE                       int16 = 0
+	ERR_CORRUPT_MESSAGE            int16 = 2
+	ERR_UNKNOWN_TOPIC_OR_PARTITION int16 = 3
+	ERR_LEADER_NOT_AVAILABLE       int16 = 5
+	ERR_NOT_LEADER_FOR_PARTITION   int16 = 6
+	ERR_REQUEST_TIMED_OUT          int16 = 7
+	ERR_NETWORK_EXCEPTION          int16 = 13
+	ERR_NOT_ENOUGH_REPLICAS        int16 = 19
+)
+
+// 可以重试的错误码
+func isRetriable(code int16) bool {
+	switch code {
+	case ERR_CORRUPT_MESSAGE, ERR_UNKNOWN_TOPIC_OR_PARTITION, ERR_LEADER_NOT_AVAILABLE,
+		ERR_NOT_LEADER_FOR_PARTITION, ERR_REQUEST_TIMED_OUT, ERR_NETWORK_EXCEPTION, ERR_NOT_ENOUGH_REPLICAS:
+		return true
+	}
+	return false
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+var errShortBuffer = errors.New("kafka 协议数据不完整")
+
+// 协议数据编码, 所有整型为大端
+type encoder struct {
+	buf []byte
+}
+
+func (this *encoder) int8(v int8) {
+	this.buf = append(this.buf, byte(v))
+}
+
+func (this *encoder) int16(v int16) {
+	this.buf = append(this.buf, byte(v>>8), byte(v))
+}
+
+func (this *encoder) int32(v int32) {
+	this.buf = append(this.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (this *encoder) int64(v int64) {
+	this.int32(int32(v >> 32))
+	this.int32(int32(v))
+}
+
+func (this *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	this.buf = append(this.buf, tmp[:n]...)
+}
+
+func (this *encoder) string(s string) {
+	this.int16(int16(len(s)))
+	this.buf = append(this.buf, s...)
+}
+
+// 可以为null的字符串, nil 编码为 -1
+func (this *encoder) nullableString(s *string) {
+	if s == nil {
+		this.int16(-1)
+		return
+	}
+	this.string(*s)
+}
+
+func (this *encoder) bytes(b []byte) {
+	if b == nil {
+		this.int32(-1)
+		return
+	}
+	this.int32(int32(len(b)))
+	this.buf = append(this.buf, b...)
+}
+
+// record 中使用 varint 长度的字节
+func (this *encoder) varintBytes(b []byte) {
+	if b == nil {
+		this.varint(-1)
+		return
+	}
+	this.varint(int64(len(b)))
+	this.buf = append(this.buf, b...)
+}
+
+// 在 offset 位置写入 int32
+func (this *encoder) putInt32(offset int, v int32) {
+	binary.BigEndian.PutUint32(this.buf[offset:], uint32(v))
+}
+
+// 协议数据解码, 出错后之后的解码都返回零值, 最后通过 err 判断
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (this *decoder) remain() int {
+	return len(this.buf) - this.pos
+}
+
+func (this *decoder) need(n int) bool {
+	if this.err != nil {
+		return false
+	}
+	if n < 0 || this.remain() < n {
+		this.err = errShortBuffer
+		return false
+	}
+	return true
+}
+
+func (this *decoder) int8() int8 {
+	if !this.need(1) {
+		return 0
+	}
+	v := int8(this.buf[this.pos])
+	this.pos++
+	return v
+}
+
+func (this *decoder) int16() int16 {
+	if !this.need(2) {
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(this.buf[this.pos:]))
+	this.pos += 2
+	return v
+}
+
+func (this *decoder) int32() int32 {
+	if !this.need(4) {
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(this.buf[this.pos:]))
+	this.pos += 4
+	return v
+}
+
+func (this *decoder) int64() int64 {
+	if !this.need(8) {
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(this.buf[this.pos:]))
+	this.pos += 8
+	return v
+}
+
+func (this *decoder) varint() int64 {
+	if this.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(this.buf[this.pos:])
+	if n <= 0 {
+		this.err = errShortBuffer
+		return 0
+	}
+	this.pos += n
+	return v
+}
+
+func (this *decoder) raw(n int) []byte {
+	if !this.need(n) {
+		return nil
+	}
+	b := this.buf[this.pos : this.pos+n]
+	this.pos += n
+	return b
+}
+
+func (this *decoder) string() string {
+	n := this.int16()
+	return string(this.raw(int(n)))
+}
+
+func (this *decoder) nullableString() *string {
+	n := this.int16()
+	if n < 0 {
+		return nil
+	}
+	s := string(this.raw(int(n)))
+	return &s
+}
+
+func (this *decoder) bytes() []byte {
+	n := this.int32()
+	if n < 0 {
+		return nil
+	}
+	return this.raw(int(n))
+}
+
+func (this *decoder) varintBytes() []byte {
+	n := this.varint()
+	if n < 0 {
+		return nil
+	}
+	return this.raw(int(n))
+}
+
+// 数组长度, null 数组返回 -1
+func (this *decoder) arrayLen() int {
+	n := this.int32()
+	if n > int32(this.remain()) { // 防止错误的数据导致分配过大的内存
+		this.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+// 一条消息
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp int64 // 毫秒
+}
+
+// 编码 v2 格式的 record batch, 所有消息属于同一个分区
+func encodeRecordBatch(msgs []*Message) []byte {
+	e := new(encoder)
+	e.int64(0) // baseOffset
+	lengthPos := len(e.buf)
+	e.int32(0)  // batchLength
+	e.int32(-1) // partitionLeaderEpoch
+	e.int8(2)   // magic
+	crcPos := len(e.buf)
+	e.int32(0) // crc
+	e.int16(0) // attributes: 不压缩, CreateTime
+
+	firstTS, maxTS := msgs[0].Timestamp, msgs[0].Timestamp
+	for _, msg := range msgs {
+		if msg.Timestamp > maxTS {
+			maxTS = msg.Timestamp
+		}
+	}
+	e.int32(int32(len(msgs) - 1)) // lastOffsetDelta
+	e.int64(firstTS)
+	e.int64(maxTS)
+	e.int64(-1) // producerId
+	e.int16(-1) // producerEpoch
+	e.int32(-1) // baseSequence
+	e.int32(int32(len(msgs)))
+
+	for i, msg := range msgs {
+		r := new(encoder)
+		r.int8(0) // attributes
+		r.varint(msg.Timestamp - firstTS)
+		r.varint(int64(i))
+		r.varintBytes(msg.Key)
+		r.varintBytes(msg.Value)
+		r.varint(0) // headers
+		e.varint(int64(len(r.buf)))
+		e.buf = append(e.buf, r.buf...)
+	}
+
+	e.putInt32(lengthPos, int32(len(e.buf)-lengthPos-4))
+	e.putInt32(crcPos, int32(crc32.Checksum(e.buf[crcPos+4:], crc32c)))
+
+	return e.buf
+}
+
+// 解码 record batch, 可能包含多个 batch
+func decodeRecordBatches(data []byte) ([]*Message, error) {
+	msgs := make([]*Message, 0, 1)
+	d := &decoder{buf: data}
+	for d.remain() > 0 {
+		d.int64() // baseOffset
+		length := d.int32()
+		batch := &decoder{buf: d.raw(int(length))}
+		if d.err != nil {
+			return nil, d.err
+		}
+
+		batch.int32() // partitionLeaderEpoch
+		if magic := batch.int8(); magic != 2 {
+			return nil, fmt.Errorf("不支持的消息格式版本: %d", magic)
+		}
+		crc := uint32(batch.int32())
+		if batch.err == nil && crc32.Checksum(batch.buf[batch.pos:], crc32c) != crc {
+			return nil, fmt.Errorf("消息crc校验失败")
+		}
+		if attributes := batch.int16(); attributes&0x07 != 0 {
+			return nil, fmt.Errorf("不支持压缩的消息")
+		}
+		batch.int32() // lastOffsetDelta
+		firstTS := batch.int64()
+		batch.int64() // maxTimestamp
+		batch.int64() // producerId
+		batch.int16() // producerEpoch
+		batch.int32() // baseSequence
+		count := batch.arrayLen()
+		for i := 0; i < count; i++ {
+			record := &decoder{buf: batch.raw(int(batch.varint()))}
+			record.int8() // attributes
+			tsDelta := record.varint()
+			record.varint() // offsetDelta
+			msg := &Message{
+				Key:       record.varintBytes(),
+				Value:     record.varintBytes(),
+				Timestamp: firstTS + tsDelta,
+			}
+			if record.err != nil {
+				return nil, record.err
+			}
+			msgs = append(msgs, msg)
+		}
+		if batch.err != nil {
+			return nil, batch.err
+		}
+	}
+
+	return msgs, nil
+}
+
+// 和 Java 客户端默认分区器一致的 murmur2 哈希, 相同的key总是写入同一个分区
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length & 3 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
+
+// 通过key获取分区
+func partitionByKey(key []byte, numPartitions int) int32 {
+	return int32(uint32(murmur2(key)&0x7fffffff) % uint32(numPartitions))
+}
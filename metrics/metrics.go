@@ -0,0 +1,404 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 实现 Prometheus 文本格式(0.0.4)的监控指标, 只包含 counter, gauge, histogram
+
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// 默认的延迟直方图桶(秒)
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 指标集合
+type Registry struct {
+	sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// 默认的指标集合, NewCounterVec 等函数创建的指标都注册到这里
+var DefaultRegistry = NewRegistry()
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+func (this *Registry) register(name string, m metric) {
+	this.Lock()
+	defer this.Unlock()
+
+	if _, ok := this.metrics[name]; ok {
+		panic(fmt.Sprintf("监控指标 %s 重复注册", name))
+	}
+	this.metrics[name] = m
+}
+
+// 按名称排序输出所有指标
+func (this *Registry) Write(w io.Writer) error {
+	this.Lock()
+	names := make([]string, 0, len(this.metrics))
+	for name := range this.metrics {
+		names = append(names, name)
+	}
+	metrics := this.metrics
+	this.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := metrics[name].write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 输出指标的 HTTP handler
+func (this *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		this.Write(w)
+	})
+}
+
+// 默认指标集合的 HTTP handler
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// 带标签的指标的公共部分, 每一组标签值对应一个子指标
+type vec struct {
+	sync.Mutex
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	children   map[string]*child
+}
+
+type child struct {
+	labelValues []string
+	value       interface{}
+}
+
+func newVec(name, help, typ string, labelNames []string) *vec {
+	return &vec{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		children:   make(map[string]*child),
+	}
+}
+
+// 获取标签值对应的子指标, 不存在则通过 newValue 创建
+func (this *vec) get(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(this.labelNames) {
+		panic(fmt.Sprintf("监控指标 %s 需要 %d 个标签值, 实际为 %d 个", this.name, len(this.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	this.Lock()
+	defer this.Unlock()
+
+	c, ok := this.children[key]
+	if !ok {
+		c = &child{labelValues: append([]string(nil), labelValues...), value: newValue()}
+		this.children[key] = c
+	}
+
+	return c.value
+}
+
+// 删除标签值对应的子指标
+func (this *vec) Delete(labelValues ...string) {
+	this.Lock()
+	defer this.Unlock()
+
+	delete(this.children, strings.Join(labelValues, "\xff"))
+}
+
+// 删除第一个标签值为指定值的所有子指标, 如: 删除一个任务的所有指标
+func (this *vec) DeletePrefix(firstLabelValue string) {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, c := range this.children {
+		if len(c.labelValues) > 0 && c.labelValues[0] == firstLabelValue {
+			delete(this.children, key)
+		}
+	}
+}
+
+// 按标签值排序的子指标
+func (this *vec) sortedChildren() []*child {
+	this.Lock()
+	defer this.Unlock()
+
+	keys := make([]string, 0, len(this.children))
+	for key := range this.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]*child, 0, len(keys))
+	for _, key := range keys {
+		children = append(children, this.children[key])
+	}
+
+	return children
+}
+
+func (this *vec) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", this.name, escapeHelp(this.help), this.name, this.typ)
+	return err
+}
+
+// 输出一个样本, extraName/extraValue 为直方图的 le 标签
+func (this *vec) writeSample(w io.Writer, name string, labelValues []string, extraName, extraValue string, value float64) error {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labelValues) > 0 || len(extraName) > 0 {
+		sb.WriteByte('{')
+		for i, labelName := range this.labelNames {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(labelName)
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(labelValues[i]))
+			sb.WriteByte('"')
+		}
+		if len(extraName) > 0 {
+			if len(this.labelNames) > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(extraName)
+			sb.WriteString(`="`)
+			sb.WriteString(extraValue)
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(formatFloat(value))
+	sb.WriteByte('\n')
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// 只增加的计数器
+type CounterVec struct {
+	*vec
+}
+
+type Counter struct {
+	sync.Mutex
+	value float64
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labelNames)}
+	DefaultRegistry.register(name, c)
+	return c
+}
+
+func (this *CounterVec) WithLabelValues(labelValues ...string) *Counter {
+	return this.get(labelValues, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (this *CounterVec) write(w io.Writer) error {
+	if err := this.writeHeader(w); err != nil {
+		return err
+	}
+	for _, c := range this.sortedChildren() {
+		if err := this.writeSample(w, this.name, c.labelValues, "", "", c.value.(*Counter).Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Counter) Inc() {
+	this.Add(1)
+}
+
+// 增加计数, v 不能小于0
+func (this *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	this.Lock()
+	this.value += v
+	this.Unlock()
+}
+
+func (this *Counter) Value() float64 {
+	this.Lock()
+	defer this.Unlock()
+	return this.value
+}
+
+// 可以增加和减少的值
+type GaugeVec struct {
+	*vec
+}
+
+type Gauge struct {
+	sync.Mutex
+	value float64
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labelNames)}
+	DefaultRegistry.register(name, g)
+	return g
+}
+
+func (this *GaugeVec) WithLabelValues(labelValues ...string) *Gauge {
+	return this.get(labelValues, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (this *GaugeVec) write(w io.Writer) error {
+	if err := this.writeHeader(w); err != nil {
+		return err
+	}
+	for _, c := range this.sortedChildren() {
+		if err := this.writeSample(w, this.name, c.labelValues, "", "", c.value.(*Gauge).Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Gauge) Set(v float64) {
+	this.Lock()
+	this.value = v
+	this.Unlock()
+}
+
+func (this *Gauge) Add(v float64) {
+	this.Lock()
+	this.value += v
+	this.Unlock()
+}
+
+func (this *Gauge) Value() float64 {
+	this.Lock()
+	defer this.Unlock()
+	return this.value
+}
+
+// 直方图, 统计值的分布
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64 // 每个桶的计数(不累加)
+	count   uint64
+	sum     float64
+}
+
+// buckets 为每个桶的上限, 需要递增. 为空则使用 DefaultBuckets
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("监控指标 %s 的桶上限需要递增", name))
+		}
+	}
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labelNames), buckets: buckets}
+	DefaultRegistry.register(name, h)
+	return h
+}
+
+func (this *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	return this.get(labelValues, func() interface{} {
+		return &Histogram{buckets: this.buckets, counts: make([]uint64, len(this.buckets))}
+	}).(*Histogram)
+}
+
+func (this *HistogramVec) write(w io.Writer) error {
+	if err := this.writeHeader(w); err != nil {
+		return err
+	}
+	for _, c := range this.sortedChildren() {
+		h := c.value.(*Histogram)
+		h.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		count, sum := h.count, h.sum
+		h.Unlock()
+
+		var cumulative uint64
+		for i, upper := range this.buckets {
+			cumulative += counts[i]
+			if err := this.writeSample(w, this.name+"_bucket", c.labelValues, "le", formatFloat(upper), float64(cumulative)); err != nil {
+				return err
+			}
+		}
+		if err := this.writeSample(w, this.name+"_bucket", c.labelValues, "le", "+Inf", float64(count)); err != nil {
+			return err
+		}
+		if err := this.writeSample(w, this.name+"_sum", c.labelValues, "", "", sum); err != nil {
+			return err
+		}
+		if err := this.writeSample(w, this.name+"_count", c.labelValues, "", "", float64(count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(this.buckets, v) // 第一个 >= v 的桶
+
+	this.Lock()
+	defer this.Unlock()
+	if i < len(this.counts) {
+		this.counts[i]++
+	}
+	this.count++
+	this.sum += v
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
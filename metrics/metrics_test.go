@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	counter := NewCounterVec("test_events_total", "测试 event 数", "task", "op")
+	counter.WithLabelValues("t1", "insert").Inc()
+	counter.WithLabelValues("t1", "insert").Add(2)
+	counter.WithLabelValues(`t"2`, "delete").Inc()
+	gauge := NewGaugeVec("test_queue_depth", "测试队列长度", "task")
+	gauge.WithLabelValues("t1").Set(5)
+	histogram := NewHistogramVec("test_exec_seconds", "测试耗时", []float64{0.1, 1}, "task")
+	histogram.WithLabelValues("t1").Observe(0.05)
+	histogram.WithLabelValues("t1").Observe(0.5)
+	histogram.WithLabelValues("t1").Observe(3)
+
+	buf := new(bytes.Buffer)
+	if err := DefaultRegistry.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE test_events_total counter",
+		`test_events_total{task="t1",op="insert"} 3`,
+		`test_events_total{task="t\"2",op="delete"} 1`,
+		"# HELP test_queue_depth 测试队列长度",
+		`test_queue_depth{task="t1"} 5`,
+		`test_exec_seconds_bucket{task="t1",le="0.1"} 1`,
+		`test_exec_seconds_bucket{task="t1",le="1"} 2`,
+		`test_exec_seconds_bucket{task="t1",le="+Inf"} 3`,
+		`test_exec_seconds_sum{task="t1"} 3.55`,
+		`test_exec_seconds_count{task="t1"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("输出中没有: %s\n%s", line, out)
+		}
+	}
+	if strings.Index(out, "test_events_total") > strings.Index(out, "test_queue_depth") {
+		t.Fatalf("指标没有按名称排序:\n%s", out)
+	}
+
+	// 删除任务的指标
+	counter.DeletePrefix("t1")
+	gauge.Delete("t1")
+	buf.Reset()
+	DefaultRegistry.Write(buf)
+	if out = buf.String(); strings.Contains(out, `{task="t1",op="insert"}`) || strings.Contains(out, `test_queue_depth{`) {
+		t.Fatalf("删除后仍然输出:\n%s", out)
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// 任务应用完成的位点, 保存在目标实例中. 每个应用线程保存一条
+type Checkpoint struct {
+	TaskUUID  string    `gorm:"column:task_uuid"`
+	WorkerID  int       `gorm:"column:worker_id"`
+	LogFile   string    `gorm:"column:log_file"`
+	LogPos    uint32    `gorm:"column:log_pos"`
+	GTIDSet   string    `gorm:"column:gtid_set"`
+	EventTime time.Time `gorm:"column:event_time"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
+
+func (this *Checkpoint) String() string {
+	return fmt.Sprintf("%s:%d", this.LogFile, this.LogPos)
+}
+
+// 获取checkpoint对应的位点
+func (this *Checkpoint) Position() *Position {
+	return &Position{
+		File:              this.LogFile,
+		Position:          this.LogPos,
+		Executed_Gtid_Set: this.GTIDSet,
+	}
+}
+
+// 通过位点信息生成checkpoint
+func NewCheckpoint(taskUUID string, workerID int, pos *Position) *Checkpoint {
+	return &Checkpoint{
+		TaskUUID:  taskUUID,
+		WorkerID:  workerID,
+		LogFile:   pos.File,
+		LogPos:    pos.Position,
+		GTIDSet:   pos.Executed_Gtid_Set,
+		EventTime: pos.TS,
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Column struct {
+	ColumnName string `gorm:"column:COLUMN_NAME"`
+	DataType   string `gorm:"column:DATA_TYPE"`
+	ColumnType string `gorm:"column:COLUMN_TYPE"`
+}
+
+// 字段是否是无符号类型
+func (this *Column) IsUnsigned() bool {
+	return strings.Contains(strings.ToLower(this.ColumnType), "unsigned")
+}
+
+// 解析字段定义, 如: `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT. 字段名可以没有反引号
+func ParseColumnDefinition(def string) (*Column, error) {
+	def = strings.TrimSpace(def)
+	var name, rest string
+	if strings.HasPrefix(def, "`") {
+		end := strings.Index(def[1:], "`")
+		if end < 0 {
+			return nil, fmt.Errorf("不能解析的字段定义: %s", def)
+		}
+		name, rest = def[1:end+1], def[end+2:]
+	} else {
+		items := strings.SplitN(def, " ", 2)
+		if len(items) != 2 {
+			return nil, fmt.Errorf("不能解析的字段定义: %s", def)
+		}
+		name, rest = items[0], items[1]
+	}
+	column := &Column{ColumnName: name}
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return nil, fmt.Errorf("不能解析的字段定义(没有字段类型): %s", def)
+	}
+	// 字段类型, 类型中的括号内可能有空格(enum, set)
+	depth := 0
+	typeEnd := len(rest)
+	for i, c := range rest {
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		} else if (c == ' ' || c == '\t' || c == '\n') && depth == 0 {
+			typeEnd = i
+			break
+		}
+	}
+	columnType := rest[:typeEnd]
+	for _, attr := range strings.Fields(strings.ToLower(rest[typeEnd:])) {
+		if attr != "unsigned" && attr != "zerofill" {
+			break
+		}
+		columnType += " " + attr
+	}
+	column.ColumnType = columnType
+	column.DataType = strings.ToLower(strings.SplitN(columnType, "(", 2)[0])
+
+	return column, nil
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// 将 GTIDEvent 中的 SID 和 GNO 转化为 uuid:gno 格式
+func GTIDString(sid []byte, gno int64) string {
+	if len(sid) != 16 {
+		return fmt.Sprintf("%s:%d", hex.EncodeToString(sid), gno)
+	}
+	h := hex.EncodeToString(sid)
+	return fmt.Sprintf("%s-%s-%s-%s-%s:%d", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32], gno)
+}
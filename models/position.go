@@ -2,6 +2,8 @@ package models
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,18 +32,43 @@ func (this *Position) Equal(other *Position) bool {
 	return true
 }
 
-// 比较两个位点是否一样
+// 比较两个位点的大小
 func (this *Position) LessThan(other *Position) bool {
-	if this.File < other.File {
-		return true
-	} else if this.File == other.File {
-		if this.Position < other.Position {
-			return true
+	if c := CompareLogFile(this.File, other.File); c != 0 {
+		return c < 0
+	}
+
+	return this.Position < other.Position
+}
+
+// 比较两个binlog文件名, 文件名的序号按数字比较(mysql-bin.999999 < mysql-bin.1000000).
+// a < b 返回 -1, a == b 返回 0, a > b 返回 1
+func CompareLogFile(a string, b string) int {
+	aBase, aSeq, aOK := splitLogFile(a)
+	bBase, bSeq, bOK := splitLogFile(b)
+	if aOK && bOK && aBase == bBase {
+		switch {
+		case aSeq < bSeq:
+			return -1
+		case aSeq > bSeq:
+			return 1
 		}
-		return false
-	} else {
-		return false
+		return 0
 	}
 
-	return true
+	return strings.Compare(a, b)
+}
+
+// 将binlog文件名拆分成 前缀 和 序号
+func splitLogFile(name string) (string, uint64, bool) {
+	idx := strings.LastIndex(name, ".")
+	if idx < 0 {
+		return name, 0, false
+	}
+	seq, err := strconv.ParseUint(name[idx+1:], 10, 64)
+	if err != nil {
+		return name, 0, false
+	}
+
+	return name[:idx], seq, true
 }
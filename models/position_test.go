@@ -0,0 +1,28 @@
+package models
+
+import "testing"
+
+func TestPosition_LessThan(t *testing.T) {
+	cases := []struct {
+		a, b *Position
+		less bool
+	}{
+		{&Position{File: "mysql-bin.000001", Position: 4}, &Position{File: "mysql-bin.000001", Position: 5}, true},
+		{&Position{File: "mysql-bin.000002", Position: 4}, &Position{File: "mysql-bin.000001", Position: 500}, false},
+		{&Position{File: "mysql-bin.999999", Position: 4}, &Position{File: "mysql-bin.1000000", Position: 4}, true},
+		{&Position{File: "mysql-bin.1000000", Position: 4}, &Position{File: "mysql-bin.999999", Position: 4}, false},
+	}
+
+	for _, c := range cases {
+		if c.a.LessThan(c.b) != c.less {
+			t.Fatalf("%s < %s 应该为 %v", c.a.String(), c.b.String(), c.less)
+		}
+	}
+}
+
+func TestGTIDString(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	if s := GTIDString(sid, 23); s != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+		t.Fatalf("gtid: %s", s)
+	}
+}
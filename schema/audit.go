@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"strings"
+	"time"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 归档表中记录数据来源的字段, 位于目标表最后
+const (
+	AUDIT_COLUMN_LOG_FILE   = "_haqi_log_file"   // event 所在binlog文件
+	AUDIT_COLUMN_LOG_POS    = "_haqi_log_pos"    // event 结束位点
+	AUDIT_COLUMN_EVENT_TIME = "_haqi_event_time" // event 时间
+	AUDIT_COLUMN_THREAD_ID  = "_haqi_thread_id"  // 产生 event 的线程ID
+	AUDIT_COLUMN_GTID       = "_haqi_gtid"       // event 所在事务的GTID
+	AUDIT_COLUMN_OP         = "_haqi_op"         // 操作类型: insert, update, delete
+
+	AUDIT_OP_INSERT = "insert"
+	AUDIT_OP_UPDATE = "update"
+	AUDIT_OP_DELETE = "delete"
+)
+
+// 审计字段定义, 都允许为NULL, 不影响目标表原有的写入
+var AuditColumns = []*models.Column{
+	{ColumnName: AUDIT_COLUMN_LOG_FILE, DataType: "varchar", ColumnType: "varchar(255)"},
+	{ColumnName: AUDIT_COLUMN_LOG_POS, DataType: "bigint", ColumnType: "bigint unsigned"},
+	{ColumnName: AUDIT_COLUMN_EVENT_TIME, DataType: "datetime", ColumnType: "datetime"},
+	{ColumnName: AUDIT_COLUMN_THREAD_ID, DataType: "bigint", ColumnType: "bigint unsigned"},
+	{ColumnName: AUDIT_COLUMN_GTID, DataType: "varchar", ColumnType: "varchar(100)"},
+	{ColumnName: AUDIT_COLUMN_OP, DataType: "varchar", ColumnType: "varchar(10)"},
+}
+
+var auditColumnNames = func() map[string]bool {
+	names := make(map[string]bool, len(AuditColumns))
+	for _, column := range AuditColumns {
+		names[column.ColumnName] = true
+	}
+	return names
+}()
+
+// 是否是审计字段
+func IsAuditColumn(name string) bool {
+	return auditColumnNames[name]
+}
+
+// 审计字段的定义, 用于 ALTER TABLE ADD COLUMN
+func AuditColumnDefinition(column *models.Column) string {
+	return "`" + column.ColumnName + "` " + column.ColumnType + " NULL DEFAULT NULL"
+}
+
+// 一行数据的审计字段值, 和 AuditColumns 一一对应
+type AuditInfo struct {
+	LogFile   string
+	LogPos    uint32
+	EventTime time.Time
+	ThreadID  uint32
+	GTID      string
+	Op        string
+}
+
+func (this *AuditInfo) Values() []interface{} {
+	var gtid interface{}
+	if len(this.GTID) != 0 {
+		gtid = this.GTID
+	}
+
+	return []interface{}{this.LogFile, this.LogPos, this.EventTime, this.ThreadID, gtid, this.Op}
+}
+
+// 生成带审计字段的表信息, 审计字段在原有字段之后. 主键不变
+func (this *Table) WithAuditColumns() *Table {
+	t := this.clone()
+	t.Version = this.Version
+	for _, column := range AuditColumns {
+		t.Columns = append(t.Columns, column)
+		t.ColumnNames = append(t.ColumnNames, column.ColumnName)
+	}
+	t.initColumnPos()
+	t.initSQLTemplate()
+
+	return t
+}
+
+// 在行数据之后添加审计字段值
+func AppendAuditValues(rows [][]interface{}, info *AuditInfo) [][]interface{} {
+	values := info.Values()
+	auditRows := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		auditRow := make([]interface{}, 0, len(row)+len(values))
+		auditRow = append(auditRow, row...)
+		auditRows[i] = append(auditRow, values...)
+	}
+
+	return auditRows
+}
+
+// 在 CREATE TABLE 的定义中添加审计字段
+func (this *DDL) WithAuditColumns() *DDL {
+	ddl := *this
+	if this.Type != DDLTypeCreateTable || this.Like {
+		return &ddl
+	}
+
+	defs := SplitDefinitions(this.Definition)
+	for _, column := range AuditColumns {
+		defs = append(defs, AuditColumnDefinition(column))
+	}
+	ddl.Definition = strings.Join(defs, ", ")
+
+	return &ddl
+}
@@ -0,0 +1,36 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTable_WithAuditColumns(t *testing.T) {
+	tbl := newTestTable()
+	auditTbl := tbl.WithAuditColumns()
+	if len(tbl.ColumnNames) != 4 || auditTbl.Version != tbl.Version {
+		t.Fatalf("原表字段: %v, 版本: %d", tbl.ColumnNames, auditTbl.Version)
+	}
+
+	eventTime := time.Date(2019, 1, 18, 22, 56, 35, 0, time.Local)
+	info := &AuditInfo{LogFile: "mysql-bin.000001", LogPos: 1024, EventTime: eventTime, ThreadID: 15, Op: AUDIT_OP_DELETE}
+	rows := AppendAuditValues([][]interface{}{{int64(1), "a", int8(2), nil}}, info)
+
+	sql, args, err := auditTbl.BuildInsertSQL(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSQL := "INSERT INTO `db1_archive`.`t1`(`id`, `name`, `age`, `ext`, `_haqi_log_file`, `_haqi_log_pos`, " +
+		"`_haqi_event_time`, `_haqi_thread_id`, `_haqi_gtid`, `_haqi_op`) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if sql != expectSQL {
+		t.Fatalf("sql: %s, expect: %s", sql, expectSQL)
+	}
+	expectArgs := []interface{}{
+		int64(1), "a", int64(2), nil,
+		"mysql-bin.000001", uint32(1024), "2019-01-18 22:56:35", uint32(15), nil, "delete",
+	}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Fatalf("args: %#v, expect: %#v", args, expectArgs)
+	}
+}
@@ -0,0 +1,822 @@
+package schema
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 字段处理方式
+const (
+	COLUMN_ACTION_DROP   = "drop"   // 删除字段
+	COLUMN_ACTION_RENAME = "rename" // 修改字段名
+	COLUMN_ACTION_SHA256 = "sha256" // 使用 HMAC-SHA256 值(16进制字符串)代替原来的值, 字段类型修改为 char(64)
+	COLUMN_ACTION_LAST4  = "last4"  // 只保留最后4个字符, 其他字符使用 * 代替, 不是字符串类型的字段修改为 varchar(255)
+	COLUMN_ACTION_NULL   = "null"   // 写入 NULL, 字段修改为允许 NULL
+	COLUMN_ACTION_ADD    = "add"    // 在最后添加字段, 值为常量或者通过源表字段计算
+)
+
+const (
+	SHA256_COLUMN_TYPE = "char(64)"
+	LAST4_COLUMN_TYPE  = "varchar(255)"
+)
+
+// 一个表的字段处理规则, 格式: schema.table: <action> <args>
+//
+//	db1.t1: drop content, attachment          删除字段
+//	db1.t1: rename phone TO contact_phone     修改字段名
+//	db1.t1: sha256 email                      sha256
+//	db1.t1: last4 phone, id_card              只保留最后4个字符
+//	db1.t1: null address                      写入 NULL
+//	db1.t1: add source varchar(32) = 'shop'   添加常量字段
+//	db1.t1: add email_hash char(64) = sha256(lower(email))
+//
+// add 的值可以是字符串, 数字, NULL, 源表字段, 以及函数: sha256, last4, upper, lower, concat, coalesce.
+// 值中的字段为源表中的字段, 使用没有经过处理的值.
+// sha256 使用 ColumnProjection.HashKey 作为密钥计算 HMAC-SHA256, 没有密钥的 sha256 可以通过字典还原手机号等取值范围小的值
+type ColumnRule struct {
+	Rule       string
+	Action     string
+	Columns    []string  // 需要处理的字段, rename 为原字段名
+	NewName    string    // rename 的新字段名, add 的字段名
+	ColumnType string    // add 的字段类型
+	expr       *exprNode // add 的值
+}
+
+// 解析字段处理规则, 返回表名(schema.table)和规则
+func ParseColumnRule(rule string) (string, *ColumnRule, error) {
+	idx := strings.Index(rule, ":")
+	if idx < 0 {
+		return "", nil, fmt.Errorf("字段处理规则格式错误, 应该为 schema.table: <action> <args>. %s", rule)
+	}
+
+	s := newDDLScanner(rule[:idx])
+	sName, tName, ok := s.tableName("")
+	if !ok || len(sName) == 0 || len(strings.TrimSpace(s.rest())) != 0 {
+		return "", nil, fmt.Errorf("字段处理规则表名格式错误, 应该为 schema.table. %s", rule)
+	}
+
+	columnRule, err := parseColumnAction(rule[idx+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("字段处理规则: %s. %v", rule, err)
+	}
+	columnRule.Rule = strings.TrimSpace(rule)
+
+	return fmt.Sprintf("%s.%s", sName, tName), columnRule, nil
+}
+
+func parseColumnAction(body string) (*ColumnRule, error) {
+	s := newDDLScanner(body)
+	action, ok := s.ident()
+	if !ok {
+		return nil, fmt.Errorf("没有指定处理方式")
+	}
+	rule := &ColumnRule{Action: strings.ToLower(action)}
+
+	switch rule.Action {
+	case COLUMN_ACTION_DROP, COLUMN_ACTION_SHA256, COLUMN_ACTION_LAST4, COLUMN_ACTION_NULL:
+		for _, def := range SplitDefinitions(s.rest()) {
+			ds := newDDLScanner(def)
+			name, ok := ds.ident()
+			if !ok || len(ds.rest()) != 0 {
+				return nil, fmt.Errorf("字段名格式错误: %s", def)
+			}
+			rule.Columns = append(rule.Columns, name)
+		}
+		if len(rule.Columns) == 0 {
+			return nil, fmt.Errorf("没有指定字段")
+		}
+	case COLUMN_ACTION_RENAME:
+		oldName, ok := s.ident()
+		if !ok || !(s.keyword("TO") || s.keyword("AS")) {
+			return nil, fmt.Errorf("格式应该为 rename <字段> TO <新字段>")
+		}
+		newName, ok := s.ident()
+		if !ok || len(s.rest()) != 0 {
+			return nil, fmt.Errorf("格式应该为 rename <字段> TO <新字段>")
+		}
+		rule.Columns = []string{oldName}
+		rule.NewName = newName
+	case COLUMN_ACTION_ADD:
+		name, ok := s.ident()
+		rest := s.rest()
+		eq := strings.Index(rest, "=")
+		if !ok || eq <= 0 {
+			return nil, fmt.Errorf("格式应该为 add <字段> <类型> = <值>")
+		}
+		rule.NewName = name
+		rule.ColumnType = strings.TrimSpace(rest[:eq])
+		expr, err := parseColumnExpr(rest[eq+1:])
+		if err != nil {
+			return nil, err
+		}
+		rule.expr = expr
+	default:
+		return nil, fmt.Errorf("不支持的处理方式: %s. 支持: drop, rename, sha256, last4, null, add", action)
+	}
+
+	return rule, nil
+}
+
+// 一个表的所有字段处理规则, 按顺序执行
+type ColumnProjection struct {
+	Rules   []*ColumnRule
+	HashKey []byte // sha256 使用的 HMAC 密钥
+}
+
+// 规则中是否使用了 sha256, 使用时需要指定密钥
+func (this *ColumnProjection) UseSHA256() bool {
+	for _, rule := range this.Rules {
+		if rule.Action == COLUMN_ACTION_SHA256 || (rule.expr != nil && rule.expr.useFunc("sha256")) {
+			return true
+		}
+	}
+	return false
+}
+
+// 规则中涉及的字段名(小写), 包括修改后的字段名和添加的字段名
+func (this *ColumnProjection) columnNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, rule := range this.Rules {
+		for _, name := range rule.Columns {
+			names[strings.ToLower(name)] = true
+		}
+		if len(rule.NewName) != 0 {
+			names[strings.ToLower(rule.NewName)] = true
+		}
+	}
+	return names
+}
+
+// 处理后的一个字段
+type projectedColumn struct {
+	column  *models.Column
+	src     int       // 源表中字段的位置, 添加的字段为 -1
+	actions []string  // 对值的处理: sha256, last4, null
+	def     string    // 建表语句中的字段定义
+	expr    *exprNode // 添加的字段的值
+	renamed bool      // 是否修改过字段名
+}
+
+func (this *projectedColumn) changed() bool {
+	return this.src < 0 || this.renamed || len(this.actions) != 0
+}
+
+// 字段处理的结果
+type projectResult struct {
+	columns   []*projectedColumn
+	renames   map[string]string // 原字段名(小写) -> 新字段名
+	dropped   map[string]bool   // 删除的字段(小写)
+	nonUnique map[string]bool   // 处理后值不再唯一的字段(小写), 唯一索引需要修改为普通索引
+}
+
+// 按顺序执行所有的规则. pkNames 为主键字段, 主键字段不能删除和写入 NULL
+func (this *ColumnProjection) apply(columns []*projectedColumn, pkNames []string) (*projectResult, error) {
+	result := &projectResult{
+		columns:   columns,
+		renames:   make(map[string]string),
+		dropped:   make(map[string]bool),
+		nonUnique: make(map[string]bool),
+	}
+	pk := make(map[string]bool, len(pkNames))
+	for _, name := range pkNames {
+		pk[strings.ToLower(name)] = true
+	}
+
+	for _, rule := range this.Rules {
+		if rule.Action == COLUMN_ACTION_ADD {
+			if result.find(rule.NewName) >= 0 {
+				return nil, fmt.Errorf("添加的字段 %s 已经存在", rule.NewName)
+			}
+			dataType, _ := newDDLScanner(rule.ColumnType).ident()
+			result.columns = append(result.columns, &projectedColumn{
+				column: &models.Column{ColumnName: rule.NewName, DataType: strings.ToLower(dataType), ColumnType: rule.ColumnType},
+				src:    -1,
+				def:    fmt.Sprintf("%s %s", quoteIdent(rule.NewName), rule.ColumnType),
+				expr:   rule.expr,
+			})
+			continue
+		}
+
+		for _, name := range rule.Columns {
+			idx := result.find(name)
+			if idx < 0 {
+				if rule.Action == COLUMN_ACTION_DROP { // 源表中已经删除的字段不需要处理
+					continue
+				}
+				return nil, fmt.Errorf("规则 %s 中的字段 %s 不存在", rule.Rule, name)
+			}
+			pc := result.columns[idx]
+			key := strings.ToLower(pc.column.ColumnName)
+			if pk[key] && (rule.Action == COLUMN_ACTION_DROP || rule.Action == COLUMN_ACTION_NULL || rule.Action == COLUMN_ACTION_LAST4) {
+				return nil, fmt.Errorf("规则 %s 不能用于主键字段 %s", rule.Rule, name)
+			}
+
+			switch rule.Action {
+			case COLUMN_ACTION_DROP:
+				result.columns = append(result.columns[:idx], result.columns[idx+1:]...)
+				result.dropped[key] = true
+			case COLUMN_ACTION_RENAME:
+				if other := result.find(rule.NewName); other >= 0 && other != idx {
+					return nil, fmt.Errorf("修改后的字段名 %s 已经存在", rule.NewName)
+				}
+				pc.rename(rule.NewName)
+				result.renames[key] = rule.NewName
+				if pk[key] {
+					delete(pk, key)
+					pk[strings.ToLower(rule.NewName)] = true
+				}
+			case COLUMN_ACTION_SHA256:
+				pc.actions = append(pc.actions, rule.Action)
+				pc.setType("char", SHA256_COLUMN_TYPE, pk[key])
+			case COLUMN_ACTION_LAST4:
+				pc.actions = append(pc.actions, rule.Action)
+				if !isStringDataType(pc.column.DataType) {
+					pc.setType("varchar", LAST4_COLUMN_TYPE, false)
+				}
+				result.nonUnique[key] = true
+			case COLUMN_ACTION_NULL:
+				pc.actions = append(pc.actions, rule.Action)
+				pc.def = nullableDefinition(pc.def)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// 获取字段的位置, 不存在返回 -1. 字段名不区分大小写
+func (this *projectResult) find(name string) int {
+	for i, pc := range this.columns {
+		if strings.EqualFold(pc.column.ColumnName, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 处理后的字段名
+func (this *projectResult) columnName(name string) string {
+	if newName, ok := this.renames[strings.ToLower(name)]; ok {
+		return newName
+	}
+	return name
+}
+
+func (this *projectedColumn) rename(name string) {
+	column := *this.column
+	column.ColumnName = name
+	this.column = &column
+	this.renamed = true
+	if len(this.def) != 0 {
+		s := newDDLScanner(this.def)
+		s.ident()
+		this.def = quoteIdent(name) + this.def[s.pos:]
+	}
+}
+
+// 修改字段类型, 建表语句中的字段定义只保留是否允许 NULL
+func (this *projectedColumn) setType(dataType string, columnType string, notNull bool) {
+	this.column = &models.Column{ColumnName: this.column.ColumnName, DataType: dataType, ColumnType: columnType}
+	if len(this.def) == 0 {
+		return
+	}
+	nullable := " DEFAULT NULL"
+	if notNull || strings.Contains(strings.ToUpper(this.def), " NOT NULL") {
+		nullable = " NOT NULL"
+	}
+	this.def = quoteIdent(this.column.ColumnName) + " " + columnType + nullable
+}
+
+// 去掉字段定义中的 NOT NULL 和自增
+func nullableDefinition(def string) string {
+	for _, kw := range []string{" NOT NULL", " AUTO_INCREMENT"} {
+		if idx := strings.Index(strings.ToUpper(def), kw); idx >= 0 {
+			def = def[:idx] + def[idx+len(kw):]
+		}
+	}
+	return def
+}
+
+func isStringDataType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return true
+	}
+	return false
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// 源表字段处理后的表信息
+type TableProjection struct {
+	Source  *Table
+	Table   *Table // 处理后的表信息, 用于生成sql
+	columns []*projectedColumn
+	values  []valueFunc // 添加的字段的值, 和 columns 一一对应
+	hashKey []byte      // sha256 使用的 HMAC 密钥
+}
+
+// 通过源表信息生成处理后的表信息
+func (this *ColumnProjection) Project(t *Table) (*TableProjection, error) {
+	columns := make([]*projectedColumn, len(t.Columns))
+	for i, column := range t.Columns {
+		columns[i] = &projectedColumn{column: column, src: i}
+	}
+	var pkNames []string
+	if t.PKType == PKTypePK {
+		pkNames = t.PKColumnNames
+	}
+	result, err := this.apply(columns, pkNames)
+	if err != nil {
+		return nil, fmt.Errorf("表: %s. %v", t.String(), err)
+	}
+	if len(result.columns) == 0 {
+		return nil, fmt.Errorf("表: %s 处理后没有字段", t.String())
+	}
+
+	projection := &TableProjection{
+		Source:  t,
+		columns: result.columns,
+		values:  make([]valueFunc, len(result.columns)),
+		hashKey: this.HashKey,
+	}
+	target := t.clone()
+	target.Version = t.Version
+	target.Columns = make([]*models.Column, len(result.columns))
+	for i, pc := range result.columns {
+		target.Columns[i] = pc.column
+		if pc.expr != nil {
+			if projection.values[i], err = pc.expr.compile(t, this.HashKey); err != nil {
+				return nil, fmt.Errorf("表: %s 字段 %s. %v", t.String(), pc.column.ColumnName, err)
+			}
+		}
+	}
+	if target.PKType == PKTypePK {
+		for i, name := range target.PKColumnNames {
+			target.PKColumnNames[i] = result.columnName(name)
+		}
+	} else {
+		target.PKColumnNames = nil
+	}
+	target.refreshColumns()
+	target.initSQLTemplate()
+	projection.Table = target
+
+	return projection, nil
+}
+
+// 处理 RowsEvent 中的数据, 返回和处理后的表字段一致的数据
+func (this *TableProjection) Rows(rows [][]interface{}) ([][]interface{}, error) {
+	projected := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if len(row) != len(this.Source.ColumnNames) {
+			return nil, fmt.Errorf("表: %s 数据字段数 %d 和表字段数 %d 不一致",
+				this.Source.String(), len(row), len(this.Source.ColumnNames))
+		}
+
+		values := make([]interface{}, len(this.columns))
+		for j, pc := range this.columns {
+			if pc.src < 0 {
+				v, err := this.values[j](row)
+				if err != nil {
+					return nil, fmt.Errorf("表: %s 字段 %s. %v", this.Source.String(), pc.column.ColumnName, err)
+				}
+				values[j] = v
+				continue
+			}
+			if len(pc.actions) == 0 {
+				values[j] = row[pc.src]
+				continue
+			}
+
+			v, err := ConvertValue(this.Source.Columns[pc.src], row[pc.src])
+			if err != nil {
+				return nil, fmt.Errorf("表: %s 字段 %s. %v", this.Source.String(), this.Source.ColumnNames[pc.src], err)
+			}
+			for _, action := range pc.actions {
+				v = applyColumnAction(action, v, this.hashKey)
+			}
+			values[j] = v
+		}
+		projected[i] = values
+	}
+
+	return projected, nil
+}
+
+func applyColumnAction(action string, v interface{}, key []byte) interface{} {
+	switch action {
+	case COLUMN_ACTION_NULL:
+		return nil
+	case COLUMN_ACTION_SHA256:
+		return sha256Value(v, key)
+	case COLUMN_ACTION_LAST4:
+		return last4Value(v)
+	}
+	return v
+}
+
+// 使用密钥计算 HMAC-SHA256, 相同的密钥和值结果相同, 可以用于关联查询
+func sha256Value(v interface{}, key []byte) interface{} {
+	if v == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(filterString(v)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 只保留最后4个字符, 不超过4个字符全部使用 * 代替
+func last4Value(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	runes := []rune(filterString(v))
+	keep := 4
+	if len(runes) <= keep {
+		keep = 0
+	}
+	for i := 0; i < len(runes)-keep; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// 修改建表语句(SHOW CREATE TABLE 的结果), 使字段和处理后的字段一致:
+// 删除字段和包含该字段的索引, 修改字段名和索引中的字段名, 修改处理后的字段类型, 在最后添加字段
+func (this *ColumnProjection) ProjectCreateTable(createSQL string) (string, error) {
+	ddls := ParseDDL(createSQL, "")
+	if len(ddls) != 1 || ddls[0].Type != DDLTypeCreateTable || ddls[0].Like {
+		return "", fmt.Errorf("不能解析的建表语句: %s", createSQL)
+	}
+	ddl := ddls[0]
+	defs, err := this.projectDefinitions(ddl.Definition)
+	if err != nil {
+		return "", fmt.Errorf("表: %s. %v", ddl.Table, err)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("CREATE TABLE %s (\n  %s\n) %s",
+		quoteIdent(ddl.Table), strings.Join(defs, ",\n  "), ddl.Options)), nil
+}
+
+// 处理建表语句中的定义, 字段在前, 索引在后
+func (this *ColumnProjection) projectDefinitions(body string) ([]string, error) {
+	columns := make([]*projectedColumn, 0, 10)
+	indexes := make([]string, 0, 2)
+	var pkNames []string
+	for _, def := range SplitDefinitions(body) {
+		if isIndexDefinition(def) {
+			s := newDDLScanner(def)
+			if s.keywords("PRIMARY", "KEY") {
+				pkNames = indexColumnNames(s)
+			}
+			indexes = append(indexes, def)
+			continue
+		}
+		column, err := models.ParseColumnDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, &projectedColumn{column: column, src: len(columns), def: def})
+	}
+
+	result, err := this.apply(columns, pkNames)
+	if err != nil {
+		return nil, err
+	}
+	defs := make([]string, 0, len(result.columns)+len(indexes))
+	for _, pc := range result.columns {
+		defs = append(defs, pc.def)
+	}
+	for _, def := range indexes {
+		if index, ok := result.projectIndex(def); ok {
+			defs = append(defs, index)
+		}
+	}
+
+	return defs, nil
+}
+
+// 修改索引定义中的字段名. 索引中有删除的字段时不保留该索引, 有值不再唯一的字段时唯一索引修改为普通索引
+func (this *projectResult) projectIndex(def string) (string, bool) {
+	start, end := indexColumnsRange(def)
+	if start < 0 {
+		return def, true
+	}
+
+	nonUnique := false
+	parts := SplitDefinitions(def[start+1 : end])
+	for i, part := range parts {
+		s := newDDLScanner(part)
+		name, ok := s.ident()
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(name)
+		if this.dropped[key] {
+			return "", false
+		}
+		nonUnique = nonUnique || this.nonUnique[key]
+		parts[i] = quoteIdent(this.columnName(name)) + part[s.pos:]
+	}
+
+	head := def[:start]
+	if nonUnique {
+		s := newDDLScanner(head)
+		if s.keyword("UNIQUE") {
+			head = strings.TrimSpace(head[s.pos:])
+			if !strings.HasPrefix(strings.ToUpper(head), "KEY") && !strings.HasPrefix(strings.ToUpper(head), "INDEX") {
+				head = "KEY " + head
+			}
+			head += " "
+		}
+	}
+
+	return head + "(" + strings.Join(parts, ",") + ")" + def[end+1:], true
+}
+
+// 索引定义中字段列表的括号位置, 没有找到返回 -1
+func indexColumnsRange(def string) (int, int) {
+	s := newDDLScanner(def)
+	inQuote := false
+	for ; s.pos < len(s.s); s.pos++ {
+		c := s.s[s.pos]
+		if c == '`' {
+			inQuote = !inQuote
+		} else if c == '(' && !inQuote {
+			start := s.pos
+			if _, ok := s.parenthesized(); !ok {
+				return -1, -1
+			}
+			return start, s.pos - 1
+		}
+	}
+	return -1, -1
+}
+
+// 修改 DDL 使其和处理后的字段一致. CREATE TABLE 修改字段定义;
+// ALTER TABLE 去掉涉及规则中的字段的修改项, 返回去掉的修改项
+func (this *ColumnProjection) ProjectDDL(ddl *DDL) (*DDL, []string, error) {
+	projected := *ddl
+	switch {
+	case ddl.Type == DDLTypeCreateTable && !ddl.Like:
+		defs, err := this.projectDefinitions(ddl.Definition)
+		if err != nil {
+			return nil, nil, err
+		}
+		projected.Definition = strings.Join(defs, ", ")
+	case ddl.Type == DDLTypeAlterTable:
+		names := this.columnNames()
+		specs := make([]string, 0, 1)
+		skipped := make([]string, 0, 1)
+		for _, spec := range SplitDefinitions(ddl.Definition) {
+			if specReferences(spec, names) {
+				skipped = append(skipped, spec)
+				continue
+			}
+			specs = append(specs, spec)
+		}
+		projected.Definition = strings.Join(specs, ", ")
+		return &projected, skipped, nil
+	}
+
+	return &projected, nil, nil
+}
+
+// 修改项中是否有指定的字段名, 不解析语法, 所有的标识符都作为字段名比较
+func specReferences(spec string, names map[string]bool) bool {
+	s := newDDLScanner(spec)
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.s) {
+			return false
+		}
+		c := s.s[s.pos]
+		switch {
+		case c == '\'' || c == '"':
+			if _, ok := s.quotedString(); !ok {
+				return false
+			}
+		case c == '`' || isIdentChar(c):
+			name, ok := s.ident()
+			if !ok {
+				return false
+			}
+			if names[strings.ToLower(name)] {
+				return true
+			}
+		default:
+			s.pos++
+		}
+	}
+}
+
+// 修改源表字段的 crc32 值, 用于和目标表比较. 没有修改的字段使用源表的 crc32,
+// 修改过的字段和添加的字段在目标表存在时使用目标表的 crc32(不需要 modify), 不存在时需要 add
+func (this *ColumnProjection) ProjectColumnCRC32(ori map[string]int64, std map[string]int64) (map[string]int64, error) {
+	columns := make([]*projectedColumn, 0, len(ori))
+	for name := range ori {
+		columns = append(columns, &projectedColumn{column: &models.Column{ColumnName: name}, src: len(columns)})
+	}
+	result, err := this.apply(columns, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]int64, len(result.columns))
+	for _, pc := range result.columns {
+		name := pc.column.ColumnName
+		if pc.changed() {
+			projected[name] = std[name]
+		} else {
+			projected[name] = ori[name]
+		}
+	}
+
+	return projected, nil
+}
+
+// 添加的字段的值
+type valueFunc func(row []interface{}) (interface{}, error)
+
+// 添加的字段的值表达式: 字符串 | 数字 | NULL | 字段 | 函数(参数, ...)
+type exprNode struct {
+	column string      // 字段名
+	value  interface{} // 常量
+	fn     string      // 函数名(小写)
+	args   []*exprNode
+}
+
+// 支持的函数和参数个数, -1 表示至少一个参数
+var columnExprFuncs = map[string]int{
+	"sha256":   1,
+	"last4":    1,
+	"upper":    1,
+	"lower":    1,
+	"concat":   -1,
+	"coalesce": -1,
+}
+
+func parseColumnExpr(expr string) (*exprNode, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("添加的字段没有指定值")
+	}
+
+	p := &filterParser{tokens: tokens}
+	node, err := parseExprNode(p)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("多余的内容")
+	}
+	return node, nil
+}
+
+// expr: 字符串 | 数字 | NULL | 字段 | 函数(expr, ...)
+func parseExprNode(p *filterParser) (*exprNode, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, p.errorf("缺少字段或值")
+	}
+	p.pos++
+
+	switch tok.kind {
+	case filterTokenQuoted:
+		return &exprNode{column: tok.text}, nil
+	case filterTokenString, filterTokenNumber: // 数字使用原始的字符串, 写入时由 MySQL 转化
+		return &exprNode{value: tok.text}, nil
+	case filterTokenIdent:
+		if strings.EqualFold(tok.text, "NULL") {
+			return &exprNode{}, nil
+		}
+		if !p.symbol("(") {
+			return &exprNode{column: tok.text}, nil
+		}
+
+		fn := strings.ToLower(tok.text)
+		argc, ok := columnExprFuncs[fn]
+		if !ok {
+			return nil, fmt.Errorf("不支持的函数: %s. 支持: sha256, last4, upper, lower, concat, coalesce", tok.text)
+		}
+		node := &exprNode{fn: fn}
+		for {
+			arg, err := parseExprNode(p)
+			if err != nil {
+				return nil, err
+			}
+			node.args = append(node.args, arg)
+			if p.symbol(")") {
+				break
+			}
+			if !p.symbol(",") {
+				return nil, p.errorf("函数 %s 缺少 )", tok.text)
+			}
+		}
+		if argc > 0 && len(node.args) != argc {
+			return nil, fmt.Errorf("函数 %s 的参数个数应该为 %d", tok.text, argc)
+		}
+		return node, nil
+	}
+
+	p.pos--
+	return nil, p.errorf("缺少字段或值")
+}
+
+// 表达式中是否使用了函数
+func (this *exprNode) useFunc(fn string) bool {
+	if this.fn == fn {
+		return true
+	}
+	for _, arg := range this.args {
+		if arg.useFunc(fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// 通过源表信息生成计算值的函数, 字段使用源表中的位置. key: sha256 使用的 HMAC 密钥
+func (this *exprNode) compile(t *Table, key []byte) (valueFunc, error) {
+	if len(this.column) != 0 {
+		pos := t.columnIndex(this.column)
+		if pos < 0 {
+			return nil, fmt.Errorf("表中不存在字段: %s", this.column)
+		}
+		column := t.Columns[pos]
+		return func(row []interface{}) (interface{}, error) {
+			return ConvertValue(column, row[pos])
+		}, nil
+	}
+	if len(this.fn) == 0 {
+		value := this.value
+		return func(row []interface{}) (interface{}, error) {
+			return value, nil
+		}, nil
+	}
+
+	args := make([]valueFunc, len(this.args))
+	for i, arg := range this.args {
+		f, err := arg.compile(t, key)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = f
+	}
+	fn := this.fn
+	return func(row []interface{}) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(row)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return callColumnExprFunc(fn, values, key), nil
+	}, nil
+}
+
+func callColumnExprFunc(fn string, values []interface{}, key []byte) interface{} {
+	switch fn {
+	case "sha256":
+		return sha256Value(values[0], key)
+	case "last4":
+		return last4Value(values[0])
+	case "upper", "lower":
+		if values[0] == nil {
+			return nil
+		}
+		if fn == "upper" {
+			return strings.ToUpper(filterString(values[0]))
+		}
+		return strings.ToLower(filterString(values[0]))
+	case "concat": // 和 MySQL 一样, 有一个参数为 NULL 结果为 NULL
+		var buf strings.Builder
+		for _, v := range values {
+			if v == nil {
+				return nil
+			}
+			buf.WriteString(filterString(v))
+		}
+		return buf.String()
+	case "coalesce":
+		for _, v := range values {
+			if v != nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
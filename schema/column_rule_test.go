@@ -0,0 +1,182 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestProjection(t *testing.T, rules ...string) *ColumnProjection {
+	projection := new(ColumnProjection)
+	for _, rule := range rules {
+		_, columnRule, err := ParseColumnRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		projection.Rules = append(projection.Rules, columnRule)
+	}
+	return projection
+}
+
+func TestColumnProjection_Project(t *testing.T) {
+	projection := newTestProjection(t,
+		"db1.t1: rename age TO user_age",
+		"db1.t1: drop ext, not_exists",
+		"db1.t1: sha256 name",
+		"db1.t1: add src varchar(16) = concat('x-', upper(`name`))",
+		"db1.t1: add note varchar(8) = coalesce(NULL, 10)",
+	)
+	projection.HashKey = []byte("secret")
+	tp, err := projection.Project(newTestTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectNames := []string{"id", "name", "user_age", "src", "note"}
+	if !reflect.DeepEqual(tp.Table.ColumnNames, expectNames) {
+		t.Fatalf("字段: %v, 期望: %v", tp.Table.ColumnNames, expectNames)
+	}
+	expectInsert := "INSERT INTO `db1_archive`.`t1`(`id`, `name`, `user_age`, `src`, `note`) VALUES"
+	if tp.Table.InsertTemplate != expectInsert {
+		t.Fatalf("insert 模板: %s", tp.Table.InsertTemplate)
+	}
+
+	rows, err := tp.Rows([][]interface{}{
+		{int64(1), "bob", int8(-1), []byte(`{}`)},
+		{int64(2), nil, int8(2), nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows := [][]interface{}{
+		{int64(1), "9c90819f883772660da011f41042fabea4a174e2873386b30949f106dbac797e", int8(-1), "x-BOB", "10"},
+		{int64(2), nil, int8(2), nil, "10"},
+	}
+	if !reflect.DeepEqual(rows, expectRows) {
+		t.Fatalf("数据: %#v, 期望: %#v", rows, expectRows)
+	}
+
+	if _, err = newTestProjection(t, "db1.t1: drop id").Project(newTestTable()); err == nil {
+		t.Fatal("删除主键字段应该失败")
+	}
+	if _, err = newTestProjection(t, "db1.t1: sha256 not_exists").Project(newTestTable()); err == nil {
+		t.Fatal("处理不存在的字段应该失败")
+	}
+}
+
+func TestColumnProjection_ProjectCreateTable(t *testing.T) {
+	projection := newTestProjection(t,
+		"db1.t1: drop ext",
+		"db1.t1: rename name TO user_name",
+		"db1.t1: last4 phone",
+		"db1.t1: null age",
+		"db1.t1: add src varchar(16) = 'shop'",
+	)
+	createSQL := "CREATE TABLE `t1` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(20) NOT NULL DEFAULT '',\n" +
+		"  `age` tinyint(3) unsigned NOT NULL,\n" +
+		"  `phone` bigint(20) NOT NULL,\n" +
+		"  `ext` json DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `uk_phone` (`phone`),\n" +
+		"  KEY `idx_name_age` (`name`(10),`age`),\n" +
+		"  KEY `idx_ext` (`ext`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+	sqlStr, err := projection.ProjectCreateTable(createSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "CREATE TABLE `t1` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `user_name` varchar(20) NOT NULL DEFAULT '',\n" +
+		"  `age` tinyint(3) unsigned,\n" +
+		"  `phone` varchar(255) NOT NULL,\n" +
+		"  `src` varchar(16),\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `uk_phone` (`phone`),\n" +
+		"  KEY `idx_name_age` (`user_name`(10),`age`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	if sqlStr != expect {
+		t.Fatalf("建表语句:\n%s\n期望:\n%s", sqlStr, expect)
+	}
+
+	crc32Map, err := projection.ProjectColumnCRC32(
+		map[string]int64{"id": 1, "name": 2, "age": 3, "phone": 4, "ext": 5},
+		map[string]int64{"id": 1, "user_name": 20, "age": 30},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCRC32 := map[string]int64{"id": 1, "user_name": 20, "age": 30, "phone": 0, "src": 0}
+	if !reflect.DeepEqual(crc32Map, expectCRC32) {
+		t.Fatalf("字段crc32: %v, 期望: %v", crc32Map, expectCRC32)
+	}
+}
+
+func TestColumnProjection_UseSHA256(t *testing.T) {
+	cases := map[string]bool{
+		"db1.t1: sha256 name": true,
+		"db1.t1: add c char(64) = concat('x', sha256(lower(a)))": true,
+		"db1.t1: add c varchar(16) = upper(a)":                   false,
+		"db1.t1: last4 name":                                     false,
+	}
+	for rule, expect := range cases {
+		if use := newTestProjection(t, rule).UseSHA256(); use != expect {
+			t.Fatalf("规则: %s 使用sha256: %v, 期望: %v", rule, use, expect)
+		}
+	}
+}
+
+func TestColumnProjection_ProjectDDL(t *testing.T) {
+	projection := newTestProjection(t, "db1.t1: sha256 name", "db1.t1: drop ext")
+	ddl := ParseDDL("ALTER TABLE t1 ADD COLUMN c int, MODIFY `name` varchar(64), DROP COLUMN ext", "db1")[0]
+
+	projected, skipped, err := projection.ProjectDDL(ddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projected.Definition != "ADD COLUMN c int" || len(skipped) != 2 {
+		t.Fatalf("修改项: %s, 不执行: %v", projected.Definition, skipped)
+	}
+
+	ddl = ParseDDL("CREATE TABLE t1 (id int, name varchar(20), ext json, PRIMARY KEY (id))", "db1")[0]
+	if projected, _, err = projection.ProjectDDL(ddl); err != nil {
+		t.Fatal(err)
+	}
+	expect := "CREATE TABLE IF NOT EXISTS `db1_archive`.`t1` (id int, `name` char(64) DEFAULT NULL, PRIMARY KEY (`id`))"
+	if sqlStr := projected.TargetSQL(&TableRouter{SchemaSuffix: "_archive"}, false); sqlStr != expect {
+		t.Fatalf("建表语句: %s", sqlStr)
+	}
+}
+
+func TestParseColumnRule(t *testing.T) {
+	key, rule, err := ParseColumnRule("`db1`.t1: RENAME `a b` AS c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "db1.t1" || rule.Action != COLUMN_ACTION_RENAME || rule.Columns[0] != "a b" || rule.NewName != "c" {
+		t.Fatalf("表: %s, 规则: %#v", key, rule)
+	}
+
+	for _, s := range []string{
+		"db1.t1 drop a",
+		"t1: drop a",
+		"db1.t1: hash a",
+		"db1.t1: drop",
+		"db1.t1: rename a b",
+		"db1.t1: add c int",
+		"db1.t1: add c int = md5(a)",
+		"db1.t1: add c int = sha256(a, b)",
+	} {
+		if _, _, err := ParseColumnRule(s); err == nil {
+			t.Errorf("%s 应该解析失败", s)
+		}
+	}
+
+	for v, expect := range map[string]string{"13812345678": "*******5678", "1234": "****", "": ""} {
+		if masked := last4Value(v); masked != expect {
+			t.Errorf("%s: %v, 期望: %s", v, masked, expect)
+		}
+	}
+}
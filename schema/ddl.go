@@ -0,0 +1,394 @@
+package schema
+
+import (
+	"strings"
+)
+
+type DDLType int8
+
+const (
+	DDLTypeCreateTable DDLType = iota + 1
+	DDLTypeAlterTable
+	DDLTypeDropTable
+	DDLTypeRenameTable
+	DDLTypeTruncateTable
+)
+
+func (this DDLType) String() string {
+	switch this {
+	case DDLTypeCreateTable:
+		return "CREATE TABLE"
+	case DDLTypeAlterTable:
+		return "ALTER TABLE"
+	case DDLTypeDropTable:
+		return "DROP TABLE"
+	case DDLTypeRenameTable:
+		return "RENAME TABLE"
+	case DDLTypeTruncateTable:
+		return "TRUNCATE TABLE"
+	}
+	return "UNKNOWN"
+}
+
+// 从 QueryEvent 中解析出来的表相关的DDL, 一条语句涉及多个表(DROP TABLE a, b)会拆分成多个
+type DDL struct {
+	Type       DDLType
+	Schema     string
+	Table      string
+	NewSchema  string // RENAME TABLE 和 ALTER TABLE ... RENAME TO 修改后的数据库名
+	NewTable   string // RENAME TABLE 和 ALTER TABLE ... RENAME TO 修改后的表名
+	Definition string // CREATE TABLE 括号中的定义, ALTER TABLE 表名之后的修改语句
+	Options    string // CREATE TABLE 括号之后的表选项(ENGINE, CHARSET 等)
+	Like       bool   // CREATE TABLE ... LIKE/SELECT, 不能通过语句获取表结构
+	Query      string // 原始语句
+}
+
+func (this *DDL) String() string {
+	return this.Schema + "." + this.Table
+}
+
+// 是否修改了表名
+func (this *DDL) IsRename() bool {
+	return this.NewTable != ""
+}
+
+// 解析DDL语句, 不是表相关的DDL(CREATE DATABASE, CREATE INDEX 等)返回nil.
+// currSchema 为执行DDL时的默认数据库, 表名没有指定数据库时使用
+func ParseDDL(query string, currSchema string) []*DDL {
+	s := newDDLScanner(query)
+	switch {
+	case s.keyword("CREATE"):
+		return parseCreateTable(s, query, currSchema)
+	case s.keyword("ALTER"):
+		return parseAlterTable(s, query, currSchema)
+	case s.keyword("DROP"):
+		return parseDropTable(s, query, currSchema)
+	case s.keyword("RENAME"):
+		return parseRenameTable(s, query, currSchema)
+	case s.keyword("TRUNCATE"):
+		s.keyword("TABLE")
+		sName, tName, ok := s.tableName(currSchema)
+		if !ok {
+			return nil
+		}
+		return []*DDL{{Type: DDLTypeTruncateTable, Schema: sName, Table: tName, Query: query}}
+	}
+
+	return nil
+}
+
+// CREATE [TEMPORARY] TABLE [IF NOT EXISTS] tbl (create_definition, ...) ...
+func parseCreateTable(s *ddlScanner, query string, currSchema string) []*DDL {
+	if s.keyword("TEMPORARY") { // 临时表不会产生 row event
+		return nil
+	}
+	if !s.keyword("TABLE") {
+		return nil
+	}
+	s.keywords("IF", "NOT", "EXISTS")
+	sName, tName, ok := s.tableName(currSchema)
+	if !ok {
+		return nil
+	}
+
+	ddl := &DDL{Type: DDLTypeCreateTable, Schema: sName, Table: tName, Query: query}
+	s.skipSpace()
+	if body, ok := s.parenthesized(); ok && !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(body)), "SELECT") {
+		ddl.Definition = body
+		ddl.Options = strings.TrimSpace(s.rest())
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(body)), "LIKE") {
+			ddl.Like = true
+		}
+	} else {
+		ddl.Like = true // CREATE TABLE ... LIKE / CREATE TABLE ... SELECT
+	}
+
+	return []*DDL{ddl}
+}
+
+// ALTER [ONLINE] [IGNORE] TABLE tbl [alter_specification [, alter_specification] ...]
+func parseAlterTable(s *ddlScanner, query string, currSchema string) []*DDL {
+	s.keyword("ONLINE")
+	s.keyword("IGNORE")
+	if !s.keyword("TABLE") {
+		return nil
+	}
+	sName, tName, ok := s.tableName(currSchema)
+	if !ok {
+		return nil
+	}
+
+	ddl := &DDL{Type: DDLTypeAlterTable, Schema: sName, Table: tName, Query: query}
+	ddl.Definition = strings.TrimSpace(s.rest())
+	for _, spec := range SplitDefinitions(ddl.Definition) {
+		ss := newDDLScanner(spec)
+		if !ss.keyword("RENAME") || ss.keyword("COLUMN") || ss.keyword("INDEX") || ss.keyword("KEY") {
+			continue
+		}
+		if !ss.keyword("TO") {
+			ss.keyword("AS")
+		}
+		if ddl.NewSchema, ddl.NewTable, ok = ss.tableName(sName); !ok {
+			return nil
+		}
+	}
+
+	return []*DDL{ddl}
+}
+
+// DROP [TEMPORARY] TABLE [IF EXISTS] tbl [, tbl] ...
+func parseDropTable(s *ddlScanner, query string, currSchema string) []*DDL {
+	if s.keyword("TEMPORARY") || !s.keyword("TABLE") {
+		return nil
+	}
+	s.keywords("IF", "EXISTS")
+
+	ddls := make([]*DDL, 0, 1)
+	for {
+		sName, tName, ok := s.tableName(currSchema)
+		if !ok {
+			break
+		}
+		ddls = append(ddls, &DDL{Type: DDLTypeDropTable, Schema: sName, Table: tName, Query: query})
+		if !s.char(',') {
+			break
+		}
+	}
+
+	return ddls
+}
+
+// RENAME TABLE tbl TO new_tbl [, tbl2 TO new_tbl2] ...
+func parseRenameTable(s *ddlScanner, query string, currSchema string) []*DDL {
+	if !s.keyword("TABLE") {
+		return nil
+	}
+
+	ddls := make([]*DDL, 0, 1)
+	for {
+		sName, tName, ok := s.tableName(currSchema)
+		if !ok || !s.keyword("TO") {
+			break
+		}
+		newSName, newTName, ok := s.tableName(currSchema)
+		if !ok {
+			break
+		}
+		ddls = append(ddls, &DDL{
+			Type:      DDLTypeRenameTable,
+			Schema:    sName,
+			Table:     tName,
+			NewSchema: newSName,
+			NewTable:  newTName,
+			Query:     query,
+		})
+		if !s.char(',') {
+			break
+		}
+	}
+
+	return ddls
+}
+
+// 按最外层的逗号拆分定义, 括号和引号中的逗号不拆分
+func SplitDefinitions(body string) []string {
+	defs := make([]string, 0, 1)
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			if def := strings.TrimSpace(body[start:i]); def != "" {
+				defs = append(defs, def)
+			}
+			start = i + 1
+		}
+	}
+	if def := strings.TrimSpace(body[start:]); def != "" {
+		defs = append(defs, def)
+	}
+
+	return defs
+}
+
+// 简单的DDL词法解析
+type ddlScanner struct {
+	s   string
+	pos int
+}
+
+func newDDLScanner(s string) *ddlScanner {
+	return &ddlScanner{s: s}
+}
+
+// 跳过空白和注释
+func (this *ddlScanner) skipSpace() {
+	for this.pos < len(this.s) {
+		c := this.s[this.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			this.pos++
+		case strings.HasPrefix(this.s[this.pos:], "/*"):
+			end := strings.Index(this.s[this.pos+2:], "*/")
+			if end < 0 {
+				this.pos = len(this.s)
+				return
+			}
+			this.pos += end + 4
+		case strings.HasPrefix(this.s[this.pos:], "-- ") || c == '#':
+			end := strings.Index(this.s[this.pos:], "\n")
+			if end < 0 {
+				this.pos = len(this.s)
+				return
+			}
+			this.pos += end + 1
+		default:
+			return
+		}
+	}
+}
+
+// 匹配一个关键字(不区分大小写), 匹配成功则跳过该关键字
+func (this *ddlScanner) keyword(kw string) bool {
+	this.skipSpace()
+	end := this.pos + len(kw)
+	if end > len(this.s) || !strings.EqualFold(this.s[this.pos:end], kw) {
+		return false
+	}
+	if end < len(this.s) && isIdentChar(this.s[end]) {
+		return false
+	}
+	this.pos = end
+	return true
+}
+
+// 依次匹配多个关键字, 全部匹配成功才跳过
+func (this *ddlScanner) keywords(kws ...string) bool {
+	pos := this.pos
+	for _, kw := range kws {
+		if !this.keyword(kw) {
+			this.pos = pos
+			return false
+		}
+	}
+	return true
+}
+
+// 匹配一个字符
+func (this *ddlScanner) char(c byte) bool {
+	this.skipSpace()
+	if this.pos < len(this.s) && this.s[this.pos] == c {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+// 获取一个标识符, 可以使用反引号
+func (this *ddlScanner) ident() (string, bool) {
+	this.skipSpace()
+	if this.pos >= len(this.s) {
+		return "", false
+	}
+	if this.s[this.pos] == '`' {
+		var buf strings.Builder
+		for i := this.pos + 1; i < len(this.s); i++ {
+			if this.s[i] != '`' {
+				buf.WriteByte(this.s[i])
+				continue
+			}
+			if i+1 < len(this.s) && this.s[i+1] == '`' { // 转义的反引号
+				buf.WriteByte('`')
+				i++
+				continue
+			}
+			this.pos = i + 1
+			return buf.String(), true
+		}
+		return "", false
+	}
+
+	start := this.pos
+	for this.pos < len(this.s) && isIdentChar(this.s[this.pos]) {
+		this.pos++
+	}
+	if start == this.pos {
+		return "", false
+	}
+	return this.s[start:this.pos], true
+}
+
+// 获取表名, 格式为 tbl 或 db.tbl
+func (this *ddlScanner) tableName(currSchema string) (string, string, bool) {
+	name, ok := this.ident()
+	if !ok {
+		return "", "", false
+	}
+	if this.pos < len(this.s) && this.s[this.pos] == '.' {
+		this.pos++
+		tName, ok := this.ident()
+		if !ok {
+			return "", "", false
+		}
+		return name, tName, true
+	}
+
+	return currSchema, name, true
+}
+
+// 获取括号中的内容
+func (this *ddlScanner) parenthesized() (string, bool) {
+	this.skipSpace()
+	if this.pos >= len(this.s) || this.s[this.pos] != '(' {
+		return "", false
+	}
+	depth := 0
+	var quote byte
+	for i := this.pos; i < len(this.s); i++ {
+		c := this.s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				body := this.s[this.pos+1 : i]
+				this.pos = i + 1
+				return body, true
+			}
+		}
+	}
+	return "", false
+}
+
+// 剩余没有解析的内容
+func (this *ddlScanner) rest() string {
+	this.skipSpace()
+	return this.s[this.pos:]
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
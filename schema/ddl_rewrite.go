@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 是否是会删除数据的DDL: DROP TABLE, TRUNCATE TABLE, ALTER TABLE ... DROP COLUMN
+func (this *DDL) IsDestructive() bool {
+	switch this.Type {
+	case DDLTypeDropTable, DDLTypeTruncateTable:
+		return true
+	case DDLTypeAlterTable:
+		return this.HasDropColumn()
+	}
+	return false
+}
+
+// ALTER TABLE 中是否有删除字段
+func (this *DDL) HasDropColumn() bool {
+	if this.Type != DDLTypeAlterTable {
+		return false
+	}
+	for _, spec := range SplitDefinitions(this.Definition) {
+		if isDropColumnSpec(spec) {
+			return true
+		}
+	}
+	return false
+}
+
+// 将DDL中的表名修改为目标表名, 生成在目标实例执行的语句.
+// skipDropColumn 为 true 时去掉 ALTER TABLE 中的删除字段. 没有需要执行的语句返回空字符串.
+// 多个源表写入同一个目标表时, 不删除, 清空和修改目标表名. 修改表名后目标表不变也不修改
+func (this *DDL) TargetSQL(router *TableRouter, skipDropColumn bool) string {
+	table := quoteTableName(router.Target(this.Schema, this.Table))
+	newTable := ""
+	if this.IsRename() {
+		newTable = quoteTableName(router.Target(this.NewSchema, this.NewTable))
+	}
+	merged := router.IsMerged(this.Schema, this.Table)
+
+	switch this.Type {
+	case DDLTypeCreateTable:
+		if this.Like {
+			return ""
+		}
+		return strings.TrimSpace(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) %s",
+			table, this.Definition, this.Options))
+	case DDLTypeAlterTable:
+		specs := make([]string, 0, 1)
+		for _, spec := range SplitDefinitions(this.Definition) {
+			if skipDropColumn && isDropColumnSpec(spec) {
+				continue
+			}
+			if isRenameTableSpec(spec) {
+				if merged || newTable == table {
+					continue
+				}
+				spec = "RENAME TO " + newTable
+			}
+			specs = append(specs, spec)
+		}
+		if len(specs) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("ALTER TABLE %s %s", table, strings.Join(specs, ", "))
+	case DDLTypeDropTable:
+		if merged {
+			return ""
+		}
+		return fmt.Sprintf("DROP TABLE IF EXISTS %s", table)
+	case DDLTypeRenameTable:
+		if merged || newTable == table {
+			return ""
+		}
+		return fmt.Sprintf("RENAME TABLE %s TO %s", table, newTable)
+	case DDLTypeTruncateTable:
+		if merged {
+			return ""
+		}
+		return fmt.Sprintf("TRUNCATE TABLE %s", table)
+	}
+
+	return ""
+}
+
+// 是否是删除字段的修改项: DROP [COLUMN] col
+func isDropColumnSpec(spec string) bool {
+	s := newDDLScanner(spec)
+	if !s.keyword("DROP") {
+		return false
+	}
+	if s.keyword("COLUMN") {
+		return true
+	}
+	return !isIndexDefinition(s.rest())
+}
+
+// 是否是修改表名的修改项: RENAME [TO|AS] tbl
+func isRenameTableSpec(spec string) bool {
+	s := newDDLScanner(spec)
+	if !s.keyword("RENAME") {
+		return false
+	}
+	return !s.keyword("COLUMN") && !s.keyword("INDEX") && !s.keyword("KEY")
+}
+
+func quoteTableName(sName string, tName string) string {
+	return fmt.Sprintf("`%s`.`%s`", strings.Replace(sName, "`", "``", -1), strings.Replace(tName, "`", "``", -1))
+}
@@ -0,0 +1,199 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestParseDDL(t *testing.T) {
+	cases := []struct {
+		query  string
+		expect []DDL
+	}{
+		{"CREATE DATABASE db2", nil},
+		{"create table if not exists `t2` (id int primary key)",
+			[]DDL{{Type: DDLTypeCreateTable, Schema: "db1", Table: "t2", Definition: "id int primary key"}}},
+		{"CREATE TABLE db2.t2 LIKE db1.t1",
+			[]DDL{{Type: DDLTypeCreateTable, Schema: "db2", Table: "t2", Like: true}}},
+		{"/* comment */ ALTER TABLE `db1`.`t1` ADD COLUMN c int, RENAME TO t3",
+			[]DDL{{Type: DDLTypeAlterTable, Schema: "db1", Table: "t1", NewSchema: "db1", NewTable: "t3",
+				Definition: "ADD COLUMN c int, RENAME TO t3"}}},
+		{"DROP TABLE `t1`,db2.t2 /* generated by server */",
+			[]DDL{{Type: DDLTypeDropTable, Schema: "db1", Table: "t1"}, {Type: DDLTypeDropTable, Schema: "db2", Table: "t2"}}},
+		{"RENAME TABLE t1 TO t1_old, t2 TO db2.t2",
+			[]DDL{{Type: DDLTypeRenameTable, Schema: "db1", Table: "t1", NewSchema: "db1", NewTable: "t1_old"},
+				{Type: DDLTypeRenameTable, Schema: "db1", Table: "t2", NewSchema: "db2", NewTable: "t2"}}},
+		{"TRUNCATE TABLE t1", []DDL{{Type: DDLTypeTruncateTable, Schema: "db1", Table: "t1"}}},
+	}
+
+	for _, c := range cases {
+		ddls := ParseDDL(c.query, "db1")
+		if len(ddls) != len(c.expect) {
+			t.Fatalf("%s: 解析出 %d 个DDL, 期望 %d 个", c.query, len(ddls), len(c.expect))
+		}
+		for i, ddl := range ddls {
+			c.expect[i].Query = c.query
+			if !reflect.DeepEqual(*ddl, c.expect[i]) {
+				t.Fatalf("%s: %#v, 期望: %#v", c.query, *ddl, c.expect[i])
+			}
+		}
+	}
+}
+
+func TestTable_ApplyAlterDDL(t *testing.T) {
+	tbl := newTestTable()
+	ddl := ParseDDL("ALTER TABLE t1 ADD COLUMN `c1` varchar(10) NOT NULL DEFAULT '' AFTER `id`, "+
+		"DROP COLUMN ext, CHANGE `name` `name2` char(20), MODIFY age int unsigned FIRST, "+
+		"ADD INDEX idx_c1(c1), ADD (c2 int, c3 json)", "db1")[0]
+
+	newTbl, err := tbl.ApplyAlterDDL(ddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNames := []string{"age", "id", "c1", "name2", "c2", "c3"}
+	if !reflect.DeepEqual(newTbl.ColumnNames, expectNames) {
+		t.Fatalf("字段: %v, 期望: %v", newTbl.ColumnNames, expectNames)
+	}
+	if newTbl.Version != tbl.Version+1 || newTbl.Columns[0].ColumnType != "int unsigned" {
+		t.Fatalf("版本: %d, age 类型: %s", newTbl.Version, newTbl.Columns[0].ColumnType)
+	}
+	expectInsert := "INSERT INTO `db1_archive`.`t1`(`age`, `id`, `c1`, `name2`, `c2`, `c3`) VALUES"
+	if newTbl.InsertTemplate != expectInsert {
+		t.Fatalf("insert 模板: %s", newTbl.InsertTemplate)
+	}
+	// 原来的版本不修改
+	if len(tbl.ColumnNames) != 4 || tbl.ColumnNames[1] != "name" {
+		t.Fatalf("原来的表结构被修改: %v", tbl.ColumnNames)
+	}
+
+	ddl = ParseDDL("ALTER TABLE t1 DROP PRIMARY KEY, ADD PRIMARY KEY (`id`, `c1`)", "db1")[0]
+	if newTbl, err = newTbl.ApplyAlterDDL(ddl); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(newTbl.PKColumnNames, []string{"id", "c1"}) {
+		t.Fatalf("主键: %v", newTbl.PKColumnNames)
+	}
+}
+
+func TestNewTableByCreateDDL(t *testing.T) {
+	ddl := ParseDDL("CREATE TABLE `t2` (\n  `id` bigint(20) unsigned NOT NULL,\n"+
+		"  `e` enum('a','b') DEFAULT NULL,\n  UNIQUE KEY `uk_e` (`e`),\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB", "db1")[0]
+	tbl, err := NewTableByCreateDDL(ddl, &TableRouter{SchemaSuffix: "_archive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tbl.ColumnNames, []string{"id", "e"}) || !reflect.DeepEqual(tbl.PKColumnNames, []string{"id"}) {
+		t.Fatalf("字段: %v, 主键: %v", tbl.ColumnNames, tbl.PKColumnNames)
+	}
+	if tbl.Columns[1].ColumnType != "enum('a','b')" || !tbl.Columns[0].IsUnsigned() {
+		t.Fatalf("字段类型: %s, %s", tbl.Columns[0].ColumnType, tbl.Columns[1].ColumnType)
+	}
+}
+
+func TestTable_ResolveByTableMap(t *testing.T) {
+	tbl := newTestTable()
+	e := &replication.TableMapEvent{
+		ColumnCount: 4,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_JSON},
+	}
+	if got, err := tbl.ResolveByTableMap(e); err != nil || got != tbl {
+		t.Fatalf("字段一致应该返回当前版本. %v", err)
+	}
+
+	// binlog 之后在最后添加了字段
+	e.ColumnCount = 3
+	e.ColumnType = e.ColumnType[:3]
+	got, err := tbl.ResolveByTableMap(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.ColumnNames, []string{"id", "name", "age"}) {
+		t.Fatalf("字段: %v", got.ColumnNames)
+	}
+	// 相同的字段数返回缓存的版本, 修改表结构后的新版本不使用之前的缓存
+	if again, err := tbl.ResolveByTableMap(e); err != nil || again != got {
+		t.Fatalf("相同的字段数应该返回同一个版本. %v", err)
+	}
+	if altered := tbl.clone(); altered.resolved != nil {
+		t.Fatal("新版本不应该使用之前版本的缓存")
+	}
+
+	// 字段类型不一致
+	e.ColumnType = []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_TINY}
+	if _, err = tbl.ResolveByTableMap(e); err == nil {
+		t.Fatal("字段类型不一致应该返回错误")
+	}
+}
+
+func TestDDL_TargetSQL(t *testing.T) {
+	cases := []struct {
+		query          string
+		skipDropColumn bool
+		destructive    bool
+		expect         string
+	}{
+		{"CREATE TABLE t2 (id int primary key) ENGINE=InnoDB", false, false,
+			"CREATE TABLE IF NOT EXISTS `db1_archive`.`t2` (id int primary key) ENGINE=InnoDB"},
+		{"CREATE TABLE t2 LIKE t1", false, false, ""},
+		{"ALTER TABLE t1 ADD COLUMN c int, DROP COLUMN ext, DROP INDEX idx_c, RENAME TO db2.t3", false, true,
+			"ALTER TABLE `db1_archive`.`t1` ADD COLUMN c int, DROP COLUMN ext, DROP INDEX idx_c, RENAME TO `db2_archive`.`t3`"},
+		{"ALTER TABLE t1 ADD COLUMN c int, DROP ext, DROP PRIMARY KEY", true, true,
+			"ALTER TABLE `db1_archive`.`t1` ADD COLUMN c int, DROP PRIMARY KEY"},
+		{"ALTER TABLE t1 DROP COLUMN ext", true, true, ""},
+		{"ALTER TABLE t1 DROP INDEX idx_c", false, false, "ALTER TABLE `db1_archive`.`t1` DROP INDEX idx_c"},
+		{"DROP TABLE t1", false, true, "DROP TABLE IF EXISTS `db1_archive`.`t1`"},
+		{"RENAME TABLE t1 TO db2.t2", false, false, "RENAME TABLE `db1_archive`.`t1` TO `db2_archive`.`t2`"},
+		{"TRUNCATE t1", false, true, "TRUNCATE TABLE `db1_archive`.`t1`"},
+	}
+
+	for _, c := range cases {
+		ddl := ParseDDL(c.query, "db1")[0]
+		if ddl.IsDestructive() != c.destructive {
+			t.Fatalf("%s: 是否删除数据: %v, 期望: %v", c.query, ddl.IsDestructive(), c.destructive)
+		}
+		if sqlStr := ddl.TargetSQL(&TableRouter{SchemaSuffix: "_archive"}, c.skipDropColumn); sqlStr != c.expect {
+			t.Fatalf("%s: %s, 期望: %s", c.query, sqlStr, c.expect)
+		}
+	}
+}
+
+func TestTable_ApplyAlterDDL_Mismatch(t *testing.T) {
+	// 表结构和DDL不一致(表结构不是DDL执行之前的表结构), 返回错误
+	tbl := newTestTable()
+	for _, query := range []string{
+		"ALTER TABLE t1 ADD COLUMN age bigint",
+		"ALTER TABLE t1 CHANGE `nm` `name` varchar(30)",
+		"ALTER TABLE t1 MODIFY `nm` varchar(30)",
+		"ALTER TABLE t1 RENAME COLUMN `nm` TO `name`",
+		"ALTER TABLE t1 DROP COLUMN c9",
+		"ALTER TABLE t1 ADD COLUMN c1 int AFTER c9",
+	} {
+		if _, err := tbl.ApplyAlterDDL(ParseDDL(query, "db1")[0]); err == nil {
+			t.Fatalf("%s: 表结构和DDL不一致应该返回错误", query)
+		}
+	}
+}
+
+func TestNewTableByCreateDDL_ColumnKey(t *testing.T) {
+	cases := []struct {
+		query  string
+		expect []string
+	}{
+		{"CREATE TABLE t3 (id int PRIMARY KEY, c varchar(10) UNIQUE)", []string{"id"}},
+		{"CREATE TABLE t3 (id int, c varchar(10) UNIQUE KEY COMMENT 'primary key')", []string{"c"}},
+		{"CREATE TABLE t3 (id int KEY, c enum('KEY', 'x'))", []string{"id"}},
+		{"CREATE TABLE t3 (id int, c varchar(10) DEFAULT 'key' COMMENT 'unique')", []string{"id", "c"}}, // 没有主键
+	}
+	for _, c := range cases {
+		tbl, err := NewTableByCreateDDL(ParseDDL(c.query, "db1")[0], &TableRouter{SchemaSuffix: "_archive"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tbl.PKColumnNames, c.expect) {
+			t.Fatalf("%s: 主键: %v, 期望: %v", c.query, tbl.PKColumnNames, c.expect)
+		}
+	}
+}
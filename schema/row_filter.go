@@ -0,0 +1,747 @@
+package schema
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 行数据过滤条件, 类似SQL的WHERE条件. 支持比较(=, !=, <>, <, <=, >, >=),
+// [NOT] IN, [NOT] BETWEEN, [NOT] LIKE, IS [NOT] NULL, 以及 AND, OR, NOT 和括号.
+// 字段值为NULL时比较结果为未知(和SQL一样), 只有结果为真的行才会执行
+type RowFilter struct {
+	Expr string
+	root filterNode
+}
+
+// 解析 schema.table: <expr> 格式的过滤规则, 返回表名(schema.table)和过滤条件
+func ParseRowFilterRule(rule string) (string, *RowFilter, error) {
+	idx := strings.Index(rule, ":")
+	if idx < 0 {
+		return "", nil, fmt.Errorf("行过滤规则格式错误, 应该为 schema.table: <expr>. %s", rule)
+	}
+
+	s := newDDLScanner(rule[:idx])
+	sName, tName, ok := s.tableName("")
+	if !ok || len(sName) == 0 || len(strings.TrimSpace(s.rest())) != 0 {
+		return "", nil, fmt.Errorf("行过滤规则表名格式错误, 应该为 schema.table. %s", rule)
+	}
+
+	filter, err := ParseRowFilter(rule[idx+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("行过滤规则: %s. %v", rule, err)
+	}
+
+	return fmt.Sprintf("%s.%s", sName, tName), filter, nil
+}
+
+// 解析过滤条件
+func ParseRowFilter(expr string) (*RowFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("过滤条件为空")
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("过滤条件 %s 附近有语法错误", p.tokens[p.pos].text)
+	}
+
+	return &RowFilter{Expr: strings.TrimSpace(expr), root: root}, nil
+}
+
+// 过滤条件中使用的字段名
+func (this *RowFilter) Columns() []string {
+	names := make([]string, 0, 1)
+	this.root.columns(&names)
+	return names
+}
+
+// 检测过滤条件中的字段在表中是否都存在
+func (this *RowFilter) Check(t *Table) error {
+	for _, name := range this.Columns() {
+		if _, ok := t.ColumnPos[name]; !ok {
+			return fmt.Errorf("表: %s 中不存在过滤条件中的字段: %s", t.String(), name)
+		}
+	}
+	return nil
+}
+
+// 行数据是否满足过滤条件, 字段通过 Table.ColumnPos 获取
+func (this *RowFilter) Match(t *Table, row []interface{}) (bool, error) {
+	if len(row) != len(t.ColumnNames) {
+		return false, fmt.Errorf("表: %s 数据字段数 %d 和表字段数 %d 不一致",
+			t.String(), len(row), len(t.ColumnNames))
+	}
+
+	b, err := this.root.eval(t, row)
+	if err != nil {
+		return false, fmt.Errorf("表: %s 过滤条件: %s. %v", t.String(), this.Expr, err)
+	}
+
+	return b == filterTrue, nil
+}
+
+// SQL的三值逻辑
+type filterBool int8
+
+const (
+	filterFalse filterBool = iota
+	filterTrue
+	filterUnknown
+)
+
+func toFilterBool(b bool) filterBool {
+	if b {
+		return filterTrue
+	}
+	return filterFalse
+}
+
+func (this filterBool) not() filterBool {
+	switch this {
+	case filterTrue:
+		return filterFalse
+	case filterFalse:
+		return filterTrue
+	}
+	return filterUnknown
+}
+
+type filterNode interface {
+	eval(t *Table, row []interface{}) (filterBool, error)
+	columns(names *[]string)
+}
+
+type filterAnd struct{ left, right filterNode }
+
+func (this *filterAnd) eval(t *Table, row []interface{}) (filterBool, error) {
+	l, err := this.left.eval(t, row)
+	if err != nil || l == filterFalse {
+		return l, err
+	}
+	r, err := this.right.eval(t, row)
+	if err != nil || r == filterFalse {
+		return r, err
+	}
+	if l == filterTrue && r == filterTrue {
+		return filterTrue, nil
+	}
+	return filterUnknown, nil
+}
+
+func (this *filterAnd) columns(names *[]string) {
+	this.left.columns(names)
+	this.right.columns(names)
+}
+
+type filterOr struct{ left, right filterNode }
+
+func (this *filterOr) eval(t *Table, row []interface{}) (filterBool, error) {
+	l, err := this.left.eval(t, row)
+	if err != nil || l == filterTrue {
+		return l, err
+	}
+	r, err := this.right.eval(t, row)
+	if err != nil || r == filterTrue {
+		return r, err
+	}
+	if l == filterFalse && r == filterFalse {
+		return filterFalse, nil
+	}
+	return filterUnknown, nil
+}
+
+func (this *filterOr) columns(names *[]string) {
+	this.left.columns(names)
+	this.right.columns(names)
+}
+
+type filterNot struct{ node filterNode }
+
+func (this *filterNot) eval(t *Table, row []interface{}) (filterBool, error) {
+	b, err := this.node.eval(t, row)
+	return b.not(), err
+}
+
+func (this *filterNot) columns(names *[]string) {
+	this.node.columns(names)
+}
+
+// 比较: =, !=, <, <=, >, >=
+type filterCompare struct {
+	op          string
+	left, right filterOperand
+}
+
+func (this *filterCompare) eval(t *Table, row []interface{}) (filterBool, error) {
+	l, err := this.left.value(t, row)
+	if err != nil {
+		return filterFalse, err
+	}
+	r, err := this.right.value(t, row)
+	if err != nil {
+		return filterFalse, err
+	}
+	if l == nil || r == nil {
+		return filterUnknown, nil
+	}
+
+	c := compareFilterValues(l, r)
+	switch this.op {
+	case "=":
+		return toFilterBool(c == 0), nil
+	case "!=", "<>":
+		return toFilterBool(c != 0), nil
+	case "<":
+		return toFilterBool(c < 0), nil
+	case "<=":
+		return toFilterBool(c <= 0), nil
+	case ">":
+		return toFilterBool(c > 0), nil
+	case ">=":
+		return toFilterBool(c >= 0), nil
+	}
+
+	return filterFalse, fmt.Errorf("不支持的比较操作: %s", this.op)
+}
+
+func (this *filterCompare) columns(names *[]string) {
+	this.left.columns(names)
+	this.right.columns(names)
+}
+
+// col IN (v1, v2, ...)
+type filterIn struct {
+	operand filterOperand
+	list    []filterOperand
+}
+
+func (this *filterIn) eval(t *Table, row []interface{}) (filterBool, error) {
+	v, err := this.operand.value(t, row)
+	if err != nil || v == nil {
+		return filterUnknown, err
+	}
+
+	result := filterFalse
+	for _, item := range this.list {
+		iv, err := item.value(t, row)
+		if err != nil {
+			return filterFalse, err
+		}
+		if iv == nil {
+			result = filterUnknown
+			continue
+		}
+		if compareFilterValues(v, iv) == 0 {
+			return filterTrue, nil
+		}
+	}
+
+	return result, nil
+}
+
+func (this *filterIn) columns(names *[]string) {
+	this.operand.columns(names)
+	for _, item := range this.list {
+		item.columns(names)
+	}
+}
+
+// col IS NULL
+type filterIsNull struct{ operand filterOperand }
+
+func (this *filterIsNull) eval(t *Table, row []interface{}) (filterBool, error) {
+	v, err := this.operand.value(t, row)
+	return toFilterBool(v == nil), err
+}
+
+func (this *filterIsNull) columns(names *[]string) {
+	this.operand.columns(names)
+}
+
+// col LIKE 'pattern', % 匹配任意个字符, _ 匹配一个字符, \ 转义
+type filterLike struct {
+	operand filterOperand
+	re      *regexp.Regexp
+}
+
+func (this *filterLike) eval(t *Table, row []interface{}) (filterBool, error) {
+	v, err := this.operand.value(t, row)
+	if err != nil || v == nil {
+		return filterUnknown, err
+	}
+
+	return toFilterBool(this.re.MatchString(filterString(v))), nil
+}
+
+func (this *filterLike) columns(names *[]string) {
+	this.operand.columns(names)
+}
+
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("(?s)^")
+	for i := 0; i < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		i += size
+		switch r {
+		case '%':
+			buf.WriteString(".*")
+		case '_':
+			buf.WriteString(".")
+		case '\\':
+			if i < len(pattern) {
+				r, size = utf8.DecodeRuneInString(pattern[i:])
+				i += size
+			}
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+
+	return regexp.Compile(buf.String())
+}
+
+// 比较的值: 字段或者常量
+type filterOperand interface {
+	value(t *Table, row []interface{}) (interface{}, error)
+	columns(names *[]string)
+}
+
+type filterColumn struct{ name string }
+
+func (this *filterColumn) value(t *Table, row []interface{}) (interface{}, error) {
+	pos, ok := t.ColumnPos[this.name]
+	if !ok {
+		return nil, fmt.Errorf("表中不存在字段: %s", this.name)
+	}
+
+	var column *models.Column
+	if pos < len(t.Columns) {
+		column = t.Columns[pos]
+	}
+
+	return ConvertValue(column, row[pos])
+}
+
+func (this *filterColumn) columns(names *[]string) {
+	*names = append(*names, this.name)
+}
+
+type filterLiteral struct{ v interface{} }
+
+func (this *filterLiteral) value(t *Table, row []interface{}) (interface{}, error) {
+	return this.v, nil
+}
+
+func (this *filterLiteral) columns(names *[]string) {}
+
+// 比较两个值. 有一个值是数字, 另一个值可以转化为数字, 按数字比较, 否则按字符串比较
+func compareFilterValues(a, b interface{}) int {
+	an, aIsNum := filterNumber(a)
+	bn, bIsNum := filterNumber(b)
+	if aIsNum || bIsNum {
+		if an == nil {
+			an, _ = new(big.Rat).SetString(strings.TrimSpace(filterString(a)))
+		}
+		if bn == nil {
+			bn, _ = new(big.Rat).SetString(strings.TrimSpace(filterString(b)))
+		}
+		if an != nil && bn != nil {
+			return an.Cmp(bn)
+		}
+	}
+
+	return strings.Compare(filterString(a), filterString(b))
+}
+
+// 数字类型的值转化为 big.Rat, 不是数字类型返回false
+func filterNumber(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint64:
+		return new(big.Rat).SetUint64(n), true
+	case uint8:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint16:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint32:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case float32:
+		return new(big.Rat).SetFloat64(float64(n)), true
+	case float64:
+		return new(big.Rat).SetFloat64(n), true
+	case *big.Rat:
+		return n, true
+	}
+	return nil, false
+}
+
+func filterString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case *big.Rat:
+		return s.RatString()
+	}
+	return fmt.Sprint(v)
+}
+
+// 过滤条件的词
+type filterToken struct {
+	kind int
+	text string
+}
+
+const (
+	filterTokenIdent  = iota + 1 // 字段名或关键字
+	filterTokenQuoted            // 反引号中的字段名
+	filterTokenString
+	filterTokenNumber
+	filterTokenSymbol
+)
+
+func tokenizeFilter(expr string) ([]*filterToken, error) {
+	tokens := make([]*filterToken, 0, 8)
+	s := newDDLScanner(expr)
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.s) {
+			return tokens, nil
+		}
+
+		c := s.s[s.pos]
+		switch {
+		case c == '`':
+			name, ok := s.ident()
+			if !ok {
+				return nil, fmt.Errorf("反引号没有结束: %s", s.s[s.pos:])
+			}
+			tokens = append(tokens, &filterToken{kind: filterTokenQuoted, text: name})
+		case c == '\'' || c == '"':
+			str, ok := s.quotedString()
+			if !ok {
+				return nil, fmt.Errorf("字符串没有结束: %s", s.s[s.pos:])
+			}
+			tokens = append(tokens, &filterToken{kind: filterTokenString, text: str})
+		case c >= '0' && c <= '9' || c == '.' || (c == '-' || c == '+') && s.pos+1 < len(s.s) && isNumberStart(s.s[s.pos+1]):
+			start := s.pos
+			s.pos++
+			for s.pos < len(s.s) && (isNumberChar(s.s[s.pos]) ||
+				(s.s[s.pos] == '-' || s.s[s.pos] == '+') && (s.s[s.pos-1] == 'e' || s.s[s.pos-1] == 'E')) {
+				s.pos++
+			}
+			tokens = append(tokens, &filterToken{kind: filterTokenNumber, text: s.s[start:s.pos]})
+		case isIdentChar(c):
+			name, _ := s.ident()
+			tokens = append(tokens, &filterToken{kind: filterTokenIdent, text: name})
+		default:
+			op := string(c)
+			if s.pos+1 < len(s.s) {
+				switch two := s.s[s.pos : s.pos+2]; two {
+				case "!=", "<>", "<=", ">=":
+					op = two
+				}
+			}
+			switch op {
+			case "=", "!=", "<>", "<", "<=", ">", ">=", "(", ")", ",":
+			default:
+				return nil, fmt.Errorf("不能识别的字符: %s", op)
+			}
+			s.pos += len(op)
+			tokens = append(tokens, &filterToken{kind: filterTokenSymbol, text: op})
+		}
+	}
+}
+
+func isNumberStart(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.'
+}
+
+func isNumberChar(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E'
+}
+
+// 获取单引号或双引号中的字符串, 支持 \ 转义和两个引号转义
+func (this *ddlScanner) quotedString() (string, bool) {
+	quote := this.s[this.pos]
+	var buf strings.Builder
+	for i := this.pos + 1; i < len(this.s); i++ {
+		c := this.s[i]
+		switch {
+		case c == '\\' && i+1 < len(this.s):
+			i++
+			switch this.s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case '0':
+				buf.WriteByte(0)
+			case '%', '_': // LIKE 中的转义需要保留
+				buf.WriteByte('\\')
+				buf.WriteByte(this.s[i])
+			default:
+				buf.WriteByte(this.s[i])
+			}
+		case c == quote:
+			if i+1 < len(this.s) && this.s[i+1] == quote {
+				buf.WriteByte(quote)
+				i++
+				continue
+			}
+			this.pos = i + 1
+			return buf.String(), true
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// 过滤条件语法解析
+type filterParser struct {
+	tokens []*filterToken
+	pos    int
+}
+
+func (this *filterParser) peek() *filterToken {
+	if this.pos < len(this.tokens) {
+		return this.tokens[this.pos]
+	}
+	return nil
+}
+
+// 匹配一个关键字
+func (this *filterParser) keyword(kw string) bool {
+	tok := this.peek()
+	if tok != nil && tok.kind == filterTokenIdent && strings.EqualFold(tok.text, kw) {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+// 匹配一个符号
+func (this *filterParser) symbol(sym string) bool {
+	tok := this.peek()
+	if tok != nil && tok.kind == filterTokenSymbol && tok.text == sym {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+func (this *filterParser) errorf(format string, args ...interface{}) error {
+	near := "结尾"
+	if tok := this.peek(); tok != nil {
+		near = tok.text
+	}
+	return fmt.Errorf("过滤条件 %s 附近有语法错误: %s", near, fmt.Sprintf(format, args...))
+}
+
+// or_expr: and_expr [OR and_expr] ...
+func (this *filterParser) parseOr() (filterNode, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for this.keyword("OR") {
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+// and_expr: not_expr [AND not_expr] ...
+func (this *filterParser) parseAnd() (filterNode, error) {
+	left, err := this.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for this.keyword("AND") {
+		right, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+// not_expr: NOT not_expr | predicate
+func (this *filterParser) parseNot() (filterNode, error) {
+	if this.keyword("NOT") {
+		node, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node: node}, nil
+	}
+	return this.parsePredicate()
+}
+
+// predicate: (or_expr) | operand 比较/IN/BETWEEN/LIKE/IS NULL
+func (this *filterParser) parsePredicate() (filterNode, error) {
+	if this.symbol("(") {
+		node, err := this.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !this.symbol(")") {
+			return nil, this.errorf("缺少 )")
+		}
+		return node, nil
+	}
+
+	left, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := this.peek(); tok != nil && tok.kind == filterTokenSymbol {
+		switch tok.text {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			this.pos++
+			right, err := this.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &filterCompare{op: tok.text, left: left, right: right}, nil
+		}
+	}
+
+	if this.keyword("IS") {
+		not := this.keyword("NOT")
+		if !this.keyword("NULL") {
+			return nil, this.errorf("IS 之后需要 NULL")
+		}
+		return wrapNot(&filterIsNull{operand: left}, not), nil
+	}
+
+	not := this.keyword("NOT")
+	switch {
+	case this.keyword("IN"):
+		if !this.symbol("(") {
+			return nil, this.errorf("IN 之后需要 (")
+		}
+		list := make([]filterOperand, 0, 1)
+		for {
+			item, err := this.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if !this.symbol(",") {
+				break
+			}
+		}
+		if !this.symbol(")") {
+			return nil, this.errorf("IN 列表缺少 )")
+		}
+		return wrapNot(&filterIn{operand: left, list: list}, not), nil
+	case this.keyword("BETWEEN"):
+		low, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !this.keyword("AND") {
+			return nil, this.errorf("BETWEEN 之后需要 AND")
+		}
+		high, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node := &filterAnd{
+			left:  &filterCompare{op: ">=", left: left, right: low},
+			right: &filterCompare{op: "<=", left: left, right: high},
+		}
+		return wrapNot(node, not), nil
+	case this.keyword("LIKE"):
+		tok := this.peek()
+		if tok == nil || tok.kind != filterTokenString {
+			return nil, this.errorf("LIKE 之后需要字符串")
+		}
+		this.pos++
+		re, err := likeToRegexp(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return wrapNot(&filterLike{operand: left, re: re}, not), nil
+	}
+
+	return nil, this.errorf("需要比较操作")
+}
+
+func wrapNot(node filterNode, not bool) filterNode {
+	if not {
+		return &filterNot{node: node}
+	}
+	return node
+}
+
+// operand: 字段名 | 数字 | 字符串 | NULL | TRUE | FALSE
+func (this *filterParser) parseOperand() (filterOperand, error) {
+	tok := this.peek()
+	if tok == nil {
+		return nil, this.errorf("缺少字段或值")
+	}
+
+	switch tok.kind {
+	case filterTokenQuoted:
+		this.pos++
+		return &filterColumn{name: tok.text}, nil
+	case filterTokenString:
+		this.pos++
+		return &filterLiteral{v: tok.text}, nil
+	case filterTokenNumber:
+		n, ok := new(big.Rat).SetString(tok.text)
+		if !ok {
+			return nil, this.errorf("不能识别的数字: %s", tok.text)
+		}
+		this.pos++
+		return &filterLiteral{v: n}, nil
+	case filterTokenIdent:
+		switch strings.ToUpper(tok.text) {
+		case "NULL":
+			this.pos++
+			return &filterLiteral{v: nil}, nil
+		case "TRUE":
+			this.pos++
+			return &filterLiteral{v: new(big.Rat).SetInt64(1)}, nil
+		case "FALSE":
+			this.pos++
+			return &filterLiteral{v: new(big.Rat).SetInt64(0)}, nil
+		case "AND", "OR", "NOT", "IN", "IS", "BETWEEN", "LIKE":
+			return nil, this.errorf("缺少字段或值")
+		}
+		this.pos++
+		return &filterColumn{name: tok.text}, nil
+	}
+
+	return nil, this.errorf("缺少字段或值")
+}
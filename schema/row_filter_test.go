@@ -0,0 +1,61 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestRowFilter_Match(t *testing.T) {
+	tbl := newTestTable()
+	row := []interface{}{int64(42), "closed", int8(-1), nil}
+
+	cases := []struct {
+		expr   string
+		expect bool
+	}{
+		{"id = 42 AND name IN ('closed', 'done')", true},
+		{"`id` != 42 OR name = \"open\"", false},
+		{"age > 200", true}, // 无符号字段按无符号比较
+		{"age BETWEEN 1 AND 100", false},
+		{"age NOT BETWEEN 1 AND 100", true},
+		{"name LIKE 'clo%' AND name NOT LIKE '_pen'", true},
+		{"ext IS NULL AND ext IS NOT NULL", false},
+		{"ext = 1", false},
+		{"NOT (ext = 1)", false}, // NULL比较结果为未知
+		{"NOT (ext = 1) OR id >= 42.0", true},
+		{"id IN (1, NULL)", false},
+		{"id = '42'", true},
+	}
+
+	for _, c := range cases {
+		filter, err := ParseRowFilter(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		ok, err := filter.Match(tbl, row)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if ok != c.expect {
+			t.Fatalf("%s: %v, 期望: %v", c.expr, ok, c.expect)
+		}
+	}
+}
+
+func TestParseRowFilterRule(t *testing.T) {
+	key, filter, err := ParseRowFilterRule("db1.`t1`: tenant_id = 42 AND status IN ('closed')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "db1.t1" || filter.Expr != "tenant_id = 42 AND status IN ('closed')" {
+		t.Fatalf("表: %s, 过滤条件: %s", key, filter.Expr)
+	}
+	if err = filter.Check(newTestTable()); err == nil {
+		t.Fatal("不存在的字段没有返回错误")
+	}
+
+	for _, rule := range []string{"t1: id = 1", "db1.t1 id = 1", "db1.t1: id =", "db1.t1: id = 1 AND", "db1.t1: (id = 1"} {
+		if _, _, err = ParseRowFilterRule(rule); err == nil {
+			t.Fatalf("%s: 没有返回错误", rule)
+		}
+	}
+}
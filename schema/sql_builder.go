@@ -0,0 +1,161 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+const (
+	SQL_TIME_FORMAT = "2006-01-02 15:04:05.999999"
+)
+
+// 无符号整型字段对应的位数
+var unsignedBits = map[string]uint{
+	"tinyint":   8,
+	"smallint":  16,
+	"mediumint": 24,
+	"int":       32,
+	"integer":   32,
+	"bigint":    64,
+}
+
+// 生成 insert 语句. 返回使用 ? 占位符的sql语句, 以及占位符对应的参数
+func (this *Table) BuildInsertSQL(rows [][]interface{}) (string, []interface{}, error) {
+	return this.buildRowsSQL(this.InsertTemplate, rows)
+}
+
+// 生成 replace 语句
+func (this *Table) BuildReplaceSQL(rows [][]interface{}) (string, []interface{}, error) {
+	return this.buildRowsSQL(this.ReplaceTemplate, rows)
+}
+
+// 生成 update 语句, 使用 before 的主键值作为条件, after 的值作为修改后的值
+func (this *Table) BuildUpdateSQL(before []interface{}, after []interface{}) (string, []interface{}, error) {
+	beforeArgs, err := this.ConvertRow(before)
+	if err != nil {
+		return "", nil, err
+	}
+	afterArgs, err := this.ConvertRow(after)
+	if err != nil {
+		return "", nil, err
+	}
+
+	args := make([]interface{}, 0, len(afterArgs)+len(this.PKColumnNames))
+	args = append(args, afterArgs...)
+	args = append(args, this.GetPKValues(beforeArgs)...)
+
+	return this.UpdateTemplate, args, nil
+}
+
+// 生成 delete 语句
+func (this *Table) BuildDeleteSQL(row []interface{}) (string, []interface{}, error) {
+	args, err := this.ConvertRow(row)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return this.DeleteTemplate, this.GetPKValues(args), nil
+}
+
+// 生成多行 values 的 insert/replace 语句
+func (this *Table) buildRowsSQL(template string, rows [][]interface{}) (string, []interface{}, error) {
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("表: %s 没有需要写入的数据", this.String())
+	}
+
+	var buf bytes.Buffer
+	args := make([]interface{}, 0, len(rows)*len(this.ColumnNames))
+	buf.WriteString(template)
+	for i, row := range rows {
+		rowArgs, err := this.ConvertRow(row)
+		if err != nil {
+			return "", nil, err
+		}
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(this.InsertValuePlaceholderTemplate)
+		args = append(args, rowArgs...)
+	}
+
+	return buf.String(), args, nil
+}
+
+// 将 RowsEvent 解析出来的一行数据转化为可以作为 sql 参数的值
+func (this *Table) ConvertRow(row []interface{}) ([]interface{}, error) {
+	if len(row) != len(this.ColumnNames) {
+		return nil, fmt.Errorf("表: %s 数据字段数 %d 和表字段数 %d 不一致",
+			this.String(), len(row), len(this.ColumnNames))
+	}
+
+	args := make([]interface{}, len(row))
+	for i, value := range row {
+		var column *models.Column
+		if i < len(this.Columns) {
+			column = this.Columns[i]
+		}
+		arg, err := ConvertValue(column, value)
+		if err != nil {
+			return nil, fmt.Errorf("表: %s 字段: %s. %v", this.String(), this.ColumnNames[i], err)
+		}
+		args[i] = arg
+	}
+
+	return args, nil
+}
+
+// 转化 go-mysql 解析出来的字段值, 以下几种需要特殊处理:
+//  1. 无符号整型是以有符号整型保存的, 需要通过字段类型转化回无符号
+//  2. json 类型是 []byte, 直接写入会被当作 binary 字符集, 需要转化为 string
+//  3. decimal(UseDecimal 解析为 decimal.Decimal) 需要转化为字符串, 保留原来的精度
+//  4. time.Time(审计字段的时间) 需要转化为字符串. 没有开启 ParseTime, binlog 中的时间类型已经是字符串
+func ConvertValue(column *models.Column, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case int8:
+		return convertInt(column, int64(v)), nil
+	case int16:
+		return convertInt(column, int64(v)), nil
+	case int32:
+		return convertInt(column, int64(v)), nil
+	case int64:
+		return convertInt(column, v), nil
+	case int:
+		return convertInt(column, int64(v)), nil
+	case uint8, uint16, uint32, uint64, uint, float32, float64, string:
+		return v, nil
+	case []byte:
+		if column != nil && strings.ToLower(column.DataType) == "json" {
+			return string(v), nil
+		}
+		return v, nil
+	case time.Time:
+		return v.Format(SQL_TIME_FORMAT), nil
+	case fmt.Stringer: // decimal.Decimal
+		return v.String(), nil
+	}
+
+	return nil, fmt.Errorf("不支持的字段值类型: %T", value)
+}
+
+// 将以有符号保存的无符号整型转化回来
+func convertInt(column *models.Column, v int64) interface{} {
+	if column == nil || v >= 0 || !column.IsUnsigned() {
+		return v
+	}
+
+	bits, ok := unsignedBits[strings.ToLower(column.DataType)]
+	if !ok {
+		return v
+	}
+	if bits == 64 {
+		return uint64(v)
+	}
+
+	return uint64(v + (1 << bits))
+}
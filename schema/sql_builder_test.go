@@ -0,0 +1,142 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/shopspring/decimal"
+)
+
+func newTestTable() *Table {
+	t := &Table{
+		SchemaName: "db1",
+		Router:     &TableRouter{SchemaSuffix: "_archive"},
+		TableName:  "t1",
+		Columns: []*models.Column{
+			{ColumnName: "id", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+			{ColumnName: "name", DataType: "varchar", ColumnType: "varchar(20)"},
+			{ColumnName: "age", DataType: "tinyint", ColumnType: "tinyint(3) unsigned"},
+			{ColumnName: "ext", DataType: "json", ColumnType: "json"},
+		},
+		ColumnNames:   []string{"id", "name", "age", "ext"},
+		PKColumnNames: []string{"id"},
+		PKType:        PKTypePK,
+	}
+	t.initColumnPos()
+	t.initSQLTemplate()
+
+	return t
+}
+
+func TestTable_BuildInsertSQL(t *testing.T) {
+	tbl := newTestTable()
+	rows := [][]interface{}{
+		{int64(1), "it's \"a\" \\ name", int8(-1), []byte(`{"a": 1}`)},
+		{int64(-1), nil, int8(2), nil},
+	}
+
+	sql, args, err := tbl.BuildInsertSQL(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSQL := "INSERT INTO `db1_archive`.`t1`(`id`, `name`, `age`, `ext`) VALUES(?, ?, ?, ?), (?, ?, ?, ?)"
+	if sql != expectSQL {
+		t.Fatalf("sql: %s, expect: %s", sql, expectSQL)
+	}
+	expectArgs := []interface{}{
+		int64(1), "it's \"a\" \\ name", uint64(255), `{"a": 1}`,
+		uint64(18446744073709551615), nil, int64(2), nil,
+	}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Fatalf("args: %#v, expect: %#v", args, expectArgs)
+	}
+}
+
+func TestTable_BuildUpdateSQL(t *testing.T) {
+	tbl := newTestTable()
+	sql, args, err := tbl.BuildUpdateSQL(
+		[]interface{}{int64(1), "a", int8(1), nil},
+		[]interface{}{int64(2), "b", int8(1), nil},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSQL := "UPDATE `db1_archive`.`t1` SET `id` = ?, `name` = ?, `age` = ?, `ext` = ? WHERE `id` <=> ?"
+	if sql != expectSQL {
+		t.Fatalf("sql: %s, expect: %s", sql, expectSQL)
+	}
+	expectArgs := []interface{}{int64(2), "b", int64(1), nil, int64(1)}
+	if !reflect.DeepEqual(args, expectArgs) {
+		t.Fatalf("args: %#v, expect: %#v", args, expectArgs)
+	}
+}
+
+func TestTable_BuildDeleteSQL(t *testing.T) {
+	tbl := newTestTable()
+	if _, _, err := tbl.BuildDeleteSQL([]interface{}{int64(1)}); err == nil {
+		t.Fatal("字段数不一致应该返回错误")
+	}
+
+	sql, args, err := tbl.BuildDeleteSQL([]interface{}{int64(1), "a", int8(1), nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSQL := "DELETE FROM `db1_archive`.`t1` WHERE `id` <=> ?"
+	if sql != expectSQL {
+		t.Fatalf("sql: %s, expect: %s", sql, expectSQL)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(1)}) {
+		t.Fatalf("args: %#v", args)
+	}
+}
+
+func TestInterpolateSQL(t *testing.T) {
+	sql, err := InterpolateSQL("DELETE FROM `d?b`.`t` WHERE `a` <=> ? AND `b` <=> ? AND `c` <=> ?",
+		[]interface{}{uint64(18446744073709551615), "it's\n\\", []byte{0x00, 0xff}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSQL := "DELETE FROM `d?b`.`t` WHERE `a` <=> 18446744073709551615 AND `b` <=> 'it\\'s\\n\\\\' AND `c` <=> X'00ff'"
+	if sql != expectSQL {
+		t.Fatalf("sql: %s, expect: %s", sql, expectSQL)
+	}
+
+	if _, err = InterpolateSQL("SELECT ?", nil); err == nil {
+		t.Fatal("参数个数不足应该返回错误")
+	}
+}
+
+func TestTable_BuildReplaceSQL(t *testing.T) {
+	tbl := newTestTable()
+	expectTemplate := "REPLACE INTO `db1_archive`.`t1`(`id`, `name`, `age`, `ext`) VALUES"
+	if tbl.ReplaceTemplate != expectTemplate {
+		t.Fatalf("replace 模板: %s", tbl.ReplaceTemplate)
+	}
+
+	sql, args, err := tbl.BuildReplaceSQL([][]interface{}{{int64(1), "a", int8(1), nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != expectTemplate+"(?, ?, ?, ?)" {
+		t.Fatalf("sql: %s", sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(1), "a", int64(1), nil}) {
+		t.Fatalf("args: %#v", args)
+	}
+
+	if _, _, err = tbl.BuildReplaceSQL(nil); err == nil {
+		t.Fatal("没有数据应该返回错误")
+	}
+}
+
+func TestConvertValue_Decimal(t *testing.T) {
+	column := &models.Column{ColumnName: "amount", DataType: "decimal", ColumnType: "decimal(38,18)"}
+	v, err := ConvertValue(column, decimal.RequireFromString("12345678901234567890.123456789012345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "12345678901234567890.123456789012345678" {
+		t.Fatalf("decimal 值: %#v", v)
+	}
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 将使用 ? 占位符的sql语句和参数拼接成完整的sql语句, 反引号中的 ? 不当作占位符
+func InterpolateSQL(sql string, args []interface{}) (string, error) {
+	var buf bytes.Buffer
+	argPos := 0
+	inQuote := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '`':
+			inQuote = !inQuote
+			buf.WriteByte(c)
+		case c == '?' && !inQuote:
+			if argPos >= len(args) {
+				return "", fmt.Errorf("sql占位符个数多于参数个数 %d. %s", len(args), sql)
+			}
+			literal, err := SQLLiteral(args[argPos])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(literal)
+			argPos++
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if argPos != len(args) {
+		return "", fmt.Errorf("sql占位符个数 %d 少于参数个数 %d. %s", argPos, len(args), sql)
+	}
+
+	return buf.String(), nil
+}
+
+// 将参数值转化为sql字面量
+func SQLLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		if len(v) == 0 {
+			return "''", nil
+		}
+		return "X'" + hex.EncodeToString(v) + "'", nil
+	case time.Time:
+		return quoteString(v.Format(SQL_TIME_FORMAT)), nil
+	case fmt.Stringer:
+		return quoteString(v.String()), nil
+	}
+
+	return "", fmt.Errorf("不支持的参数类型: %T", value)
+}
+
+// 转义字符串并加上单引号
+func quoteString(s string) string {
+	var buf bytes.Buffer
+	buf.Grow(len(s) + 2)
+	buf.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\032':
+			buf.WriteString(`\Z`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('\'')
+
+	return buf.String()
+}
@@ -2,7 +2,9 @@ package schema
 
 import (
 	"fmt"
+	"github.com/daiguadaidai/haqi/config"
 	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/models"
 	"github.com/daiguadaidai/haqi/utils"
 	"github.com/ngaut/log"
 	"strings"
@@ -18,32 +20,42 @@ var (
 
 type Table struct {
 	SchemaName                     string
-	SchemaSuffix                   string // schema后缀
+	Router                         *TableRouter // 目标表名路由
 	TableName                      string
 	ColumnNames                    []string
-	ColumnPos                      map[string]int // 每个字段对应的slice位置
-	PKColumnNames                  []string       // 主键的所有字段
-	PKType                                        // 主键类型 全部列. 主键. 唯一键
-	InsertTemplate                 string         // insert sql 模板
-	InsertValuePlaceholderTemplate string         // insert value 块的占位符
-	UpdateTemplate                 string         // update sql 模板
-	DeleteTemplate                 string         // delete sql 模板
+	Columns                        []*models.Column // 字段信息, 和 ColumnNames 一一对应
+	ColumnPos                      map[string]int   // 每个字段对应的slice位置
+	PKColumnNames                  []string         // 主键的所有字段
+	PKType                                          // 主键类型 全部列. 主键. 唯一键
+	InsertTemplate                 string           // insert sql 模板
+	InsertValuePlaceholderTemplate string           // insert value 块的占位符
+	ReplaceTemplate                string           // replace sql 模板
+	UpdateTemplate                 string           // update sql 模板
+	DeleteTemplate                 string           // delete sql 模板
+	Version                        int              // 表结构版本, 解析到修改表结构的DDL后加1
+	resolved                       map[int]*Table   // 和 TableMapEvent 字段数(少于表结构字段数)一致的表结构, 只在解析线程中使用
 }
 
 func (this *Table) String() string {
 	return fmt.Sprintf("%s.%s", this.SchemaName, this.TableName)
 }
 
-func NewTable(sName string, sSuffix string, tName string, host string, port int) (*Table, error) {
+func NewTable(sName string, router *TableRouter, tName string, dbc *config.DBConfig) (*Table, error) {
+	defaultDao, err := dao.NewDefaultDao(dbc)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTableByDao(sName, router, tName, defaultDao)
+}
+
+// 通过指定的表结构数据源创建表信息
+func NewTableByDao(sName string, router *TableRouter, tName string, dao dao.MetaDao) (*Table, error) {
 	t := new(Table)
 	t.SchemaName = sName
-	t.SchemaSuffix = sSuffix
+	t.Router = router
 	t.TableName = tName
 
-	dao, err := dao.NewDefaultDao(host, port)
-	if err != nil {
-		return nil, err
-	}
 	// 添加字段
 	if err := t.addColumnNames(dao); err != nil {
 		return nil, err
@@ -61,29 +73,47 @@ func NewTable(sName string, sSuffix string, tName string, host string, port int)
 	return t, nil
 }
 
+// 获取数据库名, needSuffix 为 true 时获取目标数据库名
 func (this *Table) GetSchema(needSuffix bool) string {
 	if needSuffix {
-		return fmt.Sprintf("%s%s", this.SchemaName, this.SchemaSuffix)
+		sName, _ := this.TargetName()
+		return sName
 	}
 	return this.SchemaName
 }
 
+// 获取目标库名和目标表名
+func (this *Table) TargetName() (string, string) {
+	return this.Router.Target(this.SchemaName, this.TableName)
+}
+
+// 目标表名: schema.table
+func (this *Table) TargetString() string {
+	sName, tName := this.TargetName()
+	return fmt.Sprintf("%s.%s", sName, tName)
+}
+
 // 添加表的所有字段名
-func (this *Table) addColumnNames(dao *dao.DefaultDao) error {
+func (this *Table) addColumnNames(dao dao.MetaDao) error {
 	var err error
-	if this.ColumnNames, err = dao.FindTableColumnNames(this.SchemaName, this.TableName); err != nil {
+	if this.Columns, err = dao.FindTableColumns(this.SchemaName, this.TableName); err != nil {
 		return err
 	}
 
-	if len(this.ColumnNames) == 0 {
+	if len(this.Columns) == 0 {
 		return fmt.Errorf("表:%s 没有获取到字段, 请确认指定表是否不存在", this.String())
 	}
 
+	this.ColumnNames = make([]string, len(this.Columns))
+	for i, column := range this.Columns {
+		this.ColumnNames[i] = column.ColumnName
+	}
+
 	return nil
 }
 
 // 添加主键
-func (this *Table) addPK(dao *dao.DefaultDao) error {
+func (this *Table) addPK(dao dao.MetaDao) error {
 	// 获取 主键
 	pkColumnNames, err := dao.FindTablePKColumnNames(this.SchemaName, this.TableName)
 	if err != nil {
@@ -135,26 +165,33 @@ func (this *Table) initSQLTemplate() {
 
 // 初始化 insert sql 模板
 func (this *Table) initInsertTemplate() {
+	sName, tName := this.TargetName()
 	template := "INSERT INTO `%s`.`%s`(`%s`) VALUES"
-	this.InsertTemplate = fmt.Sprintf(template, this.GetSchema(true), this.TableName,
+	this.InsertTemplate = fmt.Sprintf(template, sName, tName,
 		strings.Join(this.ColumnNames, "`, `"))
 	this.InsertValuePlaceholderTemplate = fmt.Sprintf("(%s)",
-		utils.StrRepeat("%#v", len(this.ColumnNames), ","))
+		utils.StrRepeat("?", len(this.ColumnNames), ", "))
+
+	template = "REPLACE INTO `%s`.`%s`(`%s`) VALUES"
+	this.ReplaceTemplate = fmt.Sprintf(template, sName, tName,
+		strings.Join(this.ColumnNames, "`, `"))
 }
 
-// 初始化 update sql 模板
+// 初始化 update sql 模板, where 条件使用 <=> 保证主键值为 NULL 时也能匹配
 func (this *Table) initUpdateTemplate() {
-	template := "UPDATE `%s`.`%s` SET %s WHERE %s;\n"
-	this.UpdateTemplate = fmt.Sprintf(template, this.GetSchema(true), this.TableName,
-		utils.SqlExprPlaceholderByColumns(this.ColumnNames, "=", "%#v", ", "),
-		utils.SqlExprPlaceholderByColumns(this.PKColumnNames, "=", "%#v", "AND "))
+	sName, tName := this.TargetName()
+	template := "UPDATE `%s`.`%s` SET %s WHERE %s"
+	this.UpdateTemplate = fmt.Sprintf(template, sName, tName,
+		utils.SqlExprPlaceholderByColumns(this.ColumnNames, "=", "?", ", "),
+		utils.SqlExprPlaceholderByColumns(this.PKColumnNames, "<=>", "?", " AND "))
 }
 
 // 初始化 delete sql 模板
 func (this *Table) initDeleteTemplate() {
-	template := "DELETE FROM `%s`.`%s` WHERE %s;\n"
-	this.DeleteTemplate = fmt.Sprintf(template, this.GetSchema(true), this.TableName,
-		utils.SqlExprPlaceholderByColumns(this.PKColumnNames, "=", "%#v", "AND "))
+	sName, tName := this.TargetName()
+	template := "DELETE FROM `%s`.`%s` WHERE %s"
+	this.DeleteTemplate = fmt.Sprintf(template, sName, tName,
+		utils.SqlExprPlaceholderByColumns(this.PKColumnNames, "<=>", "?", " AND "))
 }
 
 func (this *Table) SetPKValues(row []interface{}, pkValues []interface{}) {
@@ -162,3 +199,10 @@ func (this *Table) SetPKValues(row []interface{}, pkValues []interface{}) {
 		pkValues[i] = row[this.ColumnPos[v]]
 	}
 }
+
+// 获取行数据的主键值
+func (this *Table) GetPKValues(row []interface{}) []interface{} {
+	pkValues := make([]interface{}, len(this.PKColumnNames))
+	this.SetPKValues(row, pkValues)
+	return pkValues
+}
@@ -0,0 +1,427 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/ngaut/log"
+)
+
+// 通过 CREATE TABLE 语句创建表信息
+func NewTableByCreateDDL(ddl *DDL, router *TableRouter) (*Table, error) {
+	if ddl.Type != DDLTypeCreateTable || ddl.Like {
+		return nil, fmt.Errorf("不能通过该语句获取表结构: %s", ddl.Query)
+	}
+
+	t := new(Table)
+	t.SchemaName = ddl.Schema
+	t.Router = router
+	t.TableName = ddl.Table
+	t.Columns = make([]*models.Column, 0, 10)
+	var ukColumnNames []string
+	for _, def := range SplitDefinitions(ddl.Definition) {
+		s := newDDLScanner(def)
+		switch {
+		case s.keywords("PRIMARY", "KEY"):
+			t.PKColumnNames = indexColumnNames(s)
+		case s.keyword("UNIQUE"):
+			if !s.keyword("KEY") {
+				s.keyword("INDEX")
+			}
+			if ukColumnNames == nil {
+				ukColumnNames = indexColumnNames(s)
+			}
+		case isIndexDefinition(def):
+		default:
+			column, err := models.ParseColumnDefinition(def)
+			if err != nil {
+				return nil, fmt.Errorf("表: %s.%s. %v", ddl.Schema, ddl.Table, err)
+			}
+			t.Columns = append(t.Columns, column)
+			// 字段定义中的 PRIMARY KEY 和 UNIQUE, 如: id int PRIMARY KEY
+			switch columnKeyOption(def) {
+			case columnKeyPrimary:
+				t.PKColumnNames = []string{column.ColumnName}
+			case columnKeyUnique:
+				if ukColumnNames == nil {
+					ukColumnNames = []string{column.ColumnName}
+				}
+			}
+		}
+	}
+	if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("表: %s.%s 建表语句中没有字段", ddl.Schema, ddl.Table)
+	}
+
+	t.PKType = PKTypePK
+	if len(t.PKColumnNames) == 0 && len(ukColumnNames) != 0 {
+		t.PKColumnNames = ukColumnNames
+	}
+	t.refreshColumns()
+	t.initSQLTemplate()
+
+	return t, nil
+}
+
+// 应用 ALTER TABLE 语句, 返回新版本的表信息, 原来的表信息不修改.
+// 支持 ADD/DROP/CHANGE/MODIFY/RENAME COLUMN, ADD/DROP PRIMARY KEY 和 RENAME TO, 其他修改不影响字段
+func (this *Table) ApplyAlterDDL(ddl *DDL) (*Table, error) {
+	if ddl.Type != DDLTypeAlterTable {
+		return nil, fmt.Errorf("不是 ALTER TABLE 语句: %s", ddl.Query)
+	}
+
+	t := this.clone()
+	for _, spec := range SplitDefinitions(ddl.Definition) {
+		if err := t.applyAlterSpec(spec); err != nil {
+			return nil, fmt.Errorf("表: %s 应用DDL失败. %s. %v", this.String(), spec, err)
+		}
+	}
+	if ddl.IsRename() {
+		t.SchemaName = ddl.NewSchema
+		t.TableName = ddl.NewTable
+	}
+	t.refreshColumns()
+	t.initSQLTemplate()
+
+	return t, nil
+}
+
+// 修改表名, 返回新版本的表信息
+func (this *Table) Rename(sName string, tName string) *Table {
+	t := this.clone()
+	t.SchemaName = sName
+	t.TableName = tName
+	t.initSQLTemplate()
+
+	return t
+}
+
+// 应用一个修改项
+func (this *Table) applyAlterSpec(spec string) error {
+	s := newDDLScanner(spec)
+	switch {
+	case s.keyword("ADD"):
+		if s.keywords("PRIMARY", "KEY") {
+			this.PKColumnNames = indexColumnNames(s)
+			this.PKType = PKTypePK
+			return nil
+		}
+		if isIndexDefinition(s.rest()) {
+			return nil
+		}
+		s.keyword("COLUMN")
+		if body, ok := s.parenthesized(); ok { // ADD COLUMN (c1 int, c2 int)
+			for _, def := range SplitDefinitions(body) {
+				if err := this.addColumn(def, ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return this.addColumnWithPosition(s.rest(), "")
+	case s.keyword("DROP"):
+		if s.keywords("PRIMARY", "KEY") {
+			this.PKColumnNames = nil
+			return nil
+		}
+		if isIndexDefinition(s.rest()) || s.keyword("FOREIGN") || s.keyword("CHECK") || s.keyword("CONSTRAINT") {
+			return nil
+		}
+		s.keyword("COLUMN")
+		name, ok := s.ident()
+		if !ok {
+			return fmt.Errorf("没有获取到需要删除的字段名")
+		}
+		return this.dropColumn(name)
+	case s.keyword("CHANGE"):
+		s.keyword("COLUMN")
+		oldName, ok := s.ident()
+		if !ok {
+			return fmt.Errorf("没有获取到需要修改的字段名")
+		}
+		return this.addColumnWithPosition(s.rest(), oldName)
+	case s.keyword("MODIFY"):
+		s.keyword("COLUMN")
+		rest := s.rest()
+		name, ok := newDDLScanner(rest).ident()
+		if !ok {
+			return fmt.Errorf("没有获取到需要修改的字段名")
+		}
+		return this.addColumnWithPosition(rest, name)
+	case s.keyword("RENAME"):
+		if !s.keyword("COLUMN") {
+			return nil // RENAME TO/INDEX/KEY
+		}
+		oldName, ok := s.ident()
+		if !ok || !s.keyword("TO") {
+			return fmt.Errorf("不能解析的修改字段名语句")
+		}
+		newName, ok := s.ident()
+		if !ok {
+			return fmt.Errorf("不能解析的修改字段名语句")
+		}
+		idx := this.columnIndex(oldName)
+		if idx < 0 {
+			return fmt.Errorf("字段 %s 不存在", oldName)
+		}
+		column := *this.Columns[idx]
+		column.ColumnName = newName
+		this.Columns[idx] = &column
+		this.renamePKColumn(oldName, newName)
+	}
+
+	return nil
+}
+
+// 添加字段或者修改字段(oldName 不为空), 处理 FIRST 和 AFTER
+func (this *Table) addColumnWithPosition(def string, oldName string) error {
+	after := ""
+	upper := strings.ToUpper(def)
+	if strings.HasSuffix(upper, " FIRST") {
+		def = strings.TrimSpace(def[:len(def)-len(" FIRST")])
+		after = "\x00" // 代表第一个位置
+	} else if idx := strings.LastIndex(upper, " AFTER "); idx > 0 {
+		afterName, ok := newDDLScanner(def[idx+len(" AFTER "):]).ident()
+		if ok {
+			after = afterName
+			def = strings.TrimSpace(def[:idx])
+		}
+	}
+
+	if oldName == "" {
+		return this.addColumn(def, after)
+	}
+	return this.changeColumn(oldName, def, after)
+}
+
+// 添加字段. after 为空添加到最后, after 为 \x00 添加到第一个
+func (this *Table) addColumn(def string, after string) error {
+	column, err := models.ParseColumnDefinition(def)
+	if err != nil {
+		return err
+	}
+	if this.columnIndex(column.ColumnName) >= 0 {
+		return fmt.Errorf("字段 %s 已经存在", column.ColumnName)
+	}
+	if err = this.insertColumn(column, after); err != nil {
+		return err
+	}
+	if columnKeyOption(def) == columnKeyPrimary {
+		this.PKColumnNames = []string{column.ColumnName}
+		this.PKType = PKTypePK
+	}
+
+	return nil
+}
+
+// 修改字段定义(名称, 类型, 位置)
+func (this *Table) changeColumn(oldName string, def string, after string) error {
+	idx := this.columnIndex(oldName)
+	if idx < 0 {
+		return fmt.Errorf("字段 %s 不存在", oldName)
+	}
+	column, err := models.ParseColumnDefinition(def)
+	if err != nil {
+		return err
+	}
+
+	if after == "" { // 位置不变
+		this.Columns[idx] = column
+	} else {
+		this.Columns = append(this.Columns[:idx], this.Columns[idx+1:]...)
+		if err = this.insertColumn(column, after); err != nil {
+			return err
+		}
+	}
+	this.renamePKColumn(oldName, column.ColumnName)
+
+	return nil
+}
+
+// 在指定位置插入字段
+func (this *Table) insertColumn(column *models.Column, after string) error {
+	pos := len(this.Columns)
+	switch after {
+	case "":
+	case "\x00":
+		pos = 0
+	default:
+		idx := this.columnIndex(after)
+		if idx < 0 {
+			return fmt.Errorf("字段 %s 不存在", after)
+		}
+		pos = idx + 1
+	}
+
+	columns := make([]*models.Column, 0, len(this.Columns)+1)
+	columns = append(columns, this.Columns[:pos]...)
+	columns = append(columns, column)
+	columns = append(columns, this.Columns[pos:]...)
+	this.Columns = columns
+
+	return nil
+}
+
+// 删除字段, 主键中的该字段也会被删除
+func (this *Table) dropColumn(name string) error {
+	idx := this.columnIndex(name)
+	if idx < 0 {
+		return fmt.Errorf("字段 %s 不存在", name)
+	}
+	this.Columns = append(this.Columns[:idx], this.Columns[idx+1:]...)
+
+	if this.PKType == PKTypePK {
+		pkColumnNames := make([]string, 0, len(this.PKColumnNames))
+		for _, pkName := range this.PKColumnNames {
+			if !strings.EqualFold(pkName, name) {
+				pkColumnNames = append(pkColumnNames, pkName)
+			}
+		}
+		this.PKColumnNames = pkColumnNames
+	}
+
+	return nil
+}
+
+// 主键字段名修改
+func (this *Table) renamePKColumn(oldName string, newName string) {
+	if this.PKType != PKTypePK {
+		return
+	}
+	for i, pkName := range this.PKColumnNames {
+		if strings.EqualFold(pkName, oldName) {
+			this.PKColumnNames[i] = newName
+		}
+	}
+}
+
+// 获取字段的位置, 不存在返回 -1. 字段名不区分大小写
+func (this *Table) columnIndex(name string) int {
+	for i, column := range this.Columns {
+		if strings.EqualFold(column.ColumnName, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 字段修改后, 重新生成字段名和字段位置. 没有主键使用所有字段作为主键
+func (this *Table) refreshColumns() {
+	this.ColumnNames = make([]string, len(this.Columns))
+	for i, column := range this.Columns {
+		this.ColumnNames[i] = column.ColumnName
+	}
+	if this.PKType != PKTypePK || len(this.PKColumnNames) == 0 {
+		if this.PKType == PKTypePK {
+			log.Warnf("表: %s 没有主键, 所有字段作为该表的唯一键", this.String())
+		}
+		this.PKColumnNames = this.ColumnNames
+		this.PKType = PKTypeAllColumns
+	}
+	this.initColumnPos()
+}
+
+// 复制表信息, 生成新的版本
+func (this *Table) clone() *Table {
+	t := *this
+	t.Columns = make([]*models.Column, len(this.Columns))
+	copy(t.Columns, this.Columns)
+	t.ColumnNames = make([]string, len(this.ColumnNames))
+	copy(t.ColumnNames, this.ColumnNames)
+	t.PKColumnNames = make([]string, len(this.PKColumnNames))
+	copy(t.PKColumnNames, this.PKColumnNames)
+	t.Version = this.Version + 1
+	t.resolved = nil
+	t.initColumnPos()
+
+	return &t
+}
+
+// 获取索引定义中的字段名, 如: `uk` (`a`, `b`(10)) 返回 a, b
+func indexColumnNames(s *ddlScanner) []string {
+	// 跳过索引名, 定位到字段列表
+	inQuote := false
+	for ; s.pos < len(s.s); s.pos++ {
+		c := s.s[s.pos]
+		if c == '`' {
+			inQuote = !inQuote
+		} else if c == '(' && !inQuote {
+			break
+		}
+	}
+	body, ok := s.parenthesized()
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, 1)
+	for _, def := range SplitDefinitions(body) {
+		if name, ok := newDDLScanner(def).ident(); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 是否是索引定义
+func isIndexDefinition(def string) bool {
+	s := newDDLScanner(def)
+	for _, kw := range []string{"INDEX", "KEY", "UNIQUE", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK", "PRIMARY"} {
+		if s.keyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// 字段定义中的索引选项
+const (
+	columnKeyNone = iota
+	columnKeyPrimary
+	columnKeyUnique
+)
+
+// 获取字段定义中的索引选项: [PRIMARY] KEY 或 UNIQUE [KEY]. 跳过引号和括号中的内容
+func columnKeyOption(def string) int {
+	s := newDDLScanner(def)
+	if _, ok := s.ident(); !ok { // 字段名
+		return columnKeyNone
+	}
+
+	prev := ""
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.s) {
+			return columnKeyNone
+		}
+		switch c := s.s[s.pos]; {
+		case c == '\'' || c == '"':
+			if _, ok := s.quotedString(); !ok {
+				return columnKeyNone
+			}
+			prev = ""
+			continue
+		case c == '(':
+			if _, ok := s.parenthesized(); !ok {
+				return columnKeyNone
+			}
+			prev = ""
+			continue
+		}
+
+		word, ok := s.ident()
+		if !ok {
+			s.pos++
+			prev = ""
+			continue
+		}
+		switch word = strings.ToUpper(word); {
+		case word == "UNIQUE":
+			return columnKeyUnique
+		case word == "KEY" && prev != "UNIQUE":
+			return columnKeyPrimary
+		}
+		prev = word
+	}
+}
@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ngaut/log"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// binlog 中的字段类型对应的 information_schema.COLUMNS.DATA_TYPE
+var binlogColumnDataTypes = map[byte][]string{
+	mysql.MYSQL_TYPE_TINY:       {"tinyint", "bool", "boolean"},
+	mysql.MYSQL_TYPE_SHORT:      {"smallint"},
+	mysql.MYSQL_TYPE_INT24:      {"mediumint"},
+	mysql.MYSQL_TYPE_LONG:       {"int", "integer"},
+	mysql.MYSQL_TYPE_LONGLONG:   {"bigint"},
+	mysql.MYSQL_TYPE_FLOAT:      {"float"},
+	mysql.MYSQL_TYPE_DOUBLE:     {"double", "real"},
+	mysql.MYSQL_TYPE_DECIMAL:    {"decimal", "numeric"},
+	mysql.MYSQL_TYPE_NEWDECIMAL: {"decimal", "numeric"},
+	mysql.MYSQL_TYPE_VARCHAR:    {"varchar", "varbinary"},
+	mysql.MYSQL_TYPE_VAR_STRING: {"varchar", "varbinary"},
+	mysql.MYSQL_TYPE_STRING:     {"char", "binary", "enum", "set"},
+	mysql.MYSQL_TYPE_ENUM:       {"enum"},
+	mysql.MYSQL_TYPE_SET:        {"set"},
+	mysql.MYSQL_TYPE_BLOB: {"tinyblob", "blob", "mediumblob", "longblob",
+		"tinytext", "text", "mediumtext", "longtext"},
+	mysql.MYSQL_TYPE_JSON: {"json"},
+	mysql.MYSQL_TYPE_GEOMETRY: {"geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection"},
+	mysql.MYSQL_TYPE_DATETIME:   {"datetime"},
+	mysql.MYSQL_TYPE_DATETIME2:  {"datetime"},
+	mysql.MYSQL_TYPE_TIMESTAMP:  {"timestamp"},
+	mysql.MYSQL_TYPE_TIMESTAMP2: {"timestamp"},
+	mysql.MYSQL_TYPE_DATE:       {"date"},
+	mysql.MYSQL_TYPE_NEWDATE:    {"date"},
+	mysql.MYSQL_TYPE_TIME:       {"time"},
+	mysql.MYSQL_TYPE_TIME2:      {"time"},
+	mysql.MYSQL_TYPE_YEAR:       {"year"},
+	mysql.MYSQL_TYPE_BIT:        {"bit"},
+}
+
+// 检测 TableMapEvent 中的字段数和字段类型是否和表结构一致
+func (this *Table) MatchTableMap(e *replication.TableMapEvent) bool {
+	if int(e.ColumnCount) != len(this.ColumnNames) {
+		return false
+	}
+
+	return this.matchColumnTypes(e)
+}
+
+// 获取和 TableMapEvent 一致的表结构版本.
+// 表结构一致返回当前版本. binlog 中的字段数少于当前表结构(之后执行过在最后添加字段的DDL),
+// 并且前面的字段类型一致, 返回只包含前面字段的版本. 其他情况不能确定字段, 返回错误.
+// 只包含前面字段的版本按字段数缓存, 同一个字段数每次返回同一个版本
+func (this *Table) ResolveByTableMap(e *replication.TableMapEvent) (*Table, error) {
+	if this.MatchTableMap(e) {
+		return this, nil
+	}
+
+	count := int(e.ColumnCount)
+	if count > len(this.ColumnNames) || !this.matchColumnTypes(e) {
+		return nil, fmt.Errorf("表: %s binlog中的字段数 %d 和字段类型与表结构(%d 个字段)不一致, "+
+			"binlog 范围之后表结构有修改, 请通过 --schema-file 指定开始位点时的表结构", this.String(), count, len(this.ColumnNames))
+	}
+	if t, ok := this.resolved[count]; ok {
+		return t, nil
+	}
+
+	t := this.clone()
+	t.Version = this.Version
+	t.Columns = t.Columns[:count]
+	for _, name := range this.PKColumnNames {
+		if this.ColumnPos[name] >= count {
+			return nil, fmt.Errorf("表: %s 主键字段 %s 不在binlog中的 %d 个字段中", this.String(), name, count)
+		}
+	}
+	if t.PKType == PKTypeAllColumns {
+		t.PKColumnNames = nil
+	}
+	t.refreshColumns()
+	t.initSQLTemplate()
+	log.Warnf("表: %s binlog中的字段数 %d 少于表结构中的字段数 %d, 使用前 %d 个字段: %s",
+		this.String(), count, len(this.ColumnNames), count, strings.Join(t.ColumnNames, ", "))
+	if this.resolved == nil {
+		this.resolved = make(map[int]*Table)
+	}
+	this.resolved[count] = t
+
+	return t, nil
+}
+
+// 检测 TableMapEvent 中的字段类型和表结构的前面几个字段是否一致, 没有字段类型信息的不检测
+func (this *Table) matchColumnTypes(e *replication.TableMapEvent) bool {
+	for i, tp := range e.ColumnType {
+		if i >= len(this.Columns) {
+			return false
+		}
+		if tp == mysql.MYSQL_TYPE_STRING && i < len(e.ColumnMeta) { // char, enum, set 的真实类型在 meta 中
+			if realType := byte(e.ColumnMeta[i] >> 8); realType == mysql.MYSQL_TYPE_ENUM || realType == mysql.MYSQL_TYPE_SET {
+				tp = realType
+			}
+		}
+		dataTypes, ok := binlogColumnDataTypes[tp]
+		if !ok {
+			continue
+		}
+		match := false
+		dataType := strings.ToLower(this.Columns[i].DataType)
+		for _, t := range dataTypes {
+			if t == dataType {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
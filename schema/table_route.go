@@ -0,0 +1,180 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 目标表名路由规则, 格式: <源库>.<源表> -> <目标库>.<目标表>.
+// 源库名和源表名可以是名称, * (任意名称) 或 /正则表达式/ (需要完整匹配).
+// 目标库名和目标表名中 * 表示使用源的名称, $1, ${name} 表示源库名(源表名)对应的正则表达式中的分组. 如:
+//
+//	shop.orders -> archive_2026.orders_deleted          修改库名和表名
+//	/shop_\d+/.* -> shop_archive.*                       多个库路由到一个库
+//	/(\w+)_prod/.* -> ${1}_archive.*                     使用正则表达式分组
+//	shop./orders_\d{2}/ -> archive.orders                分表合并到一个表
+type TableRoute struct {
+	Rule          string
+	schemaPattern *regexp.Regexp
+	tablePattern  *regexp.Regexp
+	targetSchema  string
+	targetTable   string
+	merge         bool // 多个源表写入同一个目标表
+}
+
+// 解析目标表名路由规则
+func ParseTableRoute(rule string) (*TableRoute, error) {
+	parts := strings.Split(rule, "->")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("表名路由规则格式错误, 应该为 <源库>.<源表> -> <目标库>.<目标表>. %s", rule)
+	}
+
+	route := &TableRoute{Rule: strings.TrimSpace(rule)}
+	schemaPattern, tablePattern, err := splitRouteSource(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, fmt.Errorf("表名路由规则: %s. %v", rule, err)
+	}
+	if route.schemaPattern, err = compileRoutePattern(schemaPattern); err != nil {
+		return nil, fmt.Errorf("表名路由规则: %s. %v", rule, err)
+	}
+	if route.tablePattern, err = compileRoutePattern(tablePattern); err != nil {
+		return nil, fmt.Errorf("表名路由规则: %s. %v", rule, err)
+	}
+
+	target := strings.TrimSpace(parts[1])
+	idx := strings.Index(target, ".")
+	if idx <= 0 || idx == len(target)-1 {
+		return nil, fmt.Errorf("表名路由规则目标表名格式错误, 应该为 <目标库>.<目标表>. %s", rule)
+	}
+	route.targetSchema = target[:idx]
+	route.targetTable = target[idx+1:]
+
+	// 源名称不是固定的名称, 目标名称是固定的名称时, 不同的源表会写入同一个目标表
+	route.merge = (!isFixedRouteName(schemaPattern) && isFixedRouteName(route.targetSchema)) ||
+		(!isFixedRouteName(tablePattern) && isFixedRouteName(route.targetTable))
+
+	return route, nil
+}
+
+// 拆分源库名和源表名, 正则表达式中可以包含 .
+func splitRouteSource(source string) (string, string, error) {
+	idx := strings.Index(source, ".")
+	if strings.HasPrefix(source, "/") {
+		end := strings.Index(source[1:], "/.")
+		if end < 0 {
+			return "", "", fmt.Errorf("源库名正则表达式需要以 / 结尾")
+		}
+		idx = end + 2
+	}
+	if idx <= 0 || idx == len(source)-1 {
+		return "", "", fmt.Errorf("源表名格式错误, 应该为 <源库>.<源表>")
+	}
+
+	return source[:idx], source[idx+1:], nil
+}
+
+// 编译源名称的匹配规则, 名称和 * 也转化为正则表达式
+func compileRoutePattern(pattern string) (*regexp.Regexp, error) {
+	expr := ""
+	switch {
+	case pattern == "*":
+		expr = ".*"
+	case len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		expr = pattern[1 : len(pattern)-1]
+	case strings.HasPrefix(pattern, "/"):
+		return nil, fmt.Errorf("正则表达式 %s 需要以 / 结尾", pattern)
+	default:
+		expr = regexp.QuoteMeta(pattern)
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("正则表达式 %s 错误. %v", pattern, err)
+	}
+	return re, nil
+}
+
+// 名称中没有 *, /正则表达式/ 和分组引用
+func isFixedRouteName(name string) bool {
+	return name != "*" && !strings.HasPrefix(name, "/") && !strings.Contains(name, "$")
+}
+
+// 匹配源表, 返回目标库名和目标表名
+func (this *TableRoute) Match(sName string, tName string) (string, string, bool) {
+	schemaMatch := this.schemaPattern.FindStringSubmatchIndex(sName)
+	if schemaMatch == nil {
+		return "", "", false
+	}
+	tableMatch := this.tablePattern.FindStringSubmatchIndex(tName)
+	if tableMatch == nil {
+		return "", "", false
+	}
+
+	return expandRouteName(this.schemaPattern, this.targetSchema, sName, schemaMatch),
+		expandRouteName(this.tablePattern, this.targetTable, tName, tableMatch), true
+}
+
+// 多个源表是否会写入同一个目标表
+func (this *TableRoute) IsMerge() bool {
+	return this.merge
+}
+
+func expandRouteName(re *regexp.Regexp, target string, name string, match []int) string {
+	if target == "*" {
+		return name
+	}
+	return string(re.ExpandString(nil, target, name, match))
+}
+
+// 目标表名路由, 按顺序匹配规则, 没有匹配的规则时目标库名为源库名加上后缀, 目标表名和源表名相同.
+// 为 nil 时目标库名和目标表名都和源相同
+type TableRouter struct {
+	SchemaSuffix string
+	Routes       []*TableRoute
+}
+
+// 解析所有的路由规则
+func NewTableRouter(sSuffix string, rules []string) (*TableRouter, error) {
+	router := &TableRouter{
+		SchemaSuffix: sSuffix,
+		Routes:       make([]*TableRoute, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		route, err := ParseTableRoute(rule)
+		if err != nil {
+			return nil, err
+		}
+		router.Routes = append(router.Routes, route)
+	}
+
+	return router, nil
+}
+
+// 获取源表对应的目标库名和目标表名
+func (this *TableRouter) Target(sName string, tName string) (string, string) {
+	if this == nil {
+		return sName, tName
+	}
+	for _, route := range this.Routes {
+		if targetSchema, targetTable, ok := route.Match(sName, tName); ok {
+			return targetSchema, targetTable
+		}
+	}
+
+	return sName + this.SchemaSuffix, tName
+}
+
+// 源表和其他源表是否写入同一个目标表. 写入同一个目标表时, 删除表, 清空表的DDL不能在目标表执行
+func (this *TableRouter) IsMerged(sName string, tName string) bool {
+	if this == nil {
+		return false
+	}
+	for _, route := range this.Routes {
+		if _, _, ok := route.Match(sName, tName); ok {
+			return route.IsMerge()
+		}
+	}
+
+	return false
+}
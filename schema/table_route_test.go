@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableRouter_Target(t *testing.T) {
+	router, err := NewTableRouter("_archive", []string{
+		"shop.orders -> archive_2026.orders_deleted",
+		"shop./orders_\\d{2}/ -> archive_2026.orders",
+		"/shop_\\d+/.* -> shop_archive.*",
+		"/(\\w+)_prod/./(\\w+)_v\\d+/ -> ${1}_archive.$1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		sName, tName     string
+		targetS, targetT string
+		merged           bool
+	}{
+		{"shop", "orders", "archive_2026", "orders_deleted", false},
+		{"shop", "orders_07", "archive_2026", "orders", true},
+		{"shop", "orders_100", "shop_archive", "orders_100", false},
+		{"shop_01", "users", "shop_archive", "users", true},
+		{"crm_prod", "users_v2", "crm_archive", "users", false},
+		{"crm_prod", "users", "crm_prod_archive", "users", false},
+		{"db1", "t1", "db1_archive", "t1", false},
+	}
+	for _, c := range cases {
+		sName, tName := router.Target(c.sName, c.tName)
+		if sName != c.targetS || tName != c.targetT {
+			t.Fatalf("%s.%s: 目标表 %s.%s, 期望: %s.%s", c.sName, c.tName, sName, tName, c.targetS, c.targetT)
+		}
+		if merged := router.IsMerged(c.sName, c.tName); merged != c.merged {
+			t.Fatalf("%s.%s: 是否合并 %v, 期望: %v", c.sName, c.tName, merged, c.merged)
+		}
+	}
+
+	var nilRouter *TableRouter
+	if sName, tName := nilRouter.Target("db1", "t1"); sName != "db1" || tName != "t1" {
+		t.Fatalf("没有路由时目标表: %s.%s", sName, tName)
+	}
+}
+
+func TestParseTableRoute_Error(t *testing.T) {
+	rules := []string{
+		"shop.orders",
+		"shop.orders -> archive",
+		"shop -> archive.orders",
+		"/shop_(.orders -> archive.orders",
+		"/shop.orders -> archive.orders",
+		"shop./orders -> archive.orders",
+	}
+	for _, rule := range rules {
+		if _, err := ParseTableRoute(rule); err == nil {
+			t.Errorf("%s 应该解析失败", rule)
+		}
+	}
+}
+
+func TestTable_RouteTemplate(t *testing.T) {
+	router, err := NewTableRouter("_archive", []string{"db1.t1 -> archive.t1_deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := newTestTable()
+	tbl.Router = router
+	tbl.initSQLTemplate()
+
+	for _, template := range []string{tbl.InsertTemplate, tbl.ReplaceTemplate, tbl.UpdateTemplate, tbl.DeleteTemplate} {
+		if !strings.Contains(template, "`archive`.`t1_deleted`") {
+			t.Fatalf("sql 模板没有使用目标表名: %s", template)
+		}
+	}
+	if tbl.GetSchema(true) != "archive" || tbl.TargetString() != "archive.t1_deleted" {
+		t.Fatalf("目标表名: %s", tbl.TargetString())
+	}
+}
+
+func TestDDL_TargetSQL_Route(t *testing.T) {
+	router, err := NewTableRouter("_archive", []string{
+		"db1./t1_\\d+/ -> archive.t1",
+		"db1.t2 -> archive.t2_deleted",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		query  string
+		expect string
+	}{
+		{"CREATE TABLE t1_01 (id int primary key)", "CREATE TABLE IF NOT EXISTS `archive`.`t1` (id int primary key)"},
+		{"ALTER TABLE t1_01 ADD COLUMN c int", "ALTER TABLE `archive`.`t1` ADD COLUMN c int"},
+		{"ALTER TABLE t1_01 ADD COLUMN c int, RENAME TO t1_01_old", "ALTER TABLE `archive`.`t1` ADD COLUMN c int"},
+		{"DROP TABLE t1_01", ""},
+		{"TRUNCATE t1_01", ""},
+		{"RENAME TABLE t1_01 TO t1_02", ""},
+		{"ALTER TABLE t2 ADD COLUMN c int", "ALTER TABLE `archive`.`t2_deleted` ADD COLUMN c int"},
+		{"RENAME TABLE t2 TO t3", "RENAME TABLE `archive`.`t2_deleted` TO `db1_archive`.`t3`"},
+		{"DROP TABLE t2", "DROP TABLE IF EXISTS `archive`.`t2_deleted`"},
+	}
+	for _, c := range cases {
+		ddl := ParseDDL(c.query, "db1")[0]
+		if sqlStr := ddl.TargetSQL(router, false); sqlStr != c.expect {
+			t.Fatalf("%s: %s, 期望: %s", c.query, sqlStr, c.expect)
+		}
+	}
+}
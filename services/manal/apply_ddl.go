@@ -0,0 +1,114 @@
+package manal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/schema"
+	gomysql "github.com/go-sql-driver/mysql"
+)
+
+// 多个源表写入同一个目标表时, 其他源表已经执行过相同的DDL返回的错误:
+// 1050: 表已经存在, 1060: 字段已经存在, 1061: 索引已经存在, 1091: 删除的字段或索引不存在
+var appliedDDLErrors = map[uint16]bool{1050: true, 1060: true, 1061: true, 1091: true}
+
+// 按顺序执行源实例的DDL. 需要在所有应用线程应用完DDL之前的数据之后执行
+func (this *MComsume) applyDDLs(ev *EventData) error {
+	for _, ddl := range ev.DDLs {
+		if err := this.applyDDL(ev, ddl); err != nil {
+			seelog.Errorf("正在应用位点为(未完成): %s:%d", ev.LogFile, ev.LogPos)
+			return fmt.Errorf("表: %s 应用DDL失败. %s. %v", ddl.String(), ddl.Query, err)
+		}
+	}
+
+	return nil
+}
+
+func (this *MComsume) applyDDL(ev *EventData, ddl *schema.DDL) error {
+	merged := this.router.IsMerged(ddl.Schema, ddl.Table)
+	if merged && (ddl.Type == schema.DDLTypeDropTable || ddl.Type == schema.DDLTypeTruncateTable || ddl.IsRename()) {
+		seelog.Warnf("表: %s 和其他表写入同一个目标表 %s, 不执行删除, 清空和修改表名. %s",
+			ddl.String(), this.targetString(ddl.Schema, ddl.Table), ddl.Query)
+		if ddl.Type != schema.DDLTypeAlterTable {
+			return nil
+		}
+	}
+
+	skipDropColumn := false
+	if ddl.IsDestructive() {
+		switch this.TMC.DDLPolicy {
+		case config.DDL_POLICY_ABORT:
+			return fmt.Errorf("DDL会删除目标实例中的数据, DDL处理方式为: %s, 停止应用", this.TMC.DDLPolicy)
+		case config.DDL_POLICY_IGNORE:
+			if ddl.Type != schema.DDLTypeAlterTable {
+				seelog.Warnf("表: %s DDL会删除目标实例中的数据, 不执行. %s", ddl.String(), ddl.Query)
+				return nil
+			}
+			seelog.Warnf("表: %s DDL中删除的字段在目标表中保留. %s", ddl.String(), ddl.Query)
+			skipDropColumn = true
+		}
+	}
+
+	// 目标表使用字段处理后的字段, 修改规则中字段的DDL不执行
+	if projection, ok := this.projections[ddl.String()]; ok {
+		var skipped []string
+		var err error
+		if ddl, skipped, err = projection.ProjectDDL(ddl); err != nil {
+			return fmt.Errorf("字段处理失败. %v", err)
+		}
+		for _, spec := range skipped {
+			seelog.Warnf("表: %s DDL修改了字段处理规则中的字段, 不执行: %s. %s", ddl.String(), spec, ddl.Query)
+		}
+	}
+
+	// 通过DDL新建的表需要添加审计字段
+	if ddl.Type == schema.DDLTypeCreateTable && this.TMC.AuditColumns {
+		ddl = ddl.WithAuditColumns()
+	}
+	sqlStr := ddl.TargetSQL(this.router, skipDropColumn)
+	if len(sqlStr) == 0 { // CREATE TABLE ... LIKE 在生成表结构的时候已经创建
+		return nil
+	}
+
+	// 新建表和修改表名到其他数据库, 目标数据库可能不存在
+	dbName := ""
+	switch {
+	case ddl.Type == schema.DDLTypeCreateTable:
+		dbName, _ = this.router.Target(ddl.Schema, ddl.Table)
+	case ddl.IsRename():
+		dbName, _ = this.router.Target(ddl.NewSchema, ddl.NewTable)
+	}
+	if len(dbName) != 0 {
+		if err := this.execDDL(ev, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", dbName)); err != nil {
+			return fmt.Errorf("创建目标数据库出错. %v", err)
+		}
+	}
+
+	if err := this.execDDL(ev, sqlStr); err != nil {
+		var mysqlErr *gomysql.MySQLError
+		if !merged || !errors.As(err, &mysqlErr) || !appliedDDLErrors[mysqlErr.Number] {
+			return err
+		}
+		seelog.Warnf("表: %s 写入的目标表 %s 已经执行过该DDL, 跳过. %s. %v",
+			ddl.String(), this.targetString(ddl.Schema, ddl.Table), sqlStr, err)
+		return nil
+	}
+	seelog.Infof("表: %s 执行DDL成功. %s", ddl.String(), sqlStr)
+
+	return nil
+}
+
+// 在目标执行DDL, 并记录耗时
+func (this *MComsume) execDDL(ev *EventData, sqlStr string) error {
+	defer observeTargetExec(this.TMC.TaskLabel(), this.TMC.Sink, "ddl", time.Now())
+	return this.sink.ExecDDL(ev, sqlStr)
+}
+
+// 获取源表对应的目标表名: schema.table
+func (this *MComsume) targetString(sName string, tName string) string {
+	targetSchema, targetTable := this.router.Target(sName, tName)
+	return fmt.Sprintf("%s.%s", targetSchema, targetTable)
+}
@@ -0,0 +1,367 @@
+package manal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 分配给应用线程的数据. Table 为nil代表源事务结束
+type applyJob struct {
+	*EventData
+	Table          *schema.Table
+	Rows           [][]interface{}
+	Barrier        *sync.WaitGroup // 不为nil代表分发线程等待该线程应用完之前的数据
+	SaveCheckpoint bool            // 源事务结束后保存checkpoint(DDL没有目标实例事务)
+}
+
+// 应用线程, 按顺序应用分配给自己的数据
+type applyWorker struct {
+	ComsumeState
+	ID              int
+	comsume         *MComsume
+	jobChan         chan *applyJob
+	tx              SinkTx                                    // 当前正在执行的事务, 没有则为nil
+	resumePosition  *models.Position                          // 从checkpoint继续执行时, 该线程已经应用完成的位点(checkpoint中没有GTID集合)
+	resumeGTIDSet   mysql.GTIDSet                             // 从checkpoint继续执行时, 该线程已经应用完成的GTID集合
+	auditTables     map[*schema.Table]*schema.Table           // 每个表结构版本对应的带审计字段的表信息
+	projectedTables map[*schema.Table]*schema.TableProjection // 每个表结构版本对应的字段处理后的表信息
+	batches         []*insertBatch                            // 当前事务中合并的 INSERT/REPLACE 数据, 提交前执行
+}
+
+func newApplyWorker(id int, comsume *MComsume) *applyWorker {
+	return &applyWorker{
+		ComsumeState: ComsumeState{
+			CurrPosition: new(models.Position),
+		},
+		ID:              id,
+		comsume:         comsume,
+		jobChan:         make(chan *applyJob, 1000),
+		auditTables:     make(map[*schema.Table]*schema.Table),
+		projectedTables: make(map[*schema.Table]*schema.TableProjection),
+	}
+}
+
+func (this *applyWorker) run() error {
+	err := this.applyJobs()
+	if err != nil {
+		this.rollback()
+		seelog.Errorf("应用线程 %d 出错. 已经应用完成的位点: %s", this.ID, this.CurrPosition.String())
+		for job := range this.jobChan { // 丢弃剩余的数据, 防止分发线程阻塞
+			if job.Barrier != nil {
+				job.Barrier.Done()
+			}
+		}
+		return err
+	}
+
+	// 解析结束的时候事务还没有结束(结束位点在事务中间), 没有完成的事务不应用
+	if this.tx != nil {
+		this.rollback()
+		seelog.Warnf("应用线程 %d: 结束位点在事务中间, 未完成的事务已经回滚. 已经应用完成的位点: %s",
+			this.ID, this.CurrPosition.String())
+	}
+
+	return this.saveCheckpoint()
+}
+
+func (this *applyWorker) applyJobs() error {
+	for job := range this.jobChan {
+		if job.Barrier != nil {
+			job.Barrier.Done()
+			continue
+		}
+		if this.isApplied(job.EventData) { // 从checkpoint继续执行, 该线程已经应用过
+			if job.Table == nil {
+				this.updatePosition(job.EventData)
+			}
+			continue
+		}
+
+		if job.Table == nil { // 源事务结束, 提交目标实例事务
+			if err := this.commit(job.EventData); err != nil {
+				return fmt.Errorf("位点: %s:%d 提交事务失败. %v", job.LogFile, job.LogPos, err)
+			}
+			this.updatePosition(job.EventData)
+			if job.SaveCheckpoint {
+				if err := this.saveCheckpoint(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// 限流暂停时在目标实例事务开始之前等待, 已经开始的事务继续应用到源事务结束
+		if this.tx == nil && this.comsume.throttler.waitResume() {
+			return fmt.Errorf("任务已经停止, 限流暂停中没有应用的数据不再应用")
+		}
+		if err := this.begin(); err != nil {
+			return err
+		}
+		op := rowEventOp(job.BinlogEvent.Header.EventType)
+		for _, rows := range this.comsume.throttler.split(job.Rows, op) {
+			this.comsume.throttler.limit(rowCount(rows, op))
+			if err := this.applyBatch(job, op, rows); err != nil {
+				return fmt.Errorf("位点: %s:%d 应用失败. %v", job.LogFile, job.LogPos, err)
+			}
+		}
+		this.countApplied(job, op)
+	}
+
+	return nil
+}
+
+// 应用 row event 中的一批数据
+func (this *applyWorker) applyBatch(job *applyJob, op string, rows [][]interface{}) error {
+	// 按字段处理规则删除, 修改和添加字段, 之后的审计字段和sql都使用处理后的表信息
+	tbl, rows, err := this.project(job, rows)
+	if err != nil {
+		return err
+	}
+
+	if rowTx, ok := this.tx.(RowSinkTx); ok { // 按行输出数据, 不生成sql
+		defer observeTargetExec(this.comsume.TMC.TaskLabel(), this.comsume.TMC.Sink, "dml", time.Now())
+		return rowTx.WriteRows(job.EventData, tbl, op, rows)
+	}
+
+	batch := *job
+	batch.Table = tbl
+	batch.Rows = rows
+	return this.applyRows(&batch)
+}
+
+// 生成sql应用 row event 中的数据
+func (this *applyWorker) applyRows(job *applyJob) error {
+	var err error
+	switch job.BinlogEvent.Header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		rows, tbl := this.withAudit(job, schema.AUDIT_OP_INSERT)
+		err = this.applyInsert(job.EventData, rows, tbl)
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		rows, tbl := this.withAudit(job, schema.AUDIT_OP_UPDATE)
+		err = this.applyUpdate(job.EventData, rows, tbl)
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		rows, tbl := this.withAudit(job, schema.AUDIT_OP_DELETE)
+		err = this.applyDelete(job.EventData, rows, tbl)
+	}
+
+	return err
+}
+
+// 开启了审计字段, 在每一行数据之后添加审计字段值, 并使用带审计字段的表信息
+func (this *applyWorker) withAudit(job *applyJob, op string) ([][]interface{}, *schema.Table) {
+	if !this.comsume.TMC.AuditColumns {
+		return job.Rows, job.Table
+	}
+
+	tbl, ok := this.auditTables[job.Table]
+	if !ok {
+		tbl = job.Table.WithAuditColumns()
+		this.auditTables[job.Table] = tbl
+	}
+	info := &schema.AuditInfo{
+		LogFile:   job.LogFile,
+		LogPos:    job.LogPos,
+		EventTime: time.Unix(int64(job.BinlogEvent.Header.Timestamp), 0),
+		ThreadID:  job.ThreadID,
+		GTID:      job.GTID,
+		Op:        op,
+	}
+
+	return schema.AppendAuditValues(job.Rows, info), tbl
+}
+
+// event 是否已经被该线程应用过.
+// checkpoint 中有GTID集合: 有GTID的 event 所属事务包含在该集合中才已经应用, 源实例切换后binlog文件名和位点会变化, 不能按位点比较.
+// checkpoint 中没有GTID集合: checkpoint 的位点为事务结束的位点, 小于等于该位点的 event 都已经应用
+func (this *applyWorker) isApplied(ev *EventData) bool {
+	if this.resumeGTIDSet != nil {
+		if len(ev.GTID) == 0 { // 不能确定是否已经应用, 重复应用比丢失数据好
+			return false
+		}
+		gtidSet, err := mysql.ParseMysqlGTIDSet(ev.GTID)
+		if err != nil {
+			seelog.Warnf("应用线程 %d: 解析GTID %s 失败, 重新应用该事务. %v", this.ID, ev.GTID, err)
+			return false
+		}
+		return this.resumeGTIDSet.Contain(gtidSet)
+	}
+
+	if this.resumePosition == nil {
+		return false
+	}
+	pos := &models.Position{File: ev.LogFile, Position: ev.LogPos}
+	if this.resumePosition.LessThan(pos) {
+		this.resumePosition = nil // 之后的 event 都没有应用过
+		return false
+	}
+
+	return true
+}
+
+// 更新该线程应用完成的位点, 并重新计算消费者应用完成的位点
+func (this *applyWorker) updatePosition(ev *EventData) {
+	this.comsume.Lock()
+	defer this.comsume.Unlock()
+
+	this.ComsumeState.updatePosition(ev)
+	this.comsume.refreshWatermark()
+}
+
+// 开启事务, 已经开启则不做操作
+func (this *applyWorker) begin() error {
+	if this.tx != nil {
+		return nil
+	}
+
+	tx, err := this.comsume.sink.Begin()
+	if err != nil {
+		return err
+	}
+	this.tx = tx
+
+	return nil
+}
+
+// 提交事务, 没有开启事务(源事务中没有分配给该线程的数据)则不做操作.
+// 开启了checkpoint, 应用完成的位点和数据在同一个事务中提交
+func (this *applyWorker) commit(ev *EventData) error {
+	if this.tx == nil {
+		return nil
+	}
+
+	if err := this.flushBatches(); err != nil {
+		this.rollback()
+		return err
+	}
+
+	tmc := this.comsume.TMC
+	if tmc.EnableCheckpoint() {
+		cp := models.NewCheckpoint(tmc.TaskUUID, this.ID, this.nextPosition(ev))
+		if err := this.tx.SaveCheckpoint(tmc.CheckpointSchema, cp); err != nil {
+			this.rollback()
+			return fmt.Errorf("保存checkpoint失败. %v", err)
+		}
+	}
+
+	tx := this.tx
+	this.tx = nil
+	defer observeTargetExec(tmc.TaskLabel(), tmc.Sink, "commit", time.Now())
+	return tx.Commit()
+}
+
+// 回滚事务
+func (this *applyWorker) rollback() {
+	this.batches = nil
+	if this.tx == nil {
+		return
+	}
+
+	if err := this.tx.Rollback(); err != nil {
+		seelog.Errorf("应用线程 %d 回滚事务失败. %v", this.ID, err)
+	}
+	this.tx = nil
+}
+
+// 保存最终应用完成的位点, 最后一批源事务中可能没有分配给该线程的数据
+func (this *applyWorker) saveCheckpoint() error {
+	tmc := this.comsume.TMC
+	if !tmc.EnableCheckpoint() || len(this.CurrPosition.File) == 0 {
+		return nil
+	}
+
+	cp := models.NewCheckpoint(tmc.TaskUUID, this.ID, this.CurrPosition)
+	if err := this.comsume.sink.SaveCheckpoint(tmc.CheckpointSchema, cp); err != nil {
+		return fmt.Errorf("保存checkpoint失败. %v", err)
+	}
+
+	return nil
+}
+
+// 应用 insert 事件, 和同一个表的其他 insert 合并执行
+func (this *applyWorker) applyInsert(ev *EventData, rows [][]interface{}, tbl *schema.Table) error {
+	return this.appendInsert(ev, rows, tbl, this.comsume.TMC.InsertMode == config.INSERT_MODE_REPLACE)
+}
+
+// 应用 update 事件, rows 中的数据为 修改前, 修改后 成对出现. replace 方式和同一个表的其他 replace 合并执行
+func (this *applyWorker) applyUpdate(ev *EventData, rows [][]interface{}, tbl *schema.Table) error {
+	if len(rows)%2 != 0 {
+		return fmt.Errorf("表: %s update 事件数据行数 %d 不是成对出现", tbl.String(), len(rows))
+	}
+
+	switch this.comsume.TMC.UpdateMode {
+	case config.UPDATE_MODE_REPLACE:
+		afterRows := make([][]interface{}, 0, len(rows)/2)
+		for i := 1; i < len(rows); i += 2 {
+			afterRows = append(afterRows, rows[i])
+		}
+		return this.appendInsert(ev, afterRows, tbl, true)
+	default:
+		if err := this.flushTable(tbl); err != nil {
+			return err
+		}
+		for i := 0; i < len(rows); i += 2 {
+			sql, args, err := tbl.BuildUpdateSQL(rows[i], rows[i+1])
+			if err != nil {
+				return err
+			}
+			if err = this.execDML(ev, sql, args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 应用 delete 事件, 归档方式和同一个表的其他 insert 合并执行
+func (this *applyWorker) applyDelete(ev *EventData, rows [][]interface{}, tbl *schema.Table) error {
+	switch this.comsume.TMC.DeleteMode {
+	case config.DELETE_MODE_DELETE:
+		if err := this.flushTable(tbl); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			sql, args, err := tbl.BuildDeleteSQL(row)
+			if err != nil {
+				return err
+			}
+			if err = this.execDML(ev, sql, args...); err != nil {
+				return err
+			}
+		}
+	default:
+		return this.appendInsert(ev, rows, tbl, false)
+	}
+
+	return nil
+}
+
+// 在当前的事务中执行 dml
+func (this *applyWorker) execDML(ev *EventData, sql string, args ...interface{}) error {
+	defer observeTargetExec(this.comsume.TMC.TaskLabel(), this.comsume.TMC.Sink, "dml", time.Now())
+	return this.tx.Exec(ev, sql, args...)
+}
+
+// 记录应用完成的 event 数和行数, update 的修改前和修改后算一行
+func (this *applyWorker) countApplied(job *applyJob, op string) {
+	task := this.comsume.TMC.TaskLabel()
+	eventsAppliedCounter.WithLabelValues(task, job.Table.SchemaName, job.Table.TableName, op).Inc()
+
+	rowsArchivedCounter.WithLabelValues(task, job.Table.SchemaName, job.Table.TableName, op).Add(float64(rowCount(job.Rows, op)))
+}
+
+// 数据的行数, update 的修改前和修改后算一行
+func rowCount(rows [][]interface{}, op string) int {
+	if op == schema.AUDIT_OP_UPDATE {
+		return len(rows) / 2
+	}
+	return len(rows)
+}
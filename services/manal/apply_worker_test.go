@@ -0,0 +1,79 @@
+package manal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestApplyWorker_ApplyModes(t *testing.T) {
+	cases := []struct {
+		insertMode, updateMode, deleteMode string
+		expects                            []string // 执行的语句前缀, 以及语句中数据的行数
+		rows                               []int
+	}{
+		{config.INSERT_MODE_INSERT, config.UPDATE_MODE_UPDATE, config.DELETE_MODE_ARCHIVE,
+			[]string{"INSERT", "UPDATE", "INSERT"}, []int{1, 0, 1}},
+		{config.INSERT_MODE_REPLACE, config.UPDATE_MODE_REPLACE, config.DELETE_MODE_DELETE,
+			[]string{"REPLACE", "DELETE"}, []int{2, 0}},
+		{config.INSERT_MODE_INSERT, config.UPDATE_MODE_REPLACE, config.DELETE_MODE_ARCHIVE,
+			[]string{"INSERT", "REPLACE", "INSERT"}, []int{1, 1, 1}},
+	}
+
+	for _, c := range cases {
+		worker, sink := newInsertBatchTestWorker(&config.ToMySQLConfig{
+			InsertMode: c.insertMode,
+			UpdateMode: c.updateMode,
+			DeleteMode: c.deleteMode,
+		})
+		t1 := newTestTable("t1", "id", "name")
+		t1.UpdateTemplate = "UPDATE `db1_archive`.`t1` SET `id` = ?, `name` = ? WHERE `id` = ?"
+
+		applyInsertBatchTestJobs(t, worker,
+			newInsertBatchTestJob(t1, replication.WRITE_ROWS_EVENTv2, 1),
+			newInsertBatchTestJob(t1, replication.UPDATE_ROWS_EVENTv2, 1, 1), // 修改前, 修改后
+			newInsertBatchTestJob(t1, replication.DELETE_ROWS_EVENTv2, 1),
+		)
+
+		mode := c.insertMode + "/" + c.updateMode + "/" + c.deleteMode
+		if len(sink.sqls) != len(c.expects) {
+			t.Fatalf("%s: 执行的语句: %v", mode, sink.sqls)
+		}
+		for i, expect := range c.expects {
+			if !strings.HasPrefix(sink.sqls[i], expect) || strings.Count(sink.sqls[i], "(?, ?)") != c.rows[i] {
+				t.Fatalf("%s: 第 %d 个语句: %s, 期望: %s, 行数: %d", mode, i, sink.sqls[i], expect, c.rows[i])
+			}
+		}
+	}
+}
+
+// 限流暂停时在开启目标实例事务之前等待, 任务停止后不再等待
+func TestApplyWorker_ThrottleBeforeBegin(t *testing.T) {
+	worker, sink := newInsertBatchTestWorker(&config.ToMySQLConfig{InsertMode: config.INSERT_MODE_INSERT})
+	worker.comsume.throttler.gate.pause()
+
+	worker.jobChan <- newInsertBatchTestJob(newTestTable("t1", "id", "name"), replication.WRITE_ROWS_EVENTv2, 1)
+	result := make(chan error)
+	go func() { result <- worker.applyJobs() }()
+	select {
+	case err := <-result:
+		t.Fatalf("暂停中不应该应用. %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	worker.comsume.throttler.stop()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("任务停止后应该返回错误")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("任务停止后还在等待恢复应用")
+	}
+	if worker.tx != nil || len(sink.sqls) != 0 {
+		t.Fatalf("暂停中开启了事务或者执行了语句: %v", sink.sqls)
+	}
+}
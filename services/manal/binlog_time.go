@@ -0,0 +1,162 @@
+package manal
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/utils"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 读取binlog文件第一个event的时间
+type binlogTSReader func(logFile string) (time.Time, error)
+
+// 通过开始时间获取开始位点(在线模式). 二分查找第一个event时间小于等于开始时间的最后一个binlog文件
+func getStartPositionByTime(startTime time.Time, dbc *config.DBConfig) (*models.Position, error) {
+	defaultDao, err := dao.NewDefaultDao(dbc)
+	if err != nil {
+		return nil, err
+	}
+	oldestPos, _, err := defaultDao.GetOldestAndNewestPos()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := defaultDao.ShowBinaryLogs()
+	if err != nil {
+		return nil, err
+	}
+	logFiles := make([]string, 0, len(logs))
+	for _, log := range logs {
+		logFiles = append(logFiles, log.LogName)
+	}
+
+	readTS := func(logFile string) (time.Time, error) {
+		return readRemoteBinlogTS(dbc, logFile)
+	}
+	if oldestPos.TS, err = readTS(oldestPos.File); err != nil {
+		return nil, err
+	}
+	if startTime.Before(oldestPos.TS) {
+		return nil, fmt.Errorf("指定的开始时间 %s 太过久远. 存在最老的binlog为: %s:4, 时间: %s",
+			startTime.Format(utils.TIME_FORMAT), oldestPos.File, oldestPos.TS.Format(utils.TIME_FORMAT))
+	}
+
+	return searchStartPositionByTime(logFiles, startTime, readTS)
+}
+
+// 通过开始时间获取开始位点(离线模式)
+func getOfflineStartPositionByTime(startTime time.Time, bc *config.BaseConfig) (*models.Position, error) {
+	files, err := FindLocalBinlogFiles(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := searchStartPositionByTime(files, startTime, readLocalBinlogTS)
+	if err != nil {
+		return nil, err
+	}
+	pos.File = filepath.Base(pos.File)
+
+	return pos, nil
+}
+
+// 二分查找第一个event时间小于等于开始时间的最后一个binlog文件, 从该文件的开头开始解析.
+// 所有文件的时间都大于开始时间, 则从第一个文件开始解析
+func searchStartPositionByTime(logFiles []string, startTime time.Time, readTS binlogTSReader) (*models.Position, error) {
+	if len(logFiles) == 0 {
+		return nil, fmt.Errorf("没有binlog")
+	}
+
+	var readErr error
+	tsCache := make(map[int]time.Time)
+	// 第一个 event 时间大于开始时间的文件
+	idx := sort.Search(len(logFiles), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		ts, err := readTS(logFiles[i])
+		if err != nil {
+			readErr = err
+			return true
+		}
+		tsCache[i] = ts
+		return ts.After(startTime)
+	})
+	if readErr != nil {
+		return nil, readErr
+	}
+	if idx > 0 {
+		idx--
+	}
+
+	pos := getPositionByPosInfo(logFiles[idx], 4)
+	if ts, ok := tsCache[idx]; ok {
+		pos.TS = ts
+	} else {
+		ts, err := readTS(logFiles[idx])
+		if err != nil {
+			return nil, err
+		}
+		pos.TS = ts
+	}
+	seelog.Infof("开始时间 %s 对应的开始binlog为: %s, 该binlog第一个event时间: %s",
+		startTime.Format(utils.TIME_FORMAT), pos.File, pos.TS.Format(utils.TIME_FORMAT))
+
+	return pos, nil
+}
+
+// 连接源实例获取binlog文件第一个event的时间
+func readRemoteBinlogTS(dbc *config.DBConfig, logFile string) (time.Time, error) {
+	cfg, err := dbc.GetSyncerConfig()
+	if err != nil {
+		return time.Time{}, err
+	}
+	syncer := replication.NewBinlogSyncer(cfg)
+	defer syncer.Close()
+
+	streamer, err := syncer.StartSync(mysql.Position{Name: logFile, Pos: 4})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("获取binlog %s 的时间失败. %v", logFile, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("获取binlog %s 的时间失败. %v", logFile, err)
+		}
+		// 同步开始时的 RotateEvent 是伪造的, 时间为0
+		if ev.Header.Timestamp != 0 {
+			return time.Unix(int64(ev.Header.Timestamp), 0), nil
+		}
+	}
+}
+
+// 读取本地binlog文件第一个event的时间
+func readLocalBinlogTS(logFile string) (time.Time, error) {
+	var ts uint32
+	parser := newBinlogParser()
+	err := parser.ParseFile(logFile, 0, func(ev *replication.BinlogEvent) error {
+		if ev.Header.Timestamp != 0 {
+			ts = ev.Header.Timestamp
+			parser.Stop()
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("获取binlog %s 的时间失败. %v", logFile, err)
+	}
+	if ts == 0 {
+		return time.Time{}, fmt.Errorf("binlog %s 中没有有效的event时间", logFile)
+	}
+
+	return time.Unix(int64(ts), 0), nil
+}
@@ -0,0 +1,39 @@
+package manal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchStartPositionByTime(t *testing.T) {
+	base := time.Date(2019, 1, 18, 22, 0, 0, 0, time.Local)
+	logFiles := []string{"mysql-bin.000001", "mysql-bin.000002", "mysql-bin.000003"}
+	fileTS := map[string]time.Time{
+		"mysql-bin.000001": base,
+		"mysql-bin.000002": base.Add(time.Hour),
+		"mysql-bin.000003": base.Add(2 * time.Hour),
+	}
+	readTS := func(logFile string) (time.Time, error) {
+		return fileTS[logFile], nil
+	}
+
+	cases := []struct {
+		startTime time.Time
+		file      string
+	}{
+		{base.Add(-time.Minute), "mysql-bin.000001"},
+		{base, "mysql-bin.000001"},
+		{base.Add(time.Hour), "mysql-bin.000002"},
+		{base.Add(90 * time.Minute), "mysql-bin.000002"},
+		{base.Add(3 * time.Hour), "mysql-bin.000003"},
+	}
+	for _, c := range cases {
+		pos, err := searchStartPositionByTime(logFiles, c.startTime, readTS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos.File != c.file || pos.Position != 4 || !pos.TS.Equal(fileTS[c.file]) {
+			t.Fatalf("开始时间: %s, 位点: %s, 期望文件: %s", c.startTime, pos.String(), c.file)
+		}
+	}
+}
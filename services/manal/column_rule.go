@@ -0,0 +1,57 @@
+package manal
+
+import (
+	"fmt"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+// 解析字段处理规则, 同一个表的多个规则按指定的顺序执行.
+// hashKey: sha256 使用的 HMAC 密钥, 规则中使用了 sha256 时必须指定
+func parseColumnRules(rules []string, hashKey string) (map[string]*schema.ColumnProjection, error) {
+	projections := make(map[string]*schema.ColumnProjection)
+	for _, rule := range rules {
+		key, columnRule, err := schema.ParseColumnRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		projection, ok := projections[key]
+		if !ok {
+			projection = &schema.ColumnProjection{HashKey: []byte(hashKey)}
+			projections[key] = projection
+		}
+		projection.Rules = append(projection.Rules, columnRule)
+		if len(hashKey) == 0 && projection.UseSHA256() {
+			return nil, fmt.Errorf("字段处理规则使用了 sha256, 需要通过 --hash-key 或者环境变量 %s 指定密钥. %s",
+				config.ENV_HASH_KEY, columnRule.Rule)
+		}
+		seelog.Infof("表: %s 字段处理规则: %s", key, columnRule.Rule)
+	}
+
+	return projections, nil
+}
+
+// 处理 row event 中的数据, 返回处理后的表信息和数据. 没有字段处理规则返回原来的数据
+func (this *applyWorker) project(job *applyJob, rows [][]interface{}) (*schema.Table, [][]interface{}, error) {
+	projection, ok := this.comsume.projections[job.Table.String()]
+	if !ok {
+		return job.Table, rows, nil
+	}
+
+	tp, ok := this.projectedTables[job.Table]
+	if !ok {
+		var err error
+		if tp, err = projection.Project(job.Table); err != nil {
+			return nil, nil, err
+		}
+		this.projectedTables[job.Table] = tp
+	}
+	projected, err := tp.Rows(rows)
+	if err != nil {
+		return nil, nil, fmt.Errorf("字段处理失败. %v", err)
+	}
+
+	return tp.Table, projected, nil
+}
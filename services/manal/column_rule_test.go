@@ -0,0 +1,24 @@
+package manal
+
+import (
+	"testing"
+)
+
+func TestParseColumnRules_HashKey(t *testing.T) {
+	rules := []string{"db1.t1: drop ext", "db1.t1: add email_hash char(64) = sha256(lower(email))"}
+	if _, err := parseColumnRules(rules, ""); err == nil {
+		t.Fatal("使用 sha256 没有指定密钥应该返回错误")
+	}
+
+	projections, err := parseColumnRules(rules, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := string(projections["db1.t1"].HashKey); key != "secret" {
+		t.Fatalf("sha256 密钥: %s", key)
+	}
+
+	if _, err = parseColumnRules([]string{"db1.t1: last4 phone"}, ""); err != nil {
+		t.Fatalf("没有使用 sha256 不需要指定密钥. %v", err)
+	}
+}
@@ -1,89 +1,275 @@
 package manal
 
 import (
-	"bytes"
 	"fmt"
+	"hash/fnv"
+	"sync"
+
 	"github.com/cihub/seelog"
 	"github.com/daiguadaidai/haqi/config"
-	"github.com/daiguadaidai/haqi/dao"
 	"github.com/daiguadaidai/haqi/models"
 	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
 )
 
+// 将 binlog event 应用到目标实例的消费者.
+// row event 中的数据通过 表 + 主键值 分发给多个应用线程, 同一行数据的修改在同一个线程中按顺序应用.
+// 每个应用线程将一个源事务(BEGIN ... XID)中分配给自己的数据在目标实例中使用一个事务执行,
+// 多个应用线程时一个源事务在目标实例中不是原子提交的, 所以不能保存checkpoint和从checkpoint继续执行.
+// 应用完成的位点为所有应用线程中最小的位点
 type MComsume struct {
-	TMC           *config.ToMySQLConfig
-	TDBC          *config.DBConfig
-	CurrPosition  *models.Position
-	EventChan     chan *EventData
-	TransTableMap map[string]*schema.Table
-	Success       bool
-	IsQuit        bool
+	ComsumeState
+	sync.Mutex
+	TMC         *config.ToMySQLConfig
+	TDBC        *config.DBConfig
+	EventChan   chan *EventData
+	workers     []*applyWorker
+	errChan     chan error
+	sink        Sink                                // 应用的数据写入的目标
+	throttler   *throttler                          // 目标实例负载过高时暂停应用, 并限制应用的速度
+	router      *schema.TableRouter                 // 目标表名路由, 用于生成DDL
+	projections map[string]*schema.ColumnProjection // 每个表的字段处理规则
 }
 
 func NewMComsume(tmc *config.ToMySQLConfig, tdbc *config.DBConfig) *MComsume {
-	return &MComsume{
-		CurrPosition: new(models.Position),
-		TMC:          tmc,
-		TDBC:         tdbc,
+	mComsume := &MComsume{
+		ComsumeState: ComsumeState{
+			CurrPosition: new(models.Position),
+		},
+		TMC:       tmc,
+		TDBC:      tdbc,
+		sink:      NewSink(tmc, tdbc),
+		throttler: newThrottler(tmc, tdbc),
+		router:    &schema.TableRouter{SchemaSuffix: tmc.SchemaSuffix},
+	}
+
+	workerCnt := tmc.Workers
+	if workerCnt < 1 {
+		workerCnt = 1
+	}
+	mComsume.workers = make([]*applyWorker, workerCnt)
+	for i := range mComsume.workers {
+		mComsume.workers[i] = newApplyWorker(i, mComsume)
+	}
+	mComsume.errChan = make(chan error, workerCnt)
+
+	return mComsume
+}
+
+// 初始化已经应用完成的GTID集合, 所有的应用线程都需要初始化
+func (this *MComsume) initGTIDSet(gtidSet mysql.GTIDSet) {
+	this.ComsumeState.initGTIDSet(gtidSet)
+	for _, worker := range this.workers {
+		worker.initGTIDSet(gtidSet)
+	}
+}
+
+// 设置从checkpoint继续执行的位点. 保存checkpoint时只有一个应用线程, 只有一个checkpoint.
+// checkpoint 中有GTID集合使用GTID判断 event 是否已经应用, 没有则使用位点
+func (this *MComsume) initCheckpoints(cps []*models.Checkpoint) error {
+	if len(cps) == 0 {
+		return nil
+	}
+	if len(cps) != 1 || len(this.workers) != 1 {
+		return fmt.Errorf("checkpoint 数量 %d, 应用线程数 %d. 只能使用一个应用线程从一个checkpoint继续执行",
+			len(cps), len(this.workers))
+	}
+
+	cp := cps[0]
+	worker := this.workers[0]
+	if len(cp.GTIDSet) == 0 {
+		worker.resumePosition = cp.Position()
+		return nil
+	}
+
+	gtidSet, err := mysql.ParseMysqlGTIDSet(cp.GTIDSet)
+	if err != nil {
+		return fmt.Errorf("checkpoint GTID集合 %s 不正确. %v", cp.GTIDSet, err)
+	}
+	worker.resumeGTIDSet = gtidSet
+	// 之后保存的checkpoint需要包含已经应用的GTID集合
+	if worker.GTIDSet == nil {
+		worker.initGTIDSet(gtidSet)
+		return nil
 	}
+	mergeGTIDSet(worker.GTIDSet, gtidSet)
+	worker.CurrPosition.Executed_Gtid_Set = worker.GTIDSet.String()
+
+	return nil
 }
 
 func (this *MComsume) Comsume() error {
+	throttleDone := make(chan struct{})
+	go this.throttler.run(throttleDone)
+	defer close(throttleDone)
+
+	wg := new(sync.WaitGroup)
+	for _, worker := range this.workers {
+		wg.Add(1)
+		go func(worker *applyWorker) {
+			defer wg.Done()
+			if err := worker.run(); err != nil {
+				this.errChan <- err
+			}
+		}(worker)
+	}
+
+	err := this.dispatch()
+	for _, worker := range this.workers {
+		close(worker.jobChan)
+	}
+	wg.Wait()
+	close(this.errChan)
+	if closeErr := this.sink.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+	if err = <-this.errChan; err != nil { // 返回第一个出错的应用线程的错误
+		return err
+	}
+
+	this.Success = true
+	seelog.Info("binglog应用完成")
+	return nil
+}
+
+// 将 event 分发给应用线程. 事务结束的 event 需要分发给所有的应用线程
+func (this *MComsume) dispatch() error {
 	for ev := range this.EventChan {
+		select {
+		case err := <-this.errChan: // 有应用线程出错, 停止分发
+			return err
+		default:
+		}
+
+		if isTrxEndEvent(ev) {
+			ddl := len(ev.DDLs) > 0 && this.TMC.ReplicateDDL
+			if ddl {
+				if err := this.applyDDLsAfterWorkers(ev); err != nil {
+					return err
+				}
+			}
+			for _, worker := range this.workers {
+				worker.jobChan <- &applyJob{EventData: ev, SaveCheckpoint: ddl}
+			}
+			continue
+		}
+
 		switch e := ev.BinlogEvent.Event.(type) {
 		case *replication.RowsEvent:
-			key := fmt.Sprintf("%s.%s", string(e.Table.Schema), string(e.Table.Table))
-			t, ok := this.TransTableMap[key]
-			if !ok {
+			if ev.Table == nil {
 				seelog.Errorf("正在应用位点为(未完成): %s:%d", ev.LogFile, ev.LogPos)
-				return fmt.Errorf("没有获取到表需要回滚的表信息(生成原sql数据的时候) %s.", key)
+				return fmt.Errorf("没有获取到表需要回滚的表信息(生成原sql数据的时候) %s.%s.",
+					string(e.Table.Schema), string(e.Table.Table))
 			}
-			switch ev.BinlogEvent.Header.EventType {
-			case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
-			case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
-			case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
-				if err := this.writeInsert(e, t); err != nil {
-					this.CurrPosition.File = ev.LogFile
-					this.CurrPosition.Position = ev.LogPos
-					return err
+			jobs, err := this.splitRows(ev, e, ev.Table)
+			if err != nil {
+				return err
+			}
+			for i, job := range jobs {
+				if job != nil {
+					this.workers[i].jobChan <- job
 				}
 			}
 		}
-		this.CurrPosition.File = ev.LogFile
-		this.CurrPosition.Position = ev.LogPos
-		seelog.Infof("位点: %s:%d 应用完成!", this.CurrPosition.File,
-			this.CurrPosition.Position)
 	}
 
-	this.Success = true
-	seelog.Info("binglog应用完成")
 	return nil
 }
 
-func (this *MComsume) writeInsert(ev *replication.RowsEvent, tbl *schema.Table) error {
-	var buf bytes.Buffer
-	for i, row := range ev.Rows {
-		if i == 0 {
-			buf.WriteString(tbl.InsertTemplate)
-		} else {
-			buf.WriteString(",")
-		}
-		buf.WriteString(fmt.Sprintf(tbl.InsertValuePlaceholderTemplate, row...))
+// 等待所有应用线程应用完DDL之前的数据, 再在目标实例执行DDL
+func (this *MComsume) applyDDLsAfterWorkers(ev *EventData) error {
+	barrier := new(sync.WaitGroup)
+	barrier.Add(len(this.workers))
+	for _, worker := range this.workers {
+		worker.jobChan <- &applyJob{EventData: ev, Barrier: barrier}
 	}
+	barrier.Wait()
 
-	defaultDao, err := dao.NewDefaultDao(this.TDBC.Host, this.TDBC.Port)
-	if err != nil {
+	select {
+	case err := <-this.errChan: // 有应用线程出错, 不执行DDL
 		return err
+	default:
 	}
-	if buf.Len() < 10 { // insert 语句长度小于10返回记录日志
-		seelog.Warnf("无效的Insert语句 %s", buf.String())
-		return nil
+
+	return this.applyDDLs(ev)
+}
+
+// 将 row event 中的数据按 表 + 主键值 拆分给应用线程.
+// update 事件使用修改前的主键值, 修改前和修改后的数据分到同一个线程
+func (this *MComsume) splitRows(ev *EventData, e *replication.RowsEvent, t *schema.Table) ([]*applyJob, error) {
+	jobs := make([]*applyJob, len(this.workers))
+	step := 1
+	switch ev.BinlogEvent.Header.EventType {
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		if len(e.Rows)%2 != 0 {
+			return nil, fmt.Errorf("表: %s update 事件数据行数 %d 不是成对出现", t.String(), len(e.Rows))
+		}
+		step = 2
 	}
 
-	if err = defaultDao.ExecDML(buf.String()); err != nil {
-		return err
+	pkValues := make([]interface{}, len(t.PKColumnNames))
+	for i := 0; i < len(e.Rows); i += step {
+		idx := 0
+		if len(this.workers) > 1 {
+			if len(e.Rows[i]) != len(t.ColumnNames) {
+				return nil, fmt.Errorf("表: %s 数据字段数 %d 和表字段数 %d 不一致",
+					t.String(), len(e.Rows[i]), len(t.ColumnNames))
+			}
+			t.SetPKValues(e.Rows[i], pkValues)
+			idx = this.workerIndex(t, pkValues)
+		}
+		if jobs[idx] == nil {
+			jobs[idx] = &applyJob{
+				EventData: ev,
+				Table:     t,
+				Rows:      make([][]interface{}, 0, len(e.Rows)),
+			}
+		}
+		jobs[idx].Rows = append(jobs[idx].Rows, e.Rows[i:i+step]...)
 	}
 
-	return nil
+	return jobs, nil
+}
+
+// 通过 目标表 + 主键值 获取应用线程, 写入同一个目标表的分表中主键相同的数据使用同一个应用线程
+func (this *MComsume) workerIndex(t *schema.Table, pkValues []interface{}) int {
+	h := fnv.New32a()
+	h.Write([]byte(t.TargetString()))
+	for _, v := range pkValues {
+		fmt.Fprintf(h, "\x00%v", v)
+	}
+
+	return int(h.Sum32() % uint32(len(this.workers)))
+}
+
+// 应用完成的位点的副本, 应用线程在锁中修改应用完成的位点
+func (this *MComsume) Position() models.Position {
+	this.Lock()
+	defer this.Unlock()
+
+	return *this.CurrPosition
+}
+
+// 应用线程的位点发生变化, 重新计算应用完成的位点(所有应用线程中最小的位点).
+// 需要在锁中调用
+func (this *MComsume) refreshWatermark() {
+	var minPosition *models.Position
+	for _, worker := range this.workers {
+		if len(worker.CurrPosition.File) == 0 { // 还没有应用完成任何事务
+			return
+		}
+		if minPosition == nil || worker.CurrPosition.LessThan(minPosition) {
+			minPosition = worker.CurrPosition
+		}
+	}
+	if minPosition == nil {
+		return
+	}
+
+	*this.CurrPosition = *minPosition
 }
@@ -0,0 +1,150 @@
+package manal
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestMComsume_splitRows(t *testing.T) {
+	tmc := &config.ToMySQLConfig{Workers: 4}
+	mComsume := NewMComsume(tmc, nil)
+	tbl := newTestTable("t1", "id", "name")
+
+	ev := &EventData{
+		LogFile: "mysql-bin.000001",
+		LogPos:  100,
+		BinlogEvent: &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.UPDATE_ROWS_EVENTv2},
+		},
+	}
+	rowsEvent := &replication.RowsEvent{}
+	for i := int64(0); i < 20; i++ {
+		rowsEvent.Rows = append(rowsEvent.Rows, []interface{}{i, "before"}, []interface{}{i, "after"})
+	}
+
+	jobs, err := mComsume.splitRows(ev, rowsEvent, tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		for j := 0; j < len(job.Rows); j += 2 {
+			// 修改前和修改后的数据在同一个线程, 并且同一主键总是分配给同一个线程
+			if job.Rows[j][0] != job.Rows[j+1][0] || job.Rows[j][1] != "before" {
+				t.Fatalf("线程 %d 数据顺序错误: %v", i, job.Rows)
+			}
+			if idx := mComsume.workerIndex(tbl, []interface{}{job.Rows[j][0]}); idx != i {
+				t.Fatalf("主键 %v 应该分配给线程 %d, 实际为 %d", job.Rows[j][0], idx, i)
+			}
+		}
+		total += len(job.Rows)
+	}
+	if total != len(rowsEvent.Rows) {
+		t.Fatalf("拆分后的数据行数 %d, 期望 %d", total, len(rowsEvent.Rows))
+	}
+}
+
+// 生成事务结束的 event
+func newTrxEndTestEvent(logFile string, logPos uint32, gtid string) *EventData {
+	return &EventData{
+		LogFile:     logFile,
+		LogPos:      logPos,
+		GTID:        gtid,
+		BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{}, Event: &replication.XIDEvent{}},
+	}
+}
+
+func TestMComsume_initCheckpoints(t *testing.T) {
+	const uuid = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	mComsume := NewMComsume(&config.ToMySQLConfig{Workers: 1}, nil)
+	startSet, _ := mysql.ParseMysqlGTIDSet(uuid + ":1-5")
+	mComsume.initGTIDSet(startSet)
+	err := mComsume.initCheckpoints([]*models.Checkpoint{
+		{WorkerID: 0, LogFile: "mysql-bin.000010", LogPos: 500, GTIDSet: uuid + ":1-10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 有GTID集合使用GTID判断, 和binlog文件名无关(源实例切换后文件名不一样)
+	worker := mComsume.workers[0]
+	if worker.resumePosition != nil || worker.CurrPosition.Executed_Gtid_Set != uuid+":1-10" {
+		t.Fatalf("位点: %v, GTID集合: %s", worker.resumePosition, worker.CurrPosition.Executed_Gtid_Set)
+	}
+	cases := []struct {
+		ev      *EventData
+		applied bool
+	}{
+		{newTrxEndTestEvent("mysql-bin.000001", 100, uuid+":8"), true},
+		{newTrxEndTestEvent("new-bin.000001", 100, uuid+":11"), false},
+		{newTrxEndTestEvent("mysql-bin.000001", 100, ""), false},
+		{newTrxEndTestEvent("mysql-bin.000001", 100, "4e11fa47-71ca-11e1-9e33-c80aa9429562:1"), false},
+	}
+	for _, c := range cases {
+		if applied := worker.isApplied(c.ev); applied != c.applied {
+			t.Fatalf("%s:%d %s 是否已经应用: %t, 期望: %t", c.ev.LogFile, c.ev.LogPos, c.ev.GTID, applied, c.applied)
+		}
+	}
+	// 应用新的事务不修改checkpoint中的GTID集合
+	worker.updatePosition(newTrxEndTestEvent("new-bin.000001", 200, uuid+":11"))
+	if worker.resumeGTIDSet.String() != uuid+":1-10" || worker.CurrPosition.Executed_Gtid_Set != uuid+":1-11" {
+		t.Fatalf("checkpoint GTID集合: %s, 应用完成的GTID集合: %s", worker.resumeGTIDSet, worker.CurrPosition.Executed_Gtid_Set)
+	}
+
+	// 没有GTID集合使用位点判断
+	mComsume = NewMComsume(&config.ToMySQLConfig{Workers: 1}, nil)
+	if err = mComsume.initCheckpoints([]*models.Checkpoint{{WorkerID: 0, LogFile: "mysql-bin.000010", LogPos: 300}}); err != nil {
+		t.Fatal(err)
+	}
+	worker = mComsume.workers[0]
+	if worker.resumeGTIDSet != nil || worker.resumePosition.String() != "mysql-bin.000010:300" {
+		t.Fatalf("位点: %v, GTID集合: %v", worker.resumePosition, worker.resumeGTIDSet)
+	}
+	if !worker.isApplied(newTrxEndTestEvent("mysql-bin.000010", 300, uuid+":9")) {
+		t.Fatal("checkpoint 位点之前的 event 已经应用")
+	}
+	if worker.isApplied(newTrxEndTestEvent("mysql-bin.000010", 301, uuid+":10")) ||
+		worker.isApplied(newTrxEndTestEvent("mysql-bin.000010", 200, uuid+":8")) {
+		t.Fatal("checkpoint 位点之后的 event 没有应用")
+	}
+
+	// 多个应用线程或者多个checkpoint不能继续执行
+	mComsume = NewMComsume(&config.ToMySQLConfig{Workers: 2}, nil)
+	if err = mComsume.initCheckpoints([]*models.Checkpoint{{WorkerID: 0}}); err == nil {
+		t.Fatal("多个应用线程不能从checkpoint继续执行")
+	}
+	mComsume = NewMComsume(&config.ToMySQLConfig{Workers: 1}, nil)
+	if err = mComsume.initCheckpoints([]*models.Checkpoint{{WorkerID: 0}, {WorkerID: 1}}); err == nil {
+		t.Fatal("多个checkpoint不能继续执行")
+	}
+	if err = mComsume.initCheckpoints([]*models.Checkpoint{{WorkerID: 0, GTIDSet: "abc"}}); err == nil {
+		t.Fatal("GTID集合不正确应该返回错误")
+	}
+}
+
+func TestMComsume_refreshWatermark(t *testing.T) {
+	mComsume := NewMComsume(&config.ToMySQLConfig{Workers: 2}, nil)
+	w0, w1 := mComsume.workers[0], mComsume.workers[1]
+
+	// 有应用线程还没有应用完成任何事务
+	w0.updatePosition(newTrxEndTestEvent("mysql-bin.000002", 100, ""))
+	if len(mComsume.CurrPosition.File) != 0 {
+		t.Fatalf("应用完成的位点: %s", mComsume.CurrPosition.String())
+	}
+
+	w1.updatePosition(newTrxEndTestEvent("mysql-bin.000001", 900, ""))
+	if mComsume.CurrPosition.String() != "mysql-bin.000001:900" {
+		t.Fatalf("应用完成的位点: %s, 期望: mysql-bin.000001:900", mComsume.CurrPosition.String())
+	}
+	w1.updatePosition(newTrxEndTestEvent("mysql-bin.000003", 100, ""))
+	if mComsume.CurrPosition.String() != "mysql-bin.000002:100" {
+		t.Fatalf("应用完成的位点: %s, 期望: mysql-bin.000002:100", mComsume.CurrPosition.String())
+	}
+}
@@ -0,0 +1,78 @@
+package manal
+
+import (
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 消费者的公共状态
+type ComsumeState struct {
+	CurrPosition *models.Position // 已经应用完成的位点
+	GTIDSet      mysql.GTIDSet    // 已经应用完成的GTID集合
+	Success      bool             // 是否所有的event都已经应用完成
+	IsQuit       bool             // 消费者是否已经退出
+}
+
+func (this *ComsumeState) State() *ComsumeState {
+	return this
+}
+
+// 初始化已经应用完成的GTID集合
+func (this *ComsumeState) initGTIDSet(gtidSet mysql.GTIDSet) {
+	if gtidSet == nil {
+		return
+	}
+	this.GTIDSet = gtidSet.Clone()
+	this.CurrPosition.Executed_Gtid_Set = this.GTIDSet.String()
+}
+
+// event 应用完成, 更新应用完成的位点, 并将 event 所属事务的GTID添加到应用完成的GTID集合中
+func (this *ComsumeState) updatePosition(ev *EventData) {
+	this.CurrPosition.File = ev.LogFile
+	this.CurrPosition.Position = ev.LogPos
+	if ev.BinlogEvent.Header.Timestamp != 0 {
+		this.CurrPosition.TS = time.Unix(int64(ev.BinlogEvent.Header.Timestamp), 0)
+	}
+
+	if len(ev.GTID) == 0 || this.GTIDSet == nil {
+		return
+	}
+	if err := this.GTIDSet.Update(ev.GTID); err != nil {
+		seelog.Warnf("更新应用完成的GTID集合失败 %s. %v", ev.GTID, err)
+		return
+	}
+	this.CurrPosition.Executed_Gtid_Set = this.GTIDSet.String()
+}
+
+// 获取 event 应用完成后的位点, 不修改当前应用完成的位点
+func (this *ComsumeState) nextPosition(ev *EventData) *models.Position {
+	state := &ComsumeState{CurrPosition: new(models.Position)}
+	*state.CurrPosition = *this.CurrPosition
+	if this.GTIDSet != nil {
+		state.GTIDSet = this.GTIDSet.Clone()
+	}
+	state.updatePosition(ev)
+
+	return state.CurrPosition
+}
+
+// 是否是事务结束的event. XIDEvent 和 QueryEvent(COMMIT, DDL) 代表事务结束
+func isTrxEndEvent(ev *EventData) bool {
+	switch ev.BinlogEvent.Event.(type) {
+	case *replication.XIDEvent, *replication.QueryEvent:
+		return true
+	}
+
+	return false
+}
+
+// binlog event 消费者, 消费 Manal.EventChan 中的 event
+type Comsumer interface {
+	Comsume() error
+	State() *ComsumeState
+	Position() models.Position // 应用完成的位点的副本, 可以在其他线程中获取
+}
@@ -0,0 +1,132 @@
+package manal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/services/types"
+)
+
+// 暂停解析binlog. 暂停后不再产生新的 event, 已经产生的 event 会继续应用
+type pauseGate struct {
+	sync.Mutex
+	resumeChan chan struct{} // 不为nil代表已经暂停, 恢复时关闭
+}
+
+func (this *pauseGate) pause() bool {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.resumeChan != nil {
+		return false
+	}
+	this.resumeChan = make(chan struct{})
+	return true
+}
+
+func (this *pauseGate) resume() bool {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.resumeChan == nil {
+		return false
+	}
+	close(this.resumeChan)
+	this.resumeChan = nil
+	return true
+}
+
+func (this *pauseGate) paused() bool {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.resumeChan != nil
+}
+
+// 暂停中则等待恢复, 返回的 chan 为nil代表没有暂停
+func (this *pauseGate) wait() <-chan struct{} {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.resumeChan
+}
+
+// 暂停解析binlog
+func (this *Manal) Pause() {
+	if this.gate.pause() {
+		pos := this.parsedPosition()
+		seelog.Infof("暂停解析binlog. 解析到位点: %s", pos.String())
+	}
+}
+
+// 恢复解析binlog
+func (this *Manal) Resume() {
+	if this.gate.resume() {
+		pos := this.parsedPosition()
+		seelog.Infof("恢复解析binlog. 解析到位点: %s", pos.String())
+	}
+}
+
+// 是否已经暂停解析binlog
+func (this *Manal) Paused() bool {
+	return this.gate.paused()
+}
+
+// 停止任务. 不再产生新的 event, 已经产生的 event 应用完成后任务结束, 限流暂停中则不再应用
+func (this *Manal) Stop() {
+	pos := this.parsedPosition()
+	seelog.Infof("停止任务. 解析到位点: %s", pos.String())
+	this.stopProduct()
+	if this.throttler != nil { // 限流暂停中的数据不再等待应用
+		this.throttler.stop()
+	}
+}
+
+// 暂停中则等待恢复或者任务停止, 返回任务是否已经停止
+func (this *Manal) waitResume() bool {
+	resumeChan := this.gate.wait()
+	if resumeChan == nil {
+		return false
+	}
+
+	select {
+	case <-resumeChan:
+		return false
+	case <-this.ctx.Done():
+		return true
+	}
+}
+
+// 修改结束位点, 需要大于当前解析到的位点. 和解析线程使用同一个锁
+func (this *Manal) SetEndPosition(file string, pos uint32) error {
+	if len(file) == 0 {
+		return fmt.Errorf("结束位点的binlog文件不能为空")
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if endPos := (&models.Position{File: file, Position: pos}); endPos.LessThan(this.CurrentPosition) {
+		return fmt.Errorf("结束位点 %s:%d 小于已经解析到的位点 %s", file, pos, this.CurrentPosition.String())
+	}
+	this.EndPosition.File = file
+	this.EndPosition.Position = pos
+	seelog.Infof("设置结束位点为: %s:%d", file, pos)
+
+	return nil
+}
+
+// 修改结束GTID集合
+func (this *Manal) SetEndGTIDSet(endGTID string) error {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.setEndGTIDSet(endGTID)
+}
+
+// 当前解析和应用的位点信息
+func (this *Manal) SaveInfo() *types.SaveInfo {
+	return this.newSaveInfo()
+}
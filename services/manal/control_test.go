@@ -0,0 +1,133 @@
+package manal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestManal_PauseResume(t *testing.T) {
+	manal := &Manal{CurrentPosition: new(models.Position), EndPosition: new(models.Position)}
+	manal.ctx, manal.cancel = context.WithCancel(context.Background())
+
+	if manal.waitResume() {
+		t.Fatal("没有暂停时不应该停止")
+	}
+
+	// 暂停后等待恢复
+	manal.Pause()
+	if !manal.Paused() {
+		t.Fatal("暂停后状态应该为暂停")
+	}
+	result := make(chan bool, 1)
+	go func() { result <- manal.waitResume() }()
+	select {
+	case <-result:
+		t.Fatal("暂停中不应该返回")
+	case <-time.After(20 * time.Millisecond):
+	}
+	manal.Resume()
+	if stopped := <-result; stopped {
+		t.Fatal("恢复后不应该停止")
+	}
+
+	// 暂停中停止任务
+	manal.Pause()
+	go func() { result <- manal.waitResume() }()
+	manal.Stop()
+	if stopped := <-result; !stopped {
+		t.Fatal("暂停中停止任务应该返回已经停止")
+	}
+}
+
+func TestManal_SetEndPosition(t *testing.T) {
+	manal := &Manal{
+		CurrentPosition: &models.Position{File: "mysql-bin.000002", Position: 100},
+		EndPosition:     new(models.Position),
+	}
+
+	if err := manal.SetEndPosition("mysql-bin.000001", 200); err == nil {
+		t.Fatal("结束位点小于已经解析的位点应该失败")
+	}
+	if err := manal.SetEndPosition("mysql-bin.000002", 200); err != nil {
+		t.Fatal(err)
+	}
+	if manal.EndPosition.File != "mysql-bin.000002" || manal.EndPosition.Position != 200 {
+		t.Fatalf("结束位点: %s", manal.EndPosition.String())
+	}
+
+	// binlog文件名的序号按数字比较
+	manal.CurrentPosition.File = "mysql-bin.999999"
+	if err := manal.SetEndPosition("mysql-bin.1000000", 4); err != nil {
+		t.Fatal(err)
+	}
+	manal.CurrentPosition.File = "mysql-bin.1000000"
+	if err := manal.SetEndPosition("mysql-bin.999999", 200); err == nil {
+		t.Fatal("结束位点小于已经解析的位点应该失败")
+	}
+}
+
+// 解析线程修改位点的同时, 其他线程获取位点和修改结束位点(go test -race)
+func TestManal_PositionsConcurrent(t *testing.T) {
+	mComsume := NewMComsume(&config.ToMySQLConfig{Workers: 2}, nil)
+	manal := &Manal{
+		TMC:             new(config.ToMySQLConfig),
+		CurrentPosition: &models.Position{File: "mysql-bin.000001"},
+		EndPosition:     new(models.Position),
+		Comsumer:        mComsume,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := uint32(1); i <= 1000; i++ {
+			ev := &replication.BinlogEvent{Header: &replication.EventHeader{LogPos: i, Timestamp: i}}
+			if manal.advancePosition(ev) {
+				t.Errorf("位点 %d 不应该超过结束位点", i)
+				return
+			}
+			if i%100 == 0 {
+				manal.rotatePosition(fmt.Sprintf("mysql-bin.%06d", i/100+1))
+			}
+			mComsume.workers[i%2].updatePosition(newTrxEndTestEvent("mysql-bin.000001", i, ""))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		info := manal.SaveInfo()
+		if len(info.ParseLogFile) == 0 {
+			t.Fatal("没有获取到解析的位点")
+		}
+		if err := manal.SetEndPosition("mysql-bin.000100", 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestManal_SetEndGTIDSet(t *testing.T) {
+	manal := &Manal{CurrentPosition: new(models.Position), EndPosition: new(models.Position)}
+
+	// 没有开始GTID集合时不能通过GTID结束
+	if err := manal.SetEndGTIDSet("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-100"); err == nil {
+		t.Fatal("没有开始GTID集合时设置结束GTID集合应该失败")
+	}
+
+	manal.StartPosition = &models.Position{Executed_Gtid_Set: "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23"}
+	manal.TMC = new(config.ToMySQLConfig)
+	manal.TMC.StartGTID = manal.StartPosition.Executed_Gtid_Set
+	if err := manal.initGTIDState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := manal.SetEndGTIDSet("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-100"); err != nil {
+		t.Fatal(err)
+	}
+	if manal.EndPosition.Executed_Gtid_Set != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-100" {
+		t.Fatalf("结束GTID集合: %s", manal.EndPosition.Executed_Gtid_Set)
+	}
+}
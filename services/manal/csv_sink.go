@@ -0,0 +1,214 @@
+package manal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/daiguadaidai/haqi/utils"
+)
+
+const CSV_NULL = `\N` // CSV 中的 NULL, 和 LOAD DATA 一致
+
+// CSV 中每一行数据之前的元信息字段
+var csvMetaColumns = []string{"_op", "_image", "_log_file", "_log_pos", "_event_time", "_gtid", "_thread_id"}
+
+// 每个表的修改输出到各自的 CSV 文件, 文件名为: <prefix>.<schema>.<table>.000001.csv.
+// update 输出修改前(_image 为 before)和修改后(_image 为 after)两行
+type csvSink struct {
+	sync.Mutex
+	tmc   *config.ToMySQLConfig
+	files map[string]*csvTableFile
+}
+
+// 一个表的 CSV 文件. 表结构变化后切换到新的文件, 每个文件第一行为字段名
+type csvTableFile struct {
+	file   *rotateFile
+	header []string
+}
+
+func newCSVSink(tmc *config.ToMySQLConfig) *csvSink {
+	return &csvSink{
+		tmc:   tmc,
+		files: make(map[string]*csvTableFile),
+	}
+}
+
+func (this *csvSink) Begin() (SinkTx, error) {
+	return &csvSinkTx{sink: this}, nil
+}
+
+// DDL 不能输出为数据, 表结构变化后会切换到新的文件
+func (this *csvSink) ExecDDL(ev *EventData, sql string) error {
+	seelog.Infof("写入目标 %s 不输出DDL: %s", config.SINK_CSV, sql)
+	return nil
+}
+
+func (this *csvSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *csvSink) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	var err error
+	for _, tf := range this.files {
+		if closeErr := tf.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// 写入一个表的数据
+func (this *csvSink) write(tbl *schema.Table, p []byte) error {
+	this.Lock()
+	defer this.Unlock()
+
+	tf, ok := this.files[tbl.String()]
+	if !ok {
+		tf = &csvTableFile{}
+		tf.file = &rotateFile{
+			Dir:      this.tmc.OutputDir,
+			Prefix:   fmt.Sprintf("%s.%s.%s", this.tmc.OutputPrefix, tbl.SchemaName, tbl.TableName),
+			Ext:      ".csv",
+			MaxSize:  this.tmc.OutputMaxSize * 1024 * 1024,
+			Interval: this.tmc.OutputRotateInterval,
+			OnOpen:   tf.writeHeader,
+		}
+		this.files[tbl.String()] = tf
+	}
+	if !stringsEqual(tf.header, tbl.ColumnNames) {
+		if err := tf.file.Close(); err != nil {
+			return err
+		}
+		tf.header = tbl.ColumnNames
+	}
+
+	_, err := tf.file.Write(p)
+	return err
+}
+
+func (this *csvTableFile) writeHeader(w io.Writer) error {
+	header := make([]string, 0, len(csvMetaColumns)+len(this.header))
+	header = append(header, csvMetaColumns...)
+	header = append(header, this.header...)
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.Flush()
+	return cw.Error()
+}
+
+// 文件中的事务, 提交时按表写入文件
+type csvSinkTx struct {
+	sink    *csvSink
+	tables  []*schema.Table
+	buffers []*bytes.Buffer
+}
+
+func (this *csvSinkTx) WriteRows(ev *EventData, tbl *schema.Table, op string, rows [][]interface{}) error {
+	changes, err := newRowChanges(tbl, op, rows)
+	if err != nil {
+		return err
+	}
+
+	var buf *bytes.Buffer
+	if n := len(this.tables); n > 0 && this.tables[n-1] == tbl {
+		buf = this.buffers[n-1]
+	} else {
+		buf = new(bytes.Buffer)
+		this.tables = append(this.tables, tbl)
+		this.buffers = append(this.buffers, buf)
+	}
+
+	meta := []string{op, "", ev.LogFile, strconv.FormatUint(uint64(ev.LogPos), 10),
+		utils.TS2String(int64(ev.BinlogEvent.Header.Timestamp), utils.TIME_FORMAT), ev.GTID,
+		strconv.FormatUint(uint64(ev.ThreadID), 10)}
+	cw := csv.NewWriter(buf)
+	for _, change := range changes {
+		if change.Before != nil {
+			meta[1] = "before"
+			cw.Write(csvRecord(meta, change.Before))
+		}
+		if change.After != nil {
+			meta[1] = "after"
+			cw.Write(csvRecord(meta, change.After))
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func (this *csvSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	return errRowSinkExec(config.SINK_CSV)
+}
+
+func (this *csvSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *csvSinkTx) Commit() error {
+	defer this.Rollback()
+
+	for i, tbl := range this.tables {
+		if err := this.sink.write(tbl, this.buffers[i].Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *csvSinkTx) Rollback() error {
+	this.tables = nil
+	this.buffers = nil
+	return nil
+}
+
+// 生成一行 CSV 数据: 元信息 + 字段值
+func csvRecord(meta []string, values []interface{}) []string {
+	record := make([]string, 0, len(meta)+len(values))
+	record = append(record, meta...)
+	for _, v := range values {
+		record = append(record, csvValue(v))
+	}
+
+	return record
+}
+
+func csvValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return CSV_NULL
+	case string:
+		return value
+	case float32:
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
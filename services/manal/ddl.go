@@ -0,0 +1,95 @@
+package manal
+
+import (
+	"fmt"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 处理 QueryEvent 中的DDL, 维护需要执行的表的表结构版本.
+// 之后的 row event 使用DDL执行后的表结构, 已经产生的 event 仍然使用之前的版本.
+// 返回涉及需要执行的表的DDL, 需要按顺序应用到目标实例
+func (this *Manal) handleDDL(e *replication.QueryEvent) ([]*schema.DDL, error) {
+	ddls := schema.ParseDDL(string(e.Query), string(e.Schema))
+	transDDLs := make([]*schema.DDL, 0, len(ddls))
+	for _, ddl := range ddls {
+		_, tracked := this.TransTableMap[ddl.String()]
+		if err := this.trackDDL(ddl); err != nil {
+			return nil, fmt.Errorf("位点: %s 处理DDL失败. %s. %v", this.CurrentPosition.String(), ddl.Query, err)
+		}
+		if !tracked && ddl.Type == schema.DDLTypeCreateTable { // 新建的表需要执行
+			_, tracked = this.TransTableMap[ddl.String()]
+		}
+		if tracked {
+			transDDLs = append(transDDLs, ddl)
+		}
+	}
+
+	return transDDLs, nil
+}
+
+// 根据DDL生成新的表结构版本
+func (this *Manal) trackDDL(ddl *schema.DDL) error {
+	key := ddl.String()
+	t, tracked := this.TransTableMap[key]
+
+	switch ddl.Type {
+	case schema.DDLTypeCreateTable:
+		if !tracked && this.TransType != TransTypeAll {
+			return nil
+		}
+		if ddl.Like { // CREATE TABLE ... LIKE/SELECT 不能通过语句获取表结构, 使用表结构数据源中的表结构
+			seelog.Warnf("表: %s 不能通过DDL获取表结构, 使用当前的表结构. %s", key, ddl.Query)
+			return this.cacheTransTable(ddl.Schema, ddl.Table)
+		}
+		newTable, err := schema.NewTableByCreateDDL(ddl, this.Router)
+		if err != nil {
+			return err
+		}
+		if tracked {
+			newTable.Version = t.Version + 1
+		}
+		if err = this.checkRowFilter(newTable); err != nil {
+			return err
+		}
+		this.TransTableMap[key] = newTable
+		seelog.Infof("表: %s 新建表结构, 版本: %d", key, newTable.Version)
+	case schema.DDLTypeAlterTable:
+		if !tracked {
+			return nil
+		}
+		newTable, err := t.ApplyAlterDDL(ddl)
+		if err != nil {
+			return fmt.Errorf("表: %s 当前版本(%d)的表结构和DDL不一致, 表结构的第一个版本需要是开始位点时的表结构, "+
+				"请通过 --schema-file 指定(mysqldump --no-data 导出). %v", key, t.Version, err)
+		}
+		if err = this.checkRowFilter(newTable); err != nil {
+			return err
+		}
+		this.replaceTransTable(key, newTable)
+		seelog.Infof("表: %s 表结构修改, 版本: %d", newTable.String(), newTable.Version)
+	case schema.DDLTypeRenameTable:
+		if !tracked {
+			return nil
+		}
+		newTable := t.Rename(ddl.NewSchema, ddl.NewTable)
+		this.replaceTransTable(key, newTable)
+		seelog.Infof("表: %s 修改表名为: %s, 版本: %d", key, newTable.String(), newTable.Version)
+	case schema.DDLTypeDropTable:
+		if tracked {
+			seelog.Warnf("表: %s 已经被删除. %s", key, ddl.Query)
+		}
+	}
+
+	return nil
+}
+
+// 替换表结构版本, 表名修改了需要使用新的表名
+func (this *Manal) replaceTransTable(key string, t *schema.Table) {
+	if t.String() != key {
+		delete(this.TransTableMap, key)
+	}
+	this.TransTableMap[t.String()] = t
+}
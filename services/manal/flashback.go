@@ -0,0 +1,268 @@
+package manal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/daiguadaidai/haqi/utils"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 回滚sql在临时文件中的位置
+type flashbackRecord struct {
+	Offset int64
+	Length int64
+}
+
+// 生成回滚sql的消费者.
+// 消费的时候将回滚sql按event顺序写入临时文件, binlog解析完成后再倒序输出到文件或者在目标实例执行
+type FComsume struct {
+	ComsumeState
+	sync.Mutex
+	FC        *config.FlashbackConfig
+	TDBC      *config.DBConfig
+	EventChan chan *EventData
+	tmpFile   *os.File
+	tmpSize   int64
+	records   []*flashbackRecord
+}
+
+func NewFComsume(fc *config.FlashbackConfig, tdbc *config.DBConfig) (*FComsume, error) {
+	tmpFile, err := ioutil.TempFile("", "haqi_flashback_")
+	if err != nil {
+		return nil, fmt.Errorf("创建回滚sql临时文件失败. %v", err)
+	}
+
+	return &FComsume{
+		ComsumeState: ComsumeState{
+			CurrPosition: new(models.Position),
+		},
+		FC:      fc,
+		TDBC:    tdbc,
+		tmpFile: tmpFile,
+		records: make([]*flashbackRecord, 0, 1000),
+	}, nil
+}
+
+// 创建生成回滚sql的 Manal, 回滚sql作用于原表, 不需要检测和修复目标表
+func NewFlashbackManal(fc *config.FlashbackConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (*Manal, *FComsume, error) {
+	manal, err := newManal(&fc.ToMySQLConfig, odbc, tdbc, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fComsume, err := NewFComsume(fc, tdbc)
+	if err != nil {
+		return nil, nil, err
+	}
+	fComsume.EventChan = manal.EventChan
+	fComsume.initGTIDSet(manal.ParsedGTIDSet)
+	manal.Comsumer = fComsume
+
+	return manal, fComsume, nil
+}
+
+// 应用完成的位点的副本
+func (this *FComsume) Position() models.Position {
+	this.Lock()
+	defer this.Unlock()
+
+	return *this.CurrPosition
+}
+
+func (this *FComsume) Comsume() error {
+	for ev := range this.EventChan {
+		switch e := ev.BinlogEvent.Event.(type) {
+		case *replication.RowsEvent:
+			if ev.Table == nil {
+				seelog.Errorf("正在生成回滚sql位点为(未完成): %s:%d", ev.LogFile, ev.LogPos)
+				return fmt.Errorf("没有获取到表需要回滚的表信息(生成回滚sql的时候) %s.%s.",
+					string(e.Table.Schema), string(e.Table.Table))
+			}
+			sqls, err := this.rollbackSQLs(ev.BinlogEvent.Header.EventType, e, ev.Table)
+			if err != nil {
+				return fmt.Errorf("位点: %s:%d 生成回滚sql失败. %v", ev.LogFile, ev.LogPos, err)
+			}
+			comment := fmt.Sprintf("-- %s:%d %s", ev.LogFile, ev.LogPos,
+				utils.TS2String(int64(ev.BinlogEvent.Header.Timestamp), utils.TIME_FORMAT))
+			for _, sql := range sqls {
+				if err = this.writeRecord(comment, sql); err != nil {
+					return err
+				}
+			}
+		}
+		this.Lock()
+		this.updatePosition(ev)
+		this.Unlock()
+	}
+
+	this.Success = true
+	seelog.Infof("回滚sql生成完成, 共 %d 条", len(this.records))
+	return nil
+}
+
+// 生成回滚sql. insert -> delete, delete -> insert, update -> 更新为修改前的数据
+func (this *FComsume) rollbackSQLs(
+	eventType replication.EventType,
+	ev *replication.RowsEvent,
+	tbl *schema.Table,
+) ([]string, error) {
+	sqls := make([]string, 0, len(ev.Rows))
+	var sql string
+	var args []interface{}
+	var err error
+
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, row := range ev.Rows {
+			if sql, args, err = tbl.BuildDeleteSQL(row); err != nil {
+				return nil, err
+			}
+			if sql, err = schema.InterpolateSQL(sql, args); err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+		}
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		if len(ev.Rows)%2 != 0 {
+			return nil, fmt.Errorf("表: %s update 事件数据行数 %d 不是成对出现", tbl.String(), len(ev.Rows))
+		}
+		for i := 0; i < len(ev.Rows); i += 2 {
+			if sql, args, err = tbl.BuildUpdateSQL(ev.Rows[i+1], ev.Rows[i]); err != nil {
+				return nil, err
+			}
+			if sql, err = schema.InterpolateSQL(sql, args); err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range ev.Rows {
+			if sql, args, err = tbl.BuildInsertSQL([][]interface{}{row}); err != nil {
+				return nil, err
+			}
+			if sql, err = schema.InterpolateSQL(sql, args); err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+		}
+	}
+
+	return sqls, nil
+}
+
+// 将一条回滚sql写入临时文件, 格式: 注释行\nsql;\n
+func (this *FComsume) writeRecord(comment string, sql string) error {
+	data := fmt.Sprintf("%s\n%s;\n", comment, sql)
+	n, err := this.tmpFile.WriteString(data)
+	if err != nil {
+		return fmt.Errorf("写入回滚sql临时文件失败. %v", err)
+	}
+	this.records = append(this.records, &flashbackRecord{
+		Offset: this.tmpSize,
+		Length: int64(n),
+	})
+	this.tmpSize += int64(n)
+
+	return nil
+}
+
+// 将回滚sql倒序输出到文件, 或者在目标实例中使用一个事务执行
+func (this *FComsume) Output() error {
+	defer this.Close()
+
+	var writer *bufio.Writer
+	if len(this.FC.SQLFile) != 0 {
+		f, err := os.Create(this.FC.SQLFile)
+		if err != nil {
+			return fmt.Errorf("创建回滚sql文件失败. %v", err)
+		}
+		defer f.Close()
+		writer = bufio.NewWriter(f)
+		fmt.Fprintf(writer, "-- haqi flashback. 生成时间: %s\n", time.Now().Format(utils.TIME_FORMAT))
+	}
+
+	var txDao *dao.DefaultDao
+	if this.FC.Execute {
+		defaultDao, err := dao.NewDefaultDao(this.TDBC)
+		if err != nil {
+			return err
+		}
+		if txDao, err = defaultDao.Begin(); err != nil {
+			return fmt.Errorf("目标实例开启事务失败. %v", err)
+		}
+	}
+
+	for i := len(this.records) - 1; i >= 0; i-- {
+		data, err := this.readRecord(this.records[i])
+		if err != nil {
+			return this.rollbackOutput(txDao, err)
+		}
+		if writer != nil {
+			if _, err = writer.WriteString(data); err != nil {
+				return this.rollbackOutput(txDao, fmt.Errorf("写入回滚sql文件失败. %v", err))
+			}
+		}
+		if txDao != nil {
+			// 第一行为注释, 之后为sql语句
+			sql := strings.TrimSuffix(data[strings.Index(data, "\n")+1:], ";\n")
+			if err = txDao.ExecDML(sql); err != nil {
+				return this.rollbackOutput(txDao, fmt.Errorf("执行回滚sql失败. %s. %v", sql, err))
+			}
+		}
+	}
+
+	if writer != nil {
+		if err := writer.Flush(); err != nil {
+			return this.rollbackOutput(txDao, fmt.Errorf("写入回滚sql文件失败. %v", err))
+		}
+		seelog.Infof("回滚sql已经写入文件: %s", this.FC.SQLFile)
+	}
+	if txDao != nil {
+		if err := txDao.Commit(); err != nil {
+			return fmt.Errorf("回滚sql提交失败. %v", err)
+		}
+		seelog.Infof("回滚sql在目标实例 %s 执行完成", this.TDBC.Addr())
+	}
+
+	return nil
+}
+
+// 输出回滚sql出错, 回滚目标实例的事务
+func (this *FComsume) rollbackOutput(txDao *dao.DefaultDao, err error) error {
+	if txDao != nil {
+		if rbErr := txDao.Rollback(); rbErr != nil {
+			seelog.Errorf("回滚目标实例事务失败. %v", rbErr)
+		}
+	}
+	return err
+}
+
+// 从临时文件中读取一条回滚sql
+func (this *FComsume) readRecord(record *flashbackRecord) (string, error) {
+	buf := make([]byte, record.Length)
+	if _, err := this.tmpFile.ReadAt(buf, record.Offset); err != nil && err != io.EOF {
+		return "", fmt.Errorf("读取回滚sql临时文件失败. %v", err)
+	}
+	return string(buf), nil
+}
+
+// 删除临时文件
+func (this *FComsume) Close() {
+	name := this.tmpFile.Name()
+	this.tmpFile.Close()
+	if err := os.Remove(name); err != nil {
+		seelog.Warnf("删除回滚sql临时文件失败 %s. %v", name, err)
+	}
+}
@@ -0,0 +1,125 @@
+package manal
+
+import (
+	"fmt"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 解析过程中的GTID信息
+type GTIDState struct {
+	StartGTIDSet   mysql.GTIDSet // 开始GTID集合, 没有指定为nil
+	EndGTIDSet     mysql.GTIDSet // 结束GTID集合, 没有指定为nil
+	ParsedGTIDSet  mysql.GTIDSet // 已经解析完成的GTID集合(包含开始GTID集合)
+	CurrentGTID    string        // 当前事务的GTID
+	SkipCurrentTrx bool          // 当前事务的GTID包含在开始GTID集合中, 需要跳过
+}
+
+// 初始化GTID信息
+func (this *Manal) initGTIDState() error {
+	var err error
+	if this.ParsedGTIDSet, err = mysql.ParseMysqlGTIDSet(this.StartPosition.Executed_Gtid_Set); err != nil {
+		return err
+	}
+	if this.TMC.HaveStartGTIDInfo() {
+		this.StartGTIDSet = this.ParsedGTIDSet.Clone()
+	}
+	this.CurrentPosition.Executed_Gtid_Set = this.ParsedGTIDSet.String()
+
+	if this.TMC.HaveEndGTIDInfo() {
+		if err = this.setEndGTIDSet(this.TMC.EndGTID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 设置结束GTID集合, 任务开始后需要在锁中调用
+func (this *Manal) setEndGTIDSet(endGTID string) error {
+	// 没有开始GTID集合, 解析的GTID集合永远不会包含结束GTID集合
+	if this.StartGTIDSet == nil {
+		return fmt.Errorf("没有指定开始GTID集合, 不能通过结束GTID集合停止")
+	}
+	endGTIDSet, err := mysql.ParseMysqlGTIDSet(endGTID)
+	if err != nil {
+		return err
+	}
+	if this.EndGTIDSet != nil && this.EndGTIDSet.Equal(endGTIDSet) && endGTIDSet.Equal(this.EndGTIDSet) {
+		return nil
+	}
+
+	this.EndGTIDSet = endGTIDSet
+	this.EndPosition.Executed_Gtid_Set = endGTIDSet.String()
+	seelog.Infof("设置结束GTID集合为: %s", this.EndPosition.Executed_Gtid_Set)
+	return nil
+}
+
+// 开始一个新的事务
+func (this *Manal) beginGTID(ev *replication.GTIDEvent) {
+	this.CurrentGTID = models.GTIDString(ev.SID, ev.GNO)
+
+	this.SkipCurrentTrx = false
+	if this.StartGTIDSet == nil {
+		return
+	}
+	currSet, err := mysql.ParseMysqlGTIDSet(this.CurrentGTID)
+	if err != nil {
+		seelog.Warnf("解析GTID %s 失败. %v", this.CurrentGTID, err)
+		return
+	}
+	this.SkipCurrentTrx = this.StartGTIDSet.Contain(currSet)
+}
+
+// 事务结束, 将当前事务的GTID添加到已经解析的GTID集合中. 返回是否已经到达结束GTID集合
+func (this *Manal) commitGTID() bool {
+	if len(this.CurrentGTID) == 0 {
+		return false
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	if err := this.ParsedGTIDSet.Update(this.CurrentGTID); err != nil {
+		seelog.Warnf("更新解析的GTID集合失败 %s. %v", this.CurrentGTID, err)
+	}
+	this.CurrentPosition.Executed_Gtid_Set = this.ParsedGTIDSet.String()
+	this.CurrentGTID = ""
+	this.SkipCurrentTrx = false
+
+	if this.rlEndGTID() {
+		this.ProductSuccess = true // 代表任务完成
+		seelog.Infof("解析的GTID集合 %s 已经包含结束GTID集合 %s",
+			this.CurrentPosition.Executed_Gtid_Set, this.EndPosition.Executed_Gtid_Set)
+		return true
+	}
+
+	return false
+}
+
+// 已经解析的GTID集合是否包含了结束GTID集合, 需要在锁中调用
+func (this *Manal) rlEndGTID() bool {
+	if this.EndGTIDSet == nil {
+		return false
+	}
+
+	return this.ParsedGTIDSet.Contain(this.EndGTIDSet)
+}
+
+// 将 src 中的GTID添加到 dst 中, src 不修改
+func mergeGTIDSet(dst mysql.GTIDSet, src mysql.GTIDSet) {
+	dstSet, ok := dst.(*mysql.MysqlGTIDSet)
+	if !ok {
+		return
+	}
+	srcSet, ok := src.Clone().(*mysql.MysqlGTIDSet)
+	if !ok {
+		return
+	}
+	for _, uuidSet := range srcSet.Sets {
+		dstSet.AddSet(uuidSet)
+	}
+}
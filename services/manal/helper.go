@@ -6,12 +6,37 @@ import (
 	"github.com/daiguadaidai/haqi/config"
 	"github.com/daiguadaidai/haqi/dao"
 	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
 	"github.com/daiguadaidai/haqi/utils"
+	"github.com/siddontang/go-mysql/mysql"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
+var binlogFileRegexp = regexp.MustCompile(`\.\d+$`)
+
 // 获取开始的位点信息
 func GetStartPosition(bc *config.BaseConfig, dbc *config.DBConfig) (*models.Position, error) {
+	if bc.IsOffline() { // 离线模式, 开始位点需要在本地的binlog文件中
+		startPos, err := getOfflineStartPosition(bc)
+		if err != nil {
+			return nil, err
+		}
+		startPos.Executed_Gtid_Set = bc.StartGTID
+		return startPos, nil
+	}
+
+	if bc.HaveStartGTIDInfo() { // 使用GTID作为开始位点
+		// 检测开始GTID之后的binlog是否已经被清除
+		if err := checkStartGTIDNotPurged(bc.StartGTID, dbc); err != nil {
+			return nil, err
+		}
+		return &models.Position{Executed_Gtid_Set: bc.StartGTID}, nil
+	}
+
 	if bc.HaveStartPosInfo() { // 有设置开始位点信息
 		startPos := getPositionByPosInfo(bc.StartLogFile, bc.StartLogPos)
 		// 检测开始位点是否在系统保留的binlog范围内
@@ -21,9 +46,178 @@ func GetStartPosition(bc *config.BaseConfig, dbc *config.DBConfig) (*models.Posi
 		return startPos, nil
 	}
 
+	if bc.HaveStartTimeInfo() { // 通过开始时间获取开始位点
+		startTime, err := bc.StartTime()
+		if err != nil {
+			return nil, err
+		}
+		return getStartPositionByTime(startTime, dbc)
+	}
+
 	return nil, nil
 }
 
+// 从目标实例中保存的checkpoint继续执行, 使用checkpoint替换开始位点.
+// 使用GTID开始的任务使用checkpoint的GTID集合. 没有checkpoint则使用指定的开始位点
+func ResumeFromCheckpoint(tmc *config.ToMySQLConfig, tdbc *config.DBConfig) ([]*models.Checkpoint, error) {
+	defaultDao, err := dao.NewDefaultDao(tdbc)
+	if err != nil {
+		return nil, err
+	}
+	if err = defaultDao.CreateCheckpointTable(tmc.CheckpointSchema); err != nil {
+		return nil, fmt.Errorf("创建checkpoint表失败. %v", err)
+	}
+	cps, err := defaultDao.FindCheckpoints(tmc.CheckpointSchema, tmc.TaskUUID)
+	if err != nil {
+		return nil, fmt.Errorf("获取checkpoint失败. %v", err)
+	}
+	if len(cps) == 0 {
+		seelog.Warnf("TaskUUID: %s. 没有找到checkpoint, 使用指定的开始位点", tmc.TaskUUID)
+		return nil, nil
+	}
+
+	if len(cps) != 1 {
+		return nil, fmt.Errorf("TaskUUID: %s. checkpoint 数量 %d 不正确, 只能从一个应用线程保存的checkpoint继续执行",
+			tmc.TaskUUID, len(cps))
+	}
+	cp := cps[0]
+
+	if tmc.HaveStartGTIDInfo() && len(cp.GTIDSet) != 0 {
+		tmc.StartGTID = cp.GTIDSet
+		seelog.Infof("TaskUUID: %s. 从checkpoint继续执行, 开始GTID集合: %s", tmc.TaskUUID, cp.GTIDSet)
+		return cps, nil
+	}
+
+	tmc.StartLogFile = cp.LogFile
+	tmc.StartLogPos = cp.LogPos
+	seelog.Infof("TaskUUID: %s. 从checkpoint继续执行, 开始位点: %s", tmc.TaskUUID, cp.String())
+	return cps, nil
+}
+
+// 检测按位点继续执行的checkpoint中的binlog文件在源实例(离线模式为本地binlog文件)中是否存在.
+// 源实例切换后binlog文件名不一样, 按位点判断 event 是否已经应用会跳过没有应用的数据
+func checkResumeLogFiles(cps []*models.Checkpoint, bc *config.BaseConfig, dbc *config.DBConfig) error {
+	if len(cps) == 0 {
+		return nil
+	}
+
+	logFiles := make([]string, 0, 10)
+	if bc.IsOffline() {
+		files, err := FindLocalBinlogFiles(bc)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			logFiles = append(logFiles, filepath.Base(file))
+		}
+	} else {
+		defaultDao, err := dao.NewDefaultDao(dbc)
+		if err != nil {
+			return err
+		}
+		bLogs, err := defaultDao.ShowBinaryLogs()
+		if err != nil {
+			return fmt.Errorf("获取源实例binlog文件失败. %v", err)
+		}
+		for _, bLog := range bLogs {
+			logFiles = append(logFiles, bLog.LogName)
+		}
+	}
+
+	return checkCheckpointLogFiles(cps, bc.HaveStartGTIDInfo(), logFiles)
+}
+
+// 使用GTID继续执行时, 有GTID集合的checkpoint不需要检测binlog文件
+func checkCheckpointLogFiles(cps []*models.Checkpoint, byGTID bool, logFiles []string) error {
+	exists := make(map[string]bool, len(logFiles))
+	for _, logFile := range logFiles {
+		exists[logFile] = true
+	}
+
+	for _, cp := range cps {
+		if byGTID && len(cp.GTIDSet) != 0 {
+			continue
+		}
+		if !exists[cp.LogFile] {
+			return fmt.Errorf("应用线程 %d 的checkpoint %s 中的binlog文件在源实例中不存在(源实例可能已经切换), "+
+				"不能按位点继续执行. 请使用GTID继续执行(--start-gtid)或者重新指定开始位点", cp.WorkerID, cp.String())
+		}
+	}
+
+	return nil
+}
+
+// 获取离线模式的开始位点, 没有指定开始位点则从第一个binlog文件开始
+func getOfflineStartPosition(bc *config.BaseConfig) (*models.Position, error) {
+	files, err := FindLocalBinlogFiles(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bc.HaveStartPosInfo() && !bc.HaveStartGTIDInfo() && bc.HaveStartTimeInfo() { // 通过开始时间获取开始位点
+		startTime, err := bc.StartTime()
+		if err != nil {
+			return nil, err
+		}
+		return getOfflineStartPositionByTime(startTime, bc)
+	}
+
+	if !bc.HaveStartPosInfo() { // 没有指定开始位点(或者使用GTID), 从第一个文件开始
+		return getPositionByPosInfo(filepath.Base(files[0]), 4), nil
+	}
+
+	for _, file := range files {
+		if filepath.Base(file) == bc.StartLogFile {
+			return getPositionByPosInfo(bc.StartLogFile, bc.StartLogPos), nil
+		}
+	}
+
+	return nil, fmt.Errorf("指定的开始位点 %s:%d 不在本地的binlog文件中", bc.StartLogFile, bc.StartLogPos)
+}
+
+// 获取本地的binlog文件, 按文件名排序.
+// 指定了 binlog-files 使用指定的文件(相对路径基于 binlog-dir),
+// 没有指定则使用 binlog-dir 目录下所有以 .数字 结尾的文件
+func FindLocalBinlogFiles(bc *config.BaseConfig) ([]string, error) {
+	files := make([]string, 0, 1)
+	if len(bc.BinlogFiles) != 0 {
+		for _, file := range bc.BinlogFiles {
+			if !filepath.IsAbs(file) && bc.BinlogDir != "" {
+				file = filepath.Join(bc.BinlogDir, file)
+			}
+			exists, err := utils.PathExists(file)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, fmt.Errorf("binlog文件不存在: %s", file)
+			}
+			files = append(files, file)
+		}
+	} else {
+		infos, err := ioutil.ReadDir(bc.BinlogDir)
+		if err != nil {
+			return nil, fmt.Errorf("读取binlog目录失败. %v", err)
+		}
+		for _, info := range infos {
+			if info.IsDir() || !binlogFileRegexp.MatchString(info.Name()) {
+				continue
+			}
+			files = append(files, filepath.Join(bc.BinlogDir, info.Name()))
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有找到本地binlog文件. 目录: %s, 文件: %v", bc.BinlogDir, bc.BinlogFiles)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return models.CompareLogFile(filepath.Base(files[i]), filepath.Base(files[j])) < 0
+	})
+
+	return files, nil
+}
+
 // 通过位点信息
 func getPositionByPosInfo(logFile string, logPos uint32) *models.Position {
 	return &models.Position{
@@ -35,7 +229,7 @@ func getPositionByPosInfo(logFile string, logPos uint32) *models.Position {
 // 检测开始位点是否在系统保留的binlog范围内
 func checkStartPosInRange(startPos *models.Position, dbc *config.DBConfig) error {
 	// 获取 最老和最新的位点信息
-	defaultDao, err := dao.NewDefaultDao(dbc.Host, dbc.Port)
+	defaultDao, err := dao.NewDefaultDao(dbc)
 	if err != nil {
 		return err
 	}
@@ -57,12 +251,43 @@ func checkStartPosInRange(startPos *models.Position, dbc *config.DBConfig) error
 	return nil
 }
 
+// 检测开始GTID之后的binlog是否已经被清除, 已经清除的GTID集合需要包含在开始GTID集合中
+func checkStartGTIDNotPurged(startGTID string, dbc *config.DBConfig) error {
+	defaultDao, err := dao.NewDefaultDao(dbc)
+	if err != nil {
+		return err
+	}
+	purged, err := defaultDao.GetGTIDPurged()
+	if err != nil {
+		return fmt.Errorf("获取 gtid_purged 失败. %v", err)
+	}
+
+	startSet, err := mysql.ParseMysqlGTIDSet(startGTID)
+	if err != nil {
+		return err
+	}
+	purgedSet, err := mysql.ParseMysqlGTIDSet(purged)
+	if err != nil {
+		return err
+	}
+	if !startSet.Contain(purgedSet) {
+		return fmt.Errorf("指定的开始GTID %s 太过久远. 已经被清除的GTID为: %s", startGTID, purged)
+	}
+
+	return nil
+}
+
 // 获取结束位点信息
 func GetEndPosition(bc *config.BaseConfig) *models.Position {
+	pos := getPositionByPosInfo("", 0)
 	if bc.HaveEndPosInfo() {
-		return getPositionByPosInfo(bc.EndLogFile, bc.EndLogPos)
+		pos = getPositionByPosInfo(bc.EndLogFile, bc.EndLogPos)
 	}
-	return getPositionByPosInfo("", 0)
+	pos.Executed_Gtid_Set = bc.EndGTID
+	if bc.HaveStopTimeInfo() {
+		pos.TS, _ = bc.StopTime() // 时间格式在检测配置的时候已经校验过
+	}
+	return pos
 }
 
 /* 获取需要回滚的表
@@ -75,7 +300,7 @@ Return:
     ......
 ]
 */
-func FindTransTables(bc *config.BaseConfig, dbc *config.DBConfig) ([]*models.DBTable, TransType, error) {
+func FindTransTables(bc *config.BaseConfig, metaDao dao.MetaDao) ([]*models.DBTable, TransType, error) {
 	transTables := make([]*models.DBTable, 0, 1)
 
 	// 没有指定表, 说明使用所有的表
@@ -117,11 +342,7 @@ func FindTransTables(bc *config.BaseConfig, dbc *config.DBConfig) ([]*models.DBT
 		}
 		notAllTableSchema[cchema] = true
 
-		defaultDao, err := dao.NewDefaultDao(dbc.Host, dbc.Port)
-		if err != nil {
-			return nil, TransTypeNone, err
-		}
-		tables, err := defaultDao.FindTablesBySchema(cchema)
+		tables, err := metaDao.FindTablesBySchema(cchema)
 		if err != nil {
 			return nil, TransTypeNone, fmt.Errorf("获取数据库下面的所有表失败. %v", err)
 		}
@@ -135,13 +356,28 @@ func FindTransTables(bc *config.BaseConfig, dbc *config.DBConfig) ([]*models.DBT
 	return transTables, TransTypePartial, nil
 }
 
-// 检测和修复表
+// 修复目标表的选项
+type RePairOption struct {
+	AllowExtraColumns bool                                // 目标表是否允许有源表中没有的字段(源表删除的字段在归档表中保留)
+	AuditColumns      bool                                // 目标表是否需要添加审计字段
+	DryRun            bool                                // 只输出需要在目标实例执行的DDL, 不执行
+	Task              string                              // 监控指标中的任务名称
+	Projections       map[string]*schema.ColumnProjection // 每个表的字段处理规则, 目标表使用处理后的字段
+}
+
+// 记录执行的修复DDL数
+func (this *RePairOption) countRePair(sName, tName, action string) {
+	ddlRepairsCounter.WithLabelValues(this.Task, sName, tName, action).Inc()
+}
+
+// 检测和修复表, oriDao 为获取源表结构的数据源
 func CompareAndRePairTable(
-	oriDBC *config.DBConfig,
+	oriDao dao.MetaDao,
 	stdDBC *config.DBConfig,
 	sName string,
-	sSuffix string, // 数据库后缀
 	tName string,
+	router *schema.TableRouter, // 目标表名路由
+	opt *RePairOption,
 ) error {
 	var oriTableStr string
 	var stdTableStr string
@@ -149,85 +385,172 @@ func CompareAndRePairTable(
 	var err error
 
 	// 1. 获取源和目标表结构, 源表不存在则返回错误. 目标表不存在则创建一个新的.
-	oriDao, err := dao.NewDefaultDao(oriDBC.Host, oriDBC.Port)
-	if err != nil {
-		return fmt.Errorf("获取源实例dao. %v", err)
-	}
 	// 获取源实例中的键表结构
 	oriTableStr, exists, err = oriDao.ShowCreateTable(sName, tName)
 	if err != nil {
 		return fmt.Errorf("源实例show create table. %v", err)
 	}
 	if !exists { // 表不存在
-		return fmt.Errorf("表:%s.%s在源实例中不存在%s", sName, tName, oriDBC.Addr())
+		return fmt.Errorf("表:%s.%s在源表结构中不存在", sName, tName)
+	}
+	projection := opt.Projections[fmt.Sprintf("%s.%s", sName, tName)]
+	if projection != nil { // 目标表的字段为处理后的字段
+		if oriTableStr, err = projection.ProjectCreateTable(oriTableStr); err != nil {
+			return err
+		}
 	}
 
-	stdSName := fmt.Sprintf("%s%s", sName, sSuffix) // 目标数据库名称
-	stdDao, err := dao.NewDefaultDao(stdDBC.Host, stdDBC.Port)
+	stdSName, stdTName := router.Target(sName, tName) // 目标数据库名称和表名
+	stdDao, err := dao.NewDefaultDao(stdDBC)
 	if err != nil {
 		return fmt.Errorf("获取目标实例dao. %v", err)
 	}
 	// 创建目标数据库, create database if not exists xxx
-	err = stdDao.ReCreateDB(fmt.Sprintf("%s%s", sName, sSuffix))
-	if err != nil {
+	if opt.DryRun {
+		seelog.Infof("dry-run, 不执行: CREATE DATABASE IF NOT EXISTS `%s`", stdSName)
+	} else if err = stdDao.ReCreateDB(stdSName); err != nil {
 		return fmt.Errorf("创建目标数据库出错. %v", err)
 	}
 	// 获取目标数据库中的表结构
-	stdTableStr, exists, err = stdDao.ShowCreateTable(stdSName, tName)
+	stdTableStr, exists, err = stdDao.ShowCreateTable(stdSName, stdTName)
 	if err != nil {
 		return fmt.Errorf("目标实例show create table. %v", err)
 	}
 	if !exists { // 目标实例数据库中不存在表则创建相关表
-		stdTableStr = utils.ReplaceCreateTableName(oriTableStr, stdSName, tName)
+		stdTableStr = utils.ReplaceCreateTableName(oriTableStr, stdSName, stdTName)
+		if opt.DryRun {
+			seelog.Infof("dry-run, 不执行: %s", stdTableStr)
+			return nil
+		}
 		if err = stdDao.CreateTable(stdTableStr); err != nil {
 			return fmt.Errorf("创建目标数据库表 %v. %v", stdTableStr, err)
 		}
+		opt.countRePair(stdSName, stdTName, "create_table")
+		if opt.AuditColumns {
+			return addAuditColumns(stdDao, stdSName, stdTName, opt)
+		}
 		return nil
 	}
 
 	// 2. 获取原表和目标表的字段 crc32 值, 并且进行比较. 找到不一样或者多的字段
+	stdColumnCRC32Map, err := stdDao.ColumnCRC32(stdSName, stdTName)
+	if err != nil {
+		return fmt.Errorf("获取目标表%s.%s字段CRC32值. %v", stdSName, stdTName, err)
+	}
 	// 获取源表字段 crc32
-	oriColumnCRC32Map, err := oriDao.ColumnCRC32(sName, tName)
+	oriColumnCRC32Map, err := oriColumnCRC32(oriDao, sName, tName, stdColumnCRC32Map)
 	if err != nil {
 		return fmt.Errorf("获取源表%s.%s字段CRC32值. %v", sName, tName, err)
 	}
-	stdColumnCRC32Map, err := oriDao.ColumnCRC32(stdSName, tName)
-	if err != nil {
-		return fmt.Errorf("获取目标表%s.%s字段CRC32值. %v", stdSName, tName, err)
+	if projection != nil {
+		if oriColumnCRC32Map, err = projection.ProjectColumnCRC32(oriColumnCRC32Map, stdColumnCRC32Map); err != nil {
+			return fmt.Errorf("表:%s.%s. %v", sName, tName, err)
+		}
 	}
 
-	needAddColumns, needModifyColumns, err := compareColumn(oriColumnCRC32Map, stdColumnCRC32Map)
+	needAddColumns, needModifyColumns, err := compareColumn(oriColumnCRC32Map, stdColumnCRC32Map, opt.AllowExtraColumns)
 	if err != nil {
-		return fmt.Errorf("目标表:%s.%s, 源表:%s.%s. %v", sName, tName, stdSName, tName, err)
+		return fmt.Errorf("目标表:%s.%s, 源表:%s.%s. %v", stdSName, stdTName, sName, tName, err)
 	}
 
 	// 执行 alter table add column sql语句
-	addColumnSQLs := filterAddColumnSqls(oriTableStr, stdSName, tName, needAddColumns, len(oriColumnCRC32Map))
+	addColumnSQLs := filterAddColumnSqls(oriTableStr, stdSName, stdTName, needAddColumns, len(oriColumnCRC32Map))
 	for _, addSQL := range addColumnSQLs {
+		if opt.DryRun {
+			seelog.Infof("dry-run, 不执行: %s", addSQL)
+			continue
+		}
 		err = stdDao.AlterTable(addSQL)
 		if err != nil {
-			return fmt.Errorf("表:%s.%s 添加字段失败. %s. %v", stdSName, tName, addSQL, err)
+			return fmt.Errorf("表:%s.%s 添加字段失败. %s. %v", stdSName, stdTName, addSQL, err)
 		}
-		seelog.Infof("表:%s.%s 添加字段成功. %s", stdSName, tName, addSQL)
+		seelog.Infof("表:%s.%s 添加字段成功. %s", stdSName, stdTName, addSQL)
+		opt.countRePair(stdSName, stdTName, "add_column")
 	}
 
 	// 执行 alter table modify column sql语句
-	modifyColumnSQLs := filterModifyColumnSqls(oriTableStr, stdSName, tName, needModifyColumns, len(oriColumnCRC32Map))
+	modifyColumnSQLs := filterModifyColumnSqls(oriTableStr, stdSName, stdTName, needModifyColumns, len(oriColumnCRC32Map))
 	for _, modifySQL := range modifyColumnSQLs {
+		if opt.DryRun {
+			seelog.Infof("dry-run, 不执行: %s", modifySQL)
+			continue
+		}
 		err = stdDao.AlterTable(modifySQL)
 		if err != nil {
-			return fmt.Errorf("表:%s.%s 添加字段失败. %s. %v", stdSName, tName, modifySQL, err)
+			return fmt.Errorf("表:%s.%s 添加字段失败. %s. %v", stdSName, stdTName, modifySQL, err)
+		}
+		seelog.Infof("表:%s.%s modify字段成功. %s", stdSName, stdTName, modifySQL)
+		opt.countRePair(stdSName, stdTName, "modify_column")
+	}
+
+	if opt.AuditColumns {
+		return addAuditColumns(stdDao, stdSName, stdTName, opt)
+	}
+
+	return nil
+}
+
+// 目标表添加缺少的审计字段
+func addAuditColumns(stdDao *dao.DefaultDao, sName, tName string, opt *RePairOption) error {
+	columnCRC32Map, err := stdDao.ColumnCRC32(sName, tName)
+	if err != nil {
+		return fmt.Errorf("获取目标表%s.%s字段CRC32值. %v", sName, tName, err)
+	}
+
+	for _, column := range schema.AuditColumns {
+		if _, ok := columnCRC32Map[column.ColumnName]; ok {
+			continue
+		}
+		addSQL := fmt.Sprintf("ALTER TABLE `%s`.`%s` ADD COLUMN %s", sName, tName, schema.AuditColumnDefinition(column))
+		if opt.DryRun {
+			seelog.Infof("dry-run, 不执行: %s", addSQL)
+			continue
 		}
-		seelog.Infof("表:%s.%s modify字段成功. %s", stdSName, tName, modifySQL)
+		if err = stdDao.AlterTable(addSQL); err != nil {
+			return fmt.Errorf("表:%s.%s 添加审计字段失败. %s. %v", sName, tName, addSQL, err)
+		}
+		seelog.Infof("表:%s.%s 添加审计字段成功. %s", sName, tName, addSQL)
+		opt.countRePair(sName, tName, "add_audit_column")
 	}
 
 	return nil
 }
 
+// 获取源表字段 crc32.
+// 表结构来自 schema 文件时无法计算字段属性的 crc32, 只能通过字段名比较:
+// 目标表存在的字段使用目标表的 crc32(不需要 modify), 不存在的字段需要 add
+func oriColumnCRC32(oriDao dao.MetaDao, sName, tName string, stdColumnCRC32Map map[string]int64) (map[string]int64, error) {
+	if defaultDao, ok := oriDao.(*dao.DefaultDao); ok {
+		return defaultDao.ColumnCRC32(sName, tName)
+	}
+
+	columns, err := oriDao.FindTableColumns(sName, tName)
+	if err != nil {
+		return nil, err
+	}
+	columnCRC32Map := make(map[string]int64)
+	for _, column := range columns {
+		columnCRC32Map[column.ColumnName] = stdColumnCRC32Map[column.ColumnName]
+	}
+
+	return columnCRC32Map, nil
+}
+
 // 比较字段crc32
-func compareColumn(oriColumnMap, stdColumnMap map[string]int64) (map[string]bool, map[string]bool, error) {
+func compareColumn(
+	oriColumnMap, stdColumnMap map[string]int64,
+	allowExtraColumns bool,
+) (map[string]bool, map[string]bool, error) {
+	// 审计字段是目标表特有的, 不参与比较
+	stdColumnCnt := 0
+	for cName := range stdColumnMap {
+		if !schema.IsAuditColumn(cName) {
+			stdColumnCnt++
+		}
+	}
+
 	// 比较源表和目标表字段个数
-	if len(oriColumnMap) < len(stdColumnMap) { // 目标表字段 多余 源表字段
+	if !allowExtraColumns && len(oriColumnMap) < stdColumnCnt { // 目标表字段 多余 源表字段
 		return nil, nil, fmt.Errorf("获取目标表字段 多余 源表字段. 请确认是否需要删除目标表字段")
 	}
 
@@ -247,6 +570,13 @@ func compareColumn(oriColumnMap, stdColumnMap map[string]int64) (map[string]bool
 	// 循环目标表比较在目标有, 源表没有的
 	for cName, _ := range stdColumnMap {
 		if _, ok := oriColumnMap[cName]; !ok {
+			if schema.IsAuditColumn(cName) {
+				continue
+			}
+			if allowExtraColumns {
+				seelog.Warnf("字段:%s目标表中有, 源表中没有, 保留目标表中的该字段", cName)
+				continue
+			}
 			return nil, nil, fmt.Errorf("字段:%s目标表中有, 源表中没有, 请确认目标表是否需要删除该字段", cName)
 		}
 	}
@@ -256,7 +586,7 @@ func compareColumn(oriColumnMap, stdColumnMap map[string]int64) (map[string]bool
 
 // 过滤获取添加字段语句
 func filterAddColumnSqls(createTableSQL, sName, tName string, needAddColumns map[string]bool, cnt int) []string {
-	addColumnSQLs := make([]string, len(needAddColumns))
+	addColumnSQLs := make([]string, 0, len(needAddColumns))
 	if len(needAddColumns) == 0 {
 		return addColumnSQLs
 	}
@@ -266,7 +596,7 @@ func filterAddColumnSqls(createTableSQL, sName, tName string, needAddColumns map
 	for i := 1; i <= cnt; i++ {
 		cName := strings.Split(items[i], "`")[1]
 		if _, ok := needAddColumns[cName]; ok {
-			addSQL := fmt.Sprintf("ALTERT TABLE `%s`.`%s` ADD COLUMN %s", sName, tName, items[i])
+			addSQL := fmt.Sprintf("ALTER TABLE `%s`.`%s` ADD COLUMN %s", sName, tName, columnDefinition(items[i]))
 			addColumnSQLs = append(addColumnSQLs, addSQL)
 		}
 	}
@@ -275,7 +605,7 @@ func filterAddColumnSqls(createTableSQL, sName, tName string, needAddColumns map
 }
 
 func filterModifyColumnSqls(createTableSQL, sName, tName string, needModifyColumns map[string]bool, cnt int) []string {
-	modifyColumnSQLs := make([]string, len(needModifyColumns))
+	modifyColumnSQLs := make([]string, 0, len(needModifyColumns))
 	if len(needModifyColumns) == 0 {
 		return modifyColumnSQLs
 	}
@@ -285,10 +615,15 @@ func filterModifyColumnSqls(createTableSQL, sName, tName string, needModifyColum
 	for i := 1; i <= cnt; i++ {
 		cName := strings.Split(items[i], "`")[1]
 		if _, ok := needModifyColumns[cName]; ok {
-			addSQL := fmt.Sprintf("ALTERT TABLE `%s`.`%s` MODIFY %s", sName, tName, items[i])
+			addSQL := fmt.Sprintf("ALTER TABLE `%s`.`%s` MODIFY %s", sName, tName, columnDefinition(items[i]))
 			modifyColumnSQLs = append(modifyColumnSQLs, addSQL)
 		}
 	}
 
 	return modifyColumnSQLs
 }
+
+// 建表语句中的一行字段定义, 去掉结尾的逗号
+func columnDefinition(item string) string {
+	return strings.TrimSuffix(strings.TrimSpace(item), ",")
+}
@@ -0,0 +1,104 @@
+package manal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+)
+
+func TestCompareColumn(t *testing.T) {
+	oriColumnMap := map[string]int64{"id": 1, "name": 2, "age": 3}
+	stdColumnMap := map[string]int64{"id": 1, "name": 4, "_haqi_log_file": 5, "_haqi_op": 6}
+
+	needAddColumns, needModifyColumns, err := compareColumn(oriColumnMap, stdColumnMap, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(needAddColumns) != 1 || !needAddColumns["age"] {
+		t.Fatalf("需要添加的字段: %v, 期望: age", needAddColumns)
+	}
+	if len(needModifyColumns) != 1 || !needModifyColumns["name"] {
+		t.Fatalf("需要修改的字段: %v, 期望: name", needModifyColumns)
+	}
+
+	// 源表删除的字段
+	stdColumnMap["ext"] = 7
+	if _, _, err = compareColumn(oriColumnMap, stdColumnMap, false); err == nil {
+		t.Fatal("目标表中多余的字段没有返回错误")
+	}
+	if _, _, err = compareColumn(oriColumnMap, stdColumnMap, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckResumeLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// 源实例切换后的binlog文件
+	for _, name := range []string{"new-bin.000001", "new-bin.000002"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc := &config.BaseConfig{BinlogDir: dir}
+	cps := []*models.Checkpoint{
+		{WorkerID: 0, LogFile: "mysql-bin.000010", LogPos: 500, GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-10"},
+		{WorkerID: 1, LogFile: "mysql-bin.000010", LogPos: 300},
+	}
+
+	// 按位点继续执行, binlog文件名不一样
+	if err = checkResumeLogFiles(cps, bc, nil); err == nil {
+		t.Fatal("binlog文件名不一样应该不能按位点继续执行")
+	}
+	// 按GTID继续执行, 只检测没有GTID集合的checkpoint
+	if err = checkCheckpointLogFiles(cps[:1], true, []string{"new-bin.000001"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = checkCheckpointLogFiles(cps, true, []string{"new-bin.000001"}); err == nil {
+		t.Fatal("没有GTID集合的checkpoint需要按位点继续执行")
+	}
+	if err = checkCheckpointLogFiles(cps, false, []string{"mysql-bin.000009", "mysql-bin.000010"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewMetaDao_SchemaFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_schema_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "schema.sql")
+	dump := "USE `db1`;\nCREATE TABLE `t1` (\n  `id` int(11) NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB;\n"
+	if err = ioutil.WriteFile(fileName, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := &config.BaseConfig{BinlogDir: dir, SchemaFile: fileName}
+	metaDao, rePairDao, err := newMetaDao(bc, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metaDao != rePairDao {
+		t.Fatal("离线模式检测修复目标表应该使用 schema 文件中的表结构")
+	}
+	columns, err := metaDao.FindTableColumns("db1", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 1 || columns[0].ColumnName != "id" {
+		t.Fatalf("第一个版本的表结构应该使用 schema 文件中的表结构, 实际: %v", columns)
+	}
+
+	bc.SchemaFile = filepath.Join(dir, "not_exists.sql")
+	if _, _, err = newMetaDao(bc, nil, nil); err == nil {
+		t.Fatal("schema 文件不存在应该返回错误")
+	}
+}
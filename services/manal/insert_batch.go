@@ -0,0 +1,148 @@
+package manal
+
+import (
+	"time"
+
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+// 一个语句中占位符的最大个数(prepared statement 的限制)
+const MAX_PLACEHOLDERS = 65535
+
+// 合并的 INSERT/REPLACE 数据. 一个源事务中同一个目标表的多个 row event 合并为多行语句,
+// 达到行数, 大小或者时间限制时生成一个语句执行
+type insertBatch struct {
+	tbl     *schema.Table
+	replace bool
+	ev      *EventData // 最后一个合并的 event, 写入文件时作为位点注释
+	rows    [][]interface{}
+	size    int // 估算的语句大小(字节)
+	start   time.Time
+}
+
+// 再添加一行数据是否会超过限制
+func (this *insertBatch) full(rowSize int, maxRows int, maxBytes int) bool {
+	if len(this.rows) == 0 {
+		return false
+	}
+	if maxRows > 0 && len(this.rows) >= maxRows {
+		return true
+	}
+	if maxBytes > 0 && this.size+rowSize > maxBytes {
+		return true
+	}
+
+	return (len(this.rows)+1)*len(this.rows[0]) > MAX_PLACEHOLDERS
+}
+
+func (this *insertBatch) add(ev *EventData, row []interface{}, rowSize int) {
+	this.ev = ev
+	this.rows = append(this.rows, row)
+	this.size += rowSize
+}
+
+// 估算一行数据在语句中的大小
+func estimateRowSize(row []interface{}) int {
+	size := 2 // (...)
+	for _, value := range row {
+		size += 3 // 引号和逗号
+		switch v := value.(type) {
+		case nil:
+			size += 4
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		default:
+			size += 20
+		}
+	}
+
+	return size
+}
+
+// 将 INSERT/REPLACE 的数据添加到合并的数据中, 超过限制的部分先执行.
+// 同一个表的数据需要按顺序执行, 表结构版本或者语句类型不同时先执行之前合并的数据
+func (this *applyWorker) appendInsert(ev *EventData, rows [][]interface{}, tbl *schema.Table, replace bool) error {
+	tmc := this.comsume.TMC
+	idx := this.findBatch(tbl)
+	if idx >= 0 && (this.batches[idx].tbl != tbl || this.batches[idx].replace != replace) {
+		if err := this.flushBatch(idx); err != nil {
+			return err
+		}
+		idx = -1
+	}
+
+	for _, row := range rows {
+		rowSize := estimateRowSize(row)
+		if idx >= 0 && this.batches[idx].full(rowSize, tmc.InsertBatchRows, tmc.InsertBatchBytes) {
+			if err := this.flushBatch(idx); err != nil {
+				return err
+			}
+			idx = -1
+		}
+		if idx < 0 {
+			this.batches = append(this.batches, &insertBatch{tbl: tbl, replace: replace, start: time.Now()})
+			idx = len(this.batches) - 1
+		}
+		this.batches[idx].add(ev, row, rowSize)
+	}
+
+	// 超过合并的时间限制
+	if idx >= 0 && tmc.InsertBatchInterval > 0 && time.Since(this.batches[idx].start) >= tmc.InsertBatchInterval {
+		return this.flushBatch(idx)
+	}
+
+	return nil
+}
+
+// 表的合并数据的下标, 没有返回 -1
+func (this *applyWorker) findBatch(tbl *schema.Table) int {
+	for i, batch := range this.batches {
+		if batch.tbl.SchemaName == tbl.SchemaName && batch.tbl.TableName == tbl.TableName {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// 执行表的合并数据, 执行 UPDATE/DELETE 之前调用, 保证同一个表的语句按顺序执行
+func (this *applyWorker) flushTable(tbl *schema.Table) error {
+	if idx := this.findBatch(tbl); idx >= 0 {
+		return this.flushBatch(idx)
+	}
+
+	return nil
+}
+
+// 执行合并的数据, 并从合并的数据中删除
+func (this *applyWorker) flushBatch(idx int) error {
+	batch := this.batches[idx]
+	this.batches = append(this.batches[:idx], this.batches[idx+1:]...)
+
+	var sql string
+	var args []interface{}
+	var err error
+	if batch.replace {
+		sql, args, err = batch.tbl.BuildReplaceSQL(batch.rows)
+	} else {
+		sql, args, err = batch.tbl.BuildInsertSQL(batch.rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	return this.execDML(batch.ev, sql, args...)
+}
+
+// 按顺序执行所有合并的数据, 提交事务之前调用
+func (this *applyWorker) flushBatches() error {
+	for len(this.batches) > 0 {
+		if err := this.flushBatch(0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
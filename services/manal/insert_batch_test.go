@@ -0,0 +1,170 @@
+package manal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 记录执行的语句的写入目标
+type recordSink struct {
+	sqls []string
+}
+
+func (this *recordSink) Begin() (SinkTx, error) {
+	return &recordSinkTx{sink: this}, nil
+}
+
+func (this *recordSink) ExecDDL(ev *EventData, sql string) error {
+	return nil
+}
+
+func (this *recordSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *recordSink) Close() error {
+	return nil
+}
+
+type recordSinkTx struct {
+	sink *recordSink
+	sqls []string
+}
+
+func (this *recordSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	this.sqls = append(this.sqls, sql)
+	return nil
+}
+
+func (this *recordSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *recordSinkTx) Commit() error {
+	this.sink.sqls = append(this.sink.sqls, this.sqls...)
+	return nil
+}
+
+func (this *recordSinkTx) Rollback() error {
+	return nil
+}
+
+func newInsertBatchTestWorker(tmc *config.ToMySQLConfig) (*applyWorker, *recordSink) {
+	sink := new(recordSink)
+	mComsume := NewMComsume(tmc, nil)
+	mComsume.sink = sink
+
+	return mComsume.workers[0], sink
+}
+
+// 将 row event 交给应用线程应用, 事务结束后提交
+func applyInsertBatchTestJobs(t *testing.T, worker *applyWorker, jobs ...*applyJob) {
+	for _, job := range jobs {
+		worker.jobChan <- job
+	}
+	worker.jobChan <- &applyJob{EventData: &EventData{
+		LogFile:     "mysql-bin.000001",
+		LogPos:      2048,
+		BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{}, Event: &replication.XIDEvent{}},
+	}}
+	close(worker.jobChan)
+	if err := worker.applyJobs(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newInsertBatchTestJob(tbl *schema.Table, eventType replication.EventType, ids ...int64) *applyJob {
+	job := &applyJob{
+		EventData: &EventData{
+			LogFile:     "mysql-bin.000001",
+			LogPos:      1024,
+			BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{EventType: eventType}},
+		},
+		Table: tbl,
+	}
+	for _, id := range ids {
+		job.Rows = append(job.Rows, []interface{}{id, "name"})
+	}
+
+	return job
+}
+
+func TestApplyWorker_InsertBatch(t *testing.T) {
+	worker, sink := newInsertBatchTestWorker(&config.ToMySQLConfig{
+		InsertMode:      config.INSERT_MODE_INSERT,
+		DeleteMode:      config.DELETE_MODE_ARCHIVE,
+		InsertBatchRows: 3,
+	})
+	t1, t2 := newTestTable("t1", "id", "name"), newTestTable("t2", "id", "name")
+
+	applyInsertBatchTestJobs(t, worker,
+		newInsertBatchTestJob(t1, replication.WRITE_ROWS_EVENTv2, 1, 2),
+		newInsertBatchTestJob(t2, replication.WRITE_ROWS_EVENTv2, 1),
+		newInsertBatchTestJob(t1, replication.DELETE_ROWS_EVENTv2, 3, 4, 5, 6, 7), // 归档的 delete 和 insert 合并
+	)
+
+	// t1: 7行按每个语句3行拆分, t2: 1行. 提交时按表第一次出现的顺序执行
+	if len(sink.sqls) != 4 {
+		t.Fatalf("执行的语句: %v", sink.sqls)
+	}
+	for i, expect := range []struct {
+		table string
+		rows  int
+	}{{"t1", 3}, {"t1", 3}, {"t2", 1}, {"t1", 1}} {
+		if !strings.Contains(sink.sqls[i], "`"+expect.table+"`") || strings.Count(sink.sqls[i], "(?, ?)") != expect.rows {
+			t.Fatalf("第 %d 个语句: %s, 期望表: %s, 行数: %d", i, sink.sqls[i], expect.table, expect.rows)
+		}
+	}
+}
+
+func TestApplyWorker_InsertBatchOrder(t *testing.T) {
+	worker, sink := newInsertBatchTestWorker(&config.ToMySQLConfig{
+		InsertMode:       config.INSERT_MODE_INSERT,
+		DeleteMode:       config.DELETE_MODE_DELETE,
+		InsertBatchBytes: 64,
+	})
+	t1 := newTestTable("t1", "id", "name")
+
+	applyInsertBatchTestJobs(t, worker,
+		newInsertBatchTestJob(t1, replication.WRITE_ROWS_EVENTv2, 1, 2, 3),
+		newInsertBatchTestJob(t1, replication.DELETE_ROWS_EVENTv2, 1), // 先执行之前合并的 insert
+		newInsertBatchTestJob(t1, replication.WRITE_ROWS_EVENTv2, 4),
+	)
+
+	// 每行估算大小为 32 字节, 64 字节最多合并2行
+	expects := []string{"INSERT", "INSERT", "DELETE", "INSERT"}
+	if len(sink.sqls) != len(expects) {
+		t.Fatalf("执行的语句: %v", sink.sqls)
+	}
+	for i, expect := range expects {
+		if !strings.HasPrefix(sink.sqls[i], expect) {
+			t.Fatalf("第 %d 个语句: %s, 期望: %s", i, sink.sqls[i], expect)
+		}
+	}
+}
+
+func TestApplyWorker_InsertBatchInterval(t *testing.T) {
+	worker, sink := newInsertBatchTestWorker(&config.ToMySQLConfig{
+		InsertMode:          config.INSERT_MODE_INSERT,
+		InsertBatchInterval: time.Nanosecond,
+	})
+	t1 := newTestTable("t1", "id", "name")
+	if err := worker.begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过等待时间, 添加后马上执行
+	job := newInsertBatchTestJob(t1, replication.WRITE_ROWS_EVENTv2, 1)
+	if err := worker.applyInsert(job.EventData, job.Rows, t1); err != nil {
+		t.Fatal(err)
+	}
+	if len(worker.batches) != 0 || len(worker.tx.(*recordSinkTx).sqls) != 1 || len(sink.sqls) != 0 {
+		t.Fatalf("合并的数据: %d, 执行的语句: %v", len(worker.batches), worker.tx.(*recordSinkTx).sqls)
+	}
+}
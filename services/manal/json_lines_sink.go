@@ -0,0 +1,186 @@
+package manal
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/daiguadaidai/haqi/utils"
+)
+
+// 每一行数据的修改输出为一个 JSON 对象, 一行一个
+type jsonLinesSink struct {
+	sync.Mutex
+	file *rotateFile
+}
+
+// 输出的 JSON 对象
+type jsonRowChange struct {
+	Schema    string     `json:"schema"`
+	Table     string     `json:"table"`
+	Op        string     `json:"op"`
+	Before    *jsonImage `json:"before,omitempty"`
+	After     *jsonImage `json:"after,omitempty"`
+	Query     string     `json:"query,omitempty"` // op 为 ddl 时的DDL语句
+	LogFile   string     `json:"log_file"`
+	LogPos    uint32     `json:"log_pos"`
+	EventTime string     `json:"event_time"`
+	GTID      string     `json:"gtid,omitempty"`
+	ThreadID  uint32     `json:"thread_id,omitempty"`
+}
+
+// 按字段顺序输出的一行数据, key 为字段名
+type jsonImage struct {
+	names  []string
+	values []interface{}
+}
+
+func (this *jsonImage) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range this.names {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(this.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func newJSONLinesSink(tmc *config.ToMySQLConfig) *jsonLinesSink {
+	return &jsonLinesSink{
+		file: &rotateFile{
+			Dir:      tmc.OutputDir,
+			Prefix:   tmc.OutputPrefix,
+			Ext:      ".jsonl",
+			MaxSize:  tmc.OutputMaxSize * 1024 * 1024,
+			Interval: tmc.OutputRotateInterval,
+		},
+	}
+}
+
+func (this *jsonLinesSink) Begin() (SinkTx, error) {
+	return &jsonLinesSinkTx{sink: this}, nil
+}
+
+// DDL 输出为 op 为 ddl 的对象
+func (this *jsonLinesSink) ExecDDL(ev *EventData, sql string) error {
+	change := newJSONRowChange(ev, nil, "ddl")
+	change.Query = sql
+
+	var buf bytes.Buffer
+	if err := writeJSONLine(&buf, change); err != nil {
+		return err
+	}
+	return this.write(buf.Bytes())
+}
+
+func (this *jsonLinesSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *jsonLinesSink) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.file.Close()
+}
+
+func (this *jsonLinesSink) write(p []byte) error {
+	this.Lock()
+	defer this.Unlock()
+
+	_, err := this.file.Write(p)
+	return err
+}
+
+// 文件中的事务, 提交时一次写入文件
+type jsonLinesSinkTx struct {
+	sink *jsonLinesSink
+	buf  bytes.Buffer
+}
+
+func (this *jsonLinesSinkTx) WriteRows(ev *EventData, tbl *schema.Table, op string, rows [][]interface{}) error {
+	changes, err := newRowChanges(tbl, op, rows)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		jsonChange := newJSONRowChange(ev, tbl, op)
+		if change.Before != nil {
+			jsonChange.Before = &jsonImage{names: tbl.ColumnNames, values: change.Before}
+		}
+		if change.After != nil {
+			jsonChange.After = &jsonImage{names: tbl.ColumnNames, values: change.After}
+		}
+		if err = writeJSONLine(&this.buf, jsonChange); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (this *jsonLinesSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	return errRowSinkExec(config.SINK_JSON_LINES)
+}
+
+func (this *jsonLinesSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return nil
+}
+
+func (this *jsonLinesSinkTx) Commit() error {
+	err := this.sink.write(this.buf.Bytes())
+	this.buf.Reset()
+
+	return err
+}
+
+func (this *jsonLinesSinkTx) Rollback() error {
+	this.buf.Reset()
+	return nil
+}
+
+func newJSONRowChange(ev *EventData, tbl *schema.Table, op string) *jsonRowChange {
+	change := &jsonRowChange{
+		Op:        op,
+		LogFile:   ev.LogFile,
+		LogPos:    ev.LogPos,
+		EventTime: utils.TS2String(int64(ev.BinlogEvent.Header.Timestamp), utils.TIME_FORMAT),
+		GTID:      ev.GTID,
+		ThreadID:  ev.ThreadID,
+	}
+	if tbl != nil {
+		change.Schema = tbl.SchemaName
+		change.Table = tbl.TableName
+	}
+
+	return change
+}
+
+func writeJSONLine(buf *bytes.Buffer, change *jsonRowChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+
+	return nil
+}
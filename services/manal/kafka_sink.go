@@ -0,0 +1,185 @@
+package manal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/kafka"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+// 每一行数据的修改作为一条消息发送到 kafka. topic 为 <prefix><schema>.<table>,
+// key 为主键值(JSON 对象), value 和 jsonl 输出的 JSON 对象相同.
+// 一个事务中的消息在提交时同步发送, 所有消息都被确认后才在目标实例中保存checkpoint,
+// 发送成功但是保存checkpoint失败时重新执行会重复发送(at-least-once)
+type kafkaSink struct {
+	sync.Mutex
+	tmc      *config.ToMySQLConfig
+	tdbc     *config.DBConfig
+	producer *kafka.Producer
+}
+
+func newKafkaSink(tmc *config.ToMySQLConfig, tdbc *config.DBConfig) *kafkaSink {
+	return &kafkaSink{tmc: tmc, tdbc: tdbc}
+}
+
+func (this *kafkaSink) Begin() (SinkTx, error) {
+	return &kafkaSinkTx{sink: this}, nil
+}
+
+// DDL 发送到 <prefix>ddl, op 为 ddl
+func (this *kafkaSink) ExecDDL(ev *EventData, sql string) error {
+	change := newJSONRowChange(ev, nil, "ddl")
+	change.Query = sql
+	value, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	return this.send([]*kafka.Message{{
+		Topic:     this.tmc.KafkaTopicPrefix + "ddl",
+		Value:     value,
+		Timestamp: eventTimeMillis(ev),
+	}})
+}
+
+func (this *kafkaSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	defaultDao, err := dao.NewDefaultDao(this.tdbc)
+	if err != nil {
+		return err
+	}
+
+	return defaultDao.SaveCheckpoint(sName, cp)
+}
+
+func (this *kafkaSink) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.producer == nil {
+		return nil
+	}
+	return this.producer.Close()
+}
+
+// 同步发送消息, 第一次发送时创建生产者
+func (this *kafkaSink) send(msgs []*kafka.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	this.Lock()
+	if this.producer == nil {
+		producer, err := kafka.NewProducer(this.tmc.KafkaBrokers, nil)
+		if err != nil {
+			this.Unlock()
+			return err
+		}
+		this.producer = producer
+	}
+	producer := this.producer
+	this.Unlock()
+
+	if err := producer.Send(msgs); err != nil {
+		return fmt.Errorf("发送消息到kafka失败. %v", err)
+	}
+
+	return nil
+}
+
+// kafka 中的事务, 提交时发送所有消息
+type kafkaSinkTx struct {
+	sink     *kafkaSink
+	msgs     []*kafka.Message
+	cpSchema string
+	cp       *models.Checkpoint
+}
+
+func (this *kafkaSinkTx) WriteRows(ev *EventData, tbl *schema.Table, op string, rows [][]interface{}) error {
+	changes, err := newRowChanges(tbl, op, rows)
+	if err != nil {
+		return err
+	}
+
+	topic := fmt.Sprintf("%s%s.%s", this.sink.tmc.KafkaTopicPrefix, tbl.SchemaName, tbl.TableName)
+	for _, change := range changes {
+		jsonChange := newJSONRowChange(ev, tbl, op)
+		image := change.After // 通过修改后的主键值分区, update 修改了主键时数据在新的主键值对应的分区中
+		if change.Before != nil {
+			jsonChange.Before = &jsonImage{names: tbl.ColumnNames, values: change.Before}
+		}
+		if change.After != nil {
+			jsonChange.After = &jsonImage{names: tbl.ColumnNames, values: change.After}
+		} else {
+			image = change.Before
+		}
+
+		key, err := json.Marshal(pkImage(tbl, image))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(jsonChange)
+		if err != nil {
+			return err
+		}
+		this.msgs = append(this.msgs, &kafka.Message{
+			Topic:     topic,
+			Key:       key,
+			Value:     value,
+			Timestamp: eventTimeMillis(ev),
+		})
+	}
+
+	return nil
+}
+
+func (this *kafkaSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	return errRowSinkExec(config.SINK_KAFKA)
+}
+
+// 记录需要保存的checkpoint, 消息发送成功后保存
+func (this *kafkaSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	this.cpSchema, this.cp = sName, cp
+	return nil
+}
+
+func (this *kafkaSinkTx) Commit() error {
+	msgs, cp := this.msgs, this.cp
+	this.msgs, this.cp = nil, nil
+
+	if err := this.sink.send(msgs); err != nil {
+		return err
+	}
+	if cp != nil {
+		return this.sink.SaveCheckpoint(this.cpSchema, cp)
+	}
+
+	return nil
+}
+
+func (this *kafkaSinkTx) Rollback() error {
+	this.msgs, this.cp = nil, nil
+	return nil
+}
+
+// 一行数据中的主键字段
+func pkImage(tbl *schema.Table, values []interface{}) *jsonImage {
+	image := &jsonImage{
+		names:  tbl.PKColumnNames,
+		values: make([]interface{}, len(tbl.PKColumnNames)),
+	}
+	for i, name := range tbl.PKColumnNames {
+		image.values[i] = values[tbl.ColumnPos[name]]
+	}
+
+	return image
+}
+
+// event 的时间(毫秒)
+func eventTimeMillis(ev *EventData) int64 {
+	return int64(ev.BinlogEvent.Header.Timestamp) * 1000
+}
@@ -0,0 +1,71 @@
+package manal
+
+import (
+	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/kafka"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestKafkaSink(t *testing.T) {
+	broker, err := kafka.NewBroker("127.0.0.1:0", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	tmc := &config.ToMySQLConfig{
+		Sink:             config.SINK_KAFKA,
+		KafkaBrokers:     []string{broker.Addr()},
+		KafkaTopicPrefix: config.DEFAULT_KAFKA_TOPIC_PREFIX,
+	}
+	sink := NewSink(tmc, nil)
+	defer sink.Close()
+
+	tbl := newTestTable("t1", "id", "name")
+	writeRowSinkTestRows(t, sink, tbl, schema.AUDIT_OP_INSERT,
+		[]interface{}{int32(1), "a"}, []interface{}{int32(2), "b"})
+	writeRowSinkTestRows(t, sink, tbl, schema.AUDIT_OP_DELETE, []interface{}{int32(1), "a"})
+
+	// 回滚的事务不发送
+	tx, err := sink.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &EventData{BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{}}}
+	if err = tx.(RowSinkTx).WriteRows(ev, tbl, schema.AUDIT_OP_INSERT, [][]interface{}{{int32(3), "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := broker.Messages("haqi.db1.t1")
+	if len(msgs) != 3 {
+		t.Fatalf("broker 中消息数量: %d, 期望: 3", len(msgs))
+	}
+	ops := make(map[string][]string)
+	for _, msg := range msgs {
+		if msg.Timestamp != 1547823395000 {
+			t.Fatalf("消息时间: %d, 期望: 1547823395000", msg.Timestamp)
+		}
+		ops[string(msg.Key)] = append(ops[string(msg.Key)], string(msg.Value))
+	}
+	if len(ops[`{"id":1}`]) != 2 || len(ops[`{"id":2}`]) != 1 {
+		t.Fatalf("消息key: %v, 期望: {\"id\":1} 2条, {\"id\":2} 1条", ops)
+	}
+	expect := `{"schema":"db1","table":"t1","op":"delete","before":{"id":1,"name":"a"},`
+	if v := ops[`{"id":1}`][1]; len(v) < len(expect) || v[:len(expect)] != expect {
+		t.Fatalf("消息内容: %s, 期望前缀: %s", v, expect)
+	}
+
+	// 发送失败提交返回错误
+	broker.FailProduce(-1)
+	tx, _ = sink.Begin()
+	tx.(RowSinkTx).WriteRows(ev, tbl, schema.AUDIT_OP_INSERT, [][]interface{}{{int32(4), "d"}})
+	if err = tx.Commit(); err == nil {
+		t.Fatal("发送失败提交事务应该返回错误")
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"github.com/cihub/seelog"
 	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
 	"github.com/daiguadaidai/haqi/models"
 	"github.com/daiguadaidai/haqi/schema"
 	"github.com/daiguadaidai/haqi/services/types"
+	"github.com/daiguadaidai/haqi/utils"
 	"github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,6 +29,10 @@ const (
 type EventData struct {
 	LogFile     string
 	LogPos      uint32
+	GTID        string        // event 所在事务的GTID, 没有开启GTID为空
+	ThreadID    uint32        // 产生 event 的线程ID
+	Table       *schema.Table // row event 对应的表结构版本(event 所在位点有效的表结构)
+	DDLs        []*schema.DDL // QueryEvent 中需要应用到目标实例的DDL
 	BinlogEvent *replication.BinlogEvent
 }
 
@@ -46,13 +54,66 @@ type Manal struct {
 	CurrentPosition *models.Position
 	CurrentThreadID uint32
 	TransTableMap   map[string]*schema.Table
+	RowFilters      map[string]*schema.RowFilter        // 每个表的行过滤条件
+	Router          *schema.TableRouter                 // 目标表名路由
+	Projections     map[string]*schema.ColumnProjection // 每个表的字段处理规则
 	TransType
-	MComsume *MComsume
+	Comsumer    Comsumer
+	MetaDao     dao.MetaDao          // 获取开始位点时源表结构的数据源, 作为表结构的第一个版本
+	RePairDao   dao.MetaDao          // 检测和修复目标表时使用的源表结构数据源
+	BinlogFiles []string             // 离线模式需要解析的本地binlog文件
+	RePairTable bool                 // 是否需要检测和修复目标表
+	StartTime   time.Time            // 开始时间, 早于该时间的event不需要执行
+	Checkpoints []*models.Checkpoint // 从checkpoint继续执行时, 上次执行保存的checkpoint
+	GTIDState
+	gate          pauseGate  // 暂停解析binlog
+	throttler     *throttler // 应用数据的限流, 用于获取限流状态
+	EnableMetrics bool       // 是否更新队列长度和延迟监控指标, 指定了 metrics-addr 或者在 HTTP 控制服务中执行时更新
+	startedAt     time.Time  // 任务开始执行的时间, 还没有解析或应用任何 event 时从该时间开始计算延迟
 }
 
 func NewManal(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (*Manal, error) {
+	manal, err := newManal(tmc, odbc, tdbc, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// 创建保存checkpoint的表
+	if tmc.EnableCheckpoint() {
+		defaultDao, err := dao.NewDefaultDao(tdbc)
+		if err != nil {
+			return nil, err
+		}
+		if err = defaultDao.CreateCheckpointTable(tmc.CheckpointSchema); err != nil {
+			return nil, fmt.Errorf("创建checkpoint表失败. %v", err)
+		}
+	}
+
+	// 设置消费者信息
+	mComsume := NewMComsume(tmc, tdbc)
+	mComsume.router = manal.Router
+	mComsume.projections = manal.Projections
+	mComsume.EventChan = manal.EventChan
+	mComsume.initGTIDSet(manal.ParsedGTIDSet)
+	if err = mComsume.initCheckpoints(manal.Checkpoints); err != nil {
+		return nil, err
+	}
+	manal.Comsumer = mComsume
+	manal.throttler = mComsume.throttler
+
+	return manal, nil
+}
+
+func newManal(
+	tmc *config.ToMySQLConfig,
+	odbc *config.DBConfig,
+	tdbc *config.DBConfig,
+	rePairTable bool,
+) (*Manal, error) {
 	var err error
 	manal := new(Manal)
+	manal.RePairTable = rePairTable
+	manal.EnableMetrics = len(tmc.MetricsAddr) != 0
 	// 设置配置文件
 	manal.TMC = tmc
 	manal.ODBC = odbc // 源数据库配置信息
@@ -64,16 +125,48 @@ func NewManal(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBC
 	manal.CurrentPosition = new(models.Position)
 	manal.EventChan = make(chan *EventData, 1000)
 	manal.TransTableMap = make(map[string]*schema.Table)
+	if manal.RowFilters, err = parseRowFilters(tmc.RowFilters); err != nil {
+		return nil, err
+	}
+	if manal.Router, err = schema.NewTableRouter(tmc.SchemaSuffix, tmc.TableRoutes); err != nil {
+		return nil, err
+	}
+	if manal.Projections, err = parseColumnRules(tmc.ColumnRules, tmc.GetHashKey()); err != nil {
+		return nil, err
+	}
+	// 从上次应用完成的checkpoint继续执行
+	if tmc.Resume {
+		if manal.Checkpoints, err = ResumeFromCheckpoint(tmc, tdbc); err != nil {
+			return nil, err
+		}
+	}
 	// 开始位点
 	manal.StartPosition, err = GetStartPosition(&tmc.BaseConfig, odbc)
 	if err != nil {
 		return nil, err
 	}
+	if err = checkResumeLogFiles(manal.Checkpoints, &tmc.BaseConfig, odbc); err != nil {
+		return nil, err
+	}
+	if tmc.HaveStartTimeInfo() {
+		if manal.StartTime, err = tmc.StartTime(); err != nil {
+			return nil, err
+		}
+	}
 	// 获取结束位点
 	manal.EndPosition = GetEndPosition(&tmc.BaseConfig)
+	// 初始化GTID信息
+	if err = manal.initGTIDState(); err != nil {
+		return nil, err
+	}
+
+	// 获取表结构数据源
+	if manal.MetaDao, manal.RePairDao, err = newMetaDao(&tmc.BaseConfig, odbc, tdbc); err != nil {
+		return nil, err
+	}
 
 	// 获取需要执行的表
-	transTables, transType, err := FindTransTables(&tmc.BaseConfig, odbc)
+	transTables, transType, err := FindTransTables(&tmc.BaseConfig, manal.MetaDao)
 	if err != nil {
 		return nil, err
 	}
@@ -86,43 +179,155 @@ func NewManal(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBC
 		}
 	}
 
-	// 设置消费者信息
-	manal.MComsume = NewMComsume(tmc, tdbc)
-	manal.MComsume.EventChan = manal.EventChan
-	manal.MComsume.TransTableMap = manal.TransTableMap
+	// 行过滤条件中的表需要执行, 字段需要在表中存在
+	if err = manal.checkRowFilterTables(); err != nil {
+		return nil, err
+	}
+
+	if tmc.IsOffline() { // 离线模式, 获取需要解析的本地binlog文件
+		if manal.BinlogFiles, err = manal.findOfflineBinlogFiles(); err != nil {
+			return nil, err
+		}
+		return manal, nil
+	}
 
 	// 设置获取 sync
-	cfg := odbc.GetSyncerConfig()
+	cfg, err := odbc.GetSyncerConfig()
+	if err != nil {
+		return nil, err
+	}
 	manal.Syncer = replication.NewBinlogSyncer(cfg)
 
 	return manal, nil
 }
 
+// 获取表结构数据源. 返回解析binlog使用的第一个版本的表结构和检测修复目标表使用的表结构.
+// 指定了 schema 文件时第一个版本使用 schema 文件, 需要是开始位点时的表结构.
+// 在线模式: 没有指定 schema 文件使用源实例当前的表结构, 检测修复目标表使用源实例当前的表结构.
+// 离线模式: 没有指定 schema 文件使用目标实例中同名的表
+func newMetaDao(bc *config.BaseConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (dao.MetaDao, dao.MetaDao, error) {
+	var fileDao dao.MetaDao
+	if bc.SchemaFile != "" {
+		dumpDao, err := dao.NewDumpDao(bc.SchemaFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileDao = dumpDao
+	}
+
+	if !bc.IsOffline() {
+		oriDao, err := dao.NewDefaultDao(odbc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if fileDao != nil {
+			return fileDao, oriDao, nil
+		}
+		seelog.Warnf("在线模式没有指定 schema-file, 使用源实例 %s 当前的表结构作为开始位点的表结构. "+
+			"开始位点之后执行过DDL时解析会失败, 需要使用 mysqldump --no-data 导出开始位点时的表结构并指定 --schema-file",
+			odbc.Addr())
+		return oriDao, oriDao, nil
+	}
+
+	if fileDao != nil {
+		return fileDao, fileDao, nil
+	}
+	seelog.Warnf("离线模式没有指定 schema-file, 从目标实例 %s 中获取和源表同名的表结构. "+
+		"目标实例中的表结构和binlog中的数据不一致时解析会失败或写入错误的字段, 建议使用 mysqldump --no-data 导出源表结构并指定 --schema-file",
+		tdbc.Addr())
+	tarDao, err := dao.NewDefaultDao(tdbc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tarDao, tarDao, nil
+}
+
+// 获取离线模式需要解析的binlog文件, 在开始位点和结束位点范围内的文件
+func (this *Manal) findOfflineBinlogFiles() ([]string, error) {
+	files, err := FindLocalBinlogFiles(&this.TMC.BaseConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	binlogFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		name := filepath.Base(file)
+		if models.CompareLogFile(name, this.StartPosition.File) < 0 {
+			continue
+		}
+		if len(this.EndPosition.File) != 0 && models.CompareLogFile(name, this.EndPosition.File) > 0 {
+			break
+		}
+		binlogFiles = append(binlogFiles, file)
+	}
+
+	return binlogFiles, nil
+}
+
 // 保存需要进行rollback的表
 func (this *Manal) cacheTransTable(sName string, tName string) error {
 	// 比较和修复目标表结构
-	if err := CompareAndRePairTable(this.ODBC, this.TDBC, sName, this.TMC.SchemaSuffix, tName); err != nil {
-		return err
+	if this.RePairTable {
+		if err := CompareAndRePairTable(this.RePairDao, this.TDBC, sName, tName, this.Router,
+			this.rePairOption()); err != nil {
+			return err
+		}
 	}
 
 	// 获取表信息
 	key := fmt.Sprintf("%s.%s", sName, tName)
-	t, err := schema.NewTable(sName, this.TMC.SchemaSuffix, tName, this.ODBC.Host, this.ODBC.Port)
+	t, err := schema.NewTableByDao(sName, this.Router, tName, this.MetaDao)
 	if err != nil {
 		return err
 	}
+	// 检测行过滤条件中的字段
+	if err = this.checkRowFilter(t); err != nil {
+		return err
+	}
+	// 检测字段处理规则中的字段
+	if projection, ok := this.Projections[key]; ok {
+		if _, err = projection.Project(t); err != nil {
+			return err
+		}
+	}
 
 	this.TransTableMap[key] = t
 
 	return nil
 }
 
+// 修复目标表的选项
+func (this *Manal) rePairOption() *RePairOption {
+	return &RePairOption{
+		AllowExtraColumns: this.TMC.KeepDroppedColumns(),
+		AuditColumns:      this.TMC.AuditColumns,
+		DryRun:            this.TMC.DryRun,
+		Task:              this.TMC.TaskLabel(),
+		Projections:       this.Projections,
+	}
+}
+
 func (this *Manal) emit() error {
+	if this.TMC.IsOffline() {
+		return this.emitFromFiles()
+	}
+
+	return this.emitFromSyncer()
+}
+
+// 连接源实例(作为从库)获取binlog event
+func (this *Manal) emitFromSyncer() error {
 	defer this.stopProduct()
 	defer this.Syncer.Close()
 
-	pos := mysql.Position{this.StartPosition.File, this.StartPosition.Position}
-	streamer, err := this.Syncer.StartSync(pos)
+	var streamer *replication.BinlogStreamer
+	var err error
+	if this.StartGTIDSet != nil { // 使用GTID开始同步
+		streamer, err = this.Syncer.StartSyncGTID(this.StartGTIDSet.Clone())
+	} else {
+		pos := mysql.Position{Name: this.StartPosition.File, Pos: this.StartPosition.Position}
+		streamer, err = this.Syncer.StartSync(pos)
+	}
 	if err != nil {
 		return err
 	}
@@ -132,8 +337,12 @@ func (this *Manal) emit() error {
 			seelog.Info("终止发射binlog event")
 			return nil
 		default:
-			ev, err := streamer.GetEvent(context.Background())
+			ev, err := streamer.GetEvent(this.ctx)
 			if err != nil {
+				if this.ctx.Err() != nil { // 任务停止
+					seelog.Info("终止发射binlog event")
+					return nil
+				}
 				return err
 			}
 			if isStop, err := this.handleEvent(ev); err != nil {
@@ -143,34 +352,101 @@ func (this *Manal) emit() error {
 			}
 		}
 	}
+}
+
+// 解析本地binlog文件的parser, 和 syncer 一样 decimal 解析为 decimal.Decimal, 防止丢失精度
+func newBinlogParser() *replication.BinlogParser {
+	parser := replication.NewBinlogParser()
+	parser.SetUseDecimal(true)
+	return parser
+}
+
+// 离线模式, 读取本地的binlog文件获取binlog event
+func (this *Manal) emitFromFiles() error {
+	defer this.stopProduct()
+
+	parser := newBinlogParser()
+	for i, file := range this.BinlogFiles {
+		var offset int64
+		if i == 0 {
+			offset = int64(this.StartPosition.Position)
+		}
+		this.Lock()
+		this.CurrentPosition.File = filepath.Base(file)
+		this.CurrentPosition.Position = uint32(offset)
+		this.Unlock()
+		seelog.Infof("开始解析本地binlog文件: %s, 开始位点: %d", file, offset)
+
+		isStop := false
+		err := parser.ParseFile(file, offset, func(ev *replication.BinlogEvent) error {
+			select {
+			case <-this.ctx.Done():
+				isStop = true
+			default:
+				stop, err := this.handleEvent(ev)
+				if err != nil {
+					return err
+				}
+				isStop = stop
+			}
+			if isStop {
+				parser.Stop() // 停止解析当前文件
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("解析本地binlog文件 %s 出错. %v", file, err)
+		}
+		if isStop {
+			return nil
+		}
+	}
 
+	this.ProductSuccess = true // 所有文件解析完成
+	seelog.Info("本地binlog文件解析完成")
 	return nil
 }
 
 // 处理binlog事件
 func (this *Manal) handleEvent(ev *replication.BinlogEvent) (bool, error) {
-	this.CurrentPosition.Position = ev.Header.LogPos // 设置当前位点
+	if this.waitResume() { // 暂停中任务停止
+		return true, nil
+	}
 
-	// 判断是否到达了结束位点
-	if ok := this.rlEndPos(); ok {
-		seelog.Infof("解析的位点 %s 已经超过执行的位点 %s",
-			this.CurrentPosition.String(), this.EndPosition.String())
+	// 设置当前位点, 判断是否超过了结束时间或者结束位点
+	if this.advancePosition(ev) {
 		return true, nil
 	}
 
 	switch e := ev.Event.(type) {
 	case *replication.RotateEvent:
-		this.CurrentPosition.File = string(e.NextLogName)
-		// 判断是否到达了结束位点
-		if ok := this.rlEndPos(); ok {
-			seelog.Infof("(in RotateEvent)解析的位点 %s 已经超过执行的位点 %s",
-				this.CurrentPosition.String(), this.EndPosition.String())
+		if this.rotatePosition(string(e.NextLogName)) {
 			return true, nil
 		}
+	case *replication.GTIDEvent:
+		this.beginGTID(e)
 	case *replication.QueryEvent:
 		this.CurrentThreadID = e.SlaveProxyID
+		// 非事务表的事务以 COMMIT 结束, DDL 语句没有 XIDEvent, 执行完成代表事务结束
+		if isTrxEndQuery(string(e.Query)) {
+			ddls, err := this.handleDDL(e)
+			if err != nil {
+				return true, err
+			}
+			this.produceTrxEndEvent(ev, ddls)
+			if this.commitGTID() {
+				return true, nil
+			}
+		}
+	case *replication.XIDEvent:
+		this.produceTrxEndEvent(ev, nil)
+		if this.commitGTID() {
+			return true, nil
+		}
 	case *replication.TableMapEvent:
-		this.handleMapEvent(e)
+		if err := this.handleMapEvent(e); err != nil {
+			return true, err
+		}
 	case *replication.RowsEvent:
 		if err := this.produceRowEvent(ev); err != nil {
 			return true, err
@@ -180,7 +456,68 @@ func (this *Manal) handleEvent(ev *replication.BinlogEvent) (bool, error) {
 	return false, nil
 }
 
+// 设置当前 event 的位点和时间, 返回是否已经超过了结束时间或者结束位点.
+// 解析到的位点和结束位点会在其他线程(HTTP 控制服务, API)中获取和修改, 需要在锁中修改和比较
+func (this *Manal) advancePosition(ev *replication.BinlogEvent) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	this.CurrentPosition.Position = ev.Header.LogPos // 设置当前位点
+
+	// 设置当前event时间, 伪造的 RotateEvent 和 HeartbeatEvent 没有时间
+	if ev.Header.Timestamp != 0 {
+		this.CurrentPosition.TS = time.Unix(int64(ev.Header.Timestamp), 0)
+	}
+
+	// 判断是否超过了结束时间
+	if ok := this.rlStopTime(); ok {
+		seelog.Infof("解析的event时间 %s 已经超过结束时间 %s",
+			this.CurrentPosition.TS.Format(utils.TIME_FORMAT), this.EndPosition.TS.Format(utils.TIME_FORMAT))
+		return true
+	}
+
+	// 判断是否到达了结束位点
+	if ok := this.rlEndPos(); ok {
+		seelog.Infof("解析的位点 %s 已经超过执行的位点 %s",
+			this.CurrentPosition.String(), this.EndPosition.String())
+		return true
+	}
+
+	return false
+}
+
+// 切换到下一个binlog文件, 返回是否已经超过了结束位点
+func (this *Manal) rotatePosition(nextLogName string) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	this.CurrentPosition.File = nextLogName
+	// 判断是否到达了结束位点
+	if ok := this.rlEndPos(); ok {
+		seelog.Infof("(in RotateEvent)解析的位点 %s 已经超过执行的位点 %s",
+			this.CurrentPosition.String(), this.EndPosition.String())
+		return true
+	}
+
+	return false
+}
+
+// 解析到的位点的副本, 可以在其他线程中获取
+func (this *Manal) parsedPosition() models.Position {
+	this.Lock()
+	defer this.Unlock()
+
+	return *this.CurrentPosition
+}
+
+// 需要在锁中调用
 func (this *Manal) rlEndPos() bool {
+	// 判断解析过的GTID是否已经包含结束GTID集合
+	if this.rlEndGTID() {
+		this.ProductSuccess = true // 代表任务完成
+		return true
+	}
+
 	// 判断是否超过了指定位点
 	if len(this.EndPosition.File) != 0 {
 		if this.EndPosition.LessThan(this.CurrentPosition) {
@@ -192,6 +529,19 @@ func (this *Manal) rlEndPos() bool {
 	return false
 }
 
+// 判断当前event的时间是否超过了结束时间, 需要在锁中调用
+func (this *Manal) rlStopTime() bool {
+	if this.EndPosition.TS.IsZero() || this.CurrentPosition.TS.IsZero() {
+		return false
+	}
+	if this.CurrentPosition.TS.After(this.EndPosition.TS) {
+		this.ProductSuccess = true // 代表任务完成
+		return true
+	}
+
+	return false
+}
+
 // 处理 TableMapEvent
 func (this *Manal) handleMapEvent(ev *replication.TableMapEvent) error {
 	this.CurrentTable.TableSchema = string(ev.Schema)
@@ -210,6 +560,16 @@ func (this *Manal) handleMapEvent(ev *replication.TableMapEvent) error {
 
 // 产生事件
 func (this *Manal) produceRowEvent(ev *replication.BinlogEvent) error {
+	// 事务的GTID已经包含在开始GTID集合中, 不需要执行(离线模式)
+	if this.SkipCurrentTrx {
+		return nil
+	}
+
+	// event 时间早于开始时间, 不需要执行
+	if !this.StartTime.IsZero() && this.CurrentPosition.TS.Before(this.StartTime) {
+		return nil
+	}
+
 	// 判断是否是指定的 thread id
 	if this.TMC.ThreadID != 0 && this.TMC.ThreadID != this.CurrentThreadID {
 		//  没有指定, 指定了 thread id, 但是 event thread id 不等于 指定的 thread id
@@ -249,9 +609,34 @@ func (this *Manal) produceRowEvent(ev *replication.BinlogEvent) error {
 				}
 			}
 		}
+		// 获取和 binlog 中字段一致的表结构版本
+		current := this.TransTableMap[this.CurrentTable.String()]
+		t, err := current.ResolveByTableMap(e.Table)
+		if err != nil {
+			return fmt.Errorf("位点: %s. %v", this.CurrentPosition.String(), err)
+		}
+		if t != current { // 只包含前面字段的版本中可能没有过滤条件中的字段
+			if err = this.checkRowFilter(t); err != nil {
+				return fmt.Errorf("位点: %s. %v", this.CurrentPosition.String(), err)
+			}
+		}
+		// 行过滤, 没有满足条件的行不需要执行
+		if filter, ok := this.RowFilters[this.CurrentTable.String()]; ok {
+			if e.Rows, err = filterRows(filter, t, ev, e.Rows); err != nil {
+				return fmt.Errorf("位点: %s. %v", this.CurrentPosition.String(), err)
+			}
+			if len(e.Rows) == 0 {
+				return nil
+			}
+		}
+		eventsParsedCounter.WithLabelValues(this.TMC.TaskLabel(), this.CurrentTable.TableSchema,
+			this.CurrentTable.TableName, rowEventOp(ev.Header.EventType)).Inc()
 		this.EventChan <- &EventData{
 			LogFile:     this.CurrentPosition.File,
 			LogPos:      this.CurrentPosition.Position,
+			GTID:        this.CurrentGTID,
+			ThreadID:    this.CurrentThreadID,
+			Table:       t,
 			BinlogEvent: ev,
 		}
 	default:
@@ -261,6 +646,61 @@ func (this *Manal) produceRowEvent(ev *replication.BinlogEvent) error {
 	return nil
 }
 
+// 产生事务结束事件, 消费者收到后提交事务并更新应用完成的位点.
+// ddls 为 QueryEvent 中涉及需要执行的表的DDL
+func (this *Manal) produceTrxEndEvent(ev *replication.BinlogEvent, ddls []*schema.DDL) {
+	this.EventChan <- &EventData{
+		LogFile:     this.CurrentPosition.File,
+		LogPos:      this.CurrentPosition.Position,
+		GTID:        this.CurrentGTID,
+		DDLs:        ddls,
+		BinlogEvent: ev,
+	}
+}
+
+// 事务中间的语句, 不代表事务结束
+var trxInnerQueries = [][]string{
+	{"BEGIN"},
+	{"START", "TRANSACTION"},
+	{"SAVEPOINT"},
+	{"ROLLBACK", "TO"},
+	{"RELEASE", "SAVEPOINT"},
+	{"XA", "START"},
+	{"XA", "BEGIN"},
+	{"XA", "END"},
+	{"INSERT"},
+	{"UPDATE"},
+	{"DELETE"},
+	{"REPLACE"},
+}
+
+// QueryEvent 的语句是否代表事务结束: COMMIT, ROLLBACK 和 DDL 等隐式提交的语句.
+// BEGIN, SAVEPOINT, ROLLBACK TO SAVEPOINT 和 statement 格式的DML在事务中间, 不是事务结束
+func isTrxEndQuery(query string) bool {
+	words := strings.Fields(strings.ToUpper(strings.TrimRight(strings.TrimSpace(query), ";")))
+	if len(words) == 0 {
+		return false
+	}
+
+	for _, prefix := range trxInnerQueries {
+		if len(words) < len(prefix) {
+			continue
+		}
+		matched := true
+		for i, word := range prefix {
+			if words[i] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (this *Manal) stopProduct() {
 	this.cancel()
 }
@@ -274,6 +714,7 @@ func (this *Manal) closeChan() {
 }
 
 func (this *Manal) Start() error {
+	this.startedAt = time.Now()
 	wg := new(sync.WaitGroup)
 
 	wg.Add(1)
@@ -288,15 +729,18 @@ func (this *Manal) Start() error {
 	wg.Add(1)
 	go this.updateAPI(wg)
 
+	wg.Add(1)
+	go this.collectMetrics(wg)
+
 	wg.Wait()
 
 	if !this.ProductSuccess {
 		return fmt.Errorf("binlog没有产生完成. binlog解析到 %s, 应用到位点: %s, 结束位点为 %s",
-			this.CurrentPosition.String(), this.MComsume.CurrPosition.String(), this.EndPosition.String())
+			this.CurrentPosition.String(), this.Comsumer.State().CurrPosition.String(), this.EndPosition.String())
 	}
-	if !this.MComsume.Success {
+	if !this.Comsumer.State().Success {
 		return fmt.Errorf("binlog没有应用完成. binlog解析到 %s, 应用到位点: %s, 结束位点为 %s",
-			this.CurrentPosition.String(), this.MComsume.CurrPosition.String(), this.EndPosition.String())
+			this.CurrentPosition.String(), this.Comsumer.State().CurrPosition.String(), this.EndPosition.String())
 	}
 
 	return nil
@@ -314,10 +758,10 @@ func (this *Manal) product(wg *sync.WaitGroup) {
 func (this *Manal) comsume(wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer func() {
-		this.MComsume.IsQuit = true
+		this.Comsumer.State().IsQuit = true
 	}()
 
-	if err := this.MComsume.Comsume(); err != nil {
+	if err := this.Comsumer.Comsume(); err != nil {
 		this.stopProduct()
 		seelog.Error(err.Error())
 		return
@@ -346,22 +790,24 @@ func (this *Manal) readAPI(wg *sync.WaitGroup) {
 				seelog.Errorf("获取API信息失败, 无法获取到结束位点. %v", err)
 				continue
 			}
+			if len(readInfo.EndGTIDSet) != 0 { // API指定了结束GTID集合
+				if err = this.SetEndGTIDSet(readInfo.EndGTIDSet); err != nil {
+					seelog.Warnf("API获取到不正确的GTID集合 %s. %v", readInfo.EndGTIDSet, err)
+				}
+				continue
+			}
 			if len(readInfo.EndLogFile) == 0 {
 				seelog.Warnf("API获取到不正确的位点信息 %s:%d", readInfo.EndLogFile, readInfo.EndLogPos)
 				continue
 			}
 			// 将获取的结束位点信息赋值给当前任务
-			// 获取的信息和指定的相等不进行赋值
-			if readInfo.EndLogFile == this.EndPosition.File && readInfo.EndLogPos == this.EndPosition.Position {
-				continue
-			}
-			// 不相等进行赋值
+			this.Lock()
 			this.EndPosition.File = readInfo.EndLogFile
 			this.EndPosition.Position = readInfo.EndLogPos
+			this.Unlock()
 		case <-this.ctx.Done():
 			seelog.Info("停止读取API信息")
 			return
-		default:
 		}
 	}
 }
@@ -384,36 +830,47 @@ func (this *Manal) updateAPI(wg *sync.WaitGroup) {
 	for {
 		select {
 		case <-ticker1.C:
-			saveInfo := &types.SaveInfo{
-				ParseLogFile: this.CurrentPosition.File,
-				ParseLogPos:  this.CurrentPosition.Position,
-				ApplyLogFile: this.MComsume.CurrPosition.File,
-				ApplyLogPos:  this.MComsume.CurrPosition.Position,
-				EndLogFile:   this.EndPosition.File,
-				EndLogPos:    this.EndPosition.Position,
-			}
+			saveInfo := this.newSaveInfo()
 			if err := types.UpdateSaveInfo(this.TMC.TaskUUID, this.TMC.UpdateAPI, saveInfo); err != nil {
 				seelog.Errorf("保存信息出错. %v", err)
 			}
 			// 更新信息
 		case <-ticker2.C:
-			if this.MComsume.IsQuit {
+			if this.Comsumer.State().IsQuit {
 				// 信息信息
 				seelog.Info("停止更新接口")
-				saveInfo := &types.SaveInfo{
-					ParseLogFile: this.CurrentPosition.File,
-					ParseLogPos:  this.CurrentPosition.Position,
-					ApplyLogFile: this.MComsume.CurrPosition.File,
-					ApplyLogPos:  this.MComsume.CurrPosition.Position,
-					EndLogFile:   this.EndPosition.File,
-					EndLogPos:    this.EndPosition.Position,
-				}
+				saveInfo := this.newSaveInfo()
 				if err := types.UpdateSaveInfo(this.TMC.TaskUUID, this.TMC.UpdateAPI, saveInfo); err != nil {
 					seelog.Errorf("保存信息出错. %v", err)
 				}
 				return
 			}
-		default:
 		}
 	}
 }
+
+// 获取需要保存的任务信息, 解析和结束位点在 Manal 的锁中获取, 应用完成的位点在消费者的锁中获取
+func (this *Manal) newSaveInfo() *types.SaveInfo {
+	applyPos := this.Comsumer.Position()
+
+	this.Lock()
+	saveInfo := &types.SaveInfo{
+		ParseLogFile: this.CurrentPosition.File,
+		ParseLogPos:  this.CurrentPosition.Position,
+		ParseGTIDSet: this.CurrentPosition.Executed_Gtid_Set,
+		ApplyLogFile: applyPos.File,
+		ApplyLogPos:  applyPos.Position,
+		ApplyGTIDSet: applyPos.Executed_Gtid_Set,
+		EndLogFile:   this.EndPosition.File,
+		EndLogPos:    this.EndPosition.Position,
+		EndGTIDSet:   this.EndPosition.Executed_Gtid_Set,
+	}
+	this.Unlock()
+	if this.throttler != nil {
+		state := this.throttler.State()
+		saveInfo.Throttled = state.Throttled
+		saveInfo.ThrottleReason = state.Reason
+	}
+
+	return saveInfo
+}
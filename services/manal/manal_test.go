@@ -0,0 +1,156 @@
+package manal
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 测试使用的表: db1.t1 写入 db1_archive, 第一个字段为主键(int unsigned), 其他字段为 varchar
+func newTestTable(tName string, columnNames ...string) *schema.Table {
+	tbl := &schema.Table{
+		SchemaName:    "db1",
+		TableName:     tName,
+		ColumnNames:   columnNames,
+		ColumnPos:     make(map[string]int, len(columnNames)),
+		PKColumnNames: columnNames[:1],
+		PKType:        schema.PKTypePK,
+	}
+	quoted := make([]string, len(columnNames))
+	placeholders := make([]string, len(columnNames))
+	for i, name := range columnNames {
+		column := &models.Column{ColumnName: name, DataType: "varchar", ColumnType: "varchar(255)"}
+		if i == 0 {
+			column = &models.Column{ColumnName: name, DataType: "int", ColumnType: "int(10) unsigned"}
+		}
+		tbl.Columns = append(tbl.Columns, column)
+		tbl.ColumnPos[name] = i
+		quoted[i] = "`" + name + "`"
+		placeholders[i] = "?"
+	}
+	target := "`db1_archive`.`" + tName + "`"
+	tbl.InsertTemplate = "INSERT INTO " + target + "(" + strings.Join(quoted, ", ") + ") VALUES "
+	tbl.ReplaceTemplate = "REPLACE INTO " + target + "(" + strings.Join(quoted, ", ") + ") VALUES "
+	tbl.DeleteTemplate = "DELETE FROM " + target + " WHERE " + quoted[0] + " = ?"
+	tbl.InsertValuePlaceholderTemplate = "(" + strings.Join(placeholders, ", ") + ")"
+
+	return tbl
+}
+
+// 生成一个 binlog event: 19 字节的 header 加上 body
+func newTestBinlogEvent(eventType replication.EventType, body []byte) []byte {
+	data := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(data[0:], 1546272000)
+	data[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(data[5:], 1)
+	binary.LittleEndian.PutUint32(data[9:], uint32(replication.EventHeaderSize+len(body)))
+	return append(data, body...)
+}
+
+func TestNewBinlogParser_Decimal(t *testing.T) {
+	parser := newBinlogParser()
+
+	// 5.5 的 format description event 没有 checksum
+	fde := make([]byte, 2+50+4+1+40)
+	binary.LittleEndian.PutUint16(fde, 4)
+	copy(fde[2:], "5.5.62-log")
+	fde[56] = byte(replication.EventHeaderSize)
+	for i := 57; i < len(fde); i++ {
+		fde[i] = 8
+	}
+
+	// 表 db1.t1 (amount decimal(28,9))
+	tableMap := []byte{1, 0, 0, 0, 0, 0, 0, 0, 3, 'd', 'b', '1', 0, 2, 't', '1', 0,
+		1, mysql.MYSQL_TYPE_NEWDECIMAL, 2, 28, 9, 0}
+
+	// 1234567890123456789.123456789: 整数部分 1 + 234567890 + 123456789, 小数部分 123456789
+	value := []byte{0x81}
+	for _, n := range []uint32{234567890, 123456789, 123456789} {
+		value = binary.BigEndian.AppendUint32(value, n)
+	}
+	rows := append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0x01, 0x00}, value...)
+
+	var ev *replication.BinlogEvent
+	for _, data := range [][]byte{
+		newTestBinlogEvent(replication.FORMAT_DESCRIPTION_EVENT, fde),
+		newTestBinlogEvent(replication.TABLE_MAP_EVENT, tableMap),
+		newTestBinlogEvent(replication.WRITE_ROWS_EVENTv2, rows),
+	} {
+		var err error
+		if ev, err = parser.Parse(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := ev.Event.(*replication.RowsEvent).Rows[0][0]
+	stringer, ok := v.(interface{ String() string })
+	if !ok || stringer.String() != "1234567890123456789.123456789" {
+		t.Fatalf("decimal 值: %#v", v)
+	}
+}
+
+func TestIsTrxEndQuery(t *testing.T) {
+	cases := map[string]bool{
+		"BEGIN":                           false,
+		"SAVEPOINT `sp1`":                 false,
+		"ROLLBACK TO `sp1`":               false,
+		"rollback to savepoint sp1":       false,
+		"RELEASE SAVEPOINT sp1":           false,
+		"XA START 'xid1'":                 false,
+		"insert into t1 values(1)":        false,
+		"COMMIT":                          true,
+		"ROLLBACK":                        true,
+		"XA COMMIT 'xid1'":                true,
+		"ALTER TABLE t1 ADD COLUMN c int": true,
+		"DROP TABLE `t1` /* generated by server */": true,
+		"": false,
+	}
+	for query, want := range cases {
+		if got := isTrxEndQuery(query); got != want {
+			t.Errorf("语句: %q 是否事务结束: %v, 期望: %v", query, got, want)
+		}
+	}
+}
+
+// 事务中的 SAVEPOINT 和 ROLLBACK TO SAVEPOINT 不产生事务结束事件, 只有 COMMIT 和 XID 产生
+func TestManal_HandleEvent_TrxBoundary(t *testing.T) {
+	manal := &Manal{
+		CurrentPosition: new(models.Position),
+		EndPosition:     new(models.Position),
+		EventChan:       make(chan *EventData, 10),
+	}
+	manal.ctx, manal.cancel = context.WithCancel(context.Background())
+
+	queries := []string{"BEGIN", "SAVEPOINT `sp1`", "ROLLBACK TO `sp1`", "COMMIT"}
+	for i, query := range queries {
+		ev := &replication.BinlogEvent{
+			Header: &replication.EventHeader{LogPos: uint32(100 * (i + 1))},
+			Event:  &replication.QueryEvent{Query: []byte(query)},
+		}
+		if stop, err := manal.handleEvent(ev); err != nil || stop {
+			t.Fatalf("语句: %s, 停止: %v, 错误: %v", query, stop, err)
+		}
+	}
+	ev := &replication.BinlogEvent{Header: &replication.EventHeader{LogPos: 500}, Event: &replication.XIDEvent{}}
+	if stop, err := manal.handleEvent(ev); err != nil || stop {
+		t.Fatalf("XIDEvent 停止: %v, 错误: %v", stop, err)
+	}
+
+	close(manal.EventChan)
+	var positions []uint32
+	for ev := range manal.EventChan {
+		if !isTrxEndEvent(ev) {
+			t.Fatalf("位点: %d 不是事务结束事件", ev.LogPos)
+		}
+		positions = append(positions, ev.LogPos)
+	}
+	if len(positions) != 2 || positions[0] != 400 || positions[1] != 500 {
+		t.Fatalf("事务结束事件的位点: %v, 期望: [400 500]", positions)
+	}
+}
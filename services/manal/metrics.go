@@ -0,0 +1,165 @@
+package manal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/metrics"
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 更新延迟监控指标的间隔
+const METRICS_INTERVAL = 5 * time.Second
+
+// 监控指标, task 标签为任务名称(默认为 task uuid)
+var (
+	eventsParsedCounter = metrics.NewCounterVec("haqi_events_parsed_total",
+		"解析并发送给消费者的 row event 数", "task", "schema", "table", "op")
+	eventsAppliedCounter = metrics.NewCounterVec("haqi_events_applied_total",
+		"写入目标的 row event 数", "task", "schema", "table", "op")
+	rowsArchivedCounter = metrics.NewCounterVec("haqi_rows_archived_total",
+		"写入目标的行数, update 的修改前和修改后算一行", "task", "schema", "table", "op")
+	eventQueueDepthGauge = metrics.NewGaugeVec("haqi_event_queue_depth",
+		"EventChan 中等待消费的 event 数", "task")
+	parseLagBytesGauge = metrics.NewGaugeVec("haqi_parse_lag_bytes",
+		"源实例最新位点和解析到的位点相差的字节数", "task")
+	applyLagBytesGauge = metrics.NewGaugeVec("haqi_apply_lag_bytes",
+		"源实例最新位点和应用完成的位点相差的字节数", "task")
+	parseLagSecondsGauge = metrics.NewGaugeVec("haqi_parse_lag_seconds",
+		"当前时间和解析到的 event 时间相差的秒数, 已经解析到源实例最新位点时为0, 还没有 event 时从任务开始计算", "task")
+	applyLagSecondsGauge = metrics.NewGaugeVec("haqi_apply_lag_seconds",
+		"当前时间和应用完成的 event 时间相差的秒数, 已经应用到源实例最新位点时为0, 还没有 event 时从任务开始计算", "task")
+	targetExecHistogram = metrics.NewHistogramVec("haqi_target_exec_duration_seconds",
+		"写入目标的耗时, stmt 为 dml, commit, ddl", nil, "task", "sink", "stmt")
+	ddlRepairsCounter = metrics.NewCounterVec("haqi_ddl_repairs_total",
+		"检测和修复目标表时执行的DDL数, action 为 create_table, add_column, modify_column, add_audit_column",
+		"task", "schema", "table", "action")
+	throttledGauge = metrics.NewGaugeVec("haqi_throttled",
+		"是否因为目标实例负载过高暂停应用, 1: 暂停", "task")
+)
+
+// 记录写入目标的耗时
+func observeTargetExec(task string, sink string, stmt string, start time.Time) {
+	targetExecHistogram.WithLabelValues(task, sink, stmt).Observe(time.Since(start).Seconds())
+}
+
+// 定时更新队列长度和延迟指标, 任务结束后删除这些指标. 没有启用监控指标时不更新(不需要查询源实例)
+func (this *Manal) collectMetrics(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if !this.EnableMetrics {
+		return
+	}
+
+	task := this.TMC.TaskLabel()
+	ticker1 := time.NewTicker(METRICS_INTERVAL)
+	ticker2 := time.NewTicker(1 * time.Second)
+	defer func() {
+		ticker1.Stop()
+		ticker2.Stop()
+		for _, gauge := range []*metrics.GaugeVec{eventQueueDepthGauge, parseLagBytesGauge, applyLagBytesGauge,
+			parseLagSecondsGauge, applyLagSecondsGauge} {
+			gauge.Delete(task)
+		}
+	}()
+
+	this.updateLagMetrics(task)
+	for {
+		select {
+		case <-ticker1.C:
+			this.updateLagMetrics(task)
+		case <-ticker2.C:
+			if this.Comsumer.State().IsQuit { // 应用完成后停止更新
+				return
+			}
+		}
+	}
+}
+
+func (this *Manal) updateLagMetrics(task string) {
+	eventQueueDepthGauge.WithLabelValues(task).Set(float64(len(this.EventChan)))
+
+	parsePos := this.parsedPosition()
+	applyPos := this.Comsumer.Position()
+	now := time.Now()
+
+	// 离线模式没有源实例, 只能计算时间延迟
+	var logs []*models.BinaryLog
+	if !this.TMC.IsOffline() {
+		var err error
+		if logs, err = this.showSourceBinaryLogs(); err != nil {
+			seelog.Warnf("获取源实例binlog信息失败, 无法计算延迟字节数. %v", err)
+		}
+	}
+
+	parseLag, parseOk := binlogDistance(logs, &parsePos)
+	applyLag, applyOk := binlogDistance(logs, &applyPos)
+	if parseOk {
+		parseLagBytesGauge.WithLabelValues(task).Set(float64(parseLag))
+	}
+	if applyOk {
+		applyLagBytesGauge.WithLabelValues(task).Set(float64(applyLag))
+	}
+	parseLagSecondsGauge.WithLabelValues(task).Set(lagSeconds(now, &parsePos, parseOk && parseLag == 0, this.startedAt))
+	applyLagSecondsGauge.WithLabelValues(task).Set(lagSeconds(now, &applyPos, applyOk && applyLag == 0, this.startedAt))
+}
+
+func (this *Manal) showSourceBinaryLogs() ([]*models.BinaryLog, error) {
+	defaultDao, err := dao.NewDefaultDao(this.ODBC)
+	if err != nil {
+		return nil, err
+	}
+
+	return defaultDao.ShowBinaryLogs()
+}
+
+// 位点到源实例最新位点(最后一个binlog的大小)的字节数. 位点所在的binlog已经不在列表中则无法计算
+func binlogDistance(logs []*models.BinaryLog, pos *models.Position) (int64, bool) {
+	if len(logs) == 0 || len(pos.File) == 0 {
+		return 0, false
+	}
+
+	var distance int64
+	found := false
+	for _, log := range logs {
+		if log.LogName == pos.File {
+			found = true
+			distance += int64(log.FileSize) - int64(pos.Position)
+			continue
+		}
+		if found {
+			distance += int64(log.FileSize)
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	if distance < 0 { // 获取binlog信息之后又有新的数据
+		distance = 0
+	}
+
+	return distance, true
+}
+
+// 位点的 event 时间到当前时间的秒数, 已经追上源实例时为0.
+// 还没有 event 时从任务开始的时间计算, 一直没有解析或应用数据的任务延迟会增加
+func lagSeconds(now time.Time, pos *models.Position, caughtUp bool, startedAt time.Time) float64 {
+	if caughtUp {
+		return 0
+	}
+	ts := pos.TS
+	if ts.IsZero() {
+		ts = startedAt
+	}
+	if ts.IsZero() {
+		return 0
+	}
+	lag := now.Sub(ts).Seconds()
+	if lag < 0 {
+		return 0
+	}
+
+	return lag
+}
@@ -0,0 +1,57 @@
+package manal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/models"
+)
+
+func TestBinlogDistance(t *testing.T) {
+	logs := []*models.BinaryLog{
+		{LogName: "mysql-bin.000001", FileSize: 1000},
+		{LogName: "mysql-bin.000002", FileSize: 2000},
+		{LogName: "mysql-bin.000003", FileSize: 500},
+	}
+
+	cases := []struct {
+		pos      models.Position
+		distance int64
+		ok       bool
+	}{
+		{models.Position{File: "mysql-bin.000003", Position: 500}, 0, true},
+		{models.Position{File: "mysql-bin.000003", Position: 120}, 380, true},
+		{models.Position{File: "mysql-bin.000001", Position: 400}, 600 + 2000 + 500, true},
+		{models.Position{File: "mysql-bin.000003", Position: 600}, 0, true}, // 获取binlog信息之后又有新的数据
+		{models.Position{File: "mysql-bin.000000", Position: 4}, 0, false},  // binlog 已经被删除
+		{models.Position{}, 0, false},
+	}
+	for _, c := range cases {
+		distance, ok := binlogDistance(logs, &c.pos)
+		if distance != c.distance || ok != c.ok {
+			t.Fatalf("位点: %s, 相差字节数: %d %v, 期望: %d %v", c.pos.String(), distance, ok, c.distance, c.ok)
+		}
+	}
+}
+
+func TestLagSeconds(t *testing.T) {
+	now := time.Now()
+	startedAt := now.Add(-30 * time.Second)
+
+	cases := []struct {
+		pos      models.Position
+		caughtUp bool
+		lag      float64
+	}{
+		{models.Position{TS: now.Add(-10 * time.Second)}, false, 10},
+		{models.Position{TS: now.Add(-10 * time.Second)}, true, 0},
+		{models.Position{}, false, 30}, // 还没有 event, 从任务开始计算
+		{models.Position{}, true, 0},
+		{models.Position{TS: now.Add(10 * time.Second)}, false, 0},
+	}
+	for _, c := range cases {
+		if lag := lagSeconds(now, &c.pos, c.caughtUp, startedAt); lag != c.lag {
+			t.Fatalf("event 时间: %s, 是否追上: %t, 延迟: %f, 期望: %f", c.pos.TS, c.caughtUp, lag, c.lag)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package manal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+// 按大小和时间切换的输出文件, 文件名为: <prefix>.<6位序号><ext>
+type rotateFile struct {
+	Dir      string
+	Prefix   string
+	Ext      string
+	MaxSize  int64                   // 文件最大字节数, 小于等于0不按大小切换
+	Interval time.Duration           // 文件写入的最长时间, 小于等于0不按时间切换
+	OnOpen   func(w io.Writer) error // 打开新文件后调用, 如: 写入文件头
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	seq      int
+}
+
+// 写入数据, 写入后超过最大大小或者超过最长时间则先切换到新的文件. 一次写入的数据不会拆分到两个文件
+func (this *rotateFile) Write(p []byte) (int, error) {
+	if this.needRotate(len(p)) {
+		if err := this.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if this.file == nil {
+		if err := this.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := this.file.Write(p)
+	this.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("写入文件 %s 失败. %v", this.file.Name(), err)
+	}
+
+	return n, nil
+}
+
+func (this *rotateFile) needRotate(n int) bool {
+	if this.file == nil {
+		return false
+	}
+	if this.Interval > 0 && time.Since(this.openedAt) >= this.Interval {
+		return true
+	}
+
+	return this.MaxSize > 0 && this.size > 0 && this.size+int64(n) > this.MaxSize
+}
+
+// 打开下一个不存在的文件
+func (this *rotateFile) open() error {
+	if err := os.MkdirAll(this.Dir, 0755); err != nil {
+		return fmt.Errorf("创建输出目录 %s 失败. %v", this.Dir, err)
+	}
+
+	for {
+		this.seq++
+		name := filepath.Join(this.Dir, fmt.Sprintf("%s.%06d%s", this.Prefix, this.seq, this.Ext))
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("创建输出文件 %s 失败. %v", name, err)
+		}
+		this.file = file
+		this.size = 0
+		this.openedAt = time.Now()
+		seelog.Infof("开始写入输出文件: %s", name)
+		break
+	}
+
+	if this.OnOpen != nil {
+		if err := this.OnOpen(countWriter{this}); err != nil {
+			return fmt.Errorf("写入文件 %s 失败. %v", this.file.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (this *rotateFile) Close() error {
+	if this.file == nil {
+		return nil
+	}
+
+	file := this.file
+	this.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("刷新输出文件 %s 失败. %v", file.Name(), err)
+	}
+	return file.Close()
+}
+
+// 直接写入当前文件并统计大小, 不检测是否需要切换
+type countWriter struct {
+	f *rotateFile
+}
+
+func (this countWriter) Write(p []byte) (int, error) {
+	n, err := this.f.file.Write(p)
+	this.f.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,89 @@
+package manal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 解析行过滤规则, 一个表只能指定一个过滤规则
+func parseRowFilters(rules []string) (map[string]*schema.RowFilter, error) {
+	filters := make(map[string]*schema.RowFilter)
+	for _, rule := range rules {
+		key, filter, err := schema.ParseRowFilterRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := filters[key]; ok {
+			return nil, fmt.Errorf("表: %s 指定了多个行过滤规则", key)
+		}
+		filters[key] = filter
+		seelog.Infof("表: %s 行过滤条件: %s", key, filter.Expr)
+	}
+
+	return filters, nil
+}
+
+// 检测行过滤条件中的表是否需要执行, 需要执行所有的表时获取表结构检测过滤条件中的字段.
+// 需要执行的表在获取表结构时已经检测过字段
+func (this *Manal) checkRowFilterTables() error {
+	for key := range this.RowFilters {
+		if _, ok := this.TransTableMap[key]; ok {
+			continue
+		}
+		if this.TransType != TransTypeAll {
+			return fmt.Errorf("表: %s 指定了行过滤条件, 但是不在需要执行的表中", key)
+		}
+		items := strings.SplitN(key, ".", 2)
+		if err := this.cacheTransTable(items[0], items[1]); err != nil {
+			return fmt.Errorf("表: %s 检测行过滤条件失败. %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// 检测行过滤条件中的字段在表结构中是否都存在. 获取表结构和表结构修改后都需要检测
+func (this *Manal) checkRowFilter(t *schema.Table) error {
+	filter, ok := this.RowFilters[t.String()]
+	if !ok {
+		return nil
+	}
+	if err := filter.Check(t); err != nil {
+		return fmt.Errorf("行过滤条件: %s. %v", filter.Expr, err)
+	}
+
+	return nil
+}
+
+// 过滤 row event 中的数据, 返回满足条件的行.
+// update 事件修改前或修改后的数据满足条件, 修改前和修改后的数据都保留
+func filterRows(filter *schema.RowFilter, t *schema.Table, ev *replication.BinlogEvent, rows [][]interface{}) ([][]interface{}, error) {
+	step := 1
+	switch ev.Header.EventType {
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		if len(rows)%2 != 0 {
+			return nil, fmt.Errorf("表: %s update 事件数据行数 %d 不是成对出现", t.String(), len(rows))
+		}
+		step = 2
+	}
+
+	matchRows := make([][]interface{}, 0, len(rows))
+	for i := 0; i < len(rows); i += step {
+		for _, row := range rows[i : i+step] {
+			ok, err := filter.Match(t, row)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matchRows = append(matchRows, rows[i:i+step]...)
+				break
+			}
+		}
+	}
+
+	return matchRows, nil
+}
@@ -0,0 +1,71 @@
+package manal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestFilterRows(t *testing.T) {
+	filters, err := parseRowFilters([]string{"db1.t1: status = 'closed'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := newTestTable("t1", "id", "status")
+	ev := &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.UPDATE_ROWS_EVENTv2},
+	}
+	rows := [][]interface{}{
+		{int64(1), "open"}, {int64(1), "closed"}, // 修改后满足条件
+		{int64(2), "open"}, {int64(2), "open"},
+		{int64(3), "closed"}, {int64(3), "open"}, // 修改前满足条件
+	}
+
+	matchRows, err := filterRows(filters["db1.t1"], tbl, ev, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matchRows) != 4 || matchRows[0][0] != int64(1) || matchRows[2][0] != int64(3) {
+		t.Fatalf("过滤后的数据: %v", matchRows)
+	}
+
+	if _, err = parseRowFilters([]string{"db1.t1: id = 1", "db1.t1: id = 2"}); err == nil {
+		t.Fatal("一个表指定多个过滤规则没有返回错误")
+	}
+}
+
+func TestManal_CheckRowFilter(t *testing.T) {
+	tbl := newTestTable("t1", "id", "status")
+	tbl.Router = &schema.TableRouter{SchemaSuffix: "_archive"}
+	filters, err := parseRowFilters([]string{"db1.t1: status = 'closed'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manal{
+		TransType:     TransTypePartial,
+		TransTableMap: map[string]*schema.Table{"db1.t1": tbl},
+		RowFilters:    filters,
+	}
+	if err = m.checkRowFilterTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.checkRowFilter(newTestTable("t1", "id", "name")); err == nil {
+		t.Fatal("表中没有过滤条件中的字段应该返回错误")
+	}
+
+	// 修改表结构后过滤条件中的字段不存在
+	ddl := schema.ParseDDL("ALTER TABLE t1 DROP COLUMN status", "db1")[0]
+	if err = m.trackDDL(ddl); err == nil {
+		t.Fatal("删除过滤条件中的字段应该返回错误")
+	} else if !strings.Contains(err.Error(), "行过滤条件") {
+		t.Fatalf("错误: %v", err)
+	}
+
+	// 过滤条件中的表不需要执行
+	m.RowFilters["db1.t2"] = m.RowFilters["db1.t1"]
+	if err = m.checkRowFilterTables(); err == nil {
+		t.Fatal("过滤条件中的表不需要执行应该返回错误")
+	}
+}
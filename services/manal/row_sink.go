@@ -0,0 +1,107 @@
+package manal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// 按行输出数据的 Sink 中的事务, 不生成sql, 直接写入每一行修改前和修改后的数据
+type RowSinkTx interface {
+	SinkTx
+	WriteRows(ev *EventData, tbl *schema.Table, op string, rows [][]interface{}) error
+}
+
+// 一行数据的修改. insert 只有修改后的数据, delete 只有修改前的数据
+type rowChange struct {
+	Before []interface{}
+	After  []interface{}
+}
+
+// 获取 row event 对应的操作类型
+func rowEventOp(eventType replication.EventType) string {
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		return schema.AUDIT_OP_INSERT
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		return schema.AUDIT_OP_UPDATE
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		return schema.AUDIT_OP_DELETE
+	}
+	return ""
+}
+
+// 将 row event 中的数据转化为每一行的修改, 字段值转化为输出的类型
+func newRowChanges(tbl *schema.Table, op string, rows [][]interface{}) ([]*rowChange, error) {
+	step := 1
+	if op == schema.AUDIT_OP_UPDATE {
+		if len(rows)%2 != 0 {
+			return nil, fmt.Errorf("表: %s update 事件数据行数 %d 不是成对出现", tbl.String(), len(rows))
+		}
+		step = 2
+	}
+
+	changes := make([]*rowChange, 0, len(rows)/step)
+	for i := 0; i < len(rows); i += step {
+		first, err := exportRow(tbl, rows[i])
+		if err != nil {
+			return nil, err
+		}
+		change := new(rowChange)
+		switch op {
+		case schema.AUDIT_OP_INSERT:
+			change.After = first
+		case schema.AUDIT_OP_DELETE:
+			change.Before = first
+		case schema.AUDIT_OP_UPDATE:
+			change.Before = first
+			if change.After, err = exportRow(tbl, rows[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// 转化一行数据用于输出. 整型(无符号按无符号)和浮点型保持数字类型,
+// decimal 和时间类型为字符串(时间格式: 2006-01-02 15:04:05.999999),
+// 二进制类型字段的数据为 base64 编码的字符串, 文本类型(binlog 中 text 也是 []byte)和 json 字段为字符串
+func exportRow(tbl *schema.Table, row []interface{}) ([]interface{}, error) {
+	values, err := tbl.ConvertRow(row)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		b, ok := v.([]byte)
+		if !ok {
+			continue
+		}
+		if i < len(tbl.Columns) && !isBinaryDataType(tbl.Columns[i].DataType) {
+			values[i] = string(b)
+			continue
+		}
+		values[i] = base64.StdEncoding.EncodeToString(b)
+	}
+
+	return values, nil
+}
+
+// 是否是二进制类型的字段, 输出时需要编码
+func isBinaryDataType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return true
+	}
+	return false
+}
+
+// 按行输出的 Sink 不执行sql
+func errRowSinkExec(sink string) error {
+	return fmt.Errorf("写入目标 %s 按行输出数据, 不支持执行sql", sink)
+}
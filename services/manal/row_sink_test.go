@@ -0,0 +1,129 @@
+package manal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/daiguadaidai/haqi/utils"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func writeRowSinkTestRows(t *testing.T, sink Sink, tbl *schema.Table, op string, rows ...[]interface{}) {
+	ev := &EventData{
+		LogFile:     "mysql-bin.000001",
+		LogPos:      1024,
+		GTID:        "3E11FA47-71CA-11E1-9E33-C80AA9429562:23",
+		BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{Timestamp: 1547823395}},
+	}
+	tx, err := sink.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.(RowSinkTx).WriteRows(ev, tbl, op, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRowSinkTestFiles(t *testing.T, pattern string) []string {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make([]string, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+
+	return contents
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_jsonl_sink_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmc := &config.ToMySQLConfig{Sink: config.SINK_JSON_LINES, OutputDir: dir, OutputPrefix: "haqi"}
+	sink := NewSink(tmc, nil)
+	tbl := newTestTable("t1", "id", "name", "data")
+	tbl.Columns[2] = &models.Column{ColumnName: "data", DataType: "blob", ColumnType: "blob"}
+	writeRowSinkTestRows(t, sink, tbl, schema.AUDIT_OP_UPDATE,
+		[]interface{}{int32(-1), "a", nil}, []interface{}{int32(-1), "b", []byte{0x00, 0xff}})
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := readRowSinkTestFiles(t, filepath.Join(dir, "haqi.*.jsonl"))
+	expect := `{"schema":"db1","table":"t1","op":"update",` +
+		`"before":{"id":4294967295,"name":"a","data":null},"after":{"id":4294967295,"name":"b","data":"AP8="},` +
+		`"log_file":"mysql-bin.000001","log_pos":1024,"event_time":"` +
+		utils.TS2String(1547823395, utils.TIME_FORMAT) + `","gtid":"3E11FA47-71CA-11E1-9E33-C80AA9429562:23"}` + "\n"
+	if len(contents) != 1 || contents[0] != expect {
+		t.Fatalf("输出文件内容: %v, 期望: %s", contents, expect)
+	}
+}
+
+func TestExportRow_TextAndBlob(t *testing.T) {
+	tbl := newTestTable("t1", "id", "content", "data")
+	tbl.Columns[1] = &models.Column{ColumnName: "content", DataType: "text", ColumnType: "text"}
+	tbl.Columns[2] = &models.Column{ColumnName: "data", DataType: "blob", ColumnType: "blob"}
+
+	// binlog 中 text 和 blob 字段的值都是 []byte
+	values, err := exportRow(tbl, []interface{}{int32(1), []byte("你好"), []byte{0x00, 0xff}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{int64(1), "你好", "AP8="}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("输出的数据: %#v, 期望: %#v", values, expect)
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_csv_sink_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmc := &config.ToMySQLConfig{Sink: config.SINK_CSV, OutputDir: dir, OutputPrefix: "haqi"}
+	sink := NewSink(tmc, nil)
+	writeRowSinkTestRows(t, sink, newTestTable("t1", "id", "name"), schema.AUDIT_OP_DELETE,
+		[]interface{}{int32(1), "a,\"b\""}, []interface{}{int32(2), nil})
+	// 表结构变化后切换到新的文件
+	writeRowSinkTestRows(t, sink, newTestTable("t1", "id", "name", "age"), schema.AUDIT_OP_INSERT,
+		[]interface{}{int32(3), "c", "10"})
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := readRowSinkTestFiles(t, filepath.Join(dir, "haqi.db1.t1.*.csv"))
+	if len(contents) != 2 {
+		t.Fatalf("输出文件: %v", contents)
+	}
+	lines := strings.Split(strings.TrimSpace(contents[0]), "\n")
+	if len(lines) != 3 || lines[0] != "_op,_image,_log_file,_log_pos,_event_time,_gtid,_thread_id,id,name" ||
+		!strings.HasPrefix(lines[1], "delete,before,mysql-bin.000001,1024,") ||
+		!strings.HasSuffix(lines[1], `,1,"a,""b"""`) || !strings.HasSuffix(lines[2], `,2,\N`) {
+		t.Fatalf("输出文件内容:\n%s", contents[0])
+	}
+	if !strings.HasPrefix(contents[1], "_op,_image,_log_file,_log_pos,_event_time,_gtid,_thread_id,id,name,age\n") ||
+		!strings.Contains(contents[1], "insert,after,") {
+		t.Fatalf("输出文件内容:\n%s", contents[1])
+	}
+}
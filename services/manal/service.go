@@ -1,13 +1,18 @@
 package manal
 
 import (
+	"net/http"
+	"syscall"
+
 	"github.com/cihub/seelog"
 	"github.com/daiguadaidai/haqi/config"
-	"syscall"
+	"github.com/daiguadaidai/haqi/gdbc"
+	"github.com/daiguadaidai/haqi/metrics"
 )
 
 func Start(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) {
 	defer seelog.Flush()
+	defer gdbc.Close()
 	logger, _ := seelog.LoggerFromConfigAsBytes([]byte(config.LogDefautConfig()))
 	seelog.ReplaceLogger(logger)
 
@@ -17,22 +22,57 @@ func Start(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConf
 	}
 
 	config.SetToMySQLConfig(tmc)
-	if err := config.AddDBConfig(odbc); err != nil { // 添加源数据库配置文件
+
+	manal, err := NewManal(tmc, odbc, tdbc)
+	if err != nil {
 		seelog.Error(err.Error())
 		syscall.Exit(1)
 	}
-	if err := config.AddDBConfig(tdbc); err != nil { // 添加目标配数据库置文件
+
+	if len(tmc.MetricsAddr) != 0 {
+		go serveMetrics(tmc.MetricsAddr)
+	}
+
+	if err := manal.Start(); err != nil {
+		seelog.Error(err)
+		syscall.Exit(1)
+	}
+}
+
+// 启动监控指标 HTTP 服务, 启动失败不影响任务执行
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	seelog.Infof("监控指标监听: %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		seelog.Errorf("监控指标 HTTP 服务出错. %v", err)
+	}
+}
+
+func StartFlashback(fc *config.FlashbackConfig, odbc *config.DBConfig, tdbc *config.DBConfig) {
+	defer seelog.Flush()
+	defer gdbc.Close()
+	logger, _ := seelog.LoggerFromConfigAsBytes([]byte(config.LogDefautConfig()))
+	seelog.ReplaceLogger(logger)
+
+	if err := fc.Check(); err != nil {
 		seelog.Error(err.Error())
 		syscall.Exit(1)
 	}
 
-	manal, err := NewManal(tmc, odbc, tdbc)
+	manal, fComsume, err := NewFlashbackManal(fc, odbc, tdbc)
 	if err != nil {
 		seelog.Error(err.Error())
 		syscall.Exit(1)
 	}
 
 	if err := manal.Start(); err != nil {
+		fComsume.Close()
+		seelog.Error(err)
+		syscall.Exit(1)
+	}
+
+	if err := fComsume.Output(); err != nil {
 		seelog.Error(err)
 		syscall.Exit(1)
 	}
@@ -0,0 +1,102 @@
+package manal
+
+import (
+	"fmt"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/models"
+)
+
+// 应用线程写入数据的目标. 每个应用线程将一个源事务中分配给自己的数据在一个 SinkTx 中写入
+type Sink interface {
+	Begin() (SinkTx, error)
+	ExecDDL(ev *EventData, sql string) error
+	SaveCheckpoint(sName string, cp *models.Checkpoint) error // 不在事务中保存checkpoint
+	Close() error
+}
+
+// Sink 中的事务
+type SinkTx interface {
+	Exec(ev *EventData, sql string, args ...interface{}) error
+	SaveCheckpoint(sName string, cp *models.Checkpoint) error
+	Commit() error
+	Rollback() error
+}
+
+// 通过配置创建写入数据的目标
+func NewSink(tmc *config.ToMySQLConfig, tdbc *config.DBConfig) Sink {
+	switch tmc.Sink {
+	case config.SINK_SQL_FILE:
+		return newSQLFileSink(tmc)
+	case config.SINK_JSON_LINES:
+		return newJSONLinesSink(tmc)
+	case config.SINK_CSV:
+		return newCSVSink(tmc)
+	case config.SINK_KAFKA:
+		return newKafkaSink(tmc, tdbc)
+	}
+
+	return &mysqlSink{tdbc: tdbc}
+}
+
+// 写入目标实例
+type mysqlSink struct {
+	tdbc *config.DBConfig
+}
+
+func (this *mysqlSink) Begin() (SinkTx, error) {
+	defaultDao, err := dao.NewDefaultDao(this.tdbc)
+	if err != nil {
+		return nil, err
+	}
+	txDao, err := defaultDao.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("目标实例开启事务失败. %v", err)
+	}
+
+	return &mysqlSinkTx{txDao: txDao}, nil
+}
+
+func (this *mysqlSink) ExecDDL(ev *EventData, sql string) error {
+	defaultDao, err := dao.NewDefaultDao(this.tdbc)
+	if err != nil {
+		return err
+	}
+
+	return defaultDao.ExecDDL(sql)
+}
+
+func (this *mysqlSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	defaultDao, err := dao.NewDefaultDao(this.tdbc)
+	if err != nil {
+		return err
+	}
+
+	return defaultDao.SaveCheckpoint(sName, cp)
+}
+
+func (this *mysqlSink) Close() error {
+	return nil
+}
+
+// 目标实例中的事务
+type mysqlSinkTx struct {
+	txDao *dao.DefaultDao
+}
+
+func (this *mysqlSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	return this.txDao.ExecDML(sql, args...)
+}
+
+func (this *mysqlSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return this.txDao.SaveCheckpoint(sName, cp)
+}
+
+func (this *mysqlSinkTx) Commit() error {
+	return this.txDao.Commit()
+}
+
+func (this *mysqlSinkTx) Rollback() error {
+	return this.txDao.Rollback()
+}
@@ -0,0 +1,114 @@
+package manal
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/daiguadaidai/haqi/schema"
+	"github.com/daiguadaidai/haqi/utils"
+)
+
+// 将生成的sql写入文件, 每条sql之前注释对应的binlog位点和event时间.
+// 一个源事务中分配给一个应用线程的数据作为一个事务写入, 多个应用线程写入同一组文件
+type sqlFileSink struct {
+	sync.Mutex
+	file *rotateFile
+}
+
+func newSQLFileSink(tmc *config.ToMySQLConfig) *sqlFileSink {
+	return &sqlFileSink{
+		file: &rotateFile{
+			Dir:      tmc.OutputDir,
+			Prefix:   tmc.OutputPrefix,
+			Ext:      ".sql",
+			MaxSize:  tmc.OutputMaxSize * 1024 * 1024,
+			Interval: tmc.OutputRotateInterval,
+		},
+	}
+}
+
+func (this *sqlFileSink) Begin() (SinkTx, error) {
+	return &sqlFileSinkTx{sink: this}, nil
+}
+
+func (this *sqlFileSink) ExecDDL(ev *EventData, sql string) error {
+	var buf bytes.Buffer
+	buf.WriteString(eventComment(ev))
+	buf.WriteString(sql)
+	buf.WriteString(";\n")
+
+	return this.write(buf.Bytes())
+}
+
+func (this *sqlFileSink) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	return this.write([]byte(checkpointComment(cp)))
+}
+
+func (this *sqlFileSink) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.file.Close()
+}
+
+func (this *sqlFileSink) write(p []byte) error {
+	this.Lock()
+	defer this.Unlock()
+
+	_, err := this.file.Write(p)
+	return err
+}
+
+// 文件中的事务, 提交时一次写入文件
+type sqlFileSinkTx struct {
+	sink *sqlFileSink
+	buf  bytes.Buffer
+}
+
+func (this *sqlFileSinkTx) Exec(ev *EventData, sql string, args ...interface{}) error {
+	sql, err := schema.InterpolateSQL(sql, args)
+	if err != nil {
+		return err
+	}
+
+	if this.buf.Len() == 0 {
+		this.buf.WriteString("BEGIN;\n")
+	}
+	this.buf.WriteString(eventComment(ev))
+	this.buf.WriteString(sql)
+	this.buf.WriteString(";\n")
+
+	return nil
+}
+
+func (this *sqlFileSinkTx) SaveCheckpoint(sName string, cp *models.Checkpoint) error {
+	this.buf.WriteString(checkpointComment(cp))
+	return nil
+}
+
+func (this *sqlFileSinkTx) Commit() error {
+	this.buf.WriteString("COMMIT;\n")
+	err := this.sink.write(this.buf.Bytes())
+	this.buf.Reset()
+
+	return err
+}
+
+func (this *sqlFileSinkTx) Rollback() error {
+	this.buf.Reset()
+	return nil
+}
+
+// sql 之前的注释: binlog位点和event时间
+func eventComment(ev *EventData) string {
+	return fmt.Sprintf("-- %s:%d %s\n", ev.LogFile, ev.LogPos,
+		utils.TS2String(int64(ev.BinlogEvent.Header.Timestamp), utils.TIME_FORMAT))
+}
+
+// 应用完成的位点, 只作为注释记录
+func checkpointComment(cp *models.Checkpoint) string {
+	return fmt.Sprintf("-- checkpoint: worker %d, %s\n", cp.WorkerID, cp.String())
+}
@@ -0,0 +1,78 @@
+package manal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/models"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestSQLFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "haqi_sql_file_sink_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmc := &config.ToMySQLConfig{Sink: config.SINK_SQL_FILE, OutputDir: dir, OutputPrefix: "haqi"}
+	sink := NewSink(tmc, nil).(*sqlFileSink)
+	sink.file.MaxSize = 200 // 每个事务都会切换文件
+
+	ev := &EventData{
+		LogFile:     "mysql-bin.000001",
+		LogPos:      1024,
+		BinlogEvent: &replication.BinlogEvent{Header: &replication.EventHeader{Timestamp: 1547823395}},
+	}
+	for i := 0; i < 2; i++ {
+		tx, err := sink.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Exec(ev, "INSERT INTO `db1_archive`.`t1`(`id`, `name`) VALUES(?, ?)", int64(i), "it's"); err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.SaveCheckpoint("haqi", models.NewCheckpoint("uuid", 0, &models.Position{File: "mysql-bin.000001", Position: 1024})); err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 回滚的事务不写入
+	tx, _ := sink.Begin()
+	tx.Exec(ev, "DELETE FROM `db1_archive`.`t1`")
+	tx.Rollback()
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "haqi.*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[1]) != "haqi.000002.sql" {
+		t.Fatalf("输出文件: %v", files)
+	}
+	data, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, expect := range []string{
+		"BEGIN;\n-- mysql-bin.000001:1024 ",
+		"INSERT INTO `db1_archive`.`t1`(`id`, `name`) VALUES(1, 'it\\'s');\n",
+		"-- checkpoint: worker 0, mysql-bin.000001:1024\nCOMMIT;\n",
+	} {
+		if !strings.Contains(content, expect) {
+			t.Fatalf("输出文件内容:\n%s\n不包含: %s", content, expect)
+		}
+	}
+	if strings.Contains(content, "DELETE") {
+		t.Fatalf("回滚的事务写入了文件:\n%s", content)
+	}
+}
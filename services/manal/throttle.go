@@ -0,0 +1,285 @@
+package manal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+// 检测是否需要限流, 返回不为空代表需要限流的原因
+type throttleCheck func() (string, error)
+
+// 限流的状态
+type ThrottleState struct {
+	Throttled bool      // 是否暂停应用
+	Reason    string    // 暂停应用的原因
+	Since     time.Time // 开始暂停的时间
+}
+
+// 应用数据的限流. 定时检测目标实例的负载, 超过阈值时暂停所有应用线程; 并限制每秒应用的行数
+type throttler struct {
+	sync.Mutex
+	cfg      *config.ThrottleConfig
+	task     string
+	checks   []throttleCheck
+	gate     pauseGate // 暂停应用, 复用暂停解析binlog的实现
+	state    ThrottleState
+	limiter  *rateLimiter
+	stopChan chan struct{} // 任务停止后关闭, 不再等待恢复应用
+	stopOnce sync.Once
+}
+
+func newThrottler(tmc *config.ToMySQLConfig, tdbc *config.DBConfig) *throttler {
+	throttler := &throttler{
+		cfg:      &tmc.ThrottleConfig,
+		task:     tmc.TaskLabel(),
+		stopChan: make(chan struct{}),
+	}
+	if tmc.MaxRowsPerSecond > 0 {
+		throttler.limiter = &rateLimiter{rate: float64(tmc.MaxRowsPerSecond)}
+	}
+	throttler.checks = throttler.newChecks(tdbc)
+
+	return throttler
+}
+
+// 根据配置生成需要的检测
+func (this *throttler) newChecks(tdbc *config.DBConfig) []throttleCheck {
+	checks := make([]throttleCheck, 0, 4)
+
+	if len(this.cfg.ThrottleFlagFile) != 0 {
+		checks = append(checks, func() (string, error) {
+			if _, err := os.Stat(this.cfg.ThrottleFlagFile); err == nil {
+				return fmt.Sprintf("限流文件 %s 存在", this.cfg.ThrottleFlagFile), nil
+			}
+			return "", nil
+		})
+	}
+	if this.cfg.MaxThreadsRunning > 0 {
+		checks = append(checks, func() (string, error) {
+			defaultDao, err := dao.NewDefaultDao(tdbc)
+			if err != nil {
+				return "", err
+			}
+			threadsRunning, err := defaultDao.GetThreadsRunning()
+			if err != nil {
+				return "", fmt.Errorf("获取目标实例 Threads_running 失败. %v", err)
+			}
+			if threadsRunning > int64(this.cfg.MaxThreadsRunning) {
+				return fmt.Sprintf("目标实例 Threads_running %d 超过 %d", threadsRunning, this.cfg.MaxThreadsRunning), nil
+			}
+			return "", nil
+		})
+	}
+	if this.cfg.MaxReplicaLag > 0 {
+		for _, addr := range this.cfg.ThrottleReplicas {
+			checks = append(checks, this.newReplicaLagCheck(tdbc, addr))
+		}
+	}
+	if len(this.cfg.ThrottleQuery) != 0 {
+		checks = append(checks, func() (string, error) {
+			defaultDao, err := dao.NewDefaultDao(tdbc)
+			if err != nil {
+				return "", err
+			}
+			value, err := defaultDao.QueryInt(this.cfg.ThrottleQuery)
+			if err != nil {
+				return "", fmt.Errorf("执行限流查询失败. %s. %v", this.cfg.ThrottleQuery, err)
+			}
+			if value > 0 {
+				return fmt.Sprintf("限流查询返回 %d", value), nil
+			}
+			return "", nil
+		})
+	}
+
+	return checks
+}
+
+// 检测目标实例从库的延迟, 使用目标实例的用户名和密码连接从库. 复制线程没有运行也需要限流
+func (this *throttler) newReplicaLagCheck(tdbc *config.DBConfig, addr string) throttleCheck {
+	return func() (string, error) {
+		host, port, err := config.SplitReplicaAddr(addr)
+		if err != nil {
+			return "", err
+		}
+		replicaDBC := *tdbc
+		replicaDBC.Host, replicaDBC.Port = host, port
+		replicaDBC.Socket = "" // 从库通过 host:port 链接
+		defaultDao, err := dao.NewDefaultDao(&replicaDBC)
+		if err != nil {
+			return "", fmt.Errorf("连接从库 %s 失败. %v", addr, err)
+		}
+		lag, running, err := defaultDao.GetReplicaLag()
+		if err != nil {
+			return "", fmt.Errorf("获取从库 %s 延迟失败. %v", addr, err)
+		}
+		if !running {
+			return fmt.Sprintf("从库 %s 复制没有运行", addr), nil
+		}
+		if time.Duration(lag)*time.Second > this.cfg.MaxReplicaLag {
+			return fmt.Sprintf("从库 %s 延迟 %ds 超过 %s", addr, lag, this.cfg.MaxReplicaLag), nil
+		}
+		return "", nil
+	}
+}
+
+// 定时检测是否需要限流, done 关闭后停止检测
+func (this *throttler) run(done <-chan struct{}) {
+	if len(this.checks) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(this.cfg.ThrottleInterval)
+	defer func() {
+		ticker.Stop()
+		this.update(nil) // 停止检测后不再暂停应用
+		throttledGauge.Delete(this.task)
+	}()
+
+	for {
+		this.update(this.check())
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// 执行所有的检测, 返回需要限流的原因. 检测出错不限流
+func (this *throttler) check() []string {
+	reasons := make([]string, 0, 1)
+	for _, check := range this.checks {
+		reason, err := check()
+		if err != nil {
+			seelog.Warnf("限流检测失败. %v", err)
+			continue
+		}
+		if len(reason) != 0 {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	return reasons
+}
+
+// 根据检测结果暂停或者恢复应用
+func (this *throttler) update(reasons []string) {
+	this.Lock()
+	defer this.Unlock()
+
+	if len(reasons) == 0 {
+		if this.gate.resume() {
+			seelog.Infof("恢复应用. 已经暂停 %s", time.Since(this.state.Since).Truncate(time.Second))
+		}
+		this.state = ThrottleState{}
+		throttledGauge.WithLabelValues(this.task).Set(0)
+		return
+	}
+
+	reason := strings.Join(reasons, "; ")
+	if this.gate.pause() {
+		this.state.Since = time.Now()
+		seelog.Warnf("暂停应用. %s", reason)
+	}
+	this.state.Throttled = true
+	this.state.Reason = reason
+	throttledGauge.WithLabelValues(this.task).Set(1)
+}
+
+// 当前的限流状态
+func (this *throttler) State() ThrottleState {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.state
+}
+
+// 任务停止, 正在等待恢复应用和限速的应用线程不再等待
+func (this *throttler) stop() {
+	this.stopOnce.Do(func() {
+		close(this.stopChan)
+	})
+}
+
+// 暂停中则等待恢复, 返回任务是否已经停止.
+// 在目标实例事务开始之前调用, 暂停期间不持有目标实例的事务和行锁
+func (this *throttler) waitResume() bool {
+	resumeChan := this.gate.wait()
+	if resumeChan == nil {
+		return false
+	}
+
+	select {
+	case <-resumeChan:
+		return false
+	case <-this.stopChan:
+		return true
+	}
+}
+
+// 应用 rows 行数据之前调用, 按每秒应用的行数等待
+func (this *throttler) limit(rows int) {
+	if this.limiter != nil {
+		this.limiter.wait(rows, this.stopChan)
+	}
+}
+
+// 将 row event 中的数据按一次最多应用的行数拆分, update 的修改前和修改后算一行不拆开
+func (this *throttler) split(rows [][]interface{}, op string) [][][]interface{} {
+	batchRows := this.cfg.MaxBatchRows
+	if op == schema.AUDIT_OP_UPDATE {
+		batchRows *= 2
+	}
+	if batchRows <= 0 || len(rows) <= batchRows {
+		return [][][]interface{}{rows}
+	}
+
+	batches := make([][][]interface{}, 0, (len(rows)+batchRows-1)/batchRows)
+	for start := 0; start < len(rows); start += batchRows {
+		end := start + batchRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batches = append(batches, rows[start:end])
+	}
+
+	return batches
+}
+
+// 限制每秒应用的行数, 所有应用线程共用
+type rateLimiter struct {
+	sync.Mutex
+	rate float64   // 每秒的行数
+	next time.Time // 下一次可以应用的时间
+}
+
+// 等待到可以应用 n 行数据的时间, done 关闭后不再等待
+func (this *rateLimiter) wait(n int, done <-chan struct{}) {
+	this.Lock()
+	now := time.Now()
+	if this.next.Before(now) {
+		this.next = now
+	}
+	delay := this.next.Sub(now)
+	this.next = this.next.Add(time.Duration(float64(n) / this.rate * float64(time.Second)))
+	this.Unlock()
+
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
@@ -0,0 +1,115 @@
+package manal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+func TestThrottler_FlagFile(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "throttle")
+	tmc := &config.ToMySQLConfig{ThrottleConfig: config.ThrottleConfig{
+		ThrottleFlagFile: flagFile,
+		ThrottleInterval: 5 * time.Millisecond,
+	}}
+	throttler := newThrottler(tmc, nil)
+	done := make(chan struct{})
+	defer close(done)
+	go throttler.run(done)
+
+	if err := os.WriteFile(flagFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && !throttler.State().Throttled; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state := throttler.State(); !state.Throttled || len(state.Reason) == 0 {
+		t.Fatalf("限流文件存在时应该暂停应用: %+v", state)
+	}
+
+	// 暂停中等待, 删除限流文件后恢复
+	applied := make(chan struct{})
+	go func() {
+		if !throttler.waitResume() {
+			close(applied)
+		}
+	}()
+	select {
+	case <-applied:
+		t.Fatal("暂停中不应该应用")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := os.Remove(flagFile); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-applied:
+	case <-time.After(time.Second):
+		t.Fatal("删除限流文件后没有恢复应用")
+	}
+	if throttler.State().Throttled {
+		t.Fatal("恢复后状态不应该为暂停")
+	}
+
+	// 暂停中停止任务, 不再等待
+	if err := os.WriteFile(flagFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && !throttler.State().Throttled; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopped := make(chan bool)
+	go func() { stopped <- throttler.waitResume() }()
+	throttler.stop()
+	select {
+	case ok := <-stopped:
+		if !ok {
+			t.Fatal("停止后应该返回已经停止")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("停止后还在等待恢复")
+	}
+}
+
+func TestThrottler_Split(t *testing.T) {
+	throttler := newThrottler(&config.ToMySQLConfig{ThrottleConfig: config.ThrottleConfig{MaxBatchRows: 2}}, nil)
+	rows := [][]interface{}{{1}, {2}, {3}, {4}, {5}, {6}}
+
+	if batches := throttler.split(rows, schema.AUDIT_OP_INSERT); len(batches) != 3 || len(batches[2]) != 2 {
+		t.Fatalf("insert 拆分结果: %v", batches)
+	}
+	// update 的修改前和修改后不拆开
+	if batches := throttler.split(rows, schema.AUDIT_OP_UPDATE); len(batches) != 2 || len(batches[0]) != 4 || len(batches[1]) != 2 {
+		t.Fatalf("update 拆分结果: %v", batches)
+	}
+
+	throttler.cfg.MaxBatchRows = 0
+	if batches := throttler.split(rows, schema.AUDIT_OP_DELETE); len(batches) != 1 || len(batches[0]) != 6 {
+		t.Fatalf("不限制时拆分结果: %v", batches)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{rate: 1000}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.wait(10, nil) // 每次10行, 需要等待10ms
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("50行(每秒1000行)耗时: %s", elapsed)
+	}
+
+	// 停止后不再等待
+	done := make(chan struct{})
+	close(done)
+	limiter.wait(10000, nil)
+	start = time.Now()
+	limiter.wait(10, done)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("停止后等待了: %s", elapsed)
+	}
+}
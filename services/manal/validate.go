@@ -0,0 +1,74 @@
+package manal
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/dao"
+	"github.com/daiguadaidai/haqi/schema"
+)
+
+const VALIDATE_DIAL_TIMEOUT = 5 * time.Second // 检测 kafka broker 连接的超时时间
+
+// 检测任务配置(包括表名路由规则和字段处理规则), 以及任务需要连接的源实例, 目标实例, 限流从库和 kafka broker 是否可以连接. 不会执行任务
+func Validate(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) error {
+	if err := tmc.Check(); err != nil {
+		return err
+	}
+	if _, err := schema.NewTableRouter(tmc.SchemaSuffix, tmc.TableRoutes); err != nil {
+		return err
+	}
+	if _, err := parseColumnRules(tmc.ColumnRules, tmc.GetHashKey()); err != nil {
+		return err
+	}
+
+	// 离线模式不需要连接源实例
+	if !tmc.IsOffline() {
+		if err := validateDB("源实例", odbc); err != nil {
+			return err
+		}
+	}
+	// 检测和修复目标表都需要连接目标实例
+	if err := validateDB("目标实例", tdbc); err != nil {
+		return err
+	}
+	for _, addr := range tmc.ThrottleReplicas {
+		host, port, err := config.SplitReplicaAddr(addr)
+		if err != nil {
+			return err
+		}
+		replicaDBC := *tdbc
+		replicaDBC.Host, replicaDBC.Port = host, port
+		replicaDBC.Socket = "" // 从库通过 host:port 链接
+		if err = validateDB("限流从库", &replicaDBC); err != nil {
+			return err
+		}
+	}
+
+	if tmc.Sink == config.SINK_KAFKA {
+		for _, addr := range tmc.KafkaBrokers {
+			conn, err := net.DialTimeout("tcp", addr, VALIDATE_DIAL_TIMEOUT)
+			if err != nil {
+				return fmt.Errorf("连接 kafka broker %s 失败. %v", addr, err)
+			}
+			conn.Close()
+		}
+	}
+
+	return nil
+}
+
+// 检测实例是否可以连接并执行查询
+func validateDB(name string, dbc *config.DBConfig) error {
+	defaultDao, err := dao.NewDefaultDao(dbc)
+	if err != nil {
+		return fmt.Errorf("连接%s %s:%d 失败. %v", name, dbc.Host, dbc.Port, err)
+	}
+	if _, err = defaultDao.QueryInt("SELECT 1"); err != nil {
+		return fmt.Errorf("%s %s:%d 执行查询失败. %v", name, dbc.Host, dbc.Port, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/utils"
+)
+
+// 管理一个进程中的多个任务
+type TaskManager struct {
+	sync.Mutex
+	tasks     map[string]*Task
+	newRunner runnerFactory
+	wg        sync.WaitGroup
+}
+
+func NewTaskManager() *TaskManager {
+	return newTaskManager(newManalRunner)
+}
+
+func newTaskManager(newRunner runnerFactory) *TaskManager {
+	return &TaskManager{
+		tasks:     make(map[string]*Task),
+		newRunner: newRunner,
+	}
+}
+
+// 创建并开始执行任务. 指定了 task uuid 则使用它作为任务ID, 同一个ID的任务没有结束不能重复创建.
+// 没有指定结束位点的任务一直执行, 直到通过API指定结束位点或者取消
+func (this *TaskManager) Create(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (*Task, error) {
+	tmc.ServeMode = true
+	if err := tmc.Check(); err != nil {
+		return nil, err
+	}
+
+	id := tmc.TaskUUID
+	if len(id) == 0 {
+		id = utils.GetUUID()
+	}
+	tmc.TaskName = id // 监控指标中使用任务ID区分任务
+
+	this.Lock()
+	defer this.Unlock()
+
+	if old, ok := this.tasks[id]; ok && !old.isDone() {
+		return nil, fmt.Errorf("任务 %s 正在执行", id)
+	}
+	task := newTask(id, tmc, odbc, tdbc)
+	this.tasks[id] = task
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		task.run(this.newRunner)
+	}()
+
+	return task, nil
+}
+
+func (this *TaskManager) Get(id string) (*Task, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	task, ok := this.tasks[id]
+	return task, ok
+}
+
+// 所有的任务, 按创建时间排序
+func (this *TaskManager) List() []*Task {
+	this.Lock()
+	defer this.Unlock()
+
+	tasks := make([]*Task, 0, len(this.tasks))
+	for _, task := range this.tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].createdAt.Before(tasks[j].createdAt)
+	})
+
+	return tasks
+}
+
+// 取消所有没有结束的任务, 并等待所有任务结束
+func (this *TaskManager) Close() {
+	for _, task := range this.List() {
+		task.Cancel()
+	}
+	this.wg.Wait()
+}
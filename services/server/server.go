@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/metrics"
+	"github.com/daiguadaidai/haqi/services/types"
+)
+
+// 创建任务时获取参数默认值(和 tomysql 命令行参数的默认值相同)
+type ConfigFactory func() (*config.ToMySQLConfig, *config.DBConfig, *config.DBConfig)
+
+// 创建任务的请求, 没有指定的参数使用默认值.
+// 字段名和 config.ToMySQLConfig, config.DBConfig 的字段名相同, 如: {"tmc": {"StartLogFile": "mysql-bin.000001"}}
+type createTaskRequest struct {
+	TMC  *config.ToMySQLConfig `json:"tmc"`
+	ODBC *config.DBConfig      `json:"odbc"`
+	TDBC *config.DBConfig      `json:"tdbc"`
+}
+
+// 返回的数据格式, 和 pili API 的格式相同
+type response struct {
+	Status  bool        `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+}
+
+// HTTP 控制服务
+type Server struct {
+	manager   *TaskManager
+	newConfig ConfigFactory
+	mux       *http.ServeMux
+}
+
+func NewServer(manager *TaskManager, newConfig ConfigFactory) *Server {
+	server := &Server{
+		manager:   manager,
+		newConfig: newConfig,
+		mux:       http.NewServeMux(),
+	}
+	server.mux.HandleFunc("POST /api/v1/tasks", server.createTask)
+	server.mux.HandleFunc("GET /api/v1/tasks", server.listTasks)
+	server.mux.HandleFunc("GET /api/v1/tasks/{id}", server.getTask)
+	server.mux.HandleFunc("POST /api/v1/tasks/{id}/pause", server.pauseTask)
+	server.mux.HandleFunc("POST /api/v1/tasks/{id}/resume", server.resumeTask)
+	server.mux.HandleFunc("POST /api/v1/tasks/{id}/cancel", server.cancelTask)
+	server.mux.HandleFunc("PUT /api/v1/tasks/{id}/end", server.setTaskEnd)
+	server.mux.HandleFunc("GET /api/v1/tasks/{id}/positions", server.streamPositions)
+	server.mux.Handle("GET /metrics", metrics.Handler())
+
+	return server
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mux.ServeHTTP(w, r)
+}
+
+// 创建任务
+func (this *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	req := new(createTaskRequest)
+	req.TMC, req.ODBC, req.TDBC = this.newConfig()
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("解析任务参数失败. %v", err))
+		return
+	}
+	if req.TMC == nil || req.ODBC == nil || req.TDBC == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("任务参数 tmc, odbc, tdbc 不能为 null"))
+		return
+	}
+
+	task, err := this.manager.Create(req.TMC, req.ODBC, req.TDBC)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	seelog.Infof("创建任务: %s", task.ID)
+
+	writeData(w, http.StatusCreated, task.Info())
+}
+
+// 所有任务信息
+func (this *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	tasks := this.manager.List()
+	infos := make([]*TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		infos = append(infos, task.Info())
+	}
+
+	writeData(w, http.StatusOK, infos)
+}
+
+func (this *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	if task, ok := this.task(w, r); ok {
+		writeData(w, http.StatusOK, task.Info())
+	}
+}
+
+func (this *Server) pauseTask(w http.ResponseWriter, r *http.Request) {
+	this.control(w, r, (*Task).Pause)
+}
+
+func (this *Server) resumeTask(w http.ResponseWriter, r *http.Request) {
+	this.control(w, r, (*Task).Resume)
+}
+
+func (this *Server) cancelTask(w http.ResponseWriter, r *http.Request) {
+	this.control(w, r, (*Task).Cancel)
+}
+
+// 修改结束位点, 参数格式和 pili API 的 notify_info 相同: {"end_log_file": "", "end_log_pos": 0, "end_gtid_set": ""}
+func (this *Server) setTaskEnd(w http.ResponseWriter, r *http.Request) {
+	info := new(types.ReadInfo)
+	if err := json.NewDecoder(r.Body).Decode(info); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("解析结束位点参数失败. %v", err))
+		return
+	}
+
+	this.control(w, r, func(task *Task) error {
+		return task.SetEnd(info)
+	})
+}
+
+// 推送任务的解析和应用位点(Server-Sent Events), 任务结束后推送最后一次并结束.
+// interval 参数指定推送间隔, 如: ?interval=5s
+func (this *Server) streamPositions(w http.ResponseWriter, r *http.Request) {
+	interval := config.DEFAULT_STREAM_INTERVAL
+	if s := r.URL.Query().Get("interval"); len(s) != 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("推送间隔格式错误: %s", s))
+			return
+		}
+		interval = d
+	}
+	task, ok := this.task(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("不支持推送数据"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done := task.isDone()
+		data, err := json.Marshal(task.Info())
+		if err != nil {
+			seelog.Errorf("任务 %s 位点信息序列化失败. %v", task.ID, err)
+			return
+		}
+		if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-task.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// 对任务执行操作, 操作失败(任务状态不允许)返回 409
+func (this *Server) control(w http.ResponseWriter, r *http.Request, op func(task *Task) error) {
+	task, ok := this.task(w, r)
+	if !ok {
+		return
+	}
+	if err := op(task); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeData(w, http.StatusOK, task.Info())
+}
+
+// 获取请求路径中的任务, 不存在返回 404
+func (this *Server) task(w http.ResponseWriter, r *http.Request) (*Task, bool) {
+	id := r.PathValue("id")
+	task, ok := this.manager.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("任务 %s 不存在", id))
+	}
+
+	return task, ok
+}
+
+func writeData(w http.ResponseWriter, code int, data interface{}) {
+	writeResponse(w, code, &response{Status: true, Message: "success", Data: data})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeResponse(w, code, &response{Status: false, Message: err.Error()})
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp *response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		seelog.Errorf("返回数据失败. %v", err)
+	}
+}
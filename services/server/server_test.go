@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/services/types"
+)
+
+// 测试使用的任务执行者, 停止后 Start 返回
+type fakeRunner struct {
+	sync.Mutex
+	paused  bool
+	endFile string
+	endPos  uint32
+	stop    chan struct{}
+}
+
+func (this *fakeRunner) Start() error {
+	<-this.stop
+	return nil
+}
+
+func (this *fakeRunner) Pause() {
+	this.Lock()
+	defer this.Unlock()
+	this.paused = true
+}
+
+func (this *fakeRunner) Resume() {
+	this.Lock()
+	defer this.Unlock()
+	this.paused = false
+}
+
+func (this *fakeRunner) Stop() {
+	close(this.stop)
+}
+
+func (this *fakeRunner) SetEndPosition(file string, pos uint32) error {
+	this.Lock()
+	defer this.Unlock()
+	this.endFile, this.endPos = file, pos
+	return nil
+}
+
+func (this *fakeRunner) SetEndGTIDSet(endGTID string) error {
+	return fmt.Errorf("不支持GTID")
+}
+
+func (this *fakeRunner) SaveInfo() *types.SaveInfo {
+	this.Lock()
+	defer this.Unlock()
+	return &types.SaveInfo{ParseLogFile: "mysql-bin.000001", ParseLogPos: 4, EndLogFile: this.endFile, EndLogPos: this.endPos}
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *TaskManager) {
+	manager := newTaskManager(func(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (taskRunner, error) {
+		if tmc.Workers == 99 {
+			return nil, fmt.Errorf("连接源实例失败")
+		}
+		return &fakeRunner{stop: make(chan struct{})}, nil
+	})
+	newConfig := func() (*config.ToMySQLConfig, *config.DBConfig, *config.DBConfig) {
+		tmc := &config.ToMySQLConfig{
+			BaseConfig: config.BaseConfig{StartLogFile: "mysql-bin.000001", StartLogPos: 4},
+			InsertMode: config.DEFAULT_INSERT_MODE,
+			UpdateMode: config.DEFAULT_UPDATE_MODE,
+			DeleteMode: config.DEFAULT_DELETE_MODE,
+			DDLPolicy:  config.DEFAULT_DDL_POLICY,
+			Sink:       config.DEFAULT_SINK,
+			Workers:    config.DEFAULT_WORKERS,
+		}
+		return tmc, &config.DBConfig{Port: 3306}, &config.DBConfig{Port: 3306}
+	}
+
+	return httptest.NewServer(NewServer(manager, newConfig)), manager
+}
+
+// 发送请求, 返回 http 状态码和返回数据中的 data
+func doRequest(t *testing.T, method string, url string, body string, data interface{}) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	result := &response{Data: data}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, result.Message
+}
+
+func waitTaskState(t *testing.T, task *Task, state string) {
+	for i := 0; i < 200; i++ {
+		if task.Info().State == state {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("任务状态: %s, 期望: %s", task.Info().State, state)
+}
+
+func TestServer_TaskLifecycle(t *testing.T) {
+	ts, manager := newTestServer(t)
+	defer ts.Close()
+	defer manager.Close()
+	api := ts.URL + "/api/v1/tasks"
+
+	info := new(TaskInfo)
+	code, msg := doRequest(t, http.MethodPost, api, `{"tmc": {"TaskUUID": "task1", "Workers": 2}}`, info)
+	if code != http.StatusCreated || info.ID != "task1" {
+		t.Fatalf("创建任务失败: %d %s", code, msg)
+	}
+	task, _ := manager.Get("task1")
+	if task.TMC.Workers != 2 || task.TMC.InsertMode != config.DEFAULT_INSERT_MODE {
+		t.Fatalf("任务参数没有使用默认值: %+v", task.TMC)
+	}
+	waitTaskState(t, task, TASK_STATE_RUNNING)
+
+	// 同一个ID的任务没有结束不能重复创建
+	if code, _ = doRequest(t, http.MethodPost, api, `{"tmc": {"TaskUUID": "task1"}}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("重复创建任务返回: %d", code)
+	}
+	// 参数检测失败
+	if code, _ = doRequest(t, http.MethodPost, api, `{"tmc": {"InsertMode": "x"}}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("参数错误创建任务返回: %d", code)
+	}
+
+	if code, msg = doRequest(t, http.MethodPost, api+"/task1/resume", "", nil); code != http.StatusConflict {
+		t.Fatalf("恢复运行中的任务返回: %d %s", code, msg)
+	}
+	if code, msg = doRequest(t, http.MethodPost, api+"/task1/pause", "", info); code != http.StatusOK || info.State != TASK_STATE_PAUSED {
+		t.Fatalf("暂停任务失败: %d %s", code, msg)
+	}
+	if code, msg = doRequest(t, http.MethodPost, api+"/task1/resume", "", info); code != http.StatusOK || info.State != TASK_STATE_RUNNING {
+		t.Fatalf("恢复任务失败: %d %s", code, msg)
+	}
+
+	code, msg = doRequest(t, http.MethodPut, api+"/task1/end", `{"end_log_file": "mysql-bin.000003", "end_log_pos": 120}`, info)
+	if code != http.StatusOK || info.Positions == nil || info.Positions.EndLogFile != "mysql-bin.000003" || info.Positions.EndLogPos != 120 {
+		t.Fatalf("修改结束位点失败: %d %s %+v", code, msg, info.Positions)
+	}
+
+	infos := make([]*TaskInfo, 0)
+	if code, _ = doRequest(t, http.MethodGet, api, "", &infos); code != http.StatusOK || len(infos) != 1 {
+		t.Fatalf("获取任务列表失败: %d %v", code, infos)
+	}
+	if code, _ = doRequest(t, http.MethodGet, api+"/nothing", "", nil); code != http.StatusNotFound {
+		t.Fatalf("获取不存在的任务返回: %d", code)
+	}
+
+	if code, msg = doRequest(t, http.MethodPost, api+"/task1/cancel", "", nil); code != http.StatusOK {
+		t.Fatalf("取消任务失败: %d %s", code, msg)
+	}
+	waitTaskState(t, task, TASK_STATE_CANCELED)
+	if code, _ = doRequest(t, http.MethodPost, api+"/task1/cancel", "", nil); code != http.StatusConflict {
+		t.Fatalf("取消已经结束的任务返回: %d", code)
+	}
+
+	// 已经结束的任务可以使用同一个ID重新创建
+	if code, msg = doRequest(t, http.MethodPost, api, `{"tmc": {"TaskUUID": "task1", "Workers": 99}}`, nil); code != http.StatusCreated {
+		t.Fatalf("重新创建任务失败: %d %s", code, msg)
+	}
+	task, _ = manager.Get("task1")
+	waitTaskState(t, task, TASK_STATE_FAILED)
+	if task.Info().Error != "连接源实例失败" {
+		t.Fatalf("任务错误信息: %s", task.Info().Error)
+	}
+}
+
+func TestServer_StreamPositions(t *testing.T) {
+	ts, manager := newTestServer(t)
+	defer ts.Close()
+	defer manager.Close()
+	api := ts.URL + "/api/v1/tasks"
+
+	if code, msg := doRequest(t, http.MethodPost, api, `{"tmc": {"TaskUUID": "task1"}}`, nil); code != http.StatusCreated {
+		t.Fatalf("创建任务失败: %d %s", code, msg)
+	}
+	task, _ := manager.Get("task1")
+	waitTaskState(t, task, TASK_STATE_RUNNING)
+
+	resp, err := http.Get(api + "/task1/positions?interval=10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type: %s", ct)
+	}
+
+	// 收到两次推送后取消任务, 任务结束后推送结束
+	scanner := bufio.NewScanner(resp.Body)
+	events := 0
+	var last *TaskInfo
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		last = new(TaskInfo)
+		if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), last); err != nil {
+			t.Fatal(err)
+		}
+		if events++; events == 2 {
+			task.Cancel()
+		}
+	}
+	if events < 3 || last.State != TASK_STATE_CANCELED || last.Positions.ParseLogFile != "mysql-bin.000001" {
+		t.Fatalf("推送次数: %d, 最后一次推送: %+v", events, last)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/gdbc"
+)
+
+// 启动 HTTP 控制服务, 收到 SIGINT, SIGTERM 后取消所有任务, 等待任务结束后退出
+func Start(sc *config.ServeConfig, newConfig ConfigFactory) {
+	defer seelog.Flush()
+	logger, _ := seelog.LoggerFromConfigAsBytes([]byte(config.LogDefautConfig()))
+	seelog.ReplaceLogger(logger)
+
+	if err := sc.Check(); err != nil {
+		seelog.Error(err.Error())
+		syscall.Exit(1)
+	}
+
+	manager := NewTaskManager()
+	httpServer := &http.Server{
+		Addr:    sc.ListenAddr,
+		Handler: NewServer(manager, newConfig),
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		seelog.Infof("HTTP 控制服务监听: %s", sc.ListenAddr)
+		errChan <- httpServer.ListenAndServe()
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-sigChan:
+		seelog.Infof("收到信号 %s, 取消所有任务", sig)
+	case err := <-errChan:
+		seelog.Errorf("HTTP 控制服务出错. %v", err)
+		manager.Close()
+		seelog.Flush()
+		syscall.Exit(1)
+	}
+
+	if err := httpServer.Shutdown(context.Background()); err != nil {
+		seelog.Errorf("停止 HTTP 控制服务失败. %v", err)
+	}
+	manager.Close()
+	if err := gdbc.Close(); err != nil {
+		seelog.Errorf("关闭数据库链接失败. %v", err)
+	}
+	seelog.Info("所有任务已经结束")
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/daiguadaidai/haqi/config"
+	"github.com/daiguadaidai/haqi/services/manal"
+	"github.com/daiguadaidai/haqi/services/types"
+)
+
+// 任务状态
+const (
+	TASK_STATE_STARTING  = "starting"  // 正在初始化(连接源实例和目标实例, 检测和修复目标表)
+	TASK_STATE_RUNNING   = "running"   // 正在解析和应用binlog
+	TASK_STATE_PAUSED    = "paused"    // 暂停解析binlog, 已经解析的event会继续应用
+	TASK_STATE_CANCELING = "canceling" // 已经取消, 等待已经解析的event应用完成
+	TASK_STATE_CANCELED  = "canceled"  // 已经取消
+	TASK_STATE_FINISHED  = "finished"  // 应用到了结束位点
+	TASK_STATE_FAILED    = "failed"    // 执行出错
+)
+
+// 任务的执行者, manal.Manal 实现了该接口
+type taskRunner interface {
+	Start() error
+	Pause()
+	Resume()
+	Stop()
+	SetEndPosition(file string, pos uint32) error
+	SetEndGTIDSet(endGTID string) error
+	SaveInfo() *types.SaveInfo
+}
+
+// 创建任务的执行者
+type runnerFactory func(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (taskRunner, error)
+
+// 创建 manal.Manal 执行任务. 每个任务使用自己的数据库配置获取链接, 用户, socket 和 TLS 配置相同的任务共用链接.
+// HTTP 控制服务提供 /metrics, 所有的任务都更新监控指标
+func newManalRunner(tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) (taskRunner, error) {
+	m, err := manal.NewManal(tmc, odbc, tdbc)
+	if err != nil {
+		return nil, err
+	}
+	m.EnableMetrics = true
+
+	return m, nil
+}
+
+// 一个 tomysql 任务
+type Task struct {
+	sync.Mutex
+	ID         string
+	TMC        *config.ToMySQLConfig
+	ODBC       *config.DBConfig
+	TDBC       *config.DBConfig
+	state      string
+	err        error
+	createdAt  time.Time
+	finishedAt time.Time
+	runner     taskRunner
+	canceled   bool
+	done       chan struct{} // 任务结束后关闭
+}
+
+// 任务信息
+type TaskInfo struct {
+	ID         string          `json:"id"`
+	State      string          `json:"state"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+	FinishedAt string          `json:"finished_at,omitempty"`
+	Positions  *types.SaveInfo `json:"positions,omitempty"` // 解析和应用的位点, 初始化完成后才有
+}
+
+func newTask(id string, tmc *config.ToMySQLConfig, odbc *config.DBConfig, tdbc *config.DBConfig) *Task {
+	return &Task{
+		ID:        id,
+		TMC:       tmc,
+		ODBC:      odbc,
+		TDBC:      tdbc,
+		state:     TASK_STATE_STARTING,
+		createdAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+}
+
+// 执行任务, 任务结束后返回
+func (this *Task) run(newRunner runnerFactory) {
+	defer close(this.done)
+
+	runner, err := newRunner(this.TMC, this.ODBC, this.TDBC)
+	this.Lock()
+	if err != nil {
+		this.finish(err)
+		this.Unlock()
+		return
+	}
+	this.runner = runner
+	if this.canceled { // 初始化的时候已经取消
+		this.finish(nil)
+		this.Unlock()
+		return
+	}
+	this.state = TASK_STATE_RUNNING
+	this.Unlock()
+
+	seelog.Infof("任务 %s 开始执行", this.ID)
+	err = runner.Start()
+
+	this.Lock()
+	defer this.Unlock()
+	this.finish(err)
+}
+
+// 设置任务结束的状态, 需要持有锁
+func (this *Task) finish(err error) {
+	this.finishedAt = time.Now()
+	switch {
+	case this.canceled:
+		this.state = TASK_STATE_CANCELED
+		seelog.Infof("任务 %s 已经取消", this.ID)
+	case err != nil:
+		this.state = TASK_STATE_FAILED
+		this.err = err
+		seelog.Errorf("任务 %s 执行失败. %v", this.ID, err)
+	default:
+		this.state = TASK_STATE_FINISHED
+		seelog.Infof("任务 %s 执行完成", this.ID)
+	}
+}
+
+// 任务是否已经结束(状态已经是最终状态), 需要持有锁
+func (this *Task) ended() bool {
+	switch this.state {
+	case TASK_STATE_CANCELED, TASK_STATE_FINISHED, TASK_STATE_FAILED:
+		return true
+	}
+	return false
+}
+
+// 任务的执行是否已经返回
+func (this *Task) isDone() bool {
+	select {
+	case <-this.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (this *Task) Pause() error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.state != TASK_STATE_RUNNING {
+		return fmt.Errorf("任务 %s 状态为 %s, 只有 %s 的任务可以暂停", this.ID, this.state, TASK_STATE_RUNNING)
+	}
+	this.runner.Pause()
+	this.state = TASK_STATE_PAUSED
+
+	return nil
+}
+
+func (this *Task) Resume() error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.state != TASK_STATE_PAUSED {
+		return fmt.Errorf("任务 %s 状态为 %s, 只有 %s 的任务可以恢复", this.ID, this.state, TASK_STATE_PAUSED)
+	}
+	this.runner.Resume()
+	this.state = TASK_STATE_RUNNING
+
+	return nil
+}
+
+// 取消任务. 已经解析的event会继续应用, 任务结束后状态为 canceled
+func (this *Task) Cancel() error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.ended() {
+		return fmt.Errorf("任务 %s 已经结束, 状态为 %s", this.ID, this.state)
+	}
+	if this.canceled {
+		return nil
+	}
+	this.canceled = true
+	this.state = TASK_STATE_CANCELING
+	if this.runner != nil {
+		this.runner.Stop()
+	}
+
+	return nil
+}
+
+// 修改结束位点或结束GTID集合
+func (this *Task) SetEnd(info *types.ReadInfo) error {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.state != TASK_STATE_RUNNING && this.state != TASK_STATE_PAUSED {
+		return fmt.Errorf("任务 %s 状态为 %s, 只有 %s 和 %s 的任务可以修改结束位点",
+			this.ID, this.state, TASK_STATE_RUNNING, TASK_STATE_PAUSED)
+	}
+	if len(info.EndGTIDSet) != 0 {
+		return this.runner.SetEndGTIDSet(info.EndGTIDSet)
+	}
+
+	return this.runner.SetEndPosition(info.EndLogFile, info.EndLogPos)
+}
+
+// 获取任务信息
+func (this *Task) Info() *TaskInfo {
+	this.Lock()
+	defer this.Unlock()
+
+	info := &TaskInfo{
+		ID:        this.ID,
+		State:     this.state,
+		CreatedAt: this.createdAt.Format(time.RFC3339),
+	}
+	if this.err != nil {
+		info.Error = this.err.Error()
+	}
+	if !this.finishedAt.IsZero() {
+		info.FinishedAt = this.finishedAt.Format(time.RFC3339)
+	}
+	if this.runner != nil {
+		info.Positions = this.runner.SaveInfo()
+	}
+
+	return info
+}
@@ -15,15 +15,22 @@ type TaskInfo struct {
 type ReadInfo struct {
 	EndLogFile string `json:"end_log_file" form:"end_log_file"`
 	EndLogPos  uint32 `json:"end_log_pos" form:"end_log_pos"`
+	EndGTIDSet string `json:"end_gtid_set" form:"end_gtid_set"`
 }
 
 type SaveInfo struct {
 	ParseLogFile string `json:"parse_log_file" form:"parse_log_file"`
 	ParseLogPos  uint32 `json:"parse_log_pos" form:"parse_log_pos"`
+	ParseGTIDSet string `json:"parse_gtid_set" form:"parse_gtid_set"`
 	ApplyLogFile string `json:"apply_log_file" form:"apply_log_file"`
 	ApplyLogPos  uint32 `json:"apply_log_pos" form:"apply_log_pos"`
+	ApplyGTIDSet string `json:"apply_gtid_set" form:"apply_gtid_set"`
 	EndLogFile   string `json:"end_log_file" form:"end_log_file"`
 	EndLogPos    uint32 `json:"end_log_pos" form:"end_log_pos"`
+	EndGTIDSet   string `json:"end_gtid_set" form:"end_gtid_set"`
+
+	Throttled      bool   `json:"throttled" form:"throttled"`             // 是否因为目标实例负载过高暂停应用
+	ThrottleReason string `json:"throttle_reason" form:"throttle_reason"` // 暂停应用的原因
 }
 
 func GetReadInfo(taskUUID string, api string) (*ReadInfo, error) {